@@ -20,7 +20,10 @@
 
 package mpa
 
-import "io"
+import (
+	"io"
+	"math"
+)
 
 // A MalformedStream is returned when the decoder encounters a syntax or
 // semantic error it's unable to conceal. Such errors usually leave the decoder
@@ -39,6 +42,7 @@ type Decoder struct {
 	Input io.Reader
 
 	// Header fields
+	lsf           bool // MPEG-2 or MPEG-2.5 (lower sampling frequencies)
 	layer         int
 	protectionBit int
 	bitrateIndex  int
@@ -85,6 +89,8 @@ type Decoder struct {
 	scalefacL           [2][2][21]int
 	scalefacS           [2][2][12][3]int
 	part2Length         [2][2]int
+	isMaxL              [22]int
+	isMaxS              [13]int
 	huffmanData         [2][2][576]float32
 	imdct               [2][32]imdctFilter
 }
@@ -98,13 +104,15 @@ func (d *Decoder) Layer() int {
 	return d.layer
 }
 
-// NSamples returns the number of samples per channel (384 or 1152) in the last
-// decoded frame.
+// NSamples returns the number of samples per channel (384, 576, or 1152) in the
+// last decoded frame.
 // If DecodeFrame hasn't been called yet, or if the last call failed, the return
 // value is undefined.
 func (d *Decoder) NSamples() int {
 	if d.layer == 1 {
 		return 384
+	} else if d.layer == 3 && d.lsf {
+		return 576
 	} else {
 		return 1152
 	}
@@ -115,11 +123,14 @@ func (d *Decoder) NSamples() int {
 // If DecodeFrame hasn't been called yet, or if the last call failed, the return
 // value is undefined.
 func (d *Decoder) Bitrate() int {
+	if d.lsf {
+		return bitrateBpsLSF[d.layer][d.bitrateIndex]
+	}
 	return bitrateBps[d.layer][d.bitrateIndex]
 }
 
-// SamplingFrequency returns the sampling freqency (32k, 44.1k, or 48k) of the
-// last decoded frame in Hz.
+// SamplingFrequency returns the sampling freqency (8k to 48k) of the last
+// decoded frame in Hz.
 // If DecodeFrame hasn't been called yet, or if the last call failed, the return
 // value is undefined.
 func (d *Decoder) SamplingFrequency() int {
@@ -268,10 +279,18 @@ func (d *Decoder) decodeHeader() error {
 		return err
 	}
 
+	d.lsf = (header>>19)&1 == 0
 	d.layer = 4 - (header>>17)&3
 	d.protectionBit = (header >> 16) & 1
 	d.bitrateIndex = (header >> 12) & 15
 	d.samplingFreq = (header >> 10) & 3
+	if d.lsf {
+		// MPEG-2.5 is MPEG-2 with a shorter syncword.
+		d.samplingFreq += 3
+		if (header>>20)&1 == 0 {
+			d.samplingFreq += 3
+		}
+	}
 	d.paddingBit = (header >> 9) & 1
 	// private_bit is ignored.
 	d.mode = (header >> 6) & 3
@@ -296,11 +315,11 @@ func (d *Decoder) findHeader() (int, error) {
 	consumed := 0
 
 retry:
-	for header&0xfff00000 != 0xfff00000 {
+	for header&0xffe00000 != 0xffe00000 {
 		b, err := d.stream.readByte()
 		if err != nil {
 			if err == io.EOF {
-				if header&0xfff000 == 0xfff000 || header&0xfff0 == 0xfff0 {
+				if header&0xffe000 == 0xffe000 || header&0xffe0 == 0xffe0 {
 					err = io.ErrUnexpectedEOF
 				}
 			}
@@ -363,15 +382,16 @@ func (d *Decoder) trueHeader(header uint32, ffAllowed bool) bool {
 // the reported size is 0.
 func validateHeader(header uint32) (valid bool, size int) {
 	var (
-		sync  = (header >> 20) & 0xfff
-		id    = (header >> 19) & 1
-		layer = 4 - (header>>17)&3
-		br    = (header >> 12) & 15
-		sf    = (header >> 10) & 3
-		pad   = (header >> 9) & 1
+		sync    = (header >> 21) & 0x7ff
+		version = (header >> 19) & 3
+		layer   = 4 - (header>>17)&3
+		br      = (header >> 12) & 15
+		sf      = (header >> 10) & 3
+		pad     = (header >> 9) & 1
 	)
 
-	if sync != 0xfff || id == 0 || layer == 4 || br == 15 || sf == 3 {
+	// version is 3 for MPEG-1, 2 for MPEG-2, and 0 for MPEG-2.5.
+	if sync != 0x7ff || version == 1 || layer == 4 || br == 15 || sf == 3 {
 		return false, 0
 	}
 
@@ -380,8 +400,17 @@ func validateHeader(header uint32) (valid bool, size int) {
 	}
 
 	size = 12 * bitrateBps[layer][br]
-	if layer != 1 {
+	if version != 3 {
+		size = 12 * bitrateBpsLSF[layer][br]
+		sf += 3
+		if version == 0 {
+			sf += 3
+		}
+	}
+	if layer == 2 || layer == 3 && version == 3 {
 		size *= 12
+	} else if layer == 3 {
+		size *= 6
 	}
 	size /= samplingFreqHz[sf]
 	size += int(pad)
@@ -395,10 +424,7 @@ func validateHeader(header uint32) (valid bool, size int) {
 // synthetizeOutput feeds the subband samples through the synthesis filterbank
 // and clamps the resulting PCM samples to the interval [-1, 1].
 func (d *Decoder) synthetizeOutput() {
-	samplesPerSubband := 36
-	if d.layer == 1 {
-		samplesPerSubband = 12
-	}
+	samplesPerSubband := d.NSamples() / 32
 
 	for ch := 0; ch < d.nChannels; ch++ {
 		for s := 0; s < samplesPerSubband; s++ {
@@ -590,7 +616,10 @@ func (d *Decoder) decodeFrame2() error {
 
 // decodeAllocation2 decodes the bit allocation data of a Layer II frame.
 func (d *Decoder) decodeAllocation2() error {
-	aTab := allocationTables[d.nChannels-1][d.samplingFreq][d.bitrateIndex]
+	aTab := aTabLSF
+	if !d.lsf {
+		aTab = allocationTables[d.nChannels-1][d.samplingFreq][d.bitrateIndex]
+	}
 	if aTab == nil {
 		return MalformedStream("illegal combination of bitrate and mode")
 	}
@@ -710,6 +739,23 @@ func (d *Decoder) decodeSamples2() error {
 
 // Layer III
 
+// granules returns the number of granules in a Layer III frame.
+func (d *Decoder) granules() int {
+	if d.lsf {
+		return 1
+	}
+	return 2
+}
+
+// mixedBands returns the number of long scalefactor bands and the first short
+// scalefactor band of mixed blocks.
+func (d *Decoder) mixedBands() (longHigh, shortLow int) {
+	if d.lsf {
+		return 6, 3
+	}
+	return 8, 3
+}
+
 // decodeFrame3 decodes a Layer III frame.
 func (d *Decoder) decodeFrame3() error {
 	if err := d.decodeSideInformation3(); err != nil {
@@ -723,7 +769,7 @@ func (d *Decoder) decodeFrame3() error {
 	// If window_switching_flag == 1 and block_type == 0, we only report the
 	// error after reading the side information through and updating the bit
 	// reservoir, so the decoder is more likely to stay in sync.
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			if d.windowSwitchingFlag[gr][ch] == 1 && d.blockType[gr][ch] == 0 {
 				return MalformedStream("block_type == 0")
@@ -749,21 +795,32 @@ func (d *Decoder) decodeFrame3() error {
 func (d *Decoder) decodeSideInformation3() error {
 	var err error
 
-	if d.mainDataBegin, err = d.stream.readBits(9); err != nil {
+	if d.lsf {
+		d.mainDataBegin, err = d.stream.readBits(8)
+	} else {
+		d.mainDataBegin, err = d.stream.readBits(9)
+	}
+	if err != nil {
 		return err
 	}
 
 	// private_bits (ignored)
+	privateBits := 3
 	if d.mode == ModeMono {
-		_, err = d.stream.readBits(5)
-	} else {
-		_, err = d.stream.readBits(3)
+		privateBits = 5
 	}
-	if err != nil {
+	if d.lsf {
+		privateBits = 2
+		if d.mode == ModeMono {
+			privateBits = 1
+		}
+	}
+	if _, err = d.stream.readBits(privateBits); err != nil {
 		return err
 	}
 
-	for ch := 0; ch < d.nChannels; ch++ {
+	// MPEG-2 has no scalefactor selection information.
+	for ch := 0; ch < d.nChannels && !d.lsf; ch++ {
 		for scfsiBand := 0; scfsiBand < 4; scfsiBand++ {
 			if d.scfsi3[ch][scfsiBand], err = d.stream.readBits(1); err != nil {
 				return err
@@ -771,7 +828,7 @@ func (d *Decoder) decodeSideInformation3() error {
 		}
 	}
 
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			d.part23Length[gr][ch], err = d.stream.readBits(12)
 			if err != nil {
@@ -788,7 +845,11 @@ func (d *Decoder) decodeSideInformation3() error {
 				return err
 			}
 
-			d.scalefacCompress[gr][ch], err = d.stream.readBits(4)
+			if d.lsf {
+				d.scalefacCompress[gr][ch], err = d.stream.readBits(9)
+			} else {
+				d.scalefacCompress[gr][ch], err = d.stream.readBits(4)
+			}
 			if err != nil {
 				return err
 			}
@@ -847,9 +908,12 @@ func (d *Decoder) decodeSideInformation3() error {
 				}
 			}
 
-			d.preflag[gr][ch], err = d.stream.readBits(1)
-			if err != nil {
-				return err
+			// MPEG-2 derives preflag from scalefac_compress.
+			if !d.lsf {
+				d.preflag[gr][ch], err = d.stream.readBits(1)
+				if err != nil {
+					return err
+				}
 			}
 
 			d.scalefacScale[gr][ch], err = d.stream.readBits(1)
@@ -878,7 +942,10 @@ func (d *Decoder) updateReservoir3() error {
 
 	if d.bitrateIndex != 0 {
 		// Frame size
-		mainDataLength := 144 * bitrateBps[d.layer][d.bitrateIndex]
+		mainDataLength := 144 * d.Bitrate()
+		if d.lsf {
+			mainDataLength /= 2
+		}
 		mainDataLength /= samplingFreqHz[d.samplingFreq]
 		mainDataLength += d.paddingBit
 		// Header
@@ -888,9 +955,14 @@ func (d *Decoder) updateReservoir3() error {
 			mainDataLength -= 2
 		}
 		// Side information
-		if d.mode == ModeMono {
+		switch {
+		case d.lsf && d.mode == ModeMono:
+			mainDataLength -= 9
+		case d.lsf:
 			mainDataLength -= 17
-		} else {
+		case d.mode == ModeMono:
+			mainDataLength -= 17
+		default:
 			mainDataLength -= 32
 		}
 
@@ -909,9 +981,15 @@ func (d *Decoder) updateReservoir3() error {
 // decodeMainData3 decodes the main data (scalefactors & Huffman data) of a
 // Layer III frame.
 func (d *Decoder) decodeMainData3() error {
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
-			if err := d.decodeScalefactors3(gr, ch); err != nil {
+			var err error
+			if d.lsf {
+				err = d.decodeScalefactorsLSF(ch)
+			} else {
+				err = d.decodeScalefactors3(gr, ch)
+			}
+			if err != nil {
 				return err
 			}
 			if err := d.decodeHuffmanData3(gr, ch); err != nil {
@@ -981,6 +1059,99 @@ func (d *Decoder) decodeScalefactors3(gr, ch int) error {
 	return nil
 }
 
+// decodeScalefactorsLSF decodes the scalefactors of an MPEG-2 Layer III
+// granule. It also computes part2_length and preflag, and for the
+// intensity stereo channel the illegal intensity positions.
+func (d *Decoder) decodeScalefactorsLSF(ch int) error {
+	var (
+		slen  [4]int
+		table int
+		sfc   = d.scalefacCompress[0][ch]
+	)
+	d.preflag[0][ch] = 0
+	if ch == 1 && d.mode == ModeJointStereo && d.modeExtension&1 != 0 {
+		// The lowest bit is intensity_scale.
+		sfc >>= 1
+		switch {
+		case sfc < 180:
+			slen = [4]int{sfc / 36, sfc % 36 / 6, sfc % 6, 0}
+			table = 3
+		case sfc < 244:
+			sfc -= 180
+			slen = [4]int{sfc >> 4, sfc >> 2 & 3, sfc & 3, 0}
+			table = 4
+		default:
+			sfc -= 244
+			slen = [4]int{sfc / 3, sfc % 3, 0, 0}
+			table = 5
+		}
+	} else {
+		switch {
+		case sfc < 400:
+			slen = [4]int{sfc >> 4 / 5, sfc >> 4 % 5, sfc >> 2 & 3, sfc & 3}
+		case sfc < 500:
+			sfc -= 400
+			slen = [4]int{sfc >> 2 / 5, sfc >> 2 % 5, sfc & 3, 0}
+			table = 1
+		default:
+			sfc -= 500
+			slen = [4]int{sfc / 3, sfc % 3, 0, 0}
+			table = 2
+			d.preflag[0][ch] = 1
+		}
+	}
+
+	block := 0
+	if d.blockType[0][ch] == 2 {
+		block = 1 + d.mixedBlockFlag[0][ch]
+	}
+	nr := nrOfSfb[table][block]
+
+	// Read the scalefactors in the order they are stored: long bands first,
+	// then short bands window by window.
+	longHigh, shortLow := 0, 0
+	switch block {
+	case 0:
+		longHigh, shortLow = 21, 12
+	case 2:
+		longHigh, shortLow = d.mixedBands()
+	}
+	d.part2Length[0][ch] = 0
+	sfb, window := 0, 0
+	for part := 0; part < 4; part++ {
+		s := slen[part]
+		d.part2Length[0][ch] += nr[part] * s
+		for i := 0; i < nr[part]; i++ {
+			v, err := d.reservoir.readBits(s)
+			if err != nil {
+				return err
+			}
+			if sfb < longHigh {
+				d.scalefacL[0][ch][sfb] = v
+				d.isMaxL[sfb] = 1<<uint(s) - 1
+				sfb++
+				if sfb == longHigh {
+					sfb = shortLow
+				}
+				continue
+			}
+			if sfb < 12 {
+				d.scalefacS[0][ch][sfb][window] = v
+				d.isMaxS[sfb] = 1<<uint(s) - 1
+			}
+			if window++; window == 3 {
+				window = 0
+				sfb++
+			}
+		}
+	}
+	// The last bands share the intensity positions of their neighbors.
+	d.isMaxL[21] = d.isMaxL[20]
+	d.isMaxS[12] = d.isMaxS[11]
+
+	return nil
+}
+
 // decodeHuffmanData decodes the Huffman data of a Layer III frame. It reads
 // exactly part2_3_length - part2_length bits from the reservoir, skipping the
 // stuffing bits at the end of the granule, if necessary.
@@ -1003,8 +1174,10 @@ func (d *Decoder) decodeHuffmanData3(gr, ch int) error {
 		}
 		regions[0] = scfBandsL[d.samplingFreq][regions[0]]
 		regions[1] = scfBandsL[d.samplingFreq][regions[1]]
+	} else if d.blockType[gr][ch] == 2 && d.mixedBlockFlag[gr][ch] == 0 {
+		regions = [3]int{3 * scfBandsS[d.samplingFreq][3], 576, 2 * d.bigValues[gr][ch]}
 	} else {
-		regions = [3]int{36, 576, 2 * d.bigValues[gr][ch]}
+		regions = [3]int{scfBandsL[d.samplingFreq][8], 576, 2 * d.bigValues[gr][ch]}
 	}
 	if regions[2] > 576 {
 		return MalformedStream("big_values too large")
@@ -1114,14 +1287,13 @@ func (d *Decoder) decodeHuffmanData3(gr, ch int) error {
 
 // dequantize3 performs the dequantization step of Layer III decoding.
 func (d *Decoder) dequantize3() {
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			longLow, longHigh := 0, 22
 			shortLow, shortHigh := 0, 13
 			if d.blockType[gr][ch] == 2 {
 				if d.mixedBlockFlag[gr][ch] == 1 {
-					longHigh = 8
-					shortLow = 3
+					longHigh, shortLow = d.mixedBands()
 				} else {
 					longLow = 22
 				}
@@ -1180,13 +1352,12 @@ func (d *Decoder) stereo3() {
 
 	ms, intensity := d.modeExtension&2 != 0, d.modeExtension&1 != 0
 
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		longLow, longHigh := 0, 22
 		shortLow, shortHigh := 0, 13
 		if d.blockType[gr][1] == 2 {
 			if d.mixedBlockFlag[gr][1] == 1 {
-				longHigh = 8
-				shortLow = 3
+				longHigh, shortLow = d.mixedBands()
 			} else {
 				longLow = 22
 			}
@@ -1241,9 +1412,8 @@ func (d *Decoder) stereo3() {
 					isPos = 11
 				}
 				isPos = d.scalefacS[gr][1][isPos][window]
-				if intensity && sfb >= zeroPartStartS[window] && isPos < 7 {
-					L := intensityFactors[isPos]
-					R := 1 - L
+				L, R, legal := d.intensityRatio(isPos, d.isMaxS[sfb])
+				if intensity && sfb >= zeroPartStartS[window] && legal {
 					for f := winLow; f < winHigh; f++ {
 						d.huffmanData[gr][1][f] = R * d.huffmanData[gr][0][f]
 						d.huffmanData[gr][0][f] *= L
@@ -1266,9 +1436,8 @@ func (d *Decoder) stereo3() {
 				isPos = 20
 			}
 			isPos = d.scalefacL[gr][1][isPos]
-			if intensity && sfb >= zeroPartStartL && isPos < 7 {
-				L := intensityFactors[isPos]
-				R := 1 - L
+			L, R, legal := d.intensityRatio(isPos, d.isMaxL[sfb])
+			if intensity && sfb >= zeroPartStartL && legal {
 				for f := low; f < high; f++ {
 					d.huffmanData[gr][1][f] = R * d.huffmanData[gr][0][f]
 					d.huffmanData[gr][0][f] *= L
@@ -1284,9 +1453,37 @@ func (d *Decoder) stereo3() {
 	}
 }
 
+// intensityRatio returns the factors of the left and right channels for the
+// intensity stereo position isPos, and whether isPos is legal. In MPEG-2,
+// isMax is the illegal position of the scalefactor band.
+func (d *Decoder) intensityRatio(isPos, isMax int) (L, R float32, legal bool) {
+	if !d.lsf {
+		if isPos >= 7 {
+			return 0, 0, false
+		}
+		L = intensityFactors[isPos]
+		return L, 1 - L, true
+	}
+	if isPos == isMax {
+		return 0, 0, false
+	}
+	// intensity_scale selects the step between positions.
+	step := float32(0.840896415253715) // 2^(-1/4)
+	if d.scalefacCompress[0][1]&1 != 0 {
+		step = 1 / sqrt2
+	}
+	L, R = 1, 1
+	if isPos&1 != 0 {
+		L = float32(math.Pow(float64(step), float64(isPos+1)/2))
+	} else {
+		R = float32(math.Pow(float64(step), float64(isPos)/2))
+	}
+	return L, R, true
+}
+
 // reorder3 performs the reordering step of Layer III decoding.
 func (d *Decoder) reorder3() {
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			if d.blockType[gr][ch] != 2 {
 				continue
@@ -1294,7 +1491,7 @@ func (d *Decoder) reorder3() {
 
 			shortLow := 0
 			if d.mixedBlockFlag[gr][ch] == 1 {
-				shortLow = 3
+				_, shortLow = d.mixedBands()
 			}
 
 			tmp := d.huffmanData[gr][ch]
@@ -1314,7 +1511,7 @@ func (d *Decoder) reorder3() {
 
 // antialias3 performs the alias reduction step of Layer III decoding.
 func (d *Decoder) antialias3() {
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			sbLimit := 32
 			if d.blockType[gr][ch] == 2 {
@@ -1339,7 +1536,7 @@ func (d *Decoder) antialias3() {
 
 // imdctFilter3 performs the IMDCT filtering step of Layer III decoding.
 func (d *Decoder) imdctFilter3() {
-	for gr := 0; gr < 2; gr++ {
+	for gr := 0; gr < d.granules(); gr++ {
 		for ch := 0; ch < d.nChannels; ch++ {
 			var tmp [18]float32
 			for sb := 0; sb < 32; sb++ {
@@ -20,6 +20,9 @@
 
 // Package mpa is an MPEG-1 Audio library. It's currently decoding-only.
 //
+// This copy also decodes the lower sampling frequencies of MPEG-2 and
+// MPEG-2.5. README.txt lists the changes made to the upstream package.
+//
 // A trivial example which reads the MPEG-1 coded bitstream from stdin and
 // writes the decoded PCM stream to stdout:
 //
@@ -76,12 +76,43 @@ var bitrateBps = [4][15]int{
 	},
 }
 
+// bitrateBpsLSF is bitrateBps for MPEG-2 and MPEG-2.5 (ISO/IEC 13818-3 Table
+// 2.4.2.3), whose Layers II and III share the same bitrates.
+var bitrateBpsLSF = [4][15]int{
+	{},
+
+	// Layer I
+	{
+		FreeFormat, 32000, 48000, 56000, 64000, 80000, 96000, 112000,
+		128000, 144000, 160000, 176000, 192000, 224000, 256000,
+	},
+
+	// Layer II
+	{
+		FreeFormat, 8000, 16000, 24000, 32000, 40000, 48000, 56000,
+		64000, 80000, 96000, 112000, 128000, 144000, 160000,
+	},
+
+	// Layer III
+	{
+		FreeFormat, 8000, 16000, 24000, 32000, 40000, 48000, 56000,
+		64000, 80000, 96000, 112000, 128000, 144000, 160000,
+	},
+}
+
 // samplingFreqHz, indexed with the value of the sampling_frequency header field
-// tells the sampling frequency in Hz.
-var samplingFreqHz = [3]int{
+// tells the sampling frequency in Hz. The value is offset by 3 for MPEG-2 and
+// by 6 for MPEG-2.5 streams.
+var samplingFreqHz = [9]int{
 	44100,
 	48000,
 	32000,
+	22050,
+	24000,
+	16000,
+	11025,
+	12000,
+	8000,
 }
 
 // Layers I & II
@@ -271,6 +302,41 @@ var aTabD = &allocationTable{
 	{3, [16]int8{0, -5, -7, -10, 4, 5, 6, 7}},
 }
 
+// aTabLSF is the only bit allocation table of MPEG-2 and MPEG-2.5 (ISO/IEC
+// 13818-3 Table B.1).
+var aTabLSF = &allocationTable{
+	{4, [16]int8{0, -5, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+	{4, [16]int8{0, -5, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+	{4, [16]int8{0, -5, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+	{4, [16]int8{0, -5, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{3, [16]int8{0, -5, -7, 3, -10, 4, 5, 6}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+	{2, [16]int8{0, -5, -7, -10}},
+}
+
 // allocationTables tells which bit allocation table shall be used for every
 // combination of mode, sampling_frequency, and bitrate_index. The first index
 // is the number of channels minus one, the second one is sampling_frequency,
@@ -327,12 +393,25 @@ var slen1 = [16]int{0, 0, 0, 0, 3, 1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4}
 // slen2 is indexed with scalefac_compress, and it tells the value of slen2.
 var slen2 = [16]int{0, 1, 2, 3, 0, 1, 2, 3, 1, 2, 3, 1, 2, 3, 2, 3}
 
+// nrOfSfb lists the number of scalefactors in each of the four partitions
+// of an MPEG-2 or MPEG-2.5 granule (ISO/IEC 13818-3 Table 2.4.3.2). The first
+// index selects the partitioning by scalefac_compress, the second one the
+// block type: long, short, or mixed. Short block counts are given in windows.
+var nrOfSfb = [6][3][4]int{
+	{{6, 5, 5, 5}, {9, 9, 9, 9}, {6, 9, 9, 9}},
+	{{6, 5, 7, 3}, {9, 9, 12, 6}, {6, 9, 12, 6}},
+	{{11, 10, 0, 0}, {18, 18, 0, 0}, {15, 18, 0, 0}},
+	{{7, 7, 7, 0}, {12, 12, 12, 0}, {6, 15, 12, 0}},
+	{{6, 6, 6, 3}, {12, 9, 9, 6}, {6, 12, 9, 6}},
+	{{8, 8, 5, 0}, {15, 12, 9, 0}, {6, 18, 9, 0}},
+}
+
 // scfsiBands lists the boundaries of scalefactor selection information bands.
 var scfsiBands = [5]int{0, 6, 11, 16, 21}
 
 // scfBandsL lists the scalefactor band boundaries when long blocks are used.
-// (Table 3-B.8.)
-var scfBandsL = [3][23]int{
+// (Table 3-B.8 and ISO/IEC 13818-3 Table B.2.)
+var scfBandsL = [9][23]int{
 	// 44.1 kHz
 	{
 		0, 4, 8, 12, 16, 20, 24, 30,
@@ -353,11 +432,53 @@ var scfBandsL = [3][23]int{
 		36, 44, 54, 66, 82, 102, 126, 156,
 		194, 240, 296, 364, 448, 550, 576,
 	},
+
+	// 22.05 kHz
+	{
+		0, 6, 12, 18, 24, 30, 36, 44,
+		54, 66, 80, 96, 116, 140, 168, 200,
+		238, 284, 336, 396, 464, 522, 576,
+	},
+
+	// 24 kHz
+	{
+		0, 6, 12, 18, 24, 30, 36, 44,
+		54, 66, 80, 96, 114, 136, 162, 194,
+		232, 278, 332, 394, 464, 540, 576,
+	},
+
+	// 16 kHz
+	{
+		0, 6, 12, 18, 24, 30, 36, 44,
+		54, 66, 80, 96, 116, 140, 168, 200,
+		238, 284, 336, 396, 464, 522, 576,
+	},
+
+	// 11.025 kHz
+	{
+		0, 6, 12, 18, 24, 30, 36, 44,
+		54, 66, 80, 96, 116, 140, 168, 200,
+		238, 284, 336, 396, 464, 522, 576,
+	},
+
+	// 12 kHz
+	{
+		0, 6, 12, 18, 24, 30, 36, 44,
+		54, 66, 80, 96, 116, 140, 168, 200,
+		238, 284, 336, 396, 464, 522, 576,
+	},
+
+	// 8 kHz
+	{
+		0, 12, 24, 36, 48, 60, 72, 88,
+		108, 132, 160, 192, 232, 280, 336, 400,
+		476, 566, 568, 570, 572, 574, 576,
+	},
 }
 
 // scfBandsS lists the scalefactor band boundaries when short blocks are used.
-// (Table 3-B.8.)
-var scfBandsS = [3][14]int{
+// (Table 3-B.8 and ISO/IEC 13818-3 Table B.2.)
+var scfBandsS = [9][14]int{
 	// 44.1 kHz
 	{
 		0, 4, 8, 12, 16, 22, 30, 40,
@@ -375,6 +496,42 @@ var scfBandsS = [3][14]int{
 		0, 4, 8, 12, 16, 22, 30, 42,
 		58, 78, 104, 138, 180, 192,
 	},
+
+	// 22.05 kHz
+	{
+		0, 4, 8, 12, 18, 24, 32, 42,
+		56, 74, 100, 132, 174, 192,
+	},
+
+	// 24 kHz
+	{
+		0, 4, 8, 12, 18, 26, 36, 48,
+		62, 80, 104, 136, 180, 192,
+	},
+
+	// 16 kHz
+	{
+		0, 4, 8, 12, 18, 26, 36, 48,
+		62, 80, 104, 134, 174, 192,
+	},
+
+	// 11.025 kHz
+	{
+		0, 4, 8, 12, 18, 26, 36, 48,
+		62, 80, 104, 134, 174, 192,
+	},
+
+	// 12 kHz
+	{
+		0, 4, 8, 12, 18, 26, 36, 48,
+		62, 80, 104, 134, 174, 192,
+	},
+
+	// 8 kHz
+	{
+		0, 8, 16, 24, 36, 52, 72, 96,
+		124, 160, 162, 164, 166, 192,
+	},
 }
 
 // linbits is indexed with the value of the table_select field, and it tells the
@@ -103,6 +103,15 @@ var id3v1Genres = []string{
 	"Hard Rock",
 }
 
+// Genre returns the name of the ID3v1 genre numbered n, or "" if there is
+// none.
+func Genre(n int) string {
+	if n < 0 || n >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[n]
+}
+
 // ID3v2.2 and ID3v2.3 use "(NN)" where as ID3v2.4 simply uses "NN" when
 // referring to ID3v1 genres. The "(NN)" format is allowed to have trailing
 // information.
@@ -42,15 +42,21 @@ type ID3v2Header struct {
 type File struct {
 	Header ID3v2Header
 
-	Name   string
-	Artist string
-	Album  string
-	Year   string
-	Track  string
-	Disc   string
-	Genre  string
-	Length string
-	Image  *Image
+	Name        string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Composer    string
+	Year        string
+	Track       string
+	Disc        string
+	Genre       string
+	Comment     string
+	Compilation string
+	Length      string
+	Image       *Image
+	// User defined text frames by description.
+	Extended map[string]string
 }
 
 type Image struct {
@@ -44,6 +44,23 @@ func parseID3v22File(reader *bufio.Reader, file *File) {
 			file.Disc = readString(reader, size)
 		case "TCO":
 			file.Genre = readGenre(reader, size)
+		case "TP2":
+			file.AlbumArtist = readString(reader, size)
+		case "TCM":
+			file.Composer = readString(reader, size)
+		case "TCP":
+			file.Compilation = readString(reader, size)
+		case "COM":
+			if c := readComment(reader, size); file.Comment == "" {
+				file.Comment = c
+			}
+		case "TXX":
+			if d, v := readUserText(reader, size); d != "" {
+				if file.Extended == nil {
+					file.Extended = make(map[string]string)
+				}
+				file.Extended[d] = v
+			}
 		default:
 			skipBytes(reader, size)
 		}
@@ -53,6 +53,23 @@ func parseID3v23File(reader *bufio.Reader, file *File) {
 			file.Length = readString(reader, size)
 		case "APIC":
 			file.Image = readImage(reader, size)
+		case "TPE2":
+			file.AlbumArtist = readString(reader, size)
+		case "TCOM":
+			file.Composer = readString(reader, size)
+		case "TCMP":
+			file.Compilation = readString(reader, size)
+		case "COMM":
+			if c := readComment(reader, size); file.Comment == "" {
+				file.Comment = c
+			}
+		case "TXXX":
+			if d, v := readUserText(reader, size); d != "" {
+				if file.Extended == nil {
+					file.Extended = make(map[string]string)
+				}
+				file.Extended[d] = v
+			}
 		default:
 			skipBytes(reader, size)
 		}
@@ -186,6 +186,80 @@ func readImage(reader *bufio.Reader, c int) *Image {
 	return &img
 }
 
+// Parses a comment frame, skipping its language and description. Comments
+// that iTunes uses to store data, like iTunNORM, are ignored.
+//
+// Refer to section 4.10 of http://id3.org/id3v2.4.0-frames
+func readComment(reader *bufio.Reader, c int) string {
+	b := readBytes(reader, c)
+	if len(b) < 4 {
+		return ""
+	}
+	enc := b[0]
+	if enc == 2 {
+		// UTF-16BE is unsupported by parseString.
+		return ""
+	}
+	b = b[4:]
+	term := []byte{0}
+	if enc == 1 {
+		term = []byte{0, 0}
+	}
+	i := 0
+	for ; i+len(term) <= len(b); i += len(term) {
+		if bytes.Equal(b[i:i+len(term)], term) {
+			break
+		}
+	}
+	if i+len(term) > len(b) {
+		return ""
+	}
+	desc, text := b[:i], b[i+len(term):]
+	if bytes.Contains(desc, []byte("iTun")) || bytes.Contains(desc, []byte("i\x00T\x00u\x00n")) {
+		return ""
+	}
+	if enc == 1 && (len(text) < 2 || text[0] != 0xff || text[1] != 0xfe) {
+		// parseString only supports little endian UTF-16.
+		return ""
+	}
+	return parseString(append([]byte{enc}, text...))
+}
+
+// Parses a user defined text frame into its description and value.
+//
+// Refer to section 4.2.6 of http://id3.org/id3v2.4.0-frames
+func readUserText(reader *bufio.Reader, c int) (desc, value string) {
+	b := readBytes(reader, c)
+	if len(b) < 2 || b[0] == 2 {
+		return "", ""
+	}
+	enc := b[0]
+	b = b[1:]
+	term := []byte{0}
+	if enc == 1 {
+		term = []byte{0, 0}
+	}
+	i := 0
+	for ; i+len(term) <= len(b); i += len(term) {
+		if bytes.Equal(b[i:i+len(term)], term) {
+			break
+		}
+	}
+	if i+len(term) > len(b) {
+		return "", ""
+	}
+	d, v := b[:i], b[i+len(term):]
+	if enc == 1 {
+		// parseString only supports little endian UTF-16.
+		for _, p := range [][]byte{d, v} {
+			if len(p) < 2 || p[0] != 0xff || p[1] != 0xfe {
+				return "", ""
+			}
+		}
+	}
+	return parseString(append([]byte{enc}, d...)), parseString(append([]byte{enc}, v...))
+}
+
 func skipBytes(reader *bufio.Reader, c int) {
 	io.CopyN(ioutil.Discard, reader, int64(c))
 }
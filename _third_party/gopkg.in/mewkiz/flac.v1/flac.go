@@ -205,7 +205,19 @@ func (stream *Stream) Next() (f *frame.Frame, err error) {
 // ParseNext parses the entire next frame including audio samples. It returns
 // io.EOF to signal a graceful end of FLAC stream.
 func (stream *Stream) ParseNext() (f *frame.Frame, err error) {
-	return frame.Parse(stream.r)
+	f, err = frame.New(stream.r)
+	if err != nil {
+		return f, err
+	}
+	// Frame headers may defer to the StreamInfo for the sample size and rate.
+	if f.BitsPerSample == 0 {
+		f.BitsPerSample = stream.Info.BitsPerSample
+	}
+	if f.SampleRate == 0 {
+		f.SampleRate = stream.Info.SampleRate
+	}
+	err = f.Parse()
+	return f, err
 }
 
 // TODO(u): Implement a Seek method.
@@ -102,6 +102,11 @@ func Parse(r io.Reader) (frame *Frame, err error) {
 func (frame *Frame) Parse() error {
 	// Parse subframes.
 	frame.Subframes = make([]*Subframe, frame.Channels.Count())
+	if frame.BitsPerSample == 32 && frame.Channels >= ChannelsLeftSide {
+		// The side channel of 32-bit samples needs 33 bits, which overflows
+		// the int32 samples of a subframe.
+		return errors.New("frame.Frame.Parse: inter-channel decorrelation of 32 bits-per-sample is not supported")
+	}
 	var err error
 	for channel := range frame.Subframes {
 		// The side channel requires an extra bit per sample when using
@@ -302,7 +307,7 @@ func (frame *Frame) parseHeader() error {
 	//    100: 16 bits-per-sample.
 	//    101: 20 bits-per-sample.
 	//    110: 24 bits-per-sample.
-	//    111: reserved.
+	//    111: 32 bits-per-sample.
 	switch x {
 	case 0x0:
 		// 000: unknown bits-per-sample; get from StreamInfo.
@@ -321,9 +326,11 @@ func (frame *Frame) parseHeader() error {
 	case 0x6:
 		// 110: 24 bits-per-sample.
 		frame.BitsPerSample = 24
+	case 0x7:
+		// 111: 32 bits-per-sample.
+		frame.BitsPerSample = 32
 	default:
 		// 011: reserved.
-		// 111: reserved.
 		return fmt.Errorf("frame.Frame.parseHeader: reserved sample size bit pattern (%03b)", x)
 	}
 
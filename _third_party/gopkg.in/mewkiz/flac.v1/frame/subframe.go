@@ -35,6 +35,13 @@ func (frame *Frame) parseSubframe(bps uint) (subframe *Subframe, err error) {
 		return subframe, err
 	}
 
+	// Wasted bits are zero bits at the end of every sample, which are not
+	// stored.
+	if subframe.Wasted >= bps {
+		return subframe, fmt.Errorf("frame.Frame.parseSubframe: wasted bits (%d) exceed bits-per-sample (%d)", subframe.Wasted, bps)
+	}
+	bps -= subframe.Wasted
+
 	// Decode subframe audio samples.
 	subframe.NSamples = int(frame.BlockSize)
 	subframe.Samples = make([]int32, 0, subframe.NSamples)
@@ -48,6 +55,11 @@ func (frame *Frame) parseSubframe(bps uint) (subframe *Subframe, err error) {
 	case PredFIR:
 		err = subframe.decodeFIR(frame.br, bps)
 	}
+	if subframe.Wasted != 0 {
+		for i := range subframe.Samples {
+			subframe.Samples[i] <<= subframe.Wasted
+		}
+	}
 	return subframe, err
 }
 
@@ -60,6 +72,8 @@ type SubHeader struct {
 	Pred Pred
 	// Prediction order used by fixed and FIR linear prediction decoding.
 	Order int
+	// Number of wasted bits-per-sample.
+	Wasted uint
 }
 
 // parseHeader reads and parses the header of a subframe.
@@ -128,12 +142,12 @@ func (subframe *Subframe) parseHeader(br *bits.Reader) error {
 		return unexpected(err)
 	}
 	if x != 0 {
-		// The number of wasted bits-per-sample is unary coded.
-		_, err = br.ReadUnary()
+		// The number of wasted bits-per-sample is unary coded, minus one.
+		k, err := br.ReadUnary()
 		if err != nil {
 			return unexpected(err)
 		}
-		panic("Never seen a FLAC file contain wasted-bits-per-sample before. Not really a reason to panic, but I want to dissect one of those files. Please send it to me :)")
+		subframe.Wasted = uint(k) + 1
 	}
 
 	return nil
@@ -368,17 +382,16 @@ func (subframe *Subframe) decodeRicePart(br *bits.Reader, paramSize uint) error
 	// ref: https://www.xiph.org/flac/format.html#rice_partition
 	// ref: https://www.xiph.org/flac/format.html#rice2_partition
 	nparts := 1 << partOrder
+	if subframe.NSamples%nparts != 0 {
+		return fmt.Errorf("frame.Subframe.decodeRicePart: block size (%d) not divisible by number of partitions (%d)", subframe.NSamples, nparts)
+	}
 	for i := 0; i < nparts; i++ {
 		// (4 or 5) bits: Rice parameter.
 		x, err = br.Read(paramSize)
 		if err != nil {
 			return unexpected(err)
 		}
-		if paramSize == 4 && x == 0xF || paramSize == 5 && x == 0x1F {
-			// 1111 or 11111: Escape code, meaning the partition is in unencoded
-			// binary form using n bits per sample; n follows as a 5-bit number.
-			panic("not yet implemented; Rice parameter escape code.")
-		}
+		escape := paramSize == 4 && x == 0xF || paramSize == 5 && x == 0x1F
 		param := uint(x)
 
 		// Determine the number of Rice encoded samples in the partition.
@@ -390,6 +403,31 @@ func (subframe *Subframe) decodeRicePart(br *bits.Reader, paramSize uint) error
 		} else {
 			nsamples = subframe.NSamples/nparts - subframe.Order
 		}
+		if nsamples < 0 {
+			return errors.New("frame.Subframe.decodeRicePart: invalid partition order")
+		}
+
+		if escape {
+			// 1111 or 11111: Escape code, meaning the partition is in unencoded
+			// binary form using n bits per sample; n follows as a 5-bit number.
+			x, err = br.Read(5)
+			if err != nil {
+				return unexpected(err)
+			}
+			n := uint(x)
+			for j := 0; j < nsamples; j++ {
+				var residual int32
+				if n != 0 {
+					x, err = br.Read(n)
+					if err != nil {
+						return unexpected(err)
+					}
+					residual = signExtend(x, n)
+				}
+				subframe.Samples = append(subframe.Samples, residual)
+			}
+			continue
+		}
 
 		// Decode the Rice encoded residuals of the partition.
 		for j := 0; j < nsamples; j++ {
@@ -433,7 +471,7 @@ func (subframe *Subframe) decodeLPC(coeffs []int32, shift int32) error {
 		return fmt.Errorf("frame.Subframe.decodeLPC: prediction order (%d) differs from number of coefficients (%d)", subframe.Order, len(coeffs))
 	}
 	if shift < 0 {
-		panic("not yet implemented; negative shift.")
+		return fmt.Errorf("frame.Subframe.decodeLPC: invalid negative shift (%d)", shift)
 	}
 	for i := subframe.Order; i < subframe.NSamples; i++ {
 		var sample int64
@@ -0,0 +1,178 @@
+package aac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+	"github.com/mjibson/mog/codec/mp4"
+)
+
+const testFile = "testdata/aac.aac"
+
+func readFile(t *testing.T) []byte {
+	b, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decode(t *testing.T, s codec.Song) []float32 {
+	sr, ch, err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr != 44100 || ch != 2 {
+		t.Fatalf("got %d Hz, %d channels", sr, ch)
+	}
+	return codectest.PlayAll(t, s, 4096)
+}
+
+func equal(t *testing.T, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMagic(t *testing.T) {
+	// MPEG-4 and MPEG-2 headers, with and without CRC.
+	for _, magic := range []string{"\xff\xf1", "\xff\xf0", "\xff\xf9", "\xff\xf8"} {
+		b := append([]byte(magic), make([]byte, 100)...)
+		if _, name, err := codec.Decode(codectest.Reader(b)); err != nil || name != "AAC" {
+			t.Errorf("%q: got %q, %v", magic, name, err)
+		}
+	}
+}
+
+func TestADTS(t *testing.T) {
+	songs, _ := NewADTS(codectest.Reader(readFile(t)))
+	s := songs[0]
+	b := decode(t, s)
+	if n := len(b) / 2; n != 147*frameLen {
+		t.Fatalf("got %d frames", n)
+	}
+	var peak, sum float64
+	for _, v := range b {
+		peak = math.Max(peak, math.Abs(float64(v)))
+		sum += float64(v) * float64(v)
+	}
+	if rms := math.Sqrt(sum / float64(len(b))); peak > 1.1 || rms < 0.1 {
+		t.Fatalf("peak %v, rms %v", peak, rms)
+	}
+	// Seeking decodes the frame before the target, which makes the output
+	// the same as decoding from the start.
+	for _, frame := range []int{44100, 1000, 0, 100000, 147*frameLen - 1} {
+		// Round up, since seeking rounds down to a frame.
+		offset := (time.Duration(frame)*time.Second + 44099) / 44100
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		equal(t, codectest.PlayAll(t, s, 4096), b[frame*2:])
+	}
+}
+
+// box returns an MP4 box of type typ holding the concatenation of data.
+func box(typ string, data ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func u32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.BigEndian.PutUint32(b[i*4:], x)
+	}
+	return b
+}
+
+// muxMP4 returns an MP4 file with the raw AAC frames of the ADTS stream b,
+// and an edit list that presents the samples from skip to length samples
+// later.
+func muxMP4(b []byte, skip, length uint32) []byte {
+	br := bufio.NewReader(bytes.NewReader(b))
+	var off int64
+	var frames [][]byte
+	var h adtsHeader
+	for {
+		fh, f, err := nextFrame(br, &off)
+		if err != nil {
+			break
+		}
+		h = fh
+		frames = append(frames, f[fh.headerSize:])
+	}
+	asc := h.config()
+	esds := append(u32(0), 3, byte(3+17+len(asc)+3), 0, 1, 0)
+	esds = append(esds, 4, byte(13+2+len(asc)), 0x40, 0x15, 0, 0, 0)
+	esds = append(esds, u32(0, 0)...)
+	esds = append(append(esds, 5, byte(len(asc))), asc...)
+	esds = append(esds, 6, 1, 2)
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:], 1)
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	binary.BigEndian.PutUint32(entry[24:], 44100<<16)
+	sizes := u32(0, 0, uint32(len(frames)))
+	var mdat []byte
+	for _, f := range frames {
+		sizes = append(sizes, u32(uint32(len(f)))...)
+		mdat = append(mdat, f...)
+	}
+	ftyp := box("ftyp", []byte("M4A "), u32(0))
+	build := func(chunk uint32) []byte {
+		stbl := box("stbl",
+			box("stsd", u32(0, 1), box("mp4a", entry, box("esds", esds))),
+			box("stts", u32(0, 1, uint32(len(frames)), frameLen)),
+			box("stsc", u32(0, 1, 1, uint32(len(frames)), 1)),
+			box("stsz", sizes),
+			box("stco", u32(0, 1, chunk)),
+		)
+		mdia := box("mdia",
+			box("mdhd", u32(0, 0, 0, 44100, uint32(len(frames)*frameLen), 0)),
+			box("hdlr", u32(0, 0), []byte("soun"), make([]byte, 13)),
+			box("minf", stbl),
+		)
+		edts := box("edts", box("elst", u32(0, 1, length, skip, 1<<16)))
+		mvhd := box("mvhd", u32(0, 0, 0, 44100), make([]byte, 84))
+		return box("moov", mvhd, box("trak", edts, mdia))
+	}
+	moov := build(0)
+	moov = build(uint32(len(ftyp) + len(moov) + 8))
+	return append(append(ftyp, moov...), box("mdat", mdat)...)
+}
+
+func TestMP4(t *testing.T) {
+	b := readFile(t)
+	songs, _ := NewADTS(codectest.Reader(b))
+	want := decode(t, songs[0])
+	const skip, length = 2112, 100000
+	m := muxMP4(b, skip, length)
+	songs, err := mp4.New(codectest.Reader(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := songs[0]
+	equal(t, decode(t, s), want[skip*2:(skip+length)*2])
+	if err := s.(codec.Seeker).Seek(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, codectest.PlayAll(t, s, 4096), want[(skip+44100)*2:(skip+length)*2])
+}
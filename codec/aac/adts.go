@@ -0,0 +1,319 @@
+package aac
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mjibson/mog/_third_party/github.com/mjibson/id3"
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	// ADTS headers start with a 12-bit syncword, the MPEG version, a layer
+	// of 0 and the CRC flag. Only the first magic has the extension, so
+	// that files starting with an ID3 tag still match by extension.
+	for i, magic := range []string{"\xff\xf1", "\xff\xf0", "\xff\xf9", "\xff\xf8"} {
+		var exts []string
+		if i == 0 {
+			exts = []string{"aac"}
+		}
+		codec.RegisterCodec("AAC", magic, exts, NewADTS)
+	}
+}
+
+func NewADTS(rf codec.Reader) ([]codec.Song, error) {
+	a := ADTS{
+		Reader: rf,
+	}
+	return []codec.Song{&a}, nil
+}
+
+// ADTS is a stream of AAC frames with ADTS headers.
+type ADTS struct {
+	Reader codec.Reader
+	r      io.ReadCloser
+	br     *bufio.Reader
+	d      *Decoder
+	// off is the byte offset of br.
+	off int64
+	// index holds the byte offset of each frame. It is built on the first
+	// seek.
+	index []int64
+	// buf holds decoded, interleaved samples not yet played.
+	buf []float32
+	eof bool
+}
+
+// adtsHeader is the fixed and variable parts of an ADTS header.
+type adtsHeader struct {
+	objectType, rateIndex, channelConfig int
+	// size is the frame length including the header.
+	size int
+	// headerSize includes the CRC.
+	headerSize int
+	blocks     int
+}
+
+func parseADTS(b []byte) (h adtsHeader, ok bool) {
+	if len(b) < 7 || b[0] != 0xff || b[1]&0xf6 != 0xf0 {
+		return h, false
+	}
+	h.objectType = int(b[2]>>6) + 1
+	h.rateIndex = int(b[2] >> 2 & 0xf)
+	h.channelConfig = int(b[2]&1)<<2 | int(b[3]>>6)
+	h.size = int(b[3]&3)<<11 | int(b[4])<<3 | int(b[5]>>5)
+	h.blocks = int(b[6]&3) + 1
+	h.headerSize = 7
+	if b[1]&1 == 0 {
+		h.headerSize += 2
+	}
+	if h.rateIndex >= len(sampleRates) || h.size <= h.headerSize {
+		return h, false
+	}
+	return h, true
+}
+
+// config returns the AudioSpecificConfig equivalent to h.
+func (h adtsHeader) config() []byte {
+	return []byte{
+		byte(h.objectType<<3 | h.rateIndex>>1),
+		byte(h.rateIndex<<7 | h.channelConfig<<3),
+	}
+}
+
+// skipID3 skips an ID3v2 tag at the start of r, if present, and returns the
+// number of bytes skipped.
+func skipID3(r *bufio.Reader) (int64, error) {
+	b, err := r.Peek(10)
+	if err != nil || string(b[:3]) != "ID3" {
+		return 0, nil
+	}
+	n := int64(b[6]&0x7f)<<21 | int64(b[7]&0x7f)<<14 | int64(b[8]&0x7f)<<7 | int64(b[9]&0x7f)
+	n += 10
+	if b[5]&0x10 != 0 {
+		// Footer present.
+		n += 10
+	}
+	if _, err := r.Discard(int(n)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// nextFrame returns the next frame and its header, resynchronizing past
+// garbage.
+func nextFrame(br *bufio.Reader, off *int64) (adtsHeader, []byte, error) {
+	for {
+		b, err := br.Peek(7)
+		if err != nil {
+			return adtsHeader{}, nil, io.EOF
+		}
+		h, ok := parseADTS(b)
+		if !ok {
+			br.Discard(1)
+			*off++
+			continue
+		}
+		frame := make([]byte, h.size)
+		n, err := io.ReadFull(br, frame)
+		*off += int64(n)
+		if err != nil {
+			return adtsHeader{}, nil, io.EOF
+		}
+		return h, frame, nil
+	}
+}
+
+func (a *ADTS) Init() (sampleRate, channels int, err error) {
+	if a.d == nil {
+		r, _, err := a.Reader()
+		if err != nil {
+			return 0, 0, err
+		}
+		br := bufio.NewReader(r)
+		off, err := skipID3(br)
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		b, err := br.Peek(7)
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		h, ok := parseADTS(b)
+		if !ok {
+			r.Close()
+			return 0, 0, codec.ErrFormat
+		}
+		if h.channelConfig == 0 {
+			r.Close()
+			return 0, 0, fmt.Errorf("aac: program config elements unsupported")
+		}
+		d, err := NewDecoder(h.config())
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		a.r = r
+		a.br = br
+		a.off = off
+		a.d = d
+		a.buf = nil
+		a.eof = false
+	}
+	return a.d.SampleRate(), a.d.Channels(), nil
+}
+
+func (a *ADTS) Info() (info codec.SongInfo, err error) {
+	r, size, err := a.Reader()
+	if err != nil {
+		return
+	}
+	if f := id3.Read(r); f != nil {
+		codec.FillID3(&info, f)
+	}
+	r.Close()
+	r, _, err = a.Reader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	var off int64
+	if off, err = skipID3(br); err != nil {
+		return
+	}
+	tag := off
+	h, frame, err := nextFrame(br, &off)
+	if err != nil {
+		return info, codec.ErrFormat
+	}
+	rate := time.Duration(sampleRates[h.rateIndex])
+	info.Codec = "AAC"
+	info.SampleRate = sampleRates[h.rateIndex]
+	info.Channels = h.channelConfig
+	if h.channelConfig == 7 {
+		// 7.1 surround.
+		info.Channels = 8
+	}
+	info.Size = size
+	if _, ok := r.(io.Seeker); ok {
+		// Count the frames.
+		n := 1
+		for {
+			if _, _, err := nextFrame(br, &off); err != nil {
+				break
+			}
+			n++
+		}
+		info.Time = time.Duration(n*frameLen) * time.Second / rate
+	} else if size > 0 {
+		// Reading the whole stream would be too slow, so estimate from the
+		// first frame.
+		info.Time = time.Duration(size/int64(len(frame))*frameLen) * time.Second / rate
+	}
+	info.Bitrate = codec.Bitrate(size-tag, info.Time)
+	return info, nil
+}
+
+// fill decodes the next frame into buf.
+func (a *ADTS) fill() error {
+	h, frame, err := nextFrame(a.br, &a.off)
+	if err != nil {
+		a.eof = true
+		return nil
+	}
+	out, err := a.d.Decode(frame[h.headerSize:])
+	if err != nil || h.blocks != 1 {
+		// Replace undecodable frames with silence to keep the timing.
+		out = make([]float32, frameLen*h.blocks*a.d.Channels())
+	}
+	a.buf = append(a.buf, out...)
+	return nil
+}
+
+func (a *ADTS) Play(n int) ([]float32, error) {
+	for len(a.buf) < n && !a.eof {
+		if err := a.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if n > len(a.buf) {
+		n = len(a.buf)
+	}
+	ret := make([]float32, n)
+	copy(ret, a.buf)
+	a.buf = a.buf[n:]
+	return ret, nil
+}
+
+// Seek seeks to offset using an index of frame offsets, built by scanning
+// the stream on the first call.
+func (a *ADTS) Seek(offset time.Duration) error {
+	if a.d == nil {
+		return fmt.Errorf("aac: seek before init")
+	}
+	if a.index == nil {
+		r, _, err := a.Reader()
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReader(r)
+		off, err := skipID3(br)
+		for err == nil {
+			var h adtsHeader
+			if h, _, err = nextFrame(br, &off); err == nil {
+				a.index = append(a.index, off-int64(h.size))
+			}
+		}
+		r.Close()
+		if len(a.index) == 0 {
+			return fmt.Errorf("aac: no frames found")
+		}
+	}
+	target := int(int64(offset) * int64(a.d.SampleRate()) / int64(time.Second))
+	frame := target / frameLen
+	if frame >= len(a.index) {
+		frame = len(a.index) - 1
+		target = frame * frameLen
+	}
+	// The frame before the target primes the overlap.
+	start := frame - 1
+	if start < 0 {
+		start = 0
+	}
+	r, err := codec.OpenAt(a.Reader, a.index[start])
+	if err != nil {
+		return err
+	}
+	a.r.Close()
+	a.r = r
+	a.br = bufio.NewReader(r)
+	a.off = a.index[start]
+	a.d.Reset()
+	a.buf = a.buf[:0]
+	a.eof = false
+	for i := start; i <= frame && !a.eof; i++ {
+		a.buf = a.buf[:0]
+		if err := a.fill(); err != nil {
+			return err
+		}
+	}
+	skip := (target - frame*frameLen) * a.d.Channels()
+	if skip < len(a.buf) {
+		a.buf = a.buf[skip:]
+	} else {
+		a.buf = a.buf[:0]
+	}
+	return nil
+}
+
+func (a *ADTS) Close() {
+	if a.r != nil {
+		a.r.Close()
+	}
+	a.r, a.br, a.d, a.buf = nil, nil, nil, nil
+}
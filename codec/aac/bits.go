@@ -0,0 +1,96 @@
+package aac
+
+// bitReader reads bits most significant first. Reading past the end
+// returns zeros and sets overrun.
+type bitReader struct {
+	b       []byte
+	pos     int
+	overrun bool
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		v <<= 1
+		if r.pos>>3 < len(r.b) {
+			v |= uint32(r.b[r.pos>>3]>>(7-uint(r.pos&7))) & 1
+		} else {
+			r.overrun = true
+		}
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) readBool() bool {
+	return r.read(1) == 1
+}
+
+// left returns the number of unread bits.
+func (r *bitReader) left() int {
+	return len(r.b)*8 - r.pos
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.b)*8 {
+		r.overrun = true
+	}
+}
+
+func (r *bitReader) byteAlign() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// huffman is a decoding tree. Each node holds its two children; leaves are
+// stored as the complement of their symbol.
+type huffman [][2]int32
+
+func newHuffman(codes []uint32, bits []uint8) huffman {
+	h := huffman{{0, 0}}
+	for sym, code := range codes {
+		n := int32(0)
+		for i := int(bits[sym]) - 1; i >= 0; i-- {
+			b := (code >> uint(i)) & 1
+			if i == 0 {
+				h[n][b] = ^int32(sym)
+				break
+			}
+			if h[n][b] == 0 {
+				h = append(h, [2]int32{0, 0})
+				h[n][b] = int32(len(h) - 1)
+			}
+			n = h[n][b]
+		}
+	}
+	return h
+}
+
+// decode reads one symbol, returning -1 on an invalid code.
+func (h huffman) decode(r *bitReader) int {
+	n := int32(0)
+	for {
+		n = h[n][r.read(1)]
+		if n < 0 {
+			return int(^n)
+		}
+		if n == 0 || r.overrun {
+			return -1
+		}
+	}
+}
+
+var (
+	sfHuffman       = newHuffman(sfCodes, sfBits)
+	spectralHuffman [12]huffman
+)
+
+func init() {
+	for i := 1; i < len(spectralHuffman); i++ {
+		spectralHuffman[i] = newHuffman(spectralCodes[i], spectralBits[i])
+	}
+}
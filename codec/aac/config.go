@@ -0,0 +1,230 @@
+package aac
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errConfig = errors.New("aac: bad audio specific config")
+
+const (
+	aotLC  = 2
+	aotSBR = 5
+	aotPS  = 29
+)
+
+var sampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000,
+	11025, 8000, 7350,
+}
+
+// config is an AudioSpecificConfig.
+type config struct {
+	objectType int
+	// rateIndex indexes sampleRates. It is the nearest rate for explicit
+	// rates.
+	rateIndex  int
+	sampleRate int
+	// channelConfig is the channel configuration; 0 means the channels are
+	// given by pce.
+	channelConfig int
+	channels      int
+	pce           *pce
+}
+
+// parseConfig parses an AudioSpecificConfig. Only the AAC core of SBR and
+// PS streams is decoded.
+func parseConfig(b []byte) (*config, error) {
+	r := newBitReader(b)
+	c := new(config)
+	objectType := func() int {
+		t := int(r.read(5))
+		if t == 31 {
+			t = 32 + int(r.read(6))
+		}
+		return t
+	}
+	rate := func() (int, int) {
+		i := int(r.read(4))
+		if i == 15 {
+			sr := int(r.read(24))
+			return nearestRate(sr), sr
+		}
+		if i >= len(sampleRates) {
+			return -1, 0
+		}
+		return i, sampleRates[i]
+	}
+	c.objectType = objectType()
+	c.rateIndex, c.sampleRate = rate()
+	c.channelConfig = int(r.read(4))
+	if c.objectType == aotSBR || c.objectType == aotPS {
+		rate()
+		c.objectType = objectType()
+	}
+	if c.rateIndex < 0 || r.overrun {
+		return nil, errConfig
+	}
+	if c.objectType != aotLC {
+		return nil, fmt.Errorf("aac: unsupported object type %d", c.objectType)
+	}
+	if r.readBool() {
+		return nil, fmt.Errorf("aac: 960 sample frames unsupported")
+	}
+	if r.readBool() {
+		// coreCoderDelay
+		r.read(14)
+	}
+	// extensionFlag
+	r.read(1)
+	switch {
+	case c.channelConfig == 0:
+		p, err := readPCE(r)
+		if err != nil {
+			return nil, err
+		}
+		c.pce = p
+		c.channels = p.channels
+	case c.channelConfig < len(configChannels):
+		c.channels = configChannels[c.channelConfig]
+	default:
+		return nil, fmt.Errorf("aac: unsupported channel configuration %d", c.channelConfig)
+	}
+	if r.overrun || c.channels == 0 {
+		return nil, errConfig
+	}
+	return c, nil
+}
+
+func nearestRate(sr int) int {
+	best := 0
+	for i, r := range sampleRates {
+		if d, bd := r-sr, sampleRates[best]-sr; d*d < bd*bd {
+			best = i
+		}
+	}
+	return best
+}
+
+// configChannels is the channel count of each channel configuration.
+var configChannels = []int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 6: 6, 7: 8}
+
+// channelOrder maps the element order of each channel configuration to WAV
+// order.
+var channelOrder = [...][]int{
+	3: {1, 2, 0},
+	4: {1, 2, 0, 3},
+	5: {1, 2, 0, 3, 4},
+	6: {1, 2, 0, 5, 3, 4},
+	7: {1, 2, 0, 7, 5, 6, 3, 4},
+}
+
+// pce is a program config element.
+type pce struct {
+	channels int
+}
+
+func readPCE(r *bitReader) (*pce, error) {
+	p := new(pce)
+	// element_instance_tag, object_type, sampling_frequency_index
+	r.read(4 + 2 + 4)
+	front := int(r.read(4))
+	side := int(r.read(4))
+	back := int(r.read(4))
+	lfe := int(r.read(2))
+	assoc := int(r.read(3))
+	cc := int(r.read(4))
+	if r.readBool() {
+		// mono_mixdown_element_number
+		r.read(4)
+	}
+	if r.readBool() {
+		// stereo_mixdown_element_number
+		r.read(4)
+	}
+	if r.readBool() {
+		// matrix_mixdown_idx and pseudo_surround_enable
+		r.read(3)
+	}
+	for i := 0; i < front+side+back; i++ {
+		if r.readBool() {
+			p.channels += 2
+		} else {
+			p.channels++
+		}
+		r.read(4)
+	}
+	p.channels += lfe
+	r.skip(4*lfe + 4*assoc + 5*cc)
+	r.byteAlign()
+	r.skip(8 * int(r.read(8)))
+	if r.overrun {
+		return nil, errConfig
+	}
+	return p, nil
+}
+
+// parseESDS returns the decoder specific info of an MPEG-4 audio
+// elementary stream descriptor box.
+func parseESDS(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errConfig
+	}
+	b = b[4:]
+	// descriptor returns the tag and body of the first descriptor in b.
+	descriptor := func(b []byte) (tag byte, body []byte, err error) {
+		if len(b) < 2 {
+			return 0, nil, errConfig
+		}
+		tag = b[0]
+		n := 0
+		i := 1
+		for ; i < len(b) && i < 5; i++ {
+			n = n<<7 | int(b[i]&0x7f)
+			if b[i]&0x80 == 0 {
+				break
+			}
+		}
+		i++
+		if i+n > len(b) {
+			return 0, nil, errConfig
+		}
+		return tag, b[i : i+n], nil
+	}
+	tag, es, err := descriptor(b)
+	if err != nil || tag != 3 || len(es) < 3 {
+		return nil, errConfig
+	}
+	flags := es[2]
+	es = es[3:]
+	// Skip dependsOn_ES_ID, the URL and OCR_ES_Id.
+	n := 0
+	if flags&0x80 != 0 {
+		n += 2
+	}
+	if flags&0x40 != 0 {
+		if len(es) <= n {
+			return nil, errConfig
+		}
+		n += 1 + int(es[n])
+	}
+	if flags&0x20 != 0 {
+		n += 2
+	}
+	if len(es) < n {
+		return nil, errConfig
+	}
+	es = es[n:]
+	tag, dc, err := descriptor(es)
+	if err != nil || tag != 4 || len(dc) < 13 {
+		return nil, errConfig
+	}
+	if oti := dc[0]; oti != 0x40 && (oti < 0x66 || oti > 0x68) {
+		return nil, fmt.Errorf("aac: unsupported object type indication %#x", oti)
+	}
+	tag, dsi, err := descriptor(dc[13:])
+	if err != nil || tag != 5 {
+		return nil, errConfig
+	}
+	return dsi, nil
+}
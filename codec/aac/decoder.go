@@ -0,0 +1,273 @@
+package aac
+
+import (
+	"errors"
+	"math"
+
+	"github.com/mjibson/mog/codec/mdct"
+)
+
+// Syntactic element IDs.
+const (
+	idSCE = iota
+	idCPE
+	idCCE
+	idLFE
+	idDSE
+	idPCE
+	idFIL
+	idEND
+)
+
+// frameLen is the number of samples per channel in a frame.
+const frameLen = 1024
+
+// Decoder decodes raw AAC-LC frames.
+type Decoder struct {
+	cfg   *config
+	chans []channel
+	info  [2]icsInfo
+	ics   [2]ics
+	// msUsed is indexed by group then band.
+	msUsed      [8][maxBands]bool
+	msPresent   int
+	long, short *mdct.MDCT
+	buf         [2048]float32
+	// out holds each channel's output in element order.
+	out  [][]float32
+	seed uint32
+}
+
+// NewDecoder returns a decoder for the stream described by the
+// AudioSpecificConfig asc.
+func NewDecoder(asc []byte) (*Decoder, error) {
+	cfg, err := parseConfig(asc)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decoder{
+		cfg:   cfg,
+		chans: make([]channel, cfg.channels),
+		long:  mdct.New(2048),
+		short: mdct.New(256),
+		out:   make([][]float32, cfg.channels),
+		seed:  1,
+	}
+	for i := range d.ics {
+		d.ics[i].info = &d.info[i]
+	}
+	for i := range d.out {
+		d.out[i] = make([]float32, frameLen)
+	}
+	return d, nil
+}
+
+// SampleRate returns the output sample rate.
+func (d *Decoder) SampleRate() int {
+	return d.cfg.sampleRate
+}
+
+// Channels returns the number of output channels.
+func (d *Decoder) Channels() int {
+	return d.cfg.channels
+}
+
+// Reset clears the overlap between frames, as before a seek.
+func (d *Decoder) Reset() {
+	for i := range d.chans {
+		d.chans[i].reset()
+	}
+}
+
+// Decode decodes one raw_data_block into interleaved samples in WAV
+// channel order.
+func (d *Decoder) Decode(frame []byte) ([]float32, error) {
+	r := newBitReader(frame)
+	n := 0
+loop:
+	for {
+		id := r.read(3)
+		if r.overrun {
+			return nil, errData
+		}
+		switch id {
+		case idSCE, idLFE:
+			r.read(4)
+			if n >= len(d.chans) {
+				return nil, errData
+			}
+			s := &d.ics[0]
+			if err := d.readICS(r, s, false); err != nil {
+				return nil, err
+			}
+			d.noise(s, nil)
+			s.applyTNS()
+			d.synthesize(&d.chans[n], s, d.out[n])
+			n++
+		case idCPE:
+			r.read(4)
+			if n+1 >= len(d.chans) {
+				return nil, errData
+			}
+			if err := d.readCPE(r); err != nil {
+				return nil, err
+			}
+			for i := range d.ics {
+				d.ics[i].applyTNS()
+				d.synthesize(&d.chans[n], &d.ics[i], d.out[n])
+				n++
+			}
+		case idDSE:
+			r.read(4)
+			align := r.readBool()
+			count := int(r.read(8))
+			if count == 255 {
+				count += int(r.read(8))
+			}
+			if align {
+				r.byteAlign()
+			}
+			r.skip(8 * count)
+		case idPCE:
+			if _, err := readPCE(r); err != nil {
+				return nil, err
+			}
+		case idFIL:
+			count := int(r.read(4))
+			if count == 15 {
+				count += int(r.read(8)) - 1
+			}
+			// Extension payloads like SBR are ignored.
+			r.skip(8 * count)
+		case idEND:
+			break loop
+		default:
+			return nil, errors.New("aac: coupling channel elements unsupported")
+		}
+		if r.overrun {
+			return nil, errData
+		}
+	}
+	if n != len(d.chans) {
+		return nil, errData
+	}
+	var order []int
+	if d.cfg.channelConfig < len(channelOrder) {
+		order = channelOrder[d.cfg.channelConfig]
+	}
+	ret := make([]float32, frameLen*n)
+	for ch := 0; ch < n; ch++ {
+		src := d.out[ch]
+		if order != nil {
+			src = d.out[order[ch]]
+		}
+		for i, s := range src {
+			ret[i*n+ch] = s / 32768
+		}
+	}
+	return ret, nil
+}
+
+// readCPE reads a channel_pair_element into ics and applies the stereo
+// tools.
+func (d *Decoder) readCPE(r *bitReader) error {
+	common := r.readBool()
+	d.msPresent = 0
+	if common {
+		if err := d.readICSInfo(r, &d.info[0]); err != nil {
+			return err
+		}
+		groups := append(d.info[1].groups[:0], d.info[0].groups...)
+		d.info[1] = d.info[0]
+		d.info[1].groups = groups
+		d.msPresent = int(r.read(2))
+		for g := range d.info[0].groups {
+			for k := 0; k < d.info[0].maxSFB; k++ {
+				d.msUsed[g][k] = d.msPresent == 2 || d.msPresent == 1 && r.readBool()
+			}
+		}
+		if d.msPresent == 3 {
+			return errData
+		}
+	}
+	for i := range d.ics {
+		if err := d.readICS(r, &d.ics[i], common); err != nil {
+			return err
+		}
+	}
+	l, rt := &d.ics[0], &d.ics[1]
+	d.noise(l, nil)
+	d.noise(rt, l)
+	if !common {
+		return nil
+	}
+	info := l.info
+	wlen := info.windowLen()
+	win := 0
+	for g, n := range info.groups {
+		for k := 0; k < info.maxSFB; k++ {
+			lb, rb := l.bandType[g][k], rt.bandType[g][k]
+			switch {
+			case rb == intensityHCB || rb == intensityHCB2:
+				scale := float32(math.Pow(0.5, float64(rt.sf[g][k])/4))
+				if rb == intensityHCB2 {
+					scale = -scale
+				}
+				if d.msPresent == 1 && d.msUsed[g][k] {
+					scale = -scale
+				}
+				for w := win; w < win+n; w++ {
+					for i := w*wlen + info.swb[k]; i < w*wlen+info.swb[k+1]; i++ {
+						rt.spec[i] = l.spec[i] * scale
+					}
+				}
+			case d.msUsed[g][k] && lb != noiseHCB && rb != noiseHCB:
+				for w := win; w < win+n; w++ {
+					for i := w*wlen + info.swb[k]; i < w*wlen+info.swb[k+1]; i++ {
+						m, s := l.spec[i], rt.spec[i]
+						l.spec[i], rt.spec[i] = m+s, m-s
+					}
+				}
+			}
+		}
+		win += n
+	}
+	return nil
+}
+
+// noise fills the noise bands of s. If pair is the left channel of a
+// channel pair, bands that are noise in both with M/S set share its noise.
+func (d *Decoder) noise(s, pair *ics) {
+	info := s.info
+	wlen := info.windowLen()
+	win := 0
+	for g, n := range info.groups {
+		for k := 0; k < info.maxSFB; k++ {
+			if s.bandType[g][k] != noiseHCB {
+				continue
+			}
+			shared := pair != nil && d.msPresent != 0 && d.msUsed[g][k] && pair.bandType[g][k] == noiseHCB
+			for w := win; w < win+n; w++ {
+				band := s.spec[w*wlen+info.swb[k] : w*wlen+info.swb[k+1]]
+				if shared {
+					scale := float32(math.Pow(2, float64(s.sf[g][k]-pair.sf[g][k])/4))
+					for i := range band {
+						band[i] = pair.spec[w*wlen+info.swb[k]+i] * scale
+					}
+					continue
+				}
+				energy := float32(0)
+				for i := range band {
+					d.seed = d.seed*1664525 + 1013904223
+					band[i] = float32(int32(d.seed))
+					energy += band[i] * band[i]
+				}
+				scale := float32(math.Pow(2, float64(s.sf[g][k])/4) / math.Sqrt(float64(energy)))
+				for i := range band {
+					band[i] *= scale
+				}
+			}
+		}
+		win += n
+	}
+}
@@ -0,0 +1,124 @@
+package aac
+
+import "math"
+
+// Window shapes.
+const (
+	sineWindow = iota
+	kbdWindow
+)
+
+// longWindows and shortWindows hold the rising half of each window shape.
+var longWindows, shortWindows [2][]float32
+
+func init() {
+	longWindows = [2][]float32{sine(1024), kbd(1024, 4)}
+	shortWindows = [2][]float32{sine(128), kbd(128, 6)}
+}
+
+func sine(n int) []float32 {
+	w := make([]float32, n)
+	for i := range w {
+		w[i] = float32(math.Sin(math.Pi / float64(2*n) * (float64(i) + 0.5)))
+	}
+	return w
+}
+
+// kbd returns a Kaiser-Bessel derived window.
+func kbd(n int, alpha float64) []float32 {
+	a2 := (alpha * math.Pi / float64(n)) * (alpha * math.Pi / float64(n))
+	cum := make([]float64, n)
+	sum := 0.0
+	for i := range cum {
+		// Sum the series for the zeroth order modified Bessel function.
+		x := float64(i*(n-i)) * a2
+		b := 1.0
+		for j := 50; j > 0; j-- {
+			b = b*x/float64(j*j) + 1
+		}
+		sum += b
+		cum[i] = sum
+	}
+	sum++
+	w := make([]float32, n)
+	for i := range w {
+		w[i] = float32(math.Sqrt(cum[i] / sum))
+	}
+	return w
+}
+
+// channel holds the filterbank state of one output channel.
+type channel struct {
+	overlap [1024]float32
+	// shape is the window shape of the previous frame.
+	shape int
+}
+
+func (c *channel) reset() {
+	c.overlap = [1024]float32{}
+	c.shape = sineWindow
+}
+
+// synthesize transforms the spectrum of s to 1024 samples of c in out.
+func (d *Decoder) synthesize(c *channel, s *ics, out []float32) {
+	info := s.info
+	buf := &d.buf
+	prev, cur := c.shape, info.windowShape
+	if info.windowSequence == eightShort {
+		var tmp [256]float32
+		*buf = [2048]float32{}
+		for w := 0; w < 8; w++ {
+			d.short.Inverse(s.spec[w*128:(w+1)*128], tmp[:])
+			left := shortWindows[cur]
+			if w == 0 {
+				left = shortWindows[prev]
+			}
+			right := shortWindows[cur]
+			b := buf[448+w*128:]
+			for i := 0; i < 128; i++ {
+				b[i] += tmp[i] * left[i] / 128
+				b[128+i] += tmp[128+i] * right[127-i] / 128
+			}
+		}
+	} else {
+		d.long.Inverse(s.spec[:], buf[:])
+		for i := range buf {
+			buf[i] /= 1024
+		}
+		switch info.windowSequence {
+		case longStop:
+			w := shortWindows[prev]
+			for i := 0; i < 448; i++ {
+				buf[i] = 0
+			}
+			for i := 0; i < 128; i++ {
+				buf[448+i] *= w[i]
+			}
+		default:
+			w := longWindows[prev]
+			for i := 0; i < 1024; i++ {
+				buf[i] *= w[i]
+			}
+		}
+		switch info.windowSequence {
+		case longStart:
+			w := shortWindows[cur]
+			for i := 0; i < 128; i++ {
+				buf[1024+448+i] *= w[127-i]
+			}
+			for i := 1024 + 576; i < 2048; i++ {
+				buf[i] = 0
+			}
+		default:
+			w := longWindows[cur]
+			for i := 0; i < 1024; i++ {
+				buf[1024+i] *= w[1023-i]
+			}
+		}
+	}
+	for i := range out {
+		out[i] = buf[i] + c.overlap[i]
+	}
+	copy(c.overlap[:], buf[1024:])
+	c.shape = cur
+}
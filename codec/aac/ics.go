@@ -0,0 +1,449 @@
+package aac
+
+import (
+	"errors"
+	"math"
+)
+
+var errData = errors.New("aac: bad frame")
+
+// Window sequences.
+const (
+	onlyLong = iota
+	longStart
+	eightShort
+	longStop
+)
+
+// Band types.
+const (
+	zeroHCB       = 0
+	escHCB        = 11
+	noiseHCB      = 13
+	intensityHCB2 = 14
+	intensityHCB  = 15
+)
+
+// icsInfo describes the windows of a channel stream.
+type icsInfo struct {
+	windowSequence int
+	windowShape    int
+	maxSFB         int
+	// groups holds the number of windows in each window group.
+	groups []int
+	// swb holds the band offsets within a window.
+	swb []int
+	// tnsMaxBands is the highest band TNS may filter.
+	tnsMaxBands int
+}
+
+func (d *Decoder) readICSInfo(r *bitReader, info *icsInfo) error {
+	// ics_reserved_bit
+	r.read(1)
+	info.windowSequence = int(r.read(2))
+	info.windowShape = int(r.read(1))
+	info.groups = info.groups[:0]
+	if info.windowSequence == eightShort {
+		info.maxSFB = int(r.read(4))
+		grouping := r.read(7)
+		info.groups = append(info.groups, 1)
+		for i := uint(0); i < 7; i++ {
+			if grouping&(1<<(6-i)) != 0 {
+				info.groups[len(info.groups)-1]++
+			} else {
+				info.groups = append(info.groups, 1)
+			}
+		}
+		info.swb = swbShort[d.cfg.rateIndex]
+		info.tnsMaxBands = tnsMaxBandsShort[d.cfg.rateIndex]
+	} else {
+		info.maxSFB = int(r.read(6))
+		if r.readBool() {
+			return errors.New("aac: prediction unsupported")
+		}
+		info.groups = append(info.groups, 1)
+		info.swb = swbLong[d.cfg.rateIndex]
+		info.tnsMaxBands = tnsMaxBandsLong[d.cfg.rateIndex]
+	}
+	if info.maxSFB > len(info.swb)-1 {
+		return errData
+	}
+	return nil
+}
+
+// windows returns the number of windows.
+func (info *icsInfo) windows() int {
+	if info.windowSequence == eightShort {
+		return 8
+	}
+	return 1
+}
+
+// windowLen returns the number of coefficients per window.
+func (info *icsInfo) windowLen() int {
+	if info.windowSequence == eightShort {
+		return 128
+	}
+	return 1024
+}
+
+// maxBands is the most scalefactor bands a window can have.
+const maxBands = 51
+
+type tnsFilter struct {
+	length, order int
+	backward      bool
+	lpc           [21]float64
+}
+
+// ics is an individual channel stream.
+type ics struct {
+	info *icsInfo
+	// bandType and sf are indexed by group then band.
+	bandType [8][maxBands]int
+	sf       [8][maxBands]int
+	tns      [8][]tnsFilter
+	// spec holds the dequantized spectrum. Short windows are stored one
+	// after another.
+	spec [1024]float32
+}
+
+// readICS reads an individual_channel_stream. If commonWindow is set, info
+// was already read by the channel pair element.
+func (d *Decoder) readICS(r *bitReader, s *ics, commonWindow bool) error {
+	globalGain := int(r.read(8))
+	if !commonWindow {
+		if err := d.readICSInfo(r, s.info); err != nil {
+			return err
+		}
+	}
+	info := s.info
+	if err := s.readSections(r); err != nil {
+		return err
+	}
+	if err := s.readScalefactors(r, globalGain); err != nil {
+		return err
+	}
+	var pulses [][2]int
+	if r.readBool() {
+		if info.windowSequence == eightShort {
+			return errData
+		}
+		n := int(r.read(2)) + 1
+		start := int(r.read(6))
+		if start >= len(info.swb)-1 {
+			return errData
+		}
+		k := info.swb[start]
+		for i := 0; i < n; i++ {
+			k += int(r.read(5))
+			pulses = append(pulses, [2]int{k, int(r.read(4))})
+		}
+	}
+	for w := range s.tns {
+		s.tns[w] = s.tns[w][:0]
+	}
+	if r.readBool() {
+		if err := s.readTNS(r); err != nil {
+			return err
+		}
+	}
+	if r.readBool() {
+		return errors.New("aac: gain control unsupported")
+	}
+	if err := s.readSpectrum(r, pulses); err != nil {
+		return err
+	}
+	if r.overrun {
+		return errData
+	}
+	return nil
+}
+
+func (s *ics) readSections(r *bitReader) error {
+	info := s.info
+	bits, esc := uint(5), uint32(31)
+	if info.windowSequence == eightShort {
+		bits, esc = 3, 7
+	}
+	for g := range info.groups {
+		for k := 0; k < info.maxSFB; {
+			cb := int(r.read(4))
+			if cb == 12 {
+				return errData
+			}
+			n := 0
+			for {
+				inc := r.read(bits)
+				n += int(inc)
+				if inc != esc || r.overrun {
+					break
+				}
+			}
+			if k+n > info.maxSFB || r.overrun {
+				return errData
+			}
+			for end := k + n; k < end; k++ {
+				s.bandType[g][k] = cb
+			}
+			if n == 0 {
+				// A zero length section would never end.
+				return errData
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ics) readScalefactors(r *bitReader, globalGain int) error {
+	info := s.info
+	sf, noise, intensity := globalGain, globalGain-90, 0
+	noiseFirst := true
+	for g := range info.groups {
+		for k := 0; k < info.maxSFB; k++ {
+			switch s.bandType[g][k] {
+			case zeroHCB:
+				s.sf[g][k] = 0
+			case intensityHCB, intensityHCB2:
+				intensity += sfHuffman.decode(r) - 60
+				s.sf[g][k] = intensity
+			case noiseHCB:
+				if noiseFirst {
+					noiseFirst = false
+					noise += int(r.read(9)) - 256
+				} else {
+					noise += sfHuffman.decode(r) - 60
+				}
+				s.sf[g][k] = noise
+			default:
+				sf += sfHuffman.decode(r) - 60
+				if sf < 0 || sf > 255 {
+					return errData
+				}
+				s.sf[g][k] = sf
+			}
+		}
+	}
+	if r.overrun {
+		return errData
+	}
+	return nil
+}
+
+func (s *ics) readTNS(r *bitReader) error {
+	info := s.info
+	short := info.windowSequence == eightShort
+	nBits, lenBits, orderBits := uint(2), uint(6), uint(5)
+	if short {
+		nBits, lenBits, orderBits = 1, 4, 3
+	}
+	var tmp [20]float64
+	for w := 0; w < info.windows(); w++ {
+		n := int(r.read(nBits))
+		if n == 0 {
+			continue
+		}
+		res := uint(3 + r.read(1))
+		for i := 0; i < n; i++ {
+			f := tnsFilter{
+				length: int(r.read(lenBits)),
+				order:  int(r.read(orderBits)),
+			}
+			if f.order > len(tmp) {
+				return errData
+			}
+			if f.order > 0 {
+				f.backward = r.readBool()
+				bits := res - uint(r.read(1))
+				iq := (float64(int(1)<<(res-1)) - 0.5) / (math.Pi / 2)
+				iqm := (float64(int(1)<<(res-1)) + 0.5) / (math.Pi / 2)
+				for j := 0; j < f.order; j++ {
+					c := int(r.read(bits))
+					if c >= 1<<(bits-1) {
+						c -= 1 << bits
+					}
+					if c >= 0 {
+						tmp[j] = math.Sin(float64(c) / iq)
+					} else {
+						tmp[j] = math.Sin(float64(c) / iqm)
+					}
+				}
+				// Convert the reflection coefficients to LPC coefficients.
+				var b [21]float64
+				f.lpc[0] = 1
+				for m := 1; m <= f.order; m++ {
+					for i := 1; i < m; i++ {
+						b[i] = f.lpc[i] + tmp[m-1]*f.lpc[m-i]
+					}
+					for i := 1; i < m; i++ {
+						f.lpc[i] = b[i]
+					}
+					f.lpc[m] = tmp[m-1]
+				}
+			}
+			s.tns[w] = append(s.tns[w], f)
+		}
+	}
+	return nil
+}
+
+// pow43 returns |q|^(4/3) with the sign of q.
+func pow43(q int) float32 {
+	if q < 0 {
+		return -float32(math.Pow(float64(-q), 4.0/3))
+	}
+	return float32(math.Pow(float64(q), 4.0/3))
+}
+
+// readSpectrum reads and dequantizes the spectral data, applying pulses.
+// Noise and intensity bands are left zero.
+func (s *ics) readSpectrum(r *bitReader, pulses [][2]int) error {
+	info := s.info
+	var q [1024]int
+	wlen := info.windowLen()
+	win := 0
+	for g, n := range info.groups {
+		for k := 0; k < info.maxSFB; k++ {
+			cb := s.bandType[g][k]
+			if cb == zeroHCB || cb >= noiseHCB {
+				continue
+			}
+			h := spectralHuffman[cb]
+			for w := win; w < win+n; w++ {
+				base := w * wlen
+				for i := info.swb[k]; i < info.swb[k+1]; {
+					sym := h.decode(r)
+					if sym < 0 {
+						return errData
+					}
+					var v [4]int
+					dim := 2
+					switch cb {
+					case 1, 2:
+						v = [4]int{sym/27 - 1, sym/9%3 - 1, sym/3%3 - 1, sym%3 - 1}
+						dim = 4
+					case 3, 4:
+						v = [4]int{sym / 27, sym / 9 % 3, sym / 3 % 3, sym % 3}
+						dim = 4
+					case 5, 6:
+						v = [4]int{sym/9 - 4, sym%9 - 4}
+					case 7, 8:
+						v = [4]int{sym / 8, sym % 8}
+					case 9, 10:
+						v = [4]int{sym / 13, sym % 13}
+					default:
+						v = [4]int{sym / 17, sym % 17}
+					}
+					if cb != 1 && cb != 2 && cb != 5 && cb != 6 {
+						for j := 0; j < dim; j++ {
+							if v[j] != 0 && r.readBool() {
+								v[j] = -v[j]
+							}
+						}
+					}
+					if cb == escHCB {
+						for j := 0; j < 2; j++ {
+							if v[j] != 16 && v[j] != -16 {
+								continue
+							}
+							e := uint(4)
+							for r.readBool() {
+								e++
+								if e > 12 || r.overrun {
+									return errData
+								}
+							}
+							m := 1<<e + int(r.read(e))
+							if v[j] < 0 {
+								m = -m
+							}
+							v[j] = m
+						}
+					}
+					for j := 0; j < dim; j++ {
+						q[base+i+j] = v[j]
+					}
+					i += dim
+				}
+			}
+		}
+		win += n
+	}
+	for _, p := range pulses {
+		if p[0] >= len(q) {
+			return errData
+		}
+		if q[p[0]] > 0 {
+			q[p[0]] += p[1]
+		} else {
+			q[p[0]] -= p[1]
+		}
+	}
+	for i := range s.spec {
+		s.spec[i] = 0
+	}
+	win = 0
+	for g, n := range info.groups {
+		for k := 0; k < info.maxSFB; k++ {
+			cb := s.bandType[g][k]
+			if cb == zeroHCB || cb >= noiseHCB {
+				continue
+			}
+			scale := float32(math.Pow(2, float64(s.sf[g][k]-100)/4))
+			for w := win; w < win+n; w++ {
+				for i := info.swb[k]; i < info.swb[k+1]; i++ {
+					if v := q[w*wlen+i]; v != 0 {
+						s.spec[w*wlen+i] = pow43(v) * scale
+					}
+				}
+			}
+		}
+		win += n
+	}
+	return nil
+}
+
+// applyTNS runs the temporal noise shaping filters over the spectrum.
+func (s *ics) applyTNS() {
+	info := s.info
+	wlen := info.windowLen()
+	bands := len(info.swb) - 1
+	for w := 0; w < info.windows(); w++ {
+		top := bands
+		for _, f := range s.tns[w] {
+			bottom := top - f.length
+			if bottom < 0 {
+				bottom = 0
+			}
+			clamp := func(b int) int {
+				if b > info.tnsMaxBands {
+					b = info.tnsMaxBands
+				}
+				if b > info.maxSFB {
+					b = info.maxSFB
+				}
+				return info.swb[b]
+			}
+			start, end := clamp(bottom), clamp(top)
+			top = bottom
+			if f.order == 0 || end <= start {
+				continue
+			}
+			spec := s.spec[w*wlen : (w+1)*wlen]
+			var state [20]float64
+			i, inc := start, 1
+			if f.backward {
+				i, inc = end-1, -1
+			}
+			for m := start; m < end; m, i = m+1, i+inc {
+				y := float64(spec[i])
+				for j := 0; j < f.order; j++ {
+					y -= state[j] * f.lpc[j+1]
+				}
+				copy(state[1:f.order], state[:f.order-1])
+				state[0] = y
+				spec[i] = float32(y)
+			}
+		}
+	}
+}
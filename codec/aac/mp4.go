@@ -0,0 +1,28 @@
+package aac
+
+import (
+	"fmt"
+
+	"github.com/mjibson/mog/codec/mp4"
+)
+
+func init() {
+	// Each frame overlaps the one before it.
+	mp4.RegisterDecoder("mp4a", 1, newMP4Decoder)
+}
+
+func newMP4Decoder(t *mp4.Track) (mp4.Decoder, int, int, error) {
+	esds, ok := t.Entry["esds"]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("aac: missing esds")
+	}
+	asc, err := parseESDS(esds)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	d, err := NewDecoder(asc)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return d, d.SampleRate(), d.Channels(), nil
+}
@@ -0,0 +1,315 @@
+// Package alac decodes Apple Lossless audio in MP4 files.
+package alac
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/mjibson/mog/codec/mp4"
+)
+
+func init() {
+	// Frames are independent, so no preroll is needed.
+	mp4.RegisterDecoder("alac", 0, newMP4Decoder)
+}
+
+func newMP4Decoder(t *mp4.Track) (mp4.Decoder, int, int, error) {
+	cookie, ok := t.Entry["alac"]
+	if !ok || len(cookie) < 4 {
+		return nil, 0, 0, fmt.Errorf("alac: missing decoder config")
+	}
+	// Skip the version and flags of the full box.
+	d, err := NewDecoder(cookie[4:])
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return d, d.SampleRate(), d.Channels(), nil
+}
+
+var (
+	errConfig = errors.New("alac: bad decoder config")
+	errData   = errors.New("alac: bad frame")
+)
+
+// config is an ALACSpecificConfig.
+type config struct {
+	frameLength uint32
+	bitDepth    uint
+	pb, mb, kb  uint32
+	channels    int
+	maxRun      uint32
+	sampleRate  int
+}
+
+// Syntactic element IDs.
+const (
+	idSCE = iota
+	idCPE
+	idCCE
+	idLFE
+	idDSE
+	idPCE
+	idFIL
+	idEND
+)
+
+// channelOrder maps the element order of the default channel layouts to
+// WAV order.
+var channelOrder = [...][]int{
+	3: {1, 2, 0},
+	4: {1, 2, 0, 3},
+	5: {1, 2, 0, 3, 4},
+	6: {1, 2, 0, 5, 3, 4},
+	7: {1, 2, 0, 6, 5, 3, 4},
+	8: {3, 4, 0, 7, 5, 6, 1, 2},
+}
+
+// Decoder decodes ALAC frames.
+type Decoder struct {
+	cfg        config
+	predictor  []int32
+	mixU, mixV []int32
+	shift      []uint32
+	// out holds each channel in element order.
+	out [][]int32
+}
+
+// NewDecoder returns a decoder for the magic cookie, which is an
+// ALACSpecificConfig optionally preceded by QuickTime atoms.
+func NewDecoder(cookie []byte) (*Decoder, error) {
+	if len(cookie) >= 12 && string(cookie[4:8]) == "frma" {
+		cookie = cookie[12:]
+	}
+	if len(cookie) >= 12 && string(cookie[4:8]) == "alac" {
+		cookie = cookie[12:]
+	}
+	if len(cookie) < 24 {
+		return nil, errConfig
+	}
+	c := config{
+		frameLength: binary.BigEndian.Uint32(cookie),
+		bitDepth:    uint(cookie[5]),
+		pb:          uint32(cookie[6]),
+		mb:          uint32(cookie[7]),
+		kb:          uint32(cookie[8]),
+		channels:    int(cookie[9]),
+		maxRun:      uint32(binary.BigEndian.Uint16(cookie[10:])),
+		sampleRate:  int(binary.BigEndian.Uint32(cookie[20:])),
+	}
+	switch {
+	case cookie[4] != 0:
+		return nil, fmt.Errorf("alac: unsupported version %d", cookie[4])
+	case c.bitDepth != 16 && c.bitDepth != 20 && c.bitDepth != 24 && c.bitDepth != 32:
+		return nil, fmt.Errorf("alac: unsupported bit depth %d", c.bitDepth)
+	case c.channels < 1 || c.channels > 8 || c.frameLength == 0 || c.frameLength > 1<<16 || c.sampleRate == 0:
+		return nil, errConfig
+	}
+	n := int(c.frameLength)
+	d := &Decoder{
+		cfg:       c,
+		predictor: make([]int32, n),
+		mixU:      make([]int32, n),
+		mixV:      make([]int32, n),
+		shift:     make([]uint32, 2*n),
+		out:       make([][]int32, c.channels),
+	}
+	for i := range d.out {
+		d.out[i] = make([]int32, n)
+	}
+	return d, nil
+}
+
+// SampleRate returns the output sample rate.
+func (d *Decoder) SampleRate() int {
+	return d.cfg.sampleRate
+}
+
+// Channels returns the number of output channels.
+func (d *Decoder) Channels() int {
+	return d.cfg.channels
+}
+
+// Reset does nothing; frames are independent.
+func (d *Decoder) Reset() {}
+
+// Decode decodes one frame into interleaved samples in WAV channel order.
+func (d *Decoder) Decode(frame []byte) ([]float32, error) {
+	r := &bitReader{b: frame}
+	ch := 0
+	samples := -1
+loop:
+	for {
+		id := r.read(3)
+		if r.overrun {
+			return nil, errData
+		}
+		switch id {
+		case idSCE, idLFE, idCPE:
+			n := 1
+			if id == idCPE {
+				n = 2
+			}
+			if ch+n > len(d.out) {
+				return nil, errData
+			}
+			got, err := d.readElement(r, n, d.out[ch:ch+n])
+			if err != nil {
+				return nil, err
+			}
+			if samples >= 0 && got != samples {
+				return nil, errData
+			}
+			samples = got
+			ch += n
+		case idDSE:
+			r.read(4)
+			align := r.read(1) == 1
+			count := int(r.read(8))
+			if count == 255 {
+				count += int(r.read(8))
+			}
+			if align {
+				r.byteAlign()
+			}
+			r.skip(8 * count)
+		case idFIL:
+			count := int(r.read(4))
+			if count == 15 {
+				count += int(r.read(8)) - 1
+			}
+			r.skip(8 * count)
+		case idEND:
+			break loop
+		default:
+			return nil, fmt.Errorf("alac: unsupported element %d", id)
+		}
+		if r.overrun {
+			return nil, errData
+		}
+	}
+	if ch != len(d.out) || samples < 0 {
+		return nil, errData
+	}
+	var order []int
+	if len(d.out) < len(channelOrder) {
+		order = channelOrder[len(d.out)]
+	}
+	scale := 1 / float32(uint32(1)<<(d.cfg.bitDepth-1))
+	ret := make([]float32, samples*ch)
+	for c := 0; c < ch; c++ {
+		src := d.out[c]
+		if order != nil {
+			src = d.out[order[c]]
+		}
+		for i := 0; i < samples; i++ {
+			ret[i*ch+c] = float32(src[i]) * scale
+		}
+	}
+	return ret, nil
+}
+
+// readElement reads a single channel (n is 1) or channel pair (n is 2)
+// element into out, returning the number of samples.
+func (d *Decoder) readElement(r *bitReader, n int, out [][]int32) (int, error) {
+	// element_instance_tag
+	r.read(4)
+	if r.read(12) != 0 {
+		return 0, errData
+	}
+	partial := r.read(1) == 1
+	bytesShifted := uint(r.read(2))
+	escape := r.read(1) == 1
+	if bytesShifted == 3 {
+		return 0, errData
+	}
+	shift := bytesShifted * 8
+	samples := int(d.cfg.frameLength)
+	if partial {
+		samples = int(r.read(32))
+		if samples > int(d.cfg.frameLength) {
+			return 0, errData
+		}
+	}
+	mix := [2][]int32{d.mixU, d.mixV}
+	var mixBits uint
+	var mixRes int32
+	if !escape {
+		chanBits := d.cfg.bitDepth - shift + uint(n-1)
+		mixBits = uint(r.read(8))
+		mixRes = int32(int8(r.read(8)))
+		var params [2]struct {
+			mode, denShift, pbFactor uint32
+			coefs                    [32]int16
+			num                      int
+		}
+		for i := 0; i < n; i++ {
+			p := &params[i]
+			p.mode = r.read(4)
+			p.denShift = r.read(4)
+			p.pbFactor = r.read(3)
+			p.num = int(r.read(5))
+			for j := 0; j < p.num; j++ {
+				p.coefs[j] = int16(r.read(16))
+			}
+		}
+		shiftStart := r.pos
+		r.skip(int(shift) * n * samples)
+		for i := 0; i < n; i++ {
+			p := &params[i]
+			if err := d.decompress(r, samples, chanBits, d.cfg.pb*p.pbFactor/4); err != nil {
+				return 0, err
+			}
+			if p.mode != 0 {
+				unpredict(d.predictor, d.predictor, samples, nil, 31, chanBits, 0)
+			}
+			unpredict(d.predictor, mix[i], samples, p.coefs[:p.num], p.num, chanBits, uint(p.denShift))
+		}
+		if shift != 0 {
+			end := r.pos
+			r.pos = shiftStart
+			for i := 0; i < n*samples; i++ {
+				d.shift[i] = r.read(shift)
+			}
+			r.pos = end
+		}
+	} else {
+		// Uncompressed samples are interleaved.
+		bits := d.cfg.bitDepth
+		for i := 0; i < samples; i++ {
+			for c := 0; c < n; c++ {
+				mix[c][i] = int32(r.read(bits)<<(32-bits)) >> (32 - bits)
+			}
+		}
+		shift = 0
+	}
+	if r.overrun {
+		return 0, errData
+	}
+	if n == 1 {
+		for i := 0; i < samples; i++ {
+			v := d.mixU[i]
+			if shift != 0 {
+				v = v<<shift | int32(d.shift[i])
+			}
+			out[0][i] = v
+		}
+		return samples, nil
+	}
+	for i := 0; i < samples; i++ {
+		u, v := d.mixU[i], d.mixV[i]
+		var left, right int32
+		if mixRes != 0 {
+			left = u + v - (mixRes*v)>>mixBits
+			right = left - v
+		} else {
+			left, right = u, v
+		}
+		if shift != 0 {
+			left = left<<shift | int32(d.shift[2*i])
+			right = right<<shift | int32(d.shift[2*i+1])
+		}
+		out[0][i], out[1][i] = left, right
+	}
+	return samples, nil
+}
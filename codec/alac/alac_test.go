@@ -0,0 +1,69 @@
+package alac
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+	"github.com/mjibson/mog/codec/mp4"
+)
+
+// reference returns the samples of testdata/alac.wav, the source of
+// testdata/alac.m4a, a mono 16-bit file with a 44 byte header.
+func reference(t *testing.T) []float32 {
+	b, err := ioutil.ReadFile("testdata/alac.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = b[44:]
+	s := make([]float32, len(b)/2)
+	for i := range s {
+		s[i] = float32(int16(binary.LittleEndian.Uint16(b[i*2:]))) / (1 << 15)
+	}
+	return s
+}
+
+func equal(t *testing.T, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	want := reference(t)
+	songs, err := mp4.New(codectest.File("testdata/alac.m4a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := songs[0]
+	sr, ch, err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr != 11025 || ch != 1 {
+		t.Fatalf("got %d Hz, %d channels", sr, ch)
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BitDepth != 16 {
+		t.Fatalf("bit depth %d", info.BitDepth)
+	}
+	equal(t, codectest.PlayAll(t, s, 4096), want)
+	for _, offset := range []time.Duration{5 * time.Second, time.Second, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		equal(t, codectest.PlayAll(t, s, 4096), want[int(offset.Seconds()*11025):])
+	}
+}
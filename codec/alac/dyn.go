@@ -0,0 +1,201 @@
+package alac
+
+// Adaptive Golomb coding parameters.
+const (
+	qbShift     = 9
+	qb          = 1 << qbShift
+	mmulShift   = 2
+	mdenShift   = qbShift - mmulShift - 1
+	moff        = 1 << (mdenShift - 2)
+	bitOff      = 24
+	maxPrefix   = 9
+	maxMeanSize = 0xffff
+)
+
+// bitReader reads bits most significant first. Reading past the end
+// returns zeros and sets overrun.
+type bitReader struct {
+	b       []byte
+	pos     int
+	overrun bool
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		v <<= 1
+		if r.pos>>3 < len(r.b) {
+			v |= uint32(r.b[r.pos>>3]>>(7-uint(r.pos&7))) & 1
+		} else {
+			r.overrun = true
+		}
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.b)*8 {
+		r.overrun = true
+	}
+}
+
+func (r *bitReader) byteAlign() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// lead returns the number of leading zero bits in x.
+func lead(x uint32) uint32 {
+	n := uint32(0)
+	for ; n < 32 && x&0x80000000 == 0; n++ {
+		x <<= 1
+	}
+	return n
+}
+
+// golomb reads a value coded with an escape after maxPrefix ones to
+// escapeBits raw bits.
+func (r *bitReader) golomb(m, k uint32, escapeBits uint) uint32 {
+	q := uint32(0)
+	for q < maxPrefix && r.read(1) == 1 {
+		q++
+	}
+	if q == maxPrefix {
+		return r.read(escapeBits)
+	}
+	v := r.read(uint(k))
+	if v < 2 {
+		// Only k-1 bits were used.
+		r.pos--
+		return q * m
+	}
+	return q*m + v - 1
+}
+
+// decompress reads n residuals coded with adaptive Golomb codes into
+// d.predictor.
+func (d *Decoder) decompress(r *bitReader, n int, chanBits uint, pb uint32) error {
+	mb := d.cfg.mb
+	wb := uint32(1)<<d.cfg.kb - 1
+	zmode := uint32(0)
+	out := d.predictor[:n]
+	for c := 0; c < n; {
+		if r.overrun {
+			return errData
+		}
+		k := 31 - lead(mb>>qbShift+3)
+		if k > d.cfg.kb {
+			k = d.cfg.kb
+		}
+		m := uint32(1)<<k - 1
+		v := r.golomb(m, k, chanBits)
+		nd := v + zmode
+		del := int32((nd + 1) >> 1)
+		if nd&1 != 0 {
+			del = -del
+		}
+		out[c] = del
+		c++
+		mb = pb*(v+zmode) + mb - (pb*mb)>>qbShift
+		if v > maxMeanSize {
+			mb = maxMeanSize
+		}
+		zmode = 0
+		if mb<<mmulShift < qb && c < n {
+			zmode = 1
+			k := lead(mb) - bitOff + (mb+moff)>>mdenShift
+			mz := (uint32(1)<<k - 1) & wb
+			zeros := int(r.golomb(mz, k, 16))
+			if c+zeros > n {
+				return errData
+			}
+			for j := 0; j < zeros; j++ {
+				out[c] = 0
+				c++
+			}
+			if zeros >= 65535 {
+				zmode = 0
+			}
+			mb = 0
+		}
+	}
+	if r.overrun {
+		return errData
+	}
+	return nil
+}
+
+func sign(x int32) int32 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+// unpredict runs the adaptive FIR predictor over the residuals in pc,
+// writing samples to out. The coefficients adapt as they are used.
+func unpredict(pc, out []int32, n int, coefs []int16, active int, chanBits, denShift uint) {
+	chanShift := 32 - chanBits
+	ext := func(x int32) int32 {
+		return x << chanShift >> chanShift
+	}
+	if n == 0 {
+		return
+	}
+	out[0] = pc[0]
+	switch {
+	case active == 0:
+		copy(out[1:n], pc[1:n])
+		return
+	case active == 31:
+		prev := out[0]
+		for j := 1; j < n; j++ {
+			prev = ext(pc[j] + prev)
+			out[j] = prev
+		}
+		return
+	}
+	for j := 1; j <= active && j < n; j++ {
+		out[j] = ext(pc[j] + out[j-1])
+	}
+	denHalf := int32(1) << denShift >> 1
+	lim := active + 1
+	for j := lim; j < n; j++ {
+		top := out[j-lim]
+		var sum int32
+		for k := 0; k < active; k++ {
+			sum += int32(coefs[k]) * (out[j-1-k] - top)
+		}
+		del := pc[j]
+		del0 := del
+		sg := sign(del)
+		del += top + (sum+denHalf)>>denShift
+		out[j] = ext(del)
+		switch {
+		case sg > 0:
+			for k := active - 1; k >= 0; k-- {
+				dd := top - out[j-1-k]
+				s := sign(dd)
+				coefs[k] -= int16(s)
+				del0 -= int32(active-k) * ((s * dd) >> denShift)
+				if del0 <= 0 {
+					break
+				}
+			}
+		case sg < 0:
+			for k := active - 1; k >= 0; k-- {
+				dd := top - out[j-1-k]
+				s := sign(dd)
+				coefs[k] += int16(s)
+				del0 -= int32(active-k) * ((-s * dd) >> denShift)
+				if del0 >= 0 {
+					break
+				}
+			}
+		}
+	}
+}
@@ -6,8 +6,10 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 	"path/filepath"
 	"strings"
 )
@@ -24,7 +26,9 @@ type codec struct {
 // Codecs is the list of registered codecs.
 var codecs []codec
 
-var allExtensions = make(map[string]codec)
+// allExtensions maps an extension to the codecs that registered it, in
+// registration order.
+var allExtensions = make(map[string][]codec)
 
 // RegisterCodec registers an audio codec for use by Decode.
 // Name is the name of the format, like "nsf" or "wav".
@@ -39,7 +43,7 @@ func RegisterCodec(name, magic string, extensions []string, decode func(Reader)
 		decode:     decode,
 	}
 	for _, e := range extensions {
-		allExtensions[e] = c
+		allExtensions[e] = append(allExtensions[e], c)
 	}
 	codecs = append(codecs, c)
 }
@@ -71,8 +75,8 @@ func match(magic string, b []byte) bool {
 	return true
 }
 
-// Sniff determines the format of r's data.
-func sniff(r reader) codec {
+// Sniff determines the format of r's data from the given codecs.
+func sniff(r reader, codecs []codec) codec {
 	for _, f := range codecs {
 		b, err := r.Peek(len(f.magic))
 		if err == nil && match(f.magic, b) {
@@ -97,7 +101,7 @@ func Decode(rf Reader) ([]Song, string, error) {
 	}
 	defer r.Close()
 	rr := asReader(r)
-	f := sniff(rr)
+	f := sniff(rr, codecs)
 	if f.decode == nil {
 		return nil, "", ErrFormat
 	}
@@ -105,16 +109,85 @@ func Decode(rf Reader) ([]Song, string, error) {
 	return m, f.name, err
 }
 
-func ByExtension(path string, rf Reader) ([]Song, string, error) {
+// extension returns the extension of path that codecs register.
+func extension(path string) string {
 	ext := filepath.Ext(path)
 	ext = strings.Trim(ext, ".")
 	if ext == "" {
 		ext = path
 	}
-	c, ok := allExtensions[ext]
-	if !ok {
+	return ext
+}
+
+// KnownExtension returns whether a codec is registered for the extension of
+// path.
+func KnownExtension(path string) bool {
+	return len(allExtensions[extension(path)]) > 0
+}
+
+func ByExtension(path string, rf Reader) ([]Song, string, error) {
+	cs := allExtensions[extension(path)]
+	if len(cs) == 0 {
 		return nil, "", nil
 	}
+	c := cs[0]
+	for _, o := range cs[1:] {
+		if o.name == c.name {
+			continue
+		}
+		// Several formats share this extension, so look at the data, but
+		// keep to the first if it has no known magic, as the decoders may
+		// skip leading junk.
+		r, _, err := rf()
+		if err != nil {
+			return nil, "", err
+		}
+		if f := sniff(asReader(r), cs); f.decode != nil {
+			c = f
+		}
+		r.Close()
+		break
+	}
 	songs, err := c.decode(rf)
 	return songs, c.name, err
 }
+
+// OpenAt opens rf and advances it offset bytes. Readers that implement
+// io.Seeker are seeked directly; all others have their leading bytes
+// discarded.
+func OpenAt(rf Reader, offset int64) (io.ReadCloser, error) {
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(offset, 0)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, r, offset)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// HeaderBuffer keeps the bytes written to it until Stop is called. It is
+// used with io.TeeReader to keep the headers of a stream for parsing again
+// later, without keeping the rest of the stream.
+type HeaderBuffer struct {
+	bytes.Buffer
+	stopped bool
+}
+
+func (h *HeaderBuffer) Write(p []byte) (int, error) {
+	if h.stopped {
+		return len(p), nil
+	}
+	return h.Buffer.Write(p)
+}
+
+// Stop stops keeping written bytes.
+func (h *HeaderBuffer) Stop() {
+	h.stopped = true
+}
@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestHeaderBuffer(t *testing.T) {
+	h := new(HeaderBuffer)
+	r := io.TeeReader(bytes.NewReader(make([]byte, 1<<20)), h)
+	io.ReadFull(r, make([]byte, 100))
+	h.Stop()
+	if n, _ := io.Copy(ioutil.Discard, r); n != 1<<20-100 {
+		t.Fatalf("read %d bytes", n)
+	}
+	if h.Len() != 100 {
+		t.Fatalf("kept %d bytes, want 100", h.Len())
+	}
+}
+
+func TestSetReplayGain(t *testing.T) {
+	var si SongInfo
+	si.SetReplayGain("REPLAYGAIN_TRACK_PEAK", "0.5")
+	si.SetReplayGain("replaygain_album_peak", "0.9")
+	if si.TrackGain != nil || si.AlbumGain != nil {
+		t.Fatalf("peaks alone set gains: %+v, %+v", si.TrackGain, si.AlbumGain)
+	}
+	if !si.SetReplayGain("replaygain_track_gain", "-6.5 dB") {
+		t.Fatal("track gain not set")
+	}
+	if g := si.TrackGain; g == nil || *g != (Gain{DB: -6.5, Peak: 0.5}) {
+		t.Fatalf("track gain %+v", g)
+	}
+	// Opus gains are relative to -23 LUFS.
+	si.SetReplayGain("R128_ALBUM_GAIN", "-512")
+	si.SetReplayGain("replaygain_album_peak", "0.8")
+	if g := si.AlbumGain; g == nil || *g != (Gain{DB: 3, Peak: 0.8}) {
+		t.Fatalf("album gain %+v", g)
+	}
+	if si.SetReplayGain("replaygain_track_gain", "loud") || si.SetReplayGain("replaygain_reference_loudness", "89 dB") {
+		t.Fatal("bad tags accepted")
+	}
+}
+
+func TestByExtension(t *testing.T) {
+	defer func(c []codec, e map[string][]codec) {
+		codecs, allExtensions = c, e
+	}(codecs, allExtensions)
+	codecs, allExtensions = nil, make(map[string][]codec)
+	decoder := func(name string) func(Reader) ([]Song, error) {
+		return func(Reader) ([]Song, error) {
+			return nil, errors.New(name)
+		}
+	}
+	RegisterCodec("a", "AAAA", []string{"x", "y"}, decoder("a"))
+	RegisterCodec("a", "aaaa", []string{"x", "y"}, decoder("a"))
+	RegisterCodec("b", "BBBB", []string{"x"}, decoder("b"))
+	for _, c := range []struct {
+		path, data, want string
+		// read is whether the data is read to pick the codec.
+		read bool
+	}{
+		{"1.x", "BBBB", "b", true},
+		{"1.x", "aaaa", "a", true},
+		// Data without a known magic goes to the first codec.
+		{"1.x", "\x00\x00BBBB", "a", true},
+		// The data is not looked at if only one format has the extension.
+		{"1.y", "BBBB", "a", false},
+	} {
+		read := false
+		rf := func() (io.ReadCloser, int64, error) {
+			read = true
+			return ioutil.NopCloser(strings.NewReader(c.data)), int64(len(c.data)), nil
+		}
+		_, name, err := ByExtension(c.path, rf)
+		if name != c.want || err == nil || err.Error() != c.want || read != c.read {
+			t.Errorf("%s %q: got %q, %v, read %v, want %q, read %v", c.path, c.data, name, err, read, c.want, c.read)
+		}
+	}
+	if songs, name, err := ByExtension("1.z", nil); songs != nil || name != "" || err != nil {
+		t.Errorf("unknown extension: got %v, %q, %v", songs, name, err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package codectest provides helpers for testing codecs.
+package codectest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/mjibson/mog/codec"
+)
+
+// Reader returns a reader of b.
+func Reader(b []byte) codec.Reader {
+	return func() (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), int64(len(b)), nil
+	}
+}
+
+// File returns a reader of the file name.
+func File(name string) codec.Reader {
+	return func() (io.ReadCloser, int64, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+}
+
+// Open decodes r with decode, and returns its song numbered song from 0
+// after checking that it initializes to rate and channels, unless 0.
+func Open(t testing.TB, decode func(codec.Reader) ([]codec.Song, error), r codec.Reader, song, rate, channels int) codec.Song {
+	t.Helper()
+	songs, err := decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song >= len(songs) {
+		t.Fatalf("got %d songs", len(songs))
+	}
+	s := songs[song]
+	sr, ch, err := s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 0 && sr != rate || channels != 0 && ch != channels {
+		t.Fatalf("got %d Hz, %d channels, want %d Hz, %d channels", sr, ch, rate, channels)
+	}
+	return s
+}
+
+// PlayAll plays s to its end, n samples at a time. As the Song interface
+// has it, the end is when fewer than n are returned.
+func PlayAll(t testing.TB, s codec.Song, n int) []float32 {
+	t.Helper()
+	var b []float32
+	for {
+		p, err := s.Play(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, p...)
+		if len(p) < n {
+			return b
+		}
+	}
+}
+
+// RMS returns the root mean square of channel ch of the interleaved
+// samples b of channels channels.
+func RMS(b []float32, ch, channels int) float64 {
+	var sum float64
+	n := 0
+	for i := ch; i < len(b); i += channels {
+		sum += float64(b[i]) * float64(b[i])
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(sum / float64(n))
+}
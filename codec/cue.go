@@ -0,0 +1,446 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cue is a parsed CUE sheet.
+type Cue struct {
+	Title      string
+	Performer  string
+	Songwriter string
+	Genre      string
+	Date       string
+	// Disc and DiscTotal are from the REM DISCNUMBER and TOTALDISCS
+	// comments.
+	Disc, DiscTotal int
+	Files           []CueFile
+}
+
+// CueFile is an audio file of a CUE sheet and the tracks in it.
+type CueFile struct {
+	Name   string
+	Tracks []CueTrack
+}
+
+// CueTrack is a track of a CUE sheet. Indexes are in CD frames of 1/75
+// seconds from the start of the file; Index0 is -1 without a pregap.
+type CueTrack struct {
+	Num        int
+	Title      string
+	Performer  string
+	Songwriter string
+	Index0     int64
+	Index1     int64
+}
+
+// cueFields splits a CUE sheet line into fields. Quoted fields may contain
+// spaces.
+func cueFields(line string) []string {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			i := strings.IndexByte(line[1:], '"')
+			if i < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:i+1])
+			line = line[i+2:]
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:i])
+		line = line[i:]
+	}
+	return fields
+}
+
+// cueTime parses a CUE sheet time of the form mm:ss:ff into CD frames.
+func cueTime(s string) (int64, error) {
+	sp := strings.Split(s, ":")
+	if len(sp) != 3 {
+		return 0, fmt.Errorf("cue: bad time %q", s)
+	}
+	var t int64
+	for i, mul := range []int64{60, 75, 1} {
+		n, err := strconv.ParseInt(sp[i], 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("cue: bad time %q", s)
+		}
+		t = (t + n) * mul
+	}
+	return t, nil
+}
+
+// ParseCue parses a CUE sheet. Sheets that are not UTF-8 are read as
+// Latin-1.
+func ParseCue(r io.Reader) (*Cue, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(b) {
+		rs := make([]rune, len(b))
+		for i, c := range b {
+			rs[i] = rune(c)
+		}
+		b = []byte(string(rs))
+	}
+	c := new(Cue)
+	var track *CueTrack
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := cueFields(s.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch strings.ToUpper(f[0]) {
+		case "REM":
+			if len(f) < 3 {
+				break
+			}
+			switch strings.ToUpper(f[1]) {
+			case "GENRE":
+				c.Genre = f[2]
+			case "DATE":
+				c.Date = f[2]
+			case "DISCNUMBER":
+				c.Disc, _ = strconv.Atoi(f[2])
+			case "TOTALDISCS":
+				c.DiscTotal, _ = strconv.Atoi(f[2])
+			}
+		case "FILE":
+			c.Files = append(c.Files, CueFile{Name: f[1]})
+			track = nil
+		case "TRACK":
+			if len(c.Files) == 0 {
+				return nil, errors.New("cue: TRACK before FILE")
+			}
+			n, _ := strconv.Atoi(f[1])
+			cf := &c.Files[len(c.Files)-1]
+			cf.Tracks = append(cf.Tracks, CueTrack{Num: n, Index0: -1, Index1: -1})
+			track = &cf.Tracks[len(cf.Tracks)-1]
+			if len(f) > 2 && strings.ToUpper(f[2]) != "AUDIO" {
+				// Data tracks are dropped below.
+				track.Index1 = -2
+			}
+		case "TITLE", "PERFORMER":
+			title := strings.ToUpper(f[0]) == "TITLE"
+			switch {
+			case track != nil && title:
+				track.Title = f[1]
+			case track != nil:
+				track.Performer = f[1]
+			case title:
+				c.Title = f[1]
+			default:
+				c.Performer = f[1]
+			}
+		case "SONGWRITER":
+			if track != nil {
+				track.Songwriter = f[1]
+			} else {
+				c.Songwriter = f[1]
+			}
+		case "INDEX":
+			if track == nil || len(f) < 3 || track.Index1 == -2 {
+				break
+			}
+			t, err := cueTime(f[2])
+			if err != nil {
+				return nil, err
+			}
+			switch n, _ := strconv.Atoi(f[1]); n {
+			case 0:
+				track.Index0 = t
+			case 1:
+				track.Index1 = t
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	// Drop data tracks and tracks without a start.
+	for i := range c.Files {
+		cf := &c.Files[i]
+		tracks := cf.Tracks[:0]
+		for _, t := range cf.Tracks {
+			if t.Index1 >= 0 {
+				tracks = append(tracks, t)
+			}
+		}
+		cf.Tracks = tracks
+	}
+	return c, nil
+}
+
+// Track returns the track numbered num, or nil.
+func (c *Cue) Track(num int) *CueTrack {
+	for i := range c.Files {
+		for j := range c.Files[i].Tracks {
+			if t := &c.Files[i].Tracks[j]; t.Num == num {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// Info returns the song info of t, falling back to the sheet's fields.
+func (c *Cue) Info(t *CueTrack) SongInfo {
+	info := SongInfo{
+		Title:       t.Title,
+		Artist:      t.Performer,
+		Album:       c.Title,
+		AlbumArtist: c.Performer,
+		Composer:    t.Songwriter,
+		Genre:       c.Genre,
+		Date:        c.Date,
+		Track:       float64(t.Num),
+		Disc:        c.Disc,
+		DiscTotal:   c.DiscTotal,
+	}
+	if info.Artist == "" {
+		info.Artist = c.Performer
+	}
+	if info.Composer == "" {
+		info.Composer = c.Songwriter
+	}
+	return info
+}
+
+// Spans returns a Span for each track of f. newSong returns the song of the
+// whole file.
+func (c *Cue) Spans(f *CueFile, newSong func() (Song, error)) []Song {
+	var songs []Song
+	for i := range f.Tracks {
+		t := &f.Tracks[i]
+		s := &Span{
+			New:   newSong,
+			Start: t.Index1,
+			Rate:  75,
+			Meta:  c.Info(t),
+		}
+		if i+1 < len(f.Tracks) {
+			s.End = f.Tracks[i+1].Index1
+		}
+		songs = append(songs, s)
+	}
+	return songs
+}
+
+// Span is the part of a song between two positions, like a track of a CUE
+// sheet. Its boundaries are sample accurate.
+type Span struct {
+	// New returns the song that holds the span.
+	New func() (Song, error)
+	// Start and End bound the span in units of 1/Rate seconds. An End of 0
+	// plays to the end of the song.
+	Start, End, Rate int64
+	// Meta holds the fields that override those of the song.
+	Meta SongInfo
+
+	song       Song
+	init       bool
+	sampleRate int64
+	channels   int
+	// pos is the position of the song in sample frames, and left the frames
+	// left to play, or -1 until the end of the song.
+	pos, left int64
+}
+
+func (s *Span) base() (Song, error) {
+	if s.song == nil {
+		song, err := s.New()
+		if err != nil {
+			return nil, err
+		}
+		s.song = song
+	}
+	return s.song, nil
+}
+
+func (s *Span) Info() (SongInfo, error) {
+	song, err := s.base()
+	if err != nil {
+		return SongInfo{}, err
+	}
+	info, err := song.Info()
+	if err != nil {
+		return info, err
+	}
+	if s.End > 0 {
+		info.Time = time.Duration(s.End-s.Start) * time.Second / time.Duration(s.Rate)
+	} else if info.Time -= time.Duration(s.Start) * time.Second / time.Duration(s.Rate); info.Time < 0 {
+		info.Time = 0
+	}
+	m := s.Meta
+	if m.Title != "" {
+		info.Title = m.Title
+	}
+	if m.Artist != "" {
+		info.Artist = m.Artist
+	}
+	if m.Album != "" {
+		info.Album = m.Album
+	}
+	if m.Track != 0 {
+		info.Track = m.Track
+	}
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&info.AlbumArtist, m.AlbumArtist)
+	set(&info.Composer, m.Composer)
+	set(&info.Genre, m.Genre)
+	set(&info.Date, m.Date)
+	if m.Disc != 0 {
+		info.Disc, info.DiscTotal = m.Disc, m.DiscTotal
+	}
+	return info, nil
+}
+
+// frames converts a span position to sample frames.
+func (s *Span) frames(t int64) int64 {
+	return t * s.sampleRate / s.Rate
+}
+
+func (s *Span) Init() (sampleRate, channels int, err error) {
+	song, err := s.base()
+	if err != nil {
+		return 0, 0, err
+	}
+	sr, ch, err := song.Init()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !s.init {
+		s.init = true
+		s.sampleRate, s.channels = int64(sr), ch
+		s.pos = 0
+		if err := s.seek(0); err != nil {
+			return 0, 0, err
+		}
+	}
+	return sr, ch, nil
+}
+
+// seek positions the song offset frames into the span.
+func (s *Span) seek(offset int64) error {
+	for {
+		if done, err := s.seekStep(offset); done || err != nil {
+			return err
+		}
+	}
+}
+
+// seekStep runs a step of a seek offset frames into the span, and returns
+// whether it is done.
+func (s *Span) seekStep(offset int64) (bool, error) {
+	target := s.frames(s.Start) + offset
+	s.left = -1
+	if s.End > 0 {
+		if s.left = s.frames(s.End) - target; s.left < 0 {
+			s.left = 0
+		}
+	}
+	// Round up so the seeker's rounding down lands on target.
+	d := time.Duration((target*int64(time.Second) + s.sampleRate - 1) / s.sampleRate)
+	switch sk := s.song.(type) {
+	case StepSeeker:
+		done, err := sk.SeekStep(d)
+		if done && err == nil {
+			s.pos = target
+		}
+		return done, err
+	case Seeker:
+		if err := sk.Seek(d); err != nil {
+			return false, err
+		}
+		s.pos = target
+		return true, nil
+	}
+	if target < s.pos {
+		s.song.Close()
+		if _, _, err := s.song.Init(); err != nil {
+			return false, err
+		}
+		s.pos = 0
+	}
+	end := target
+	if step := s.sampleRate * int64(StepLength) / int64(time.Second); end > s.pos+step {
+		end = s.pos + step
+	}
+	for s.pos < end {
+		n := end - s.pos
+		if n > 4096 {
+			n = 4096
+		}
+		b, err := s.song.Play(int(n) * s.channels)
+		if err != nil {
+			return false, err
+		}
+		s.pos += int64(len(b) / s.channels)
+		if len(b) < int(n)*s.channels {
+			// The song ended first.
+			return true, nil
+		}
+	}
+	return s.pos >= target, nil
+}
+
+// Play plays up to n samples, rounded down to whole sample frames.
+func (s *Span) Play(n int) ([]float32, error) {
+	n -= n % s.channels
+	if s.left >= 0 && int64(n) > s.left*int64(s.channels) {
+		n = int(s.left) * s.channels
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	b, err := s.song.Play(n)
+	frames := int64(len(b) / s.channels)
+	s.pos += frames
+	if s.left >= 0 {
+		s.left -= frames
+	}
+	return b, err
+}
+
+// Seek seeks to offset from the start of the span.
+func (s *Span) Seek(offset time.Duration) error {
+	return SeekSteps(s, offset)
+}
+
+// SeekStep runs a step of a seek to offset from the start of the span.
+func (s *Span) SeekStep(offset time.Duration) (bool, error) {
+	if !s.init {
+		return false, errors.New("span: seek before init")
+	}
+	return s.seekStep(int64(offset) * s.sampleRate / int64(time.Second))
+}
+
+func (s *Span) Close() {
+	if s.song != nil {
+		s.song.Close()
+	}
+	s.init = false
+}
@@ -0,0 +1,267 @@
+package codec
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCue(t *testing.T) {
+	const sheet = "\xef\xbb\xbfREM GENRE Rock\n" +
+		"REM DATE 1999\n" +
+		"REM DISCNUMBER 2\n" +
+		"REM TOTALDISCS 3\n" +
+		"PERFORMER \"The Band\"\n" +
+		"TITLE \"An Album\"\n" +
+		"FILE \"disc one.flac\" WAVE\n" +
+		"  TRACK 01 AUDIO\n" +
+		"    TITLE \"First Song\"\n" +
+		"    INDEX 01 00:00:00\n" +
+		"  TRACK 02 AUDIO\n" +
+		"    TITLE Second\n" +
+		"    PERFORMER \"A Guest\"\n" +
+		"    INDEX 00 03:59:70\n" +
+		"    INDEX 01 04:00:05\n" +
+		"  TRACK 03 MODE1/2352\n" +
+		"    INDEX 01 08:00:00\n" +
+		"FILE two.flac WAVE\n" +
+		"  TRACK 04 AUDIO\n" +
+		"    TITLE \"Unclosed\n" +
+		"    INDEX 01 00:00:00\n"
+	c, err := ParseCue(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "An Album" || c.Performer != "The Band" || c.Genre != "Rock" || c.Date != "1999" || c.Disc != 2 || c.DiscTotal != 3 {
+		t.Fatalf("sheet %+v", c)
+	}
+	if len(c.Files) != 2 || c.Files[0].Name != "disc one.flac" || c.Files[1].Name != "two.flac" {
+		t.Fatalf("files %+v", c.Files)
+	}
+	// The data track is dropped.
+	want := [][]CueTrack{
+		{
+			{Num: 1, Title: "First Song", Index0: -1, Index1: 0},
+			{Num: 2, Title: "Second", Performer: "A Guest", Index0: (3*60+59)*75 + 70, Index1: 4*60*75 + 5},
+		},
+		{
+			{Num: 4, Title: "Unclosed", Index0: -1, Index1: 0},
+		},
+	}
+	for i, f := range c.Files {
+		if len(f.Tracks) != len(want[i]) {
+			t.Fatalf("file %d: tracks %+v", i, f.Tracks)
+		}
+		for j, tr := range f.Tracks {
+			if tr != want[i][j] {
+				t.Errorf("file %d track %d: got %+v, want %+v", i, j, tr, want[i][j])
+			}
+		}
+	}
+	if tr := c.Track(4); tr == nil || tr.Title != "Unclosed" {
+		t.Fatalf("track 4: %+v", tr)
+	}
+	if c.Track(3) != nil {
+		t.Fatal("data track kept")
+	}
+}
+
+func TestParseCueLatin1(t *testing.T) {
+	c, err := ParseCue(strings.NewReader("TITLE \"Caf\xe9\"\nFILE a.wav WAVE\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Café" {
+		t.Fatalf("title %q", c.Title)
+	}
+}
+
+func TestParseCueBad(t *testing.T) {
+	for _, sheet := range []string{
+		"TRACK 01 AUDIO\n",
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\nINDEX 01 00:00\n",
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\nINDEX 01 00:-1:00\n",
+	} {
+		if _, err := ParseCue(strings.NewReader(sheet)); err == nil {
+			t.Errorf("%q: expected an error", sheet)
+		}
+	}
+}
+
+// rampSong is a mono song at 7500Hz, 100 samples per CD frame, whose
+// samples count up from 0.
+type rampSong struct {
+	n, pos int
+}
+
+func (r *rampSong) Init() (int, int, error) {
+	r.pos = 0
+	return 7500, 1, nil
+}
+
+func (r *rampSong) Play(n int) ([]float32, error) {
+	if n > r.n-r.pos {
+		n = r.n - r.pos
+	}
+	b := make([]float32, n)
+	for i := range b {
+		b[i] = float32(r.pos + i)
+	}
+	r.pos += n
+	return b, nil
+}
+
+func (r *rampSong) Close() {}
+
+func (r *rampSong) Info() (SongInfo, error) {
+	return SongInfo{
+		Title:  "File",
+		Artist: "Someone",
+		Album:  "Files",
+		Genre:  "Noise",
+		Time:   time.Duration(r.n) * time.Second / 7500,
+	}, nil
+}
+
+// seekingRamp is a rampSong that can seek natively.
+type seekingRamp struct {
+	rampSong
+}
+
+func (r *seekingRamp) Seek(offset time.Duration) error {
+	r.pos = int(offset * 7500 / time.Second)
+	return nil
+}
+
+// steppingRamp is a rampSong that seeks by running forward.
+type steppingRamp struct {
+	rampSong
+}
+
+func (r *steppingRamp) Seek(offset time.Duration) error {
+	return SeekSteps(r, offset)
+}
+
+func (r *steppingRamp) SeekStep(offset time.Duration) (bool, error) {
+	target := int(offset * 7500 / time.Second)
+	if target < r.pos {
+		r.pos = 0
+	}
+	if r.pos += 7500; r.pos >= target {
+		r.pos = target
+		return true, nil
+	}
+	return false, nil
+}
+
+// playSpan plays all of s.
+func playSpan(t *testing.T, s Song) []float32 {
+	t.Helper()
+	var out []float32
+	for {
+		b, err := s.Play(1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b...)
+		if len(b) < 1000 {
+			return out
+		}
+	}
+}
+
+func checkSpan(t *testing.T, name string, b []float32, from, to int) {
+	t.Helper()
+	if len(b) != to-from {
+		t.Fatalf("%s: got %d samples, want %d", name, len(b), to-from)
+	}
+	for i, v := range b {
+		if int(v) != from+i {
+			t.Fatalf("%s: sample %d is %v, want %d", name, i, v, from+i)
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	for name, newSong := range map[string]func() Song{
+		"forward":  func() Song { return &rampSong{n: 100000} },
+		"seeker":   func() Song { return &seekingRamp{rampSong{n: 100000}} },
+		"stepping": func() Song { return &steppingRamp{rampSong{n: 100000}} },
+	} {
+		newSong := newSong
+		span := func(start, end int64) *Span {
+			return &Span{
+				New:   func() (Song, error) { return newSong(), nil },
+				Start: start,
+				End:   end,
+				Rate:  75,
+			}
+		}
+		// Spans start and end on the exact samples of their CD frames.
+		s := span(150, 301)
+		if _, _, err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+		checkSpan(t, name, playSpan(t, s), 15000, 30100)
+		// The last span plays to the end of the song.
+		s = span(900, 0)
+		s.Init()
+		checkSpan(t, name+" last", playSpan(t, s), 90000, 100000)
+		// Seeks are relative to the start, and keep the end.
+		s = span(150, 301)
+		s.Init()
+		for _, d := range []time.Duration{time.Second, time.Second / 3, 0} {
+			if err := s.Seek(d); err != nil {
+				t.Fatal(err)
+			}
+			checkSpan(t, name+" "+d.String(), playSpan(t, s), 15000+int(d*7500/time.Second), 30100)
+		}
+		// Seeks by steps run forward a step at a time.
+		s = span(0, 0)
+		s.Init()
+		steps := 0
+		for done := false; !done; steps++ {
+			var err error
+			if done, err = s.SeekStep(10 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if name == "seeker" && steps != 1 || name != "seeker" && steps < 10 {
+			t.Errorf("%s: seek done in %d steps", name, steps)
+		}
+		checkSpan(t, name+" steps", playSpan(t, s), 75000, 100000)
+	}
+}
+
+func TestSpanInfo(t *testing.T) {
+	s := &Span{
+		New:   func() (Song, error) { return &rampSong{n: 100000}, nil },
+		Start: 150,
+		End:   300,
+		Rate:  75,
+		Meta:  SongInfo{Title: "Track", Artist: "Band", Track: 2, Disc: 1, DiscTotal: 2},
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fields of Meta override those of the song, which fill the rest.
+	want := SongInfo{
+		Title:     "Track",
+		Artist:    "Band",
+		Album:     "Files",
+		Genre:     "Noise",
+		Track:     2,
+		Disc:      1,
+		DiscTotal: 2,
+		Time:      2 * time.Second,
+	}
+	if info != want {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+	// The last span lasts to the end of the song.
+	s.End = 0
+	if info, _ := s.Info(); info.Time != 100000*time.Second/7500-2*time.Second {
+		t.Fatalf("last span time %v", info.Time)
+	}
+}
@@ -1,19 +1,20 @@
 package flac
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mjibson/mog/_third_party/gopkg.in/mewkiz/flac.v1"
 	"github.com/mjibson/mog/_third_party/gopkg.in/mewkiz/flac.v1/frame"
 	"github.com/mjibson/mog/_third_party/gopkg.in/mewkiz/flac.v1/meta"
 	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/vorbis"
 )
 
 func init() {
@@ -21,48 +22,140 @@ func init() {
 }
 
 func New(rf codec.Reader) ([]codec.Song, error) {
-	f := Flac{
-		Reader: rf,
+	newSong := func() (codec.Song, error) {
+		return &Flac{Reader: rf}, nil
 	}
-	return []codec.Song{&f}, nil
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	fv, err := flac.Parse(r)
+	r.Close()
+	if err != nil {
+		// Let Init and Info report the error.
+		return []codec.Song{&Flac{Reader: rf}}, nil
+	}
+	if spans := cueSpans(fv, newSong); len(spans) > 1 {
+		return spans, nil
+	}
+	return []codec.Song{&Flac{Reader: rf}}, nil
+}
+
+// cueSpans returns a song for each audio track of an embedded CUESHEET
+// block. Titles and performers come from a CUESHEET tag, if present.
+func cueSpans(fv *flac.Stream, newSong func() (codec.Song, error)) []codec.Song {
+	var cs *meta.CueSheet
+	cue := new(codec.Cue)
+	for _, b := range fv.Blocks {
+		switch v := b.Body.(type) {
+		case *meta.CueSheet:
+			cs = v
+		case *meta.VorbisComment:
+			for _, tag := range v.Tags {
+				if strings.EqualFold(tag[0], "CUESHEET") {
+					if c, err := codec.ParseCue(strings.NewReader(tag[1])); err == nil {
+						cue = c
+					}
+				}
+			}
+		}
+	}
+	if cs == nil {
+		return nil
+	}
+	var songs []codec.Song
+	for i, t := range cs.Tracks {
+		if !t.IsAudio || len(t.Indicies) == 0 || i+1 >= len(cs.Tracks) {
+			continue
+		}
+		info := codec.SongInfo{Track: float64(t.Num)}
+		if ct := cue.Track(int(t.Num)); ct != nil {
+			info = cue.Info(ct)
+		}
+		songs = append(songs, &codec.Span{
+			New:   newSong,
+			Start: int64(t.Offset + index1(t)),
+			End:   int64(cs.Tracks[i+1].Offset + index1(cs.Tracks[i+1])),
+			Rate:  int64(fv.Info.SampleRate),
+			Meta:  info,
+		})
+	}
+	return songs
+}
+
+// index1 returns the offset of index point 1 of t, where its audio starts.
+func index1(t meta.CueSheetTrack) uint64 {
+	for _, idx := range t.Indicies {
+		if idx.Num == 1 {
+			return idx.Offset
+		}
+	}
+	return 0
 }
 
 type Flac struct {
 	Reader  codec.Reader
 	r       io.ReadCloser
+	br      *bufio.Reader
 	initbuf []byte
 	f       *flac.Stream
-	samples []int32
+	// samples are the decoded samples not yet played, a slice of buf.
+	samples []float32
+	buf     []float32
+	// pos is the sample frame number of the next frame read from br.
+	pos uint64
+	// start is the byte offset of the first audio frame, and size the file
+	// size.
+	start, size int64
 }
 
 func (f *Flac) Init() (sampleRate, channels int, err error) {
 	if f.f == nil {
-		r, _, err := f.Reader()
+		r, size, err := f.Reader()
 		if err != nil {
 			return 0, 0, err
 		}
-		buf := new(bytes.Buffer)
+		f.size = size
+		buf := new(codec.HeaderBuffer)
 		defer func() {
+			buf.Stop()
 			f.initbuf = buf.Bytes()
 		}()
-		fr, err := flac.Parse(io.TeeReader(r, buf))
+		// flac.Parse reuses a *bufio.Reader, so frames can be read from br
+		// directly after the metadata.
+		br := bufio.NewReader(io.TeeReader(r, buf))
+		fr, err := flac.Parse(br)
 		if err != nil {
 			r.Close()
 			return 0, 0, err
 		}
 		f.r = r
+		f.br = br
 		f.f = fr
+		f.pos = 0
+		f.samples = nil
+		f.start = audioStart(fr)
 	}
 	return int(f.f.Info.SampleRate), int(f.f.Info.NChannels), nil
 }
 
+// audioStart returns the byte offset of the first audio frame of fv.
+func audioStart(fv *flac.Stream) int64 {
+	start := int64(len("fLaC")) + 4 + 34
+	for _, b := range fv.Blocks {
+		start += 4 + b.Length
+	}
+	return start
+}
+
 func (f *Flac) Info() (info codec.SongInfo, err error) {
 	var r io.ReadCloser
+	size := f.size
 	if len(f.initbuf) != 0 {
 		r = ioutil.NopCloser(bytes.NewBuffer(f.initbuf))
 	}
 	if r == nil {
-		r, _, err = f.Reader()
+		r, size, err = f.Reader()
 		if err != nil {
 			return
 		}
@@ -73,24 +166,22 @@ func (f *Flac) Info() (info codec.SongInfo, err error) {
 		return
 	}
 	si := codec.SongInfo{
-		Time: time.Duration(fv.Info.NSamples) / time.Duration(fv.Info.SampleRate) * time.Second,
+		Time:       time.Duration(fv.Info.NSamples) * time.Second / time.Duration(fv.Info.SampleRate),
+		Codec:      "FLAC",
+		SampleRate: int(fv.Info.SampleRate),
+		Channels:   int(fv.Info.NChannels),
+		BitDepth:   int(fv.Info.BitsPerSample),
+		Size:       size,
 	}
+	si.Bitrate = codec.Bitrate(size-audioStart(fv), si.Time)
 	for _, b := range fv.Blocks {
 		switch v := b.Body.(type) {
 		case *meta.VorbisComment:
+			c := &vorbis.Comment{Vendor: v.Vendor}
 			for _, tag := range v.Tags {
-				switch tag[0] {
-				case "TITLE":
-					si.Title = tag[1]
-				case "ARTIST":
-					si.Artist = tag[1]
-				case "ALBUM":
-					si.Album = tag[1]
-				case "TRACKNUMBER":
-					n, _ := strconv.Atoi(tag[1])
-					si.Track = float64(n)
-				}
+				c.Tags = append(c.Tags, [2]string{strings.ToUpper(tag[0]), tag[1]})
 			}
+			c.Fill(&si)
 		case *meta.Picture:
 			if v.MIME == "-->" {
 				si.ImageURL = string(v.Data)
@@ -103,28 +194,127 @@ func (f *Flac) Info() (info codec.SongInfo, err error) {
 }
 
 func (f *Flac) Play(n int) ([]float32, error) {
-	var err error
-	var frame *frame.Frame
-	for len(f.samples) < n && err == nil {
-		frame, err = f.f.ParseNext()
-		if err != nil {
-			break
+	ret := make([]float32, 0, n)
+	for len(ret) < n {
+		if len(f.samples) == 0 {
+			fr, err := f.f.ParseNext()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return ret, err
+			}
+			if err := f.decode(fr); err != nil {
+				return ret, err
+			}
+			f.pos += uint64(fr.BlockSize)
 		}
-		for i := 0; i < int(frame.BlockSize); i++ {
-			for _, sf := range frame.Subframes {
-				f.samples = append(f.samples, sf.Samples[i])
+		c := copy(ret[len(ret):n], f.samples)
+		ret = ret[:len(ret)+c]
+		f.samples = f.samples[c:]
+	}
+	return ret, nil
+}
+
+// decode interleaves and scales the samples of fr into f.samples, reusing
+// its buffer.
+func (f *Flac) decode(fr *frame.Frame) error {
+	nc := int(f.f.Info.NChannels)
+	if len(fr.Subframes) != nc {
+		return fmt.Errorf("flac: frame has %d channels, stream has %d", len(fr.Subframes), nc)
+	}
+	bps := fr.BitsPerSample
+	if bps == 0 || bps > 32 {
+		return fmt.Errorf("flac: unsupported sample size %d", bps)
+	}
+	scale := 1 / float32(uint64(1)<<(bps-1))
+	size := int(fr.BlockSize) * nc
+	if cap(f.buf) < size {
+		f.buf = make([]float32, size)
+	}
+	f.samples = f.buf[:size]
+	for c, sf := range fr.Subframes {
+		for i, s := range sf.Samples[:fr.BlockSize] {
+			f.samples[i*nc+c] = float32(s) * scale
+		}
+	}
+	return nil
+}
+
+// Seek seeks to offset using the stream's SEEKTABLE, if present. Without a
+// seek point at or before offset, the stream is decoded from the closest
+// known position.
+func (f *Flac) Seek(offset time.Duration) error {
+	return codec.SeekSteps(f, offset)
+}
+
+// SeekStep jumps to the seek point before offset, or decodes a step toward
+// it from there.
+func (f *Flac) SeekStep(offset time.Duration) (bool, error) {
+	if f.f == nil {
+		return false, fmt.Errorf("flac: seek before init")
+	}
+	nc := uint64(f.f.Info.NChannels)
+	target := uint64(offset) * uint64(f.f.Info.SampleRate) / uint64(time.Second)
+	// The current position is the sample after those still buffered.
+	cur := f.pos - uint64(len(f.samples))/nc
+	var best meta.SeekPoint
+	for _, b := range f.f.Blocks {
+		st, ok := b.Body.(*meta.SeekTable)
+		if !ok {
+			continue
+		}
+		for _, p := range st.Points {
+			if p.SampleNum == meta.PlaceholderPoint || p.SampleNum > target {
+				continue
 			}
+			if p.SampleNum >= best.SampleNum {
+				best = p
+			}
+		}
+	}
+	if target < cur || best.SampleNum > cur {
+		r, err := codec.OpenAt(f.Reader, f.start+int64(best.Offset))
+		if err != nil {
+			return false, err
 		}
+		f.r.Close()
+		f.r = r
+		f.br.Reset(r)
+		f.pos = best.SampleNum
+		f.samples = f.samples[:0]
+		cur = best.SampleNum
 	}
-	if n > len(f.samples) {
-		n = len(f.samples)
+	// Discard whole frames up to the one containing target, then the leading
+	// samples of that frame.
+	step := f.pos + uint64(codec.StepLength)*uint64(f.f.Info.SampleRate)/uint64(time.Second)
+	for f.pos <= target {
+		if f.pos > step {
+			return false, nil
+		}
+		fr, err := f.f.ParseNext()
+		if err == io.EOF {
+			// Seeking past the end leaves nothing to play.
+			f.samples = f.samples[:0]
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		f.samples = f.samples[:0]
+		cur = f.pos
+		f.pos += uint64(fr.BlockSize)
+		if f.pos <= target {
+			continue
+		}
+		if err := f.decode(fr); err != nil {
+			return false, err
+		}
 	}
-	ret := make([]float32, n)
-	for i, s := range f.samples[:n] {
-		ret[i] = (float32(s) - math.MinInt16) / (math.MaxInt16 - math.MinInt16)
+	skip := (target - cur) * nc
+	if skip > uint64(len(f.samples)) {
+		skip = uint64(len(f.samples))
 	}
-	f.samples = f.samples[n:]
-	return ret, err
+	f.samples = f.samples[skip:]
+	return true, nil
 }
 
 func (f *Flac) Close() {
@@ -0,0 +1,204 @@
+package flac
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/_third_party/gopkg.in/mewpkg/hashutil.v1/crc16"
+	"github.com/mjibson/mog/_third_party/gopkg.in/mewpkg/hashutil.v1/crc8"
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+type bitWriter struct {
+	b []byte
+	n uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>(i-1)&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+// Channel assignments other than independent channels.
+const (
+	independent = -1
+	leftSide    = 8
+	midSide     = 10
+)
+
+var sampleSizes = map[int]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6, 32: 7}
+
+// flacStream returns a FLAC stream with a frame for each element of frames,
+// which holds the samples of each subframe. Subframes are stored verbatim.
+func flacStream(rate, channels, bps, assign int, frames [][][]int64) []byte {
+	var nsamples, block int
+	for _, f := range frames {
+		nsamples += len(f[0])
+		if len(f[0]) > block {
+			block = len(f[0])
+		}
+	}
+	w := new(bitWriter)
+	w.b = []byte("fLaC")
+	w.n = 32
+	w.write(1, 1) // last metadata block
+	w.write(0, 7) // STREAMINFO
+	w.write(34, 24)
+	w.write(uint64(block), 16)
+	w.write(uint64(block), 16)
+	w.write(0, 48)
+	w.write(uint64(rate), 20)
+	w.write(uint64(channels-1), 3)
+	w.write(uint64(bps-1), 5)
+	w.write(uint64(nsamples), 36)
+	w.write(0, 128)
+	for i, f := range frames {
+		start := len(w.b)
+		w.write(0x3ffe, 14)
+		w.write(0, 2) // fixed block size
+		w.write(7, 4) // 16-bit block size at the end of the header
+		w.write(0, 4) // sample rate from STREAMINFO
+		if assign == independent {
+			w.write(uint64(channels-1), 4)
+		} else {
+			w.write(uint64(assign), 4)
+		}
+		w.write(sampleSizes[bps], 3)
+		w.write(0, 1)
+		w.write(uint64(i), 8)
+		w.write(uint64(len(f[0])-1), 16)
+		w.write(uint64(crc8.ChecksumATM(w.b[start:])), 8)
+		for c, samples := range f {
+			w.write(0, 1)
+			w.write(1, 6) // verbatim
+			w.write(0, 1)
+			size := uint(bps)
+			if assign == leftSide && c == 1 || assign == midSide && c == 1 {
+				size++
+			}
+			for _, s := range samples {
+				w.write(uint64(s)&(1<<size-1), size)
+			}
+		}
+		w.n = uint(len(w.b)) * 8
+		w.write(uint64(crc16.ChecksumIBM(w.b[start:])), 16)
+	}
+	return w.b
+}
+
+// ramp returns n samples counting up from start by step.
+func ramp(start, step int64, n int) []int64 {
+	s := make([]int64, n)
+	for i := range s {
+		s[i] = start + int64(i)*step
+	}
+	return s
+}
+
+func checkSamples(t *testing.T, got []float32, want []int64, bps uint) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	scale := 1 / float32(uint64(1)<<(bps-1))
+	for i := range got {
+		if w := float32(want[i]) * scale; got[i] != w {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], w)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		bps    int
+		assign int
+		l, r   []int64
+	}{
+		{"16-bit", 16, independent, ramp(-1000, 7, 300), ramp(500, -3, 300)},
+		{"24-bit mid-side", 24, midSide, ramp(-1<<23, 1<<15, 300), ramp(1<<23-1, -1<<14, 300)},
+		{"32-bit", 32, independent, ramp(-1<<31, 1<<22, 300), ramp(1<<31-1, -1<<21, 300)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var frames [][][]int64
+			var want []int64
+			for i := 0; i < len(test.l); i += 128 {
+				j := i + 128
+				if j > len(test.l) {
+					j = len(test.l)
+				}
+				l, r := test.l[i:j], test.r[i:j]
+				if test.assign == midSide {
+					mid := make([]int64, len(l))
+					side := make([]int64, len(l))
+					for k := range l {
+						mid[k] = (l[k] + r[k]) >> 1
+						side[k] = l[k] - r[k]
+					}
+					frames = append(frames, [][]int64{mid, side})
+				} else {
+					frames = append(frames, [][]int64{l, r})
+				}
+				for k := range l {
+					want = append(want, l[k], r[k])
+				}
+			}
+			songs, err := New(codectest.Reader(flacStream(44100, 2, test.bps, test.assign, frames)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := songs[0]
+			if sr, ch, err := s.Init(); err != nil || sr != 44100 || ch != 2 {
+				t.Fatalf("init: %d %d %v", sr, ch, err)
+			}
+			checkSamples(t, codectest.PlayAll(t, s, 1000), want, uint(test.bps))
+		})
+	}
+}
+
+func TestSideChannel32(t *testing.T) {
+	frames := [][][]int64{{ramp(0, 1, 16), ramp(0, 1, 16)}}
+	songs, _ := New(codectest.Reader(flacStream(44100, 2, 32, leftSide, frames)))
+	s := songs[0]
+	if _, _, err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Play(32); err == nil {
+		t.Fatal("expected an error for a 32-bit side channel")
+	}
+}
+
+func TestSeek(t *testing.T) {
+	var frames [][][]int64
+	var want []int64
+	for i := 0; i < 5; i++ {
+		f := ramp(int64(i*100), 1, 100)
+		frames = append(frames, [][]int64{f})
+		want = append(want, f...)
+	}
+	songs, _ := New(codectest.Reader(flacStream(1000, 1, 16, independent, frames)))
+	s := songs[0]
+	if _, _, err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Time != 500*time.Millisecond {
+		t.Fatalf("time %v", info.Time)
+	}
+	sk := s.(codec.Seeker)
+	for _, frame := range []int{250, 420, 100, 0, 499} {
+		if err := sk.Seek(time.Duration(frame) * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		checkSamples(t, codectest.PlayAll(t, s, 1000), want[frame:], 16)
+	}
+}
@@ -0,0 +1,340 @@
+package gbs
+
+// Register offsets from $FF10.
+const (
+	regNR50 = 0x14
+	regNR51 = 0x15
+	regNR52 = 0x16
+	regWave = 0x20
+)
+
+// readMask holds the bits of each register that read back as 1.
+var readMask = [0x30]byte{
+	0x80, 0x3f, 0x00, 0xff, 0xbf,
+	0xff, 0x3f, 0x00, 0xff, 0xbf,
+	0x7f, 0xff, 0x9f, 0xff, 0xbf,
+	0xff, 0xff, 0x00, 0x00, 0xbf,
+	0x00, 0x00, 0x70,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// duties are the square wave patterns.
+var duties = [4]byte{0x01, 0x81, 0x87, 0x7e}
+
+var noiseDivisors = [8]int{8, 16, 32, 48, 64, 80, 96, 112}
+
+// waveShifts are the wave channel volume shifts.
+var waveShifts = [4]uint{4, 0, 1, 2}
+
+// channel is the state of one sound channel. Its registers are at
+// $FF10 + 5*index.
+type channel struct {
+	on, dac  bool
+	length   int
+	timer    int
+	pos      int
+	vol      byte
+	envTimer byte
+	lfsr     uint16
+}
+
+// apu emulates the Game Boy sound hardware.
+type apu struct {
+	regs  [0x30]byte
+	power bool
+	ch    [4]channel
+
+	// Square 1 frequency sweep.
+	sweepOn    bool
+	sweepTimer byte
+	shadow     int
+
+	// seqTimer counts down to the next frame sequencer step.
+	seqTimer int
+	seqStep  int
+
+	// l and r sum the output over n cycles. frac counts toward the next
+	// sample, and capL and capR are the output high-pass filter state.
+	l, r       float64
+	n          int
+	frac       int
+	capL, capR float64
+}
+
+func newAPU() *apu {
+	a := &apu{seqTimer: 8192}
+	a.write(0xff26, 0x80)
+	a.write(0xff24, 0x77)
+	a.write(0xff25, 0xff)
+	return a
+}
+
+func (a *apu) freq(i int) int {
+	return int(a.regs[i*5+3]) | int(a.regs[i*5+4]&7)<<8
+}
+
+// period returns the timer period of channel i in cycles, or 0 if it is
+// not clocked.
+func (a *apu) period(i int) int {
+	switch i {
+	case 0, 1:
+		return (2048 - a.freq(i)) * 4
+	case 2:
+		return (2048 - a.freq(i)) * 2
+	}
+	nr43 := a.regs[0x12]
+	if nr43>>4 >= 14 {
+		return 0
+	}
+	return noiseDivisors[nr43&7] << (nr43 >> 4)
+}
+
+func (a *apu) read(addr uint16) byte {
+	r := addr - 0xff10
+	if r == regNR52 {
+		b := byte(0x70)
+		if a.power {
+			b |= 0x80
+		}
+		for i := range a.ch {
+			if a.ch[i].on {
+				b |= 1 << uint(i)
+			}
+		}
+		return b
+	}
+	return a.regs[r] | readMask[r]
+}
+
+func (a *apu) write(addr uint16, v byte) {
+	r := int(addr - 0xff10)
+	if r == regNR52 {
+		a.power = v&0x80 != 0
+		if !a.power {
+			for i := 0; i < regNR52; i++ {
+				a.regs[i] = 0
+			}
+			a.ch = [4]channel{}
+		}
+		return
+	}
+	if !a.power && r < regWave {
+		return
+	}
+	a.regs[r] = v
+	if r >= regNR50 {
+		return
+	}
+	i, c := r/5, &a.ch[r/5]
+	switch r % 5 {
+	case 0:
+		if i == 2 {
+			c.dac = v&0x80 != 0
+			if !c.dac {
+				c.on = false
+			}
+		}
+	case 1:
+		if i == 2 {
+			c.length = 256 - int(v)
+		} else {
+			c.length = 64 - int(v&0x3f)
+		}
+	case 2:
+		if i != 2 {
+			c.dac = v&0xf8 != 0
+			if !c.dac {
+				c.on = false
+			}
+		}
+	case 4:
+		if v&0x80 != 0 {
+			a.trigger(i)
+		}
+	}
+}
+
+func (a *apu) trigger(i int) {
+	c := &a.ch[i]
+	c.on = c.dac
+	if c.length == 0 {
+		c.length = 64
+		if i == 2 {
+			c.length = 256
+		}
+	}
+	c.timer = a.period(i)
+	c.pos = 0
+	c.lfsr = 0x7fff
+	env := a.regs[i*5+2]
+	c.vol = env >> 4
+	c.envTimer = env & 7
+	if i == 0 {
+		a.shadow = a.freq(0)
+		a.sweepTimer = a.sweepPeriod()
+		a.sweepOn = a.regs[0]&0x77 != 0
+		if a.regs[0]&7 != 0 {
+			a.sweep()
+		}
+	}
+}
+
+func (a *apu) sweepPeriod() byte {
+	if p := a.regs[0] >> 4 & 7; p != 0 {
+		return p
+	}
+	return 8
+}
+
+// sweep returns the next swept frequency, disabling square 1 on overflow.
+func (a *apu) sweep() int {
+	d := a.shadow >> (a.regs[0] & 7)
+	if a.regs[0]&8 != 0 {
+		d = -d
+	}
+	f := a.shadow + d
+	if f > 2047 {
+		a.ch[0].on = false
+	}
+	return f
+}
+
+// sequence clocks the length counters, sweep and envelopes.
+func (a *apu) sequence() {
+	if a.seqStep&1 == 0 {
+		for i := range a.ch {
+			c := &a.ch[i]
+			if a.regs[i*5+4]&0x40 != 0 && c.length > 0 {
+				if c.length--; c.length == 0 {
+					c.on = false
+				}
+			}
+		}
+	}
+	if a.seqStep == 2 || a.seqStep == 6 {
+		if a.sweepTimer--; a.sweepTimer == 0 {
+			a.sweepTimer = a.sweepPeriod()
+			if a.sweepOn && a.regs[0]&0x70 != 0 {
+				if f := a.sweep(); f <= 2047 && a.regs[0]&7 != 0 {
+					a.shadow = f
+					a.regs[3] = byte(f)
+					a.regs[4] = a.regs[4]&^7 | byte(f>>8)
+					a.sweep()
+				}
+			}
+		}
+	}
+	if a.seqStep == 7 {
+		for _, i := range []int{0, 1, 3} {
+			c := &a.ch[i]
+			env := a.regs[i*5+2]
+			if env&7 == 0 {
+				continue
+			}
+			if c.envTimer--; c.envTimer == 0 {
+				c.envTimer = env & 7
+				if env&8 != 0 && c.vol < 15 {
+					c.vol++
+				} else if env&8 == 0 && c.vol > 0 {
+					c.vol--
+				}
+			}
+		}
+	}
+	a.seqStep = (a.seqStep + 1) & 7
+}
+
+// output returns the digital output of channel i, from 0 to 15.
+func (a *apu) output(i int) int {
+	c := &a.ch[i]
+	if !c.on {
+		return 0
+	}
+	switch i {
+	case 0, 1:
+		if duties[a.regs[i*5+1]>>6]>>uint(c.pos)&1 != 0 {
+			return int(c.vol)
+		}
+	case 2:
+		s := a.regs[regWave+c.pos/2]
+		if c.pos&1 == 0 {
+			s >>= 4
+		}
+		return int(s&0xf) >> waveShifts[a.regs[0xc]>>5&3]
+	case 3:
+		if c.lfsr&1 == 0 {
+			return int(c.vol)
+		}
+	}
+	return 0
+}
+
+// advance steps channel i by one timer period.
+func (a *apu) advance(i int) {
+	c := &a.ch[i]
+	switch i {
+	case 0, 1:
+		c.pos = (c.pos + 1) & 7
+	case 2:
+		c.pos = (c.pos + 1) & 31
+	case 3:
+		x := (c.lfsr ^ c.lfsr>>1) & 1
+		c.lfsr = c.lfsr>>1 | x<<14
+		if a.regs[0x12]&8 != 0 {
+			c.lfsr = c.lfsr&^0x40 | x<<6
+		}
+	}
+}
+
+// run runs for the given number of clock cycles, appending stereo samples
+// to out.
+func (a *apu) run(cycles int, out []float32) []float32 {
+	for ; cycles > 0; cycles -= 4 {
+		for i := range a.ch {
+			c := &a.ch[i]
+			if !c.on {
+				continue
+			}
+			p := a.period(i)
+			if p == 0 {
+				continue
+			}
+			for c.timer -= 4; c.timer <= 0; c.timer += p {
+				a.advance(i)
+			}
+		}
+		if a.seqTimer -= 4; a.seqTimer <= 0 {
+			a.seqTimer += 8192
+			a.sequence()
+		}
+
+		// Each DAC maps 0 to 15 to 1 to -1.
+		nr51 := a.regs[regNR51]
+		for i := range a.ch {
+			if !a.ch[i].dac {
+				continue
+			}
+			v := 1 - float64(a.output(i))/7.5
+			if nr51&(0x10<<uint(i)) != 0 {
+				a.l += v
+			}
+			if nr51&(1<<uint(i)) != 0 {
+				a.r += v
+			}
+		}
+		a.n++
+
+		a.frac += rate * 4
+		if a.frac >= clock {
+			a.frac -= clock
+			nr50 := a.regs[regNR50]
+			l := a.l / float64(a.n) / 4 * float64(nr50>>4&7+1) / 8
+			r := a.r / float64(a.n) / 4 * float64(nr50&7+1) / 8
+			a.l, a.r, a.n = 0, 0, 0
+			l, a.capL = l-a.capL, l-(l-a.capL)*0.996
+			r, a.capR = r-a.capR, r-(r-a.capR)*0.996
+			out = append(out, float32(l), float32(r))
+		}
+	}
+	return out
+}
@@ -0,0 +1,468 @@
+package gbs
+
+// Flags.
+const (
+	flagC = 0x10 << iota
+	flagH
+	flagN
+	flagZ
+)
+
+// opCycles is the number of clock cycles of each instruction. Conditional
+// jumps, calls and returns take more when taken.
+var opCycles = [256]uint8{
+	4, 12, 8, 8, 4, 4, 8, 4, 20, 8, 8, 8, 4, 4, 8, 4,
+	4, 12, 8, 8, 4, 4, 8, 4, 12, 8, 8, 8, 4, 4, 8, 4,
+	8, 12, 8, 8, 4, 4, 8, 4, 8, 8, 8, 8, 4, 4, 8, 4,
+	8, 12, 8, 8, 12, 12, 12, 4, 8, 8, 8, 8, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	8, 8, 8, 8, 8, 8, 4, 8, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 4, 4, 4, 8, 4,
+	8, 12, 12, 16, 12, 16, 8, 16, 8, 16, 12, 4, 12, 24, 8, 16,
+	8, 12, 12, 4, 12, 16, 8, 16, 8, 16, 12, 4, 12, 4, 8, 16,
+	12, 12, 8, 4, 4, 16, 8, 16, 16, 4, 16, 4, 4, 4, 8, 16,
+	12, 12, 8, 4, 4, 16, 8, 16, 12, 8, 16, 4, 4, 4, 8, 16,
+}
+
+func (g *gb) fetch() byte {
+	b := g.read(g.pc)
+	g.pc++
+	return b
+}
+
+func (g *gb) fetch16() uint16 {
+	lo := g.fetch()
+	return uint16(g.fetch())<<8 | uint16(lo)
+}
+
+func (g *gb) push(v uint16) {
+	g.sp -= 2
+	g.write(g.sp, byte(v))
+	g.write(g.sp+1, byte(v>>8))
+}
+
+func (g *gb) pop() uint16 {
+	v := uint16(g.read(g.sp)) | uint16(g.read(g.sp+1))<<8
+	g.sp += 2
+	return v
+}
+
+func (g *gb) hl() uint16 {
+	return uint16(g.h)<<8 | uint16(g.l)
+}
+
+func (g *gb) setHL(v uint16) {
+	g.h, g.l = byte(v>>8), byte(v)
+}
+
+// reg returns register i in the order B, C, D, E, H, L, (HL), A.
+func (g *gb) reg(i byte) byte {
+	switch i {
+	case 0:
+		return g.b
+	case 1:
+		return g.c
+	case 2:
+		return g.d
+	case 3:
+		return g.e
+	case 4:
+		return g.h
+	case 5:
+		return g.l
+	case 6:
+		return g.read(g.hl())
+	}
+	return g.a
+}
+
+func (g *gb) setReg(i, v byte) {
+	switch i {
+	case 0:
+		g.b = v
+	case 1:
+		g.c = v
+	case 2:
+		g.d = v
+	case 3:
+		g.e = v
+	case 4:
+		g.h = v
+	case 5:
+		g.l = v
+	case 6:
+		g.write(g.hl(), v)
+	default:
+		g.a = v
+	}
+}
+
+// pair returns register pair p in the order BC, DE, HL, SP.
+func (g *gb) pair(p byte) uint16 {
+	switch p {
+	case 0:
+		return uint16(g.b)<<8 | uint16(g.c)
+	case 1:
+		return uint16(g.d)<<8 | uint16(g.e)
+	case 2:
+		return g.hl()
+	}
+	return g.sp
+}
+
+func (g *gb) setPair(p byte, v uint16) {
+	switch p {
+	case 0:
+		g.b, g.c = byte(v>>8), byte(v)
+	case 1:
+		g.d, g.e = byte(v>>8), byte(v)
+	case 2:
+		g.setHL(v)
+	default:
+		g.sp = v
+	}
+}
+
+// cond returns whether condition NZ, Z, NC or C holds.
+func (g *gb) cond(y byte) bool {
+	switch y & 3 {
+	case 0:
+		return g.f&flagZ == 0
+	case 1:
+		return g.f&flagZ != 0
+	case 2:
+		return g.f&flagC == 0
+	}
+	return g.f&flagC != 0
+}
+
+func zero(v byte) byte {
+	if v == 0 {
+		return flagZ
+	}
+	return 0
+}
+
+func flag(b bool, f byte) byte {
+	if b {
+		return f
+	}
+	return 0
+}
+
+// alu performs ADD, ADC, SUB, SBC, AND, XOR, OR or CP of v with A.
+func (g *gb) alu(op, v byte) {
+	a := g.a
+	carry := g.f >> 4 & 1
+	switch op {
+	case 0, 1:
+		if op == 0 {
+			carry = 0
+		}
+		r := int(a) + int(v) + int(carry)
+		g.a = byte(r)
+		g.f = zero(g.a) | flag(a&0xf+v&0xf+carry > 0xf, flagH) | flag(r > 0xff, flagC)
+	case 2, 3, 7:
+		if op != 3 {
+			carry = 0
+		}
+		r := int(a) - int(v) - int(carry)
+		g.f = zero(byte(r)) | flagN | flag(int(a&0xf)-int(v&0xf)-int(carry) < 0, flagH) | flag(r < 0, flagC)
+		if op != 7 {
+			g.a = byte(r)
+		}
+	case 4:
+		g.a &= v
+		g.f = zero(g.a) | flagH
+	case 5:
+		g.a ^= v
+		g.f = zero(g.a)
+	case 6:
+		g.a |= v
+		g.f = zero(g.a)
+	}
+}
+
+// addSP returns SP plus a signed immediate and sets the flags.
+func (g *gb) addSP() uint16 {
+	v := uint16(int8(g.fetch()))
+	g.f = flag(g.sp&0xf+v&0xf > 0xf, flagH) | flag(g.sp&0xff+v&0xff > 0xff, flagC)
+	return g.sp + v
+}
+
+// step executes one instruction and returns the cycles it took.
+func (g *gb) step() int {
+	op := g.fetch()
+	cycles := int(opCycles[op])
+	x, y, z := op>>6, op>>3&7, op&7
+	p, q := y>>1, y&1
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				// NOP
+			case 1:
+				a := g.fetch16()
+				g.write(a, byte(g.sp))
+				g.write(a+1, byte(g.sp>>8))
+			case 2:
+				// STOP
+				g.pc++
+			case 3:
+				d := int8(g.fetch())
+				g.pc += uint16(d)
+			default:
+				d := int8(g.fetch())
+				if g.cond(y - 4) {
+					g.pc += uint16(d)
+					cycles += 4
+				}
+			}
+		case 1:
+			if q == 0 {
+				g.setPair(p, g.fetch16())
+			} else {
+				hl, v := g.hl(), g.pair(p)
+				g.f = g.f&flagZ | flag(hl&0xfff+v&0xfff > 0xfff, flagH) | flag(uint32(hl)+uint32(v) > 0xffff, flagC)
+				g.setHL(hl + v)
+			}
+		case 2:
+			var a uint16
+			switch p {
+			case 0, 1:
+				a = g.pair(p)
+			case 2:
+				a = g.hl()
+				g.setHL(a + 1)
+			case 3:
+				a = g.hl()
+				g.setHL(a - 1)
+			}
+			if q == 0 {
+				g.write(a, g.a)
+			} else {
+				g.a = g.read(a)
+			}
+		case 3:
+			if q == 0 {
+				g.setPair(p, g.pair(p)+1)
+			} else {
+				g.setPair(p, g.pair(p)-1)
+			}
+		case 4:
+			v := g.reg(y) + 1
+			g.setReg(y, v)
+			g.f = g.f&flagC | zero(v) | flag(v&0xf == 0, flagH)
+		case 5:
+			v := g.reg(y) - 1
+			g.setReg(y, v)
+			g.f = g.f&flagC | zero(v) | flagN | flag(v&0xf == 0xf, flagH)
+		case 6:
+			g.setReg(y, g.fetch())
+		case 7:
+			g.misc(y)
+		}
+	case 1:
+		if op == 0x76 {
+			g.halt()
+		} else {
+			g.setReg(y, g.reg(z))
+		}
+	case 2:
+		g.alu(y, g.reg(z))
+	case 3:
+		switch z {
+		case 0:
+			switch y {
+			case 4:
+				g.write(0xff00|uint16(g.fetch()), g.a)
+			case 5:
+				g.sp = g.addSP()
+			case 6:
+				g.a = g.read(0xff00 | uint16(g.fetch()))
+			case 7:
+				g.setHL(g.addSP())
+			default:
+				if g.cond(y) {
+					g.pc = g.pop()
+					cycles += 12
+				}
+			}
+		case 1:
+			switch {
+			case q == 0:
+				v := g.pop()
+				if p == 3 {
+					g.a, g.f = byte(v>>8), byte(v)&0xf0
+				} else {
+					g.setPair(p, v)
+				}
+			case p == 0, p == 1:
+				// RET and RETI
+				g.pc = g.pop()
+			case p == 2:
+				g.pc = g.hl()
+			default:
+				g.sp = g.hl()
+			}
+		case 2:
+			switch y {
+			case 4:
+				g.write(0xff00|uint16(g.c), g.a)
+			case 5:
+				g.write(g.fetch16(), g.a)
+			case 6:
+				g.a = g.read(0xff00 | uint16(g.c))
+			case 7:
+				g.a = g.read(g.fetch16())
+			default:
+				a := g.fetch16()
+				if g.cond(y) {
+					g.pc = a
+					cycles += 4
+				}
+			}
+		case 3:
+			switch y {
+			case 0:
+				g.pc = g.fetch16()
+			case 1:
+				cycles = g.cb()
+			}
+			// DI, EI and the undefined opcodes do nothing: interrupts
+			// are not emulated.
+		case 4:
+			if y < 4 {
+				a := g.fetch16()
+				if g.cond(y) {
+					g.push(g.pc)
+					g.pc = a
+					cycles += 12
+				}
+			}
+		case 5:
+			switch {
+			case q == 0 && p == 3:
+				g.push(uint16(g.a)<<8 | uint16(g.f))
+			case q == 0:
+				g.push(g.pair(p))
+			case p == 0:
+				a := g.fetch16()
+				g.push(g.pc)
+				g.pc = a
+			}
+		case 6:
+			g.alu(y, g.fetch())
+		case 7:
+			// RST jumps relative to the load address.
+			g.push(g.pc)
+			g.pc = g.load + uint16(y)*8
+		}
+	}
+	return cycles
+}
+
+// misc executes the accumulator rotates and flag instructions.
+func (g *gb) misc(y byte) {
+	a := g.a
+	switch y {
+	case 0:
+		g.a = a<<1 | a>>7
+		g.f = a >> 7 << 4
+	case 1:
+		g.a = a>>1 | a<<7
+		g.f = a & 1 << 4
+	case 2:
+		g.a = a<<1 | g.f>>4&1
+		g.f = a >> 7 << 4
+	case 3:
+		g.a = a>>1 | g.f&flagC<<3
+		g.f = a & 1 << 4
+	case 4:
+		// DAA
+		if g.f&flagN == 0 {
+			if g.f&flagC != 0 || a > 0x99 {
+				a += 0x60
+				g.f |= flagC
+			}
+			if g.f&flagH != 0 || a&0xf > 9 {
+				a += 6
+			}
+		} else {
+			if g.f&flagC != 0 {
+				a -= 0x60
+			}
+			if g.f&flagH != 0 {
+				a -= 6
+			}
+		}
+		g.a = a
+		g.f = g.f&(flagN|flagC) | zero(a)
+	case 5:
+		g.a = ^a
+		g.f |= flagN | flagH
+	case 6:
+		g.f = g.f&flagZ | flagC
+	case 7:
+		g.f = g.f&(flagZ|flagC) ^ flagC
+	}
+}
+
+// cb executes a CB prefixed instruction and returns its cycles.
+func (g *gb) cb() int {
+	op := g.fetch()
+	x, y, z := op>>6, op>>3&7, op&7
+	v := g.reg(z)
+	switch x {
+	case 0:
+		var c byte
+		switch y {
+		case 0:
+			c = v >> 7
+			v = v<<1 | c
+		case 1:
+			c = v & 1
+			v = v>>1 | c<<7
+		case 2:
+			c = v >> 7
+			v = v<<1 | g.f>>4&1
+		case 3:
+			c = v & 1
+			v = v>>1 | g.f&flagC<<3
+		case 4:
+			c = v >> 7
+			v <<= 1
+		case 5:
+			c = v & 1
+			v = v>>1 | v&0x80
+		case 6:
+			v = v<<4 | v>>4
+		case 7:
+			c = v & 1
+			v >>= 1
+		}
+		g.f = zero(v) | c<<4
+		g.setReg(z, v)
+	case 1:
+		g.f = g.f&flagC | flagH | zero(v&(1<<y))
+		if z == 6 {
+			return 12
+		}
+		return 8
+	case 2:
+		g.setReg(z, v&^(1<<y))
+	case 3:
+		g.setReg(z, v|1<<y)
+	}
+	if z == 6 {
+		return 16
+	}
+	return 8
+}
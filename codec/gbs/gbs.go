@@ -0,0 +1,392 @@
+// Package gbs plays Game Boy GBS files by emulating the LR35902 CPU and the
+// Game Boy sound hardware.
+package gbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("GBS", "GBS\x01", []string{"gbs"}, ReadGBSSongs)
+}
+
+const (
+	// rate is the output sample rate.
+	rate = 44100
+	// clock is the CPU clock rate.
+	clock = 4194304
+
+	headerSize = 0x70
+	// maxSize bounds the file: 256 banks of 16K.
+	maxSize = headerSize + 256*0x4000
+
+	// idle is the address the CPU returns to after init and play. It is
+	// in the I/O area, which holds no code.
+	idle = 0xff7f
+	// frameCycles is the number of cycles between vertical blanks.
+	frameCycles = 70224
+)
+
+var (
+	DefaultDuration = time.Minute * 2
+	DefaultFade     = time.Second * 2
+	// DefaultSilence is the time after which a silent song ends.
+	DefaultSilence = time.Second * 2
+)
+
+var errHeader = errors.New("gbs: bad header")
+
+// timerDivisors are the timer clock divisors selected by TAC.
+var timerDivisors = [4]int64{1024, 16, 64, 256}
+
+// file holds the parsed contents of a GBS file.
+type file struct {
+	songs                    int
+	load, init, play, sp     uint16
+	tma, tac                 byte
+	title, author, copyright string
+	// rom is the code, placed at the load address.
+	rom []byte
+	// size is the file size.
+	size int64
+}
+
+func headerString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func parse(b []byte) (*file, error) {
+	if len(b) < headerSize {
+		return nil, errHeader
+	}
+	le := binary.LittleEndian
+	f := &file{
+		songs:     int(b[4]),
+		load:      le.Uint16(b[6:]),
+		init:      le.Uint16(b[8:]),
+		play:      le.Uint16(b[0xa:]),
+		sp:        le.Uint16(b[0xc:]),
+		tma:       b[0xe],
+		tac:       b[0xf],
+		title:     headerString(b[0x10:0x30]),
+		author:    headerString(b[0x30:0x50]),
+		copyright: headerString(b[0x50:0x70]),
+	}
+	if f.load < 0x400 || f.load >= 0x8000 || f.songs == 0 {
+		return nil, errHeader
+	}
+	n := (int(f.load) + len(b) - headerSize + 0x3fff) &^ 0x3fff
+	f.rom = make([]byte, n)
+	copy(f.rom[f.load:], b[headerSize:])
+	return f, nil
+}
+
+// gb is the state of the emulated Game Boy.
+type gb struct {
+	a, f, b, c, d, e, h, l byte
+	sp, pc                 uint16
+
+	load uint16
+	play uint16
+	rom  []byte
+	bank int
+	ram  [0x10000]byte
+	apu  *apu
+
+	// cycles counts CPU cycles. The play routine is next called at
+	// nextPlay.
+	cycles, nextPlay int64
+	// double is set for tunes that run the CPU at double speed.
+	double bool
+	out    []float32
+}
+
+func newGB(f *file, song int) *gb {
+	g := &gb{
+		load: f.load,
+		play: f.play,
+		rom:  f.rom,
+		bank: 1,
+		apu:  newAPU(),
+		a:    byte(song - 1),
+		sp:   f.sp,
+		pc:   idle,
+	}
+	g.ram[0xff06] = f.tma
+	g.ram[0xff07] = f.tac
+	g.double = f.tac&0x80 != 0
+	g.call(f.init)
+	return g
+}
+
+func (g *gb) read(a uint16) byte {
+	switch {
+	case a < 0x4000:
+		return g.rom[a]
+	case a < 0x8000:
+		if i := g.bank*0x4000 + int(a-0x4000); i < len(g.rom) {
+			return g.rom[i]
+		}
+		return 0xff
+	case a >= 0xe000 && a < 0xfe00:
+		return g.ram[a-0x2000]
+	case a >= 0xff10 && a < 0xff40:
+		return g.apu.read(a)
+	case a == 0xff04:
+		return byte(g.cycles >> 8)
+	}
+	return g.ram[a]
+}
+
+func (g *gb) write(a uint16, b byte) {
+	switch {
+	case a >= 0x2000 && a < 0x4000:
+		g.bank = int(b)
+		if g.bank == 0 {
+			g.bank = 1
+		}
+	case a < 0x8000:
+		// ROM
+	case a >= 0xe000 && a < 0xfe00:
+		g.ram[a-0x2000] = b
+	case a >= 0xff10 && a < 0xff40:
+		g.apu.write(a, b)
+	default:
+		g.ram[a] = b
+	}
+}
+
+// call calls addr as a subroutine that returns to idle.
+func (g *gb) call(addr uint16) {
+	g.push(idle)
+	g.pc = addr
+}
+
+// halt stops the CPU until the next call to play, as interrupts are not
+// emulated.
+func (g *gb) halt() {
+	g.pc = idle
+}
+
+// period returns the number of cycles between calls to play. It is the
+// timer period if the timer is enabled, or else the vertical blank.
+func (g *gb) period() int64 {
+	tac := g.ram[0xff07]
+	if tac&4 == 0 {
+		if g.double {
+			return frameCycles * 2
+		}
+		return frameCycles
+	}
+	return (256 - int64(g.ram[0xff06])) * timerDivisors[tac&3]
+}
+
+// run fills out with stereo samples.
+func (g *gb) run(out []float32) {
+	for len(g.out) < len(out) {
+		if g.cycles >= g.nextPlay {
+			g.nextPlay += g.period()
+			if g.pc == idle {
+				g.call(g.play)
+			}
+		}
+		var n int
+		if g.pc == idle {
+			n = int(g.nextPlay - g.cycles)
+			if n > 1024 {
+				n = 1024
+			}
+			n = (n + 3) &^ 3
+		} else {
+			n = g.step()
+		}
+		g.cycles += int64(n)
+		if g.double {
+			n /= 2
+		}
+		g.out = g.apu.run(n, g.out)
+	}
+	n := copy(out, g.out)
+	g.out = g.out[:copy(g.out, g.out[n:])]
+}
+
+func read(rf codec.Reader) (*file, error) {
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	f.size = int64(len(b))
+	return f, nil
+}
+
+// ReadGBSSongs returns a song for each subsong of a GBS file.
+func ReadGBSSongs(rf codec.Reader) ([]codec.Song, error) {
+	f, err := read(rf)
+	if err != nil {
+		return nil, err
+	}
+	songs := make([]codec.Song, f.songs)
+	for i := range songs {
+		songs[i] = &GBSSong{
+			Index:  i + 1,
+			Reader: rf,
+			f:      f,
+		}
+	}
+	return songs, nil
+}
+
+// GBSSong is one subsong of a GBS file. It plays for DefaultDuration and
+// then fades out, or ends early after DefaultSilence of silence.
+type GBSSong struct {
+	Index  int
+	Reader codec.Reader
+	f      *file
+	g      *gb
+	// played, silent, length and fade are in sample frames.
+	played, silent, length, fade int
+}
+
+func (s *GBSSong) file() (*file, error) {
+	if s.f != nil {
+		return s.f, nil
+	}
+	f, err := read(s.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return f, nil
+}
+
+func (s *GBSSong) Init() (sampleRate, channels int, err error) {
+	if s.g == nil {
+		f, err := s.file()
+		if err != nil {
+			return 0, 0, err
+		}
+		s.g = newGB(f, s.Index)
+		s.played, s.silent = 0, 0
+		s.length = int(DefaultDuration * rate / time.Second)
+		s.fade = int(DefaultFade * rate / time.Second)
+	}
+	return rate, 2, nil
+}
+
+func (s *GBSSong) Info() (info codec.SongInfo, err error) {
+	f, err := s.file()
+	if err != nil {
+		return
+	}
+	return codec.SongInfo{
+		Time:       DefaultDuration + DefaultFade,
+		Artist:     f.author,
+		Title:      fmt.Sprintf("%s:%02d", f.title, s.Index),
+		Album:      f.title,
+		Track:      float64(s.Index),
+		Comment:    f.copyright,
+		Codec:      "GBS",
+		SampleRate: rate,
+		Channels:   2,
+		Size:       f.size,
+	}, nil
+}
+
+func (s *GBSSong) Play(n int) ([]float32, error) {
+	frames := n / 2
+	if left := s.length + s.fade - s.played; frames > left {
+		frames = left
+	}
+	if frames <= 0 || s.silent > int(DefaultSilence*rate/time.Second) {
+		return nil, nil
+	}
+	out := make([]float32, frames*2)
+	s.g.run(out)
+	frames = s.countSilence(out)
+	for i := 0; i < frames; i++ {
+		if pos := s.played + i; pos >= s.length {
+			g := 1 - float32(pos-s.length)/float32(s.fade)
+			out[i*2] *= g
+			out[i*2+1] *= g
+		}
+	}
+	s.played += frames
+	return out[:frames*2], nil
+}
+
+// countSilence counts the silent frames of out and returns the number of
+// frames before the song ends for being silent too long. The end does not
+// depend on how playback is split into calls to Play and Seek.
+func (s *GBSSong) countSilence(out []float32) int {
+	limit := int(DefaultSilence * rate / time.Second)
+	for i := 0; i < len(out); i += 2 {
+		if math.Abs(float64(out[i])) >= 1e-4 || math.Abs(float64(out[i+1])) >= 1e-4 {
+			s.silent = 0
+		} else if s.silent++; s.silent > limit {
+			return i / 2
+		}
+	}
+	return len(out) / 2
+}
+
+// Seek seeks by emulating from the start, or from the current position when
+// seeking forward.
+func (s *GBSSong) Seek(offset time.Duration) error {
+	return codec.SeekSteps(s, offset)
+}
+
+// SeekStep emulates a step toward offset.
+func (s *GBSSong) SeekStep(offset time.Duration) (bool, error) {
+	if s.g == nil {
+		return false, errors.New("gbs: seek before init")
+	}
+	target := int(offset * rate / time.Second)
+	if max := s.length + s.fade; target > max {
+		target = max
+	}
+	if target < s.played {
+		s.g = newGB(s.f, s.Index)
+		s.played, s.silent = 0, 0
+	}
+	end := target
+	if step := int(codec.StepLength * rate / time.Second); end > s.played+step {
+		end = s.played + step
+	}
+	buf := make([]float32, 2*1024)
+	for s.played < end {
+		n := end - s.played
+		if n > 1024 {
+			n = 1024
+		}
+		s.g.run(buf[:2*n])
+		s.countSilence(buf[:2*n])
+		s.played += n
+	}
+	return s.played >= target, nil
+}
+
+func (s *GBSSong) Close() {
+	s.g = nil
+}
@@ -0,0 +1,137 @@
+package gbs
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// testFile returns a GBS file with two songs. Init plays a 512Hz square
+// wave on the left channel. The play routine of song 1 turns off the DAC
+// after 30 calls; song 2 plays forever.
+func testFile() []byte {
+	b := make([]byte, headerSize)
+	copy(b, "GBS\x01")
+	b[4], b[5] = 2, 1
+	le := binary.LittleEndian
+	le.PutUint16(b[6:], 0x400)
+	le.PutUint16(b[8:], 0x400)
+	le.PutUint16(b[0xa:], 0x420)
+	le.PutUint16(b[0xc:], 0xfffe)
+	copy(b[0x10:], "Square")
+	copy(b[0x30:], "Composer")
+	copy(b[0x50:], "1990 Test")
+
+	code := make([]byte, 0x40)
+	copy(code, []byte{
+		0xea, 0x01, 0xc0, // ld ($c001), a
+		0x3e, 0xf0, // ld a, $f0
+		0xe0, 0x12, // ldh ($12), a
+		0x3e, 0x80, // ld a, $80
+		0xe0, 0x11, // ldh ($11), a
+		0x3e, 0x00, // ld a, $00
+		0xe0, 0x13, // ldh ($13), a
+		0x3e, 0x87, // ld a, $87
+		0xe0, 0x14, // ldh ($14), a
+		0x3e, 0x10, // ld a, $10
+		0xe0, 0x25, // ldh ($25), a
+		0xc9, // ret
+	})
+	copy(code[0x20:], []byte{
+		0xfa, 0x01, 0xc0, // ld a, ($c001)
+		0xa7,             // and a
+		0xc0,             // ret nz
+		0x21, 0x00, 0xc0, // ld hl, $c000
+		0x34,     // inc (hl)
+		0x7e,     // ld a, (hl)
+		0xfe, 30, // cp 30
+		0xc0,       // ret nz
+		0xaf,       // xor a
+		0xe0, 0x12, // ldh ($12), a
+		0xc9, // ret
+	})
+	return append(b, code...)
+}
+
+// open returns song number song of testFile.
+func open(t *testing.T, song int) codec.Song {
+	return codectest.Open(t, ReadGBSSongs, codectest.Reader(testFile()), song-1, rate, 2)
+}
+
+func TestInfo(t *testing.T) {
+	songs, _ := ReadGBSSongs(codectest.Reader(testFile()))
+	info, err := songs[1].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Square:02" || info.Album != "Square" || info.Artist != "Composer" || info.Comment != "1990 Test" {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Time != DefaultDuration+DefaultFade {
+		t.Fatalf("time %v", info.Time)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	b := codectest.PlayAll(t, open(t, 1), 4096)
+	// The DAC is turned off after 30 frames of 1/59.7s, and the song ends
+	// after DefaultSilence more.
+	n := float64(len(b)/2) / rate
+	if want := 30/59.7 + DefaultSilence.Seconds(); math.Abs(n-want) > 0.1 {
+		t.Fatalf("played %.2fs, want %.2fs", n, want)
+	}
+	second := b[rate/10*2 : rate*4/10*2]
+	crossings := 0
+	for i := 2; i < len(second); i += 2 {
+		if (second[i-2] < 0) != (second[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 305 || crossings > 310 {
+		t.Fatalf("%d zero crossings in 0.3s, want 307", crossings)
+	}
+	// A full volume square wave on one of four channels, at full master
+	// volume.
+	if l, r := codectest.RMS(second, 0, 2), codectest.RMS(second, 1, 2); math.Abs(l-0.25) > 0.01 || r > 1e-3 {
+		t.Fatalf("rms %v, %v", l, r)
+	}
+}
+
+func TestFade(t *testing.T) {
+	defer func(d, f time.Duration) {
+		DefaultDuration, DefaultFade = d, f
+	}(DefaultDuration, DefaultFade)
+	DefaultDuration, DefaultFade = time.Second, time.Second
+	b := codectest.PlayAll(t, open(t, 2), 4096)
+	if len(b) != 2*rate*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	full := codectest.RMS(b[rate/2*2:rate*2], 0, 2)
+	if half := codectest.RMS(b[rate*3/2*2-rate/10:rate*3/2*2+rate/10], 0, 2); math.Abs(half/full-0.5) > 0.05 {
+		t.Fatalf("rms %v halfway through the fade, %v before", half, full)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	s := open(t, 1)
+	want := codectest.PlayAll(t, s, 4096)
+	for _, offset := range []time.Duration{time.Second, 100 * time.Millisecond, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		w := want[int(offset*rate/time.Second)*2:]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got %d samples, want %d", offset, len(got), len(w))
+		}
+		for i := range got {
+			if got[i] != w[i] {
+				t.Fatalf("%v: sample %d is %v, want %v", offset, i, got[i], w[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"github.com/mjibson/mog/_third_party/github.com/mjibson/id3"
+)
+
+// FillID3 sets the fields of si from the ID3v2 tag f. Fields that are not
+// tagged are left as they are.
+func FillID3(si *SongInfo, f *id3.File) {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&si.Title, f.Name)
+	set(&si.Artist, f.Artist)
+	set(&si.Album, f.Album)
+	set(&si.AlbumArtist, f.AlbumArtist)
+	set(&si.Composer, f.Composer)
+	set(&si.Genre, f.Genre)
+	set(&si.Date, f.Year)
+	set(&si.Comment, f.Comment)
+	if n, _ := ParseNumber(f.Track); n != 0 {
+		si.Track = float64(n)
+	}
+	if n, total := ParseNumber(f.Disc); n != 0 {
+		si.Disc, si.DiscTotal = n, total
+	}
+	if f.Compilation == "1" {
+		si.Compilation = true
+	}
+	for desc, v := range f.Extended {
+		si.SetReplayGain(desc, v)
+	}
+	if f.Image != nil {
+		si.ImageURL = f.Image.DataURL()
+	}
+}
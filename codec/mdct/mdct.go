@@ -0,0 +1,118 @@
+// Package mdct computes inverse modified discrete cosine transforms.
+package mdct
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// MDCT computes the inverse MDCT of one block size with an FFT of a
+// quarter of its length.
+type MDCT struct {
+	n int
+	// pre and post are the twiddle factors around the FFT.
+	pre, post []complex128
+	fft       *fft
+	buf       []complex128
+	u         []float64
+}
+
+// New returns an MDCT for blocks of n output samples. n must be a power of
+// two and at least 8.
+func New(n int) *MDCT {
+	m := n / 2
+	t := &MDCT{
+		n:    n,
+		pre:  make([]complex128, m/2),
+		post: make([]complex128, m/2),
+		fft:  newFFT(m / 2),
+		buf:  make([]complex128, m/2),
+		u:    make([]float64, m),
+	}
+	for i := range t.pre {
+		t.pre[i] = cmplx.Exp(complex(0, -math.Pi*(float64(i)+.25)/float64(m)))
+		t.post[i] = cmplx.Exp(complex(0, -math.Pi*float64(i)/float64(m)))
+	}
+	return t
+}
+
+// Inverse computes n output samples from the n/2 coefficients in x:
+//
+//	y[i] = sum(x[k] * cos(2*pi/n * (i + 1/2 + n/4) * (k + 1/2)))
+func (t *MDCT) Inverse(x []float32, y []float32) {
+	m := t.n / 2
+	// Compute the DCT-IV of x into u.
+	for i := range t.buf {
+		t.buf[i] = complex(float64(x[2*i]), float64(x[m-1-2*i])) * t.pre[i]
+	}
+	t.fft.transform(t.buf)
+	for i, v := range t.buf {
+		v *= t.post[i]
+		t.u[2*i] = real(v)
+		t.u[m-1-2*i] = -imag(v)
+	}
+	// Unfold using the symmetries of the DCT-IV.
+	h := m / 2
+	for i := 0; i < h; i++ {
+		y[i] = float32(t.u[i+h])
+	}
+	for i := h; i < 3*h; i++ {
+		y[i] = float32(-t.u[3*h-1-i])
+	}
+	for i := 3 * h; i < 2*m; i++ {
+		y[i] = float32(-t.u[i-3*h])
+	}
+}
+
+// fft is a radix-2 complex FFT.
+type fft struct {
+	n       int
+	twiddle []complex128
+	rev     []int
+}
+
+func newFFT(n int) *fft {
+	f := &fft{
+		n:       n,
+		twiddle: make([]complex128, n/2),
+		rev:     make([]int, n),
+	}
+	for i := range f.twiddle {
+		f.twiddle[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+	bits := uint(0)
+	for 1<<bits < n {
+		bits++
+	}
+	for i := range f.rev {
+		r := 0
+		for b := uint(0); b < bits; b++ {
+			if i&(1<<b) != 0 {
+				r |= 1 << (bits - 1 - b)
+			}
+		}
+		f.rev[i] = r
+	}
+	return f
+}
+
+func (f *fft) transform(x []complex128) {
+	for i, r := range f.rev {
+		if i < r {
+			x[i], x[r] = x[r], x[i]
+		}
+	}
+	for size := 2; size <= f.n; size <<= 1 {
+		half := size / 2
+		step := f.n / size
+		for start := 0; start < f.n; start += size {
+			for k := 0; k < half; k++ {
+				w := f.twiddle[k*step]
+				a := x[start+k]
+				b := x[start+k+half] * w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package midi plays Standard MIDI Files through a SoundFont wavetable
+// synthesizer.
+package midi
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("MIDI", "MThd", []string{"mid", "midi", "kar"}, ReadMIDI)
+}
+
+const (
+	// rate is the output sample rate.
+	rate = 44100
+	// maxSize bounds the size of a MIDI file.
+	maxSize = 16 << 20
+	// tail lets notes release after the last event, in sample frames.
+	tail = rate
+)
+
+var errNoSoundFont = errors.New("midi: no SoundFont loaded")
+
+var soundFonts = struct {
+	sync.RWMutex
+	sf *soundFont
+}{}
+
+// LoadSoundFont loads the SF2 SoundFont used to render MIDI files.
+func LoadSoundFont(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sf, err := parseSoundFont(b)
+	if err != nil {
+		return err
+	}
+	soundFonts.Lock()
+	soundFonts.sf = sf
+	soundFonts.Unlock()
+	return nil
+}
+
+func loadedSoundFont() *soundFont {
+	soundFonts.RLock()
+	defer soundFonts.RUnlock()
+	return soundFonts.sf
+}
+
+func read(rf codec.Reader) (*smf, error) {
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseSMF(b)
+	if err != nil {
+		return nil, err
+	}
+	f.size = int64(len(b))
+	return f, nil
+}
+
+// ReadMIDI returns the song of a MIDI file.
+func ReadMIDI(rf codec.Reader) ([]codec.Song, error) {
+	f, err := read(rf)
+	if err != nil {
+		return nil, err
+	}
+	return []codec.Song{&MIDI{Reader: rf, f: f}}, nil
+}
+
+// MIDI is a Standard MIDI File of type 0 or 1.
+type MIDI struct {
+	Reader codec.Reader
+	f      *smf
+	p      *player
+}
+
+func (s *MIDI) file() (*smf, error) {
+	if s.f != nil {
+		return s.f, nil
+	}
+	f, err := read(s.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return f, nil
+}
+
+func (s *MIDI) Init() (sampleRate, channels int, err error) {
+	if s.p == nil {
+		f, err := s.file()
+		if err != nil {
+			return 0, 0, err
+		}
+		sf := loadedSoundFont()
+		if sf == nil {
+			return 0, 0, errNoSoundFont
+		}
+		s.p = newPlayer(f, sf)
+	}
+	return rate, 2, nil
+}
+
+func (s *MIDI) Info() (info codec.SongInfo, err error) {
+	f, err := s.file()
+	if err != nil {
+		return
+	}
+	comment := f.text
+	if f.copyright != "" {
+		comment = append([]string{f.copyright}, comment...)
+	}
+	return codec.SongInfo{
+		Time:       time.Duration(f.length+tail) * time.Second / rate,
+		Title:      f.title,
+		Comment:    strings.Join(comment, "\n"),
+		Codec:      "MIDI",
+		SampleRate: rate,
+		Channels:   2,
+		Size:       f.size,
+	}, nil
+}
+
+func (s *MIDI) Play(n int) ([]float32, error) {
+	out := make([]float32, n/2*2)
+	frames := s.p.render(out, len(out)/2)
+	return out[:frames*2], nil
+}
+
+// Seek seeks by replaying events from the start, or from the current
+// position when seeking forward, without sounding notes.
+func (s *MIDI) Seek(offset time.Duration) error {
+	return codec.SeekSteps(s, offset)
+}
+
+// SeekStep replays a step of events toward offset. Notes held at offset
+// sound once it is reached.
+func (s *MIDI) SeekStep(offset time.Duration) (bool, error) {
+	if s.p == nil {
+		return false, errors.New("midi: seek before init")
+	}
+	target := int64(offset * rate / time.Second)
+	if target < s.p.pos {
+		s.p = newPlayer(s.p.f, s.p.s.sf)
+	}
+	s.p.s.voices = nil
+	n := target - s.p.pos
+	if step := int64(codec.StepLength * rate / time.Second); n > step {
+		n = step
+	}
+	if s.p.render(nil, int(n)) < int(n) || s.p.pos >= target {
+		s.p.resume()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MIDI) Close() {
+	s.p = nil
+}
+
+// player sequences the events of a file into a synth.
+type player struct {
+	f    *smf
+	s    *synth
+	next int
+	// pos is the current time in sample frames.
+	pos int64
+	// held is the velocity of keys held during rendering without notes.
+	held [16][128]byte
+}
+
+func newPlayer(f *smf, sf *soundFont) *player {
+	return &player{f: f, s: newSynth(sf)}
+}
+
+// resume starts the notes held when rendering without notes.
+func (p *player) resume() {
+	for ch := range p.held {
+		for key, vel := range p.held[ch] {
+			if vel != 0 {
+				p.s.noteOn(ch, key, int(vel))
+				p.held[ch][key] = 0
+			}
+		}
+	}
+}
+
+// render renders up to frames frames into out and returns the number
+// rendered. A nil out skips notes and mixing.
+func (p *player) render(out []float32, frames int) int {
+	done := 0
+	for done < frames && p.pos < p.f.length+tail {
+		for p.next < len(p.f.events) && p.f.events[p.next].time <= p.pos {
+			e := p.f.events[p.next]
+			p.next++
+			if s := e.status & 0xf0; out == nil && (s == 0x80 || s == 0x90) {
+				v := e.b
+				if s == 0x80 {
+					v = 0
+				}
+				p.held[e.status&0xf][e.a] = v
+				continue
+			}
+			p.s.message(e)
+		}
+		n := int64(frames - done)
+		if p.next < len(p.f.events) {
+			if d := p.f.events[p.next].time - p.pos; d < n {
+				n = d
+			}
+		}
+		if d := p.f.length + tail - p.pos; d < n {
+			n = d
+		}
+		if out != nil {
+			p.s.render(out[done*2 : (done+int(n))*2])
+		}
+		done += int(n)
+		p.pos += n
+	}
+	return done
+}
@@ -0,0 +1,214 @@
+package midi
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+func chunk(id string, data ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b, id)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func list(typ string, data ...[]byte) []byte {
+	return chunk("LIST", append([][]byte{[]byte(typ)}, data...)...)
+}
+
+// record returns a little endian record of a name padded to 20 bytes,
+// followed by the 16-bit values in16 and the 32-bit values in32.
+func record(name string, in16 []int, in32 []int) []byte {
+	b := make([]byte, 20)
+	copy(b, name)
+	for _, v := range in16 {
+		b = append(b, byte(v), byte(v>>8))
+	}
+	for _, v := range in32 {
+		b = append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	return b
+}
+
+// u16 returns the values as little endian 16-bit integers.
+func u16(v ...int) []byte {
+	var b []byte
+	for _, x := range v {
+		b = append(b, byte(x), byte(x>>8))
+	}
+	return b
+}
+
+// testSoundFont returns a SoundFont with one preset that plays a looped
+// square wave of 100 samples at 44.1kHz, so 441Hz, at key 69.
+func testSoundFont() []byte {
+	smpl := make([]byte, 2*(100+46))
+	for i := 0; i < 100; i++ {
+		v := 16384
+		if i >= 50 {
+			v = -16384
+		}
+		binary.LittleEndian.PutUint16(smpl[i*2:], uint16(v))
+	}
+	shdr := append(record("Square", nil, []int{0, 100, 0, 100, rate}), 69, 0, 0, 0, 1, 0)
+	shdr = append(shdr, make([]byte, 46)...)
+	copy(shdr[46:], "EOS")
+	return chunk("RIFF", []byte("sfbk"),
+		list("INFO", chunk("ifil", u16(2, 1)), chunk("INAM", []byte("Test\x00\x00"))),
+		list("sdta", chunk("smpl", smpl)),
+		list("pdta",
+			chunk("phdr", record("Square", []int{0, 0, 0}, []int{0, 0, 0}), record("EOP", []int{0, 0, 1}, []int{0, 0, 0})),
+			chunk("pbag", u16(0, 0, 1, 0)),
+			chunk("pmod", make([]byte, 10)),
+			chunk("pgen", u16(genInstrument, 0, 0, 0)),
+			chunk("inst", record("Square", []int{0}, nil), record("EOI", []int{1}, nil)),
+			chunk("ibag", u16(0, 0, 2, 0)),
+			chunk("imod", make([]byte, 10)),
+			chunk("igen", u16(genSampleModes, modeLoop, genSampleID, 0, 0, 0)),
+			chunk("shdr", shdr),
+		),
+	)
+}
+
+func loadSoundFont(t *testing.T) {
+	f, err := ioutil.TempFile("", "sf2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(testSoundFont())
+	f.Close()
+	if err := LoadSoundFont(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unloadSoundFont() {
+	soundFonts.Lock()
+	soundFonts.sf = nil
+	soundFonts.Unlock()
+}
+
+// testFile returns a type 0 MIDI file at 120 beats per minute that plays
+// key 69 for a second.
+func testFile() []byte {
+	trk := []byte{
+		0x00, 0xff, 0x03, 4, 'S', 'o', 'n', 'g',
+		0x00, 0xff, 0x02, 4, '(', 'c', ')', ' ',
+		0x00, 0xff, 0x01, 4, 'T', 'e', 'x', 't',
+		0x00, 0x90, 69, 127,
+		0x87, 0x40, 0x80, 69, 0, // 960 ticks later
+		0x00, 0xff, 0x2f, 0x00,
+	}
+	// MIDI files are big endian.
+	b := []byte("MThd\x00\x00\x00\x06\x00\x00\x00\x01\x01\xe0MTrk")
+	b = append(b, 0, 0, 0, byte(len(trk)))
+	b = append(b, trk...)
+	return b
+}
+
+func open(t *testing.T) codec.Song {
+	return codectest.Open(t, ReadMIDI, codectest.Reader(testFile()), 0, rate, 2)
+}
+
+// seconds returns the samples from start to end seconds.
+func seconds(b []float32, start, end float64) []float32 {
+	return b[int(start*rate)*2 : int(end*rate)*2]
+}
+
+func TestInfo(t *testing.T) {
+	songs, err := ReadMIDI(codectest.Reader(testFile()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := songs[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Song" || info.Comment != "(c)\nText" {
+		t.Fatalf("got %+v", info)
+	}
+	// The last event and the release tail.
+	if info.Time != 2*time.Second {
+		t.Fatalf("time %v", info.Time)
+	}
+	unloadSoundFont()
+	if _, _, err := songs[0].Init(); err != errNoSoundFont {
+		t.Fatalf("init without a SoundFont: %v", err)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	loadSoundFont(t)
+	defer unloadSoundFont()
+	b := codectest.PlayAll(t, open(t), 4096)
+	if len(b) != 2*rate*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	note := seconds(b, 0.1, 0.9)
+	crossings := 0
+	for i := 2; i < len(note); i += 2 {
+		if (note[i-2] < 0) != (note[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 704 || crossings > 708 {
+		t.Fatalf("%d zero crossings in 0.8s, want 706", crossings)
+	}
+	// Half scale at the default channel volume of 100, centered.
+	want := 0.5 * math.Pow(100.0/127, 2) * gain * math.Sqrt(0.5)
+	for ch := 0; ch < 2; ch++ {
+		if r := codectest.RMS(note, ch, 2); math.Abs(r-want) > want*0.01 {
+			t.Fatalf("channel %d: rms %v, want %v", ch, r, want)
+		}
+	}
+	if r := codectest.RMS(seconds(b, 1.05, 2), 0, 2); r != 0 {
+		t.Fatalf("rms %v after the note off", r)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	loadSoundFont(t)
+	defer unloadSoundFont()
+	s := open(t)
+	want := codectest.PlayAll(t, s, 4096)
+	// Seeking into the note restarts it.
+	if err := s.(codec.Seeker).Seek(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	got := codectest.PlayAll(t, s, 4096)
+	if len(got) != len(want)*3/4 {
+		t.Fatalf("got %d samples, want %d", len(got), len(want)*3/4)
+	}
+	if r, w := codectest.RMS(seconds(got, 0.1, 0.4), 0, 2), codectest.RMS(seconds(want, 0.6, 0.9), 0, 2); math.Abs(r-w) > w*0.01 {
+		t.Fatalf("rms %v after seeking, want %v", r, w)
+	}
+	if r := codectest.RMS(seconds(got, 0.55, 1.5), 0, 2); r != 0 {
+		t.Fatalf("rms %v after the note off", r)
+	}
+	for _, offset := range []time.Duration{1500 * time.Millisecond, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		w := want[int(offset*rate/time.Second)*2:]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got %d samples, want %d", offset, len(got), len(w))
+		}
+		for i := range got {
+			if got[i] != w[i] {
+				t.Fatalf("%v: sample %d is %v, want %v", offset, i, got[i], w[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package midi
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errSoundFont = errors.New("midi: bad SoundFont")
+
+// SoundFont generator operators.
+const (
+	genStartOffset       = 0
+	genEndOffset         = 1
+	genLoopStartOffset   = 2
+	genLoopEndOffset     = 3
+	genStartCoarse       = 4
+	genVibLfoToPitch     = 6
+	genFilterFc          = 8
+	genFilterQ           = 9
+	genEndCoarse         = 12
+	genPan               = 17
+	genVibLfoDelay       = 23
+	genVibLfoFreq        = 24
+	genVolEnvDelay       = 33
+	genVolEnvAttack      = 34
+	genVolEnvHold        = 35
+	genVolEnvDecay       = 36
+	genVolEnvSustain     = 37
+	genVolEnvRelease     = 38
+	genKeyToVolEnvHold   = 39
+	genKeyToVolEnvDecay  = 40
+	genInstrument        = 41
+	genKeyRange          = 43
+	genVelRange          = 44
+	genLoopStartCoarse   = 45
+	genKeynum            = 46
+	genVelocity          = 47
+	genAttenuation       = 48
+	genLoopEndCoarse     = 50
+	genCoarseTune        = 51
+	genFineTune          = 52
+	genSampleID          = 53
+	genSampleModes       = 54
+	genScaleTuning       = 56
+	genExclusiveClass    = 57
+	genOverridingRootKey = 58
+	numGens              = 61
+)
+
+// genDefaults are the generator values of an instrument zone that does not
+// set them.
+var genDefaults = func() (g [numGens]int) {
+	g[genFilterFc] = 13500
+	for _, i := range []int{genVibLfoDelay, genVolEnvDelay, genVolEnvAttack, genVolEnvHold, genVolEnvDecay, genVolEnvRelease, 21, 25, 26, 27, 28, 30} {
+		g[i] = -12000
+	}
+	g[genScaleTuning] = 100
+	g[genKeynum] = -1
+	g[genVelocity] = -1
+	g[genOverridingRootKey] = -1
+	return
+}()
+
+// sfSample is a sample header. Positions index the soundFont data.
+type sfSample struct {
+	start, end         int
+	loopStart, loopEnd int
+	rate               int
+	key                int
+	correction         int
+	rom                bool
+}
+
+// sfZone is a preset or instrument zone. Instrument zones hold absolute
+// generator values; preset zones hold values added to them.
+type sfZone struct {
+	keyLo, keyHi int
+	velLo, velHi int
+	gens         [numGens]int
+	inst         *sfInstrument
+	smp          *sfSample
+}
+
+func (z *sfZone) match(key, vel int) bool {
+	return key >= z.keyLo && key <= z.keyHi && vel >= z.velLo && vel <= z.velHi
+}
+
+type sfInstrument struct {
+	zones []*sfZone
+}
+
+type sfPreset struct {
+	name          string
+	bank, program int
+	zones         []*sfZone
+}
+
+// soundFont is a parsed SF2 file.
+type soundFont struct {
+	name    string
+	data    []int16
+	presets map[int]*sfPreset
+}
+
+// preset returns the preset of bank and program, falling back to bank 0 or
+// 128 for percussion, and to the first preset.
+func (sf *soundFont) preset(bank, program int) *sfPreset {
+	if p := sf.presets[bank<<7|program]; p != nil {
+		return p
+	}
+	fallback := 0
+	if bank == 128 {
+		fallback = 128
+		program = 0
+	}
+	if p := sf.presets[fallback<<7|program]; p != nil {
+		return p
+	}
+	if bank == 128 {
+		if p := sf.presets[128<<7]; p != nil {
+			return p
+		}
+		return nil
+	}
+	var first *sfPreset
+	for _, p := range sf.presets {
+		if p.bank != 128 && (first == nil || p.bank<<7|p.program < first.bank<<7|first.program) {
+			first = p
+		}
+	}
+	return first
+}
+
+// riffChunks returns the sub-chunks of b by id. LIST chunks are keyed by
+// their list type.
+func riffChunks(b []byte) map[string][]byte {
+	m := make(map[string][]byte)
+	for len(b) >= 8 {
+		id, size := string(b[:4]), int(binary.LittleEndian.Uint32(b[4:]))
+		b = b[8:]
+		if size > len(b) || size < 0 {
+			size = len(b)
+		}
+		c := b[:size]
+		if id == "LIST" && len(c) >= 4 {
+			id, c = string(c[:4]), c[4:]
+		}
+		m[id] = c
+		b = b[size:]
+		if size&1 != 0 && len(b) > 0 {
+			b = b[1:]
+		}
+	}
+	return m
+}
+
+func cstring(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+type sfGen struct {
+	oper   int
+	amount int
+}
+
+// sfBag is a zone's generator list.
+type sfBag []sfGen
+
+// apply sets the generators of a bag on z and returns the terminal
+// generator's value, or -1.
+func (bag sfBag) apply(z *sfZone) int {
+	term := -1
+	for _, g := range bag {
+		switch g.oper {
+		case genKeyRange:
+			z.keyLo, z.keyHi = g.amount&0xff, g.amount>>8&0xff
+		case genVelRange:
+			z.velLo, z.velHi = g.amount&0xff, g.amount>>8&0xff
+		case genInstrument, genSampleID:
+			term = g.amount & 0xffff
+		default:
+			if g.oper < numGens {
+				z.gens[g.oper] = int(int16(g.amount))
+			}
+		}
+	}
+	return term
+}
+
+// readBags returns the generator lists of the zones of each size-byte
+// record in rec, whose bag index is at offset bi.
+func readBags(rec []byte, size, bi int, bag, gen []byte) [][]sfBag {
+	le := binary.LittleEndian
+	nrec := len(rec) / size
+	nbag, ngen := len(bag)/4, len(gen)/4
+	var out [][]sfBag
+	for i := 0; i+1 < nrec; i++ {
+		b0, b1 := int(le.Uint16(rec[i*size+bi:])), int(le.Uint16(rec[(i+1)*size+bi:]))
+		var zones []sfBag
+		for j := b0; j < b1 && j < nbag; j++ {
+			g0 := int(le.Uint16(bag[j*4:]))
+			g1 := ngen
+			if j+1 < nbag {
+				g1 = int(le.Uint16(bag[(j+1)*4:]))
+			}
+			var z sfBag
+			for k := g0; k < g1 && k < ngen; k++ {
+				z = append(z, sfGen{int(le.Uint16(gen[k*4:])), int(le.Uint16(gen[k*4+2:]))})
+			}
+			zones = append(zones, z)
+		}
+		out = append(out, zones)
+	}
+	return out
+}
+
+func parseSoundFont(b []byte) (*soundFont, error) {
+	le := binary.LittleEndian
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "sfbk" {
+		return nil, errSoundFont
+	}
+	top := riffChunks(b[12:])
+	info, sdta, pdta := riffChunks(top["INFO"]), riffChunks(top["sdta"]), riffChunks(top["pdta"])
+	smpl := sdta["smpl"]
+	if len(smpl) == 0 || pdta["phdr"] == nil {
+		return nil, errSoundFont
+	}
+	sf := &soundFont{
+		name:    cstring(info["INAM"]),
+		data:    make([]int16, len(smpl)/2),
+		presets: make(map[int]*sfPreset),
+	}
+	for i := range sf.data {
+		sf.data[i] = int16(le.Uint16(smpl[i*2:]))
+	}
+
+	shdr := pdta["shdr"]
+	var samples []*sfSample
+	for i := 0; (i+1)*46 <= len(shdr); i++ {
+		h := shdr[i*46:]
+		s := &sfSample{
+			start:      int(le.Uint32(h[20:])),
+			end:        int(le.Uint32(h[24:])),
+			loopStart:  int(le.Uint32(h[28:])),
+			loopEnd:    int(le.Uint32(h[32:])),
+			rate:       int(le.Uint32(h[36:])),
+			key:        int(h[40]),
+			correction: int(int8(h[41])),
+			rom:        le.Uint16(h[44:])&0x8000 != 0,
+		}
+		if s.key > 127 {
+			s.key = 60
+		}
+		if s.rate == 0 {
+			s.rate = rate
+		}
+		samples = append(samples, s)
+	}
+
+	var instruments []*sfInstrument
+	for _, bags := range readBags(pdta["inst"], 22, 20, pdta["ibag"], pdta["igen"]) {
+		in := new(sfInstrument)
+		instruments = append(instruments, in)
+		global := sfZone{keyHi: 127, velHi: 127, gens: genDefaults}
+		for j, bag := range bags {
+			z := global
+			term := bag.apply(&z)
+			if term < 0 {
+				if j == 0 {
+					global = z
+				}
+				continue
+			}
+			if term >= len(samples) || samples[term].rom {
+				continue
+			}
+			z.smp = samples[term]
+			in.zones = append(in.zones, &z)
+		}
+	}
+
+	phdr := pdta["phdr"]
+	for i, bags := range readBags(phdr, 38, 24, pdta["pbag"], pdta["pgen"]) {
+		h := phdr[i*38:]
+		p := &sfPreset{
+			name:    cstring(h[:20]),
+			program: int(le.Uint16(h[20:])) & 0x7f,
+			bank:    int(le.Uint16(h[22:])),
+		}
+		if p.bank > 128 {
+			continue
+		}
+		global := sfZone{keyHi: 127, velHi: 127}
+		for j, bag := range bags {
+			z := global
+			term := bag.apply(&z)
+			if term < 0 {
+				if j == 0 {
+					global = z
+				}
+				continue
+			}
+			if term >= len(instruments) {
+				continue
+			}
+			z.inst = instruments[term]
+			p.zones = append(p.zones, &z)
+		}
+		if _, ok := sf.presets[p.bank<<7|p.program]; !ok {
+			sf.presets[p.bank<<7|p.program] = p
+		}
+	}
+	if len(sf.presets) == 0 {
+		return nil, errSoundFont
+	}
+	return sf, nil
+}
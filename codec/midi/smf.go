@@ -0,0 +1,199 @@
+package midi
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+var errFormat = errors.New("midi: bad file")
+
+// event is a channel message at a time in sample frames.
+type event struct {
+	tick   int64
+	time   int64
+	status byte
+	a, b   byte
+	// tempo is set for tempo changes, in microseconds per quarter note.
+	tempo int
+}
+
+// smf is a parsed Standard MIDI File with all tracks merged.
+type smf struct {
+	events []event
+	// length is the time of the last event in sample frames.
+	length    int64
+	title     string
+	copyright string
+	text      []string
+	// size is the file size.
+	size int64
+}
+
+// readVar reads a variable-length quantity.
+func readVar(b []byte, i *int) (int, error) {
+	v := 0
+	for n := 0; n < 4; n++ {
+		if *i >= len(b) {
+			return 0, errFormat
+		}
+		c := b[*i]
+		*i++
+		v = v<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errFormat
+}
+
+func parseSMF(b []byte) (*smf, error) {
+	be := binary.BigEndian
+	if len(b) < 14 || string(b[:4]) != "MThd" {
+		return nil, errFormat
+	}
+	hlen := int(be.Uint32(b[4:]))
+	format, ntracks, division := be.Uint16(b[8:]), int(be.Uint16(b[10:])), int(be.Uint16(b[12:]))
+	if format > 1 || division == 0 || 8+hlen > len(b) {
+		return nil, errFormat
+	}
+	f := new(smf)
+	var events []event
+	o := 8 + hlen
+	for t := 0; t < ntracks && o+8 <= len(b); t++ {
+		id, size := string(b[o:o+4]), int(be.Uint32(b[o+4:]))
+		o += 8
+		end := o + size
+		if end > len(b) || end < o {
+			end = len(b)
+		}
+		trk := b[o:end]
+		o = end
+		if id != "MTrk" {
+			t--
+			continue
+		}
+		var tick int64
+		var status byte
+		for i := 0; i < len(trk); {
+			delta, err := readVar(trk, &i)
+			if err != nil {
+				break
+			}
+			tick += int64(delta)
+			if i >= len(trk) {
+				break
+			}
+			c := trk[i]
+			switch {
+			case c == 0xff:
+				if i+2 > len(trk) {
+					i = len(trk)
+					break
+				}
+				typ := trk[i+1]
+				i += 2
+				n, err := readVar(trk, &i)
+				if err != nil || i+n > len(trk) {
+					i = len(trk)
+					break
+				}
+				data := trk[i : i+n]
+				i += n
+				switch typ {
+				case 0x51:
+					if n == 3 {
+						events = append(events, event{tick: tick, tempo: int(data[0])<<16 | int(data[1])<<8 | int(data[2])})
+					}
+				case 0x03:
+					if t == 0 && f.title == "" {
+						f.title = strings.TrimSpace(string(data))
+					}
+				case 0x02:
+					if f.copyright == "" {
+						f.copyright = strings.TrimSpace(string(data))
+					}
+				case 0x01:
+					if s := strings.TrimSpace(string(data)); s != "" {
+						f.text = append(f.text, s)
+					}
+				case 0x2f:
+					i = len(trk)
+				}
+				// Track ends count toward the length.
+				events = append(events, event{tick: tick})
+			case c == 0xf0 || c == 0xf7:
+				i++
+				n, err := readVar(trk, &i)
+				if err != nil {
+					i = len(trk)
+					break
+				}
+				i += n
+			default:
+				if c&0x80 != 0 {
+					status = c
+					i++
+				}
+				if status == 0 {
+					// Data without a status byte.
+					i = len(trk)
+					break
+				}
+				e := event{tick: tick, status: status}
+				n := 2
+				if s := status & 0xf0; s == 0xc0 || s == 0xd0 {
+					n = 1
+				}
+				if i+n > len(trk) {
+					i = len(trk)
+					break
+				}
+				e.a = trk[i] & 0x7f
+				if n == 2 {
+					e.b = trk[i+1] & 0x7f
+				}
+				i += n
+				events = append(events, e)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].tick < events[j].tick })
+
+	// Convert ticks to time with the tempo map.
+	var secPerTick float64
+	smpte := division&0x8000 != 0
+	if smpte {
+		fps := -int(int8(division >> 8))
+		if fps <= 0 || division&0xff == 0 {
+			return nil, errFormat
+		}
+		secPerTick = 1 / float64(fps*(division&0xff))
+	} else {
+		secPerTick = 0.5 / float64(division)
+	}
+	var lastTick int64
+	var sec float64
+	for _, e := range events {
+		sec += float64(e.tick-lastTick) * secPerTick
+		lastTick = e.tick
+		if e.tempo != 0 {
+			if !smpte {
+				secPerTick = float64(e.tempo) / 1e6 / float64(division)
+			}
+			continue
+		}
+		e.time = int64(sec * rate)
+		if e.time > f.length {
+			f.length = e.time
+		}
+		if e.status != 0 {
+			f.events = append(f.events, e)
+		}
+	}
+	if len(f.events) == 0 {
+		return nil, errFormat
+	}
+	return f, nil
+}
@@ -0,0 +1,507 @@
+package midi
+
+import "math"
+
+const (
+	// maxVoices bounds polyphony. New notes steal the quietest voice.
+	maxVoices = 64
+	// gain scales the mix of all voices.
+	gain = 0.5
+	// drums is the percussion channel.
+	drums = 9
+	// silent is the envelope level at which voices stop.
+	silent = 1e-4
+)
+
+// Envelope stages.
+const (
+	envDelay = iota
+	envAttack
+	envHold
+	envDecay
+	envSustain
+	envRelease
+	envDone
+)
+
+// Sample modes.
+const (
+	modeNoLoop  = 0
+	modeLoop    = 1
+	modeRelease = 3
+)
+
+type channel struct {
+	bank, program int
+	preset        *sfPreset
+	volume        int
+	expression    int
+	pan           int
+	modWheel      int
+	sustain       bool
+	// bend is the pitch wheel in cents.
+	bend      float64
+	bendRange float64
+	wheel     int
+	rpn       int
+}
+
+func (c *channel) resetControllers() {
+	c.volume, c.expression, c.pan = 100, 127, 64
+	c.modWheel = 0
+	c.sustain = false
+	c.wheel, c.bend = 8192, 0
+	c.rpn = 0x3fff
+}
+
+type voice struct {
+	ch, key int
+	// on is cleared when the key is released.
+	on        bool
+	sustained bool
+	exclusive int
+	age       int
+
+	data               []int16
+	pos, step          float64
+	end                int
+	loopStart, loopEnd int
+	mode               int
+
+	amp float64
+	pan float64
+
+	stage      int
+	level      float64
+	counter    int
+	attackInc  float64
+	hold       int
+	decayMul   float64
+	sustainLvl float64
+	releaseMul float64
+	vibDelay   int
+	vibPhase   float64
+	vibInc     float64
+	vibDepth   float64
+	filter     bool
+	b0, b1, b2 float64
+	a1, a2     float64
+	x1, x2     float64
+	y1, y2     float64
+}
+
+// samples converts timecents to sample frames.
+func samples(tc int) int {
+	if tc < -12000 {
+		tc = -12000
+	}
+	if tc > 8000 {
+		tc = 8000
+	}
+	return int(rate * math.Pow(2, float64(tc)/1200))
+}
+
+// fall returns the per-frame multiplier of a 96 dB fall over tc timecents.
+func fall(tc int) float64 {
+	return math.Pow(10, -4.8/float64(samples(tc)+1))
+}
+
+// absCents converts absolute cents to Hz.
+func absCents(c int) float64 {
+	return 8.176 * math.Pow(2, float64(c)/1200)
+}
+
+func (v *voice) release() {
+	if v.stage == envDone {
+		return
+	}
+	v.stage = envRelease
+	v.on, v.sustained = false, false
+}
+
+// envelope advances the volume envelope by one frame.
+func (v *voice) envelope() {
+	switch v.stage {
+	case envDelay:
+		if v.counter--; v.counter <= 0 {
+			v.stage = envAttack
+		}
+	case envAttack:
+		if v.level += v.attackInc; v.level >= 1 {
+			v.level, v.stage, v.counter = 1, envHold, v.hold
+		}
+	case envHold:
+		if v.counter--; v.counter <= 0 {
+			v.stage = envDecay
+		}
+	case envDecay:
+		if v.level *= v.decayMul; v.level <= v.sustainLvl {
+			v.level, v.stage = v.sustainLvl, envSustain
+		}
+		if v.level < silent {
+			v.stage = envDone
+		}
+	case envSustain:
+		if v.level < silent {
+			v.stage = envDone
+		}
+	case envRelease:
+		if v.level *= v.releaseMul; v.level < silent {
+			v.stage = envDone
+		}
+	}
+}
+
+// synth is a SoundFont wavetable synthesizer with General MIDI channels.
+type synth struct {
+	sf     *soundFont
+	ch     [16]channel
+	voices []*voice
+	age    int
+}
+
+func newSynth(sf *soundFont) *synth {
+	s := &synth{sf: sf}
+	for i := range s.ch {
+		c := &s.ch[i]
+		c.resetControllers()
+		c.bendRange = 200
+		if i == drums {
+			c.bank = 128
+		}
+	}
+	return s
+}
+
+// message handles a channel message.
+func (s *synth) message(e event) {
+	c := &s.ch[e.status&0xf]
+	switch e.status & 0xf0 {
+	case 0x80:
+		s.noteOff(int(e.status&0xf), int(e.a))
+	case 0x90:
+		if e.b == 0 {
+			s.noteOff(int(e.status&0xf), int(e.a))
+		} else {
+			s.noteOn(int(e.status&0xf), int(e.a), int(e.b))
+		}
+	case 0xb0:
+		s.control(int(e.status&0xf), int(e.a), int(e.b))
+	case 0xc0:
+		c.program, c.preset = int(e.a), nil
+	case 0xe0:
+		c.wheel = int(e.b)<<7 | int(e.a)
+		c.bend = float64(c.wheel-8192) / 8192 * c.bendRange
+	}
+}
+
+func (s *synth) control(ch, cc, val int) {
+	c := &s.ch[ch]
+	switch cc {
+	case 0:
+		if ch != drums {
+			c.bank, c.preset = val, nil
+		}
+	case 1:
+		c.modWheel = val
+	case 6:
+		if c.rpn == 0 {
+			c.bendRange = float64(val*100) + math.Mod(c.bendRange, 100)
+		}
+	case 38:
+		if c.rpn == 0 {
+			c.bendRange = math.Floor(c.bendRange/100)*100 + float64(val)
+		}
+	case 7:
+		c.volume = val
+	case 10:
+		c.pan = val
+	case 11:
+		c.expression = val
+	case 64:
+		c.sustain = val >= 64
+		if !c.sustain {
+			for _, v := range s.voices {
+				if v.ch == ch && v.sustained {
+					v.release()
+				}
+			}
+		}
+	case 98, 99:
+		c.rpn = 0x3fff
+	case 100:
+		c.rpn = c.rpn&^0x7f | val
+	case 101:
+		c.rpn = c.rpn&0x7f | val<<7
+	case 120:
+		s.voices = s.removeVoices(ch)
+	case 121:
+		c.resetControllers()
+	case 123:
+		for _, v := range s.voices {
+			if v.ch == ch && v.on {
+				s.noteOff(ch, v.key)
+			}
+		}
+	}
+	if cc == 6 || cc == 38 {
+		c.bend = float64(c.wheel-8192) / 8192 * c.bendRange
+	}
+}
+
+// removeVoices returns the voices not on channel ch.
+func (s *synth) removeVoices(ch int) []*voice {
+	vs := s.voices[:0]
+	for _, v := range s.voices {
+		if v.ch != ch {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+func (s *synth) noteOff(ch, key int) {
+	for _, v := range s.voices {
+		if v.ch != ch || v.key != key || !v.on {
+			continue
+		}
+		if s.ch[ch].sustain {
+			v.on, v.sustained = false, true
+		} else {
+			v.release()
+		}
+	}
+}
+
+// presetGen reports whether generator g of a preset zone adds to the
+// instrument zone's value.
+func presetGen(g int) bool {
+	switch g {
+	case genStartOffset, genEndOffset, genLoopStartOffset, genLoopEndOffset,
+		genStartCoarse, genEndCoarse, genLoopStartCoarse, genLoopEndCoarse,
+		genKeynum, genVelocity, genSampleModes, genExclusiveClass, genOverridingRootKey:
+		return false
+	}
+	return true
+}
+
+func (s *synth) noteOn(ch, key, vel int) {
+	c := &s.ch[ch]
+	if c.preset == nil {
+		c.preset = s.sf.preset(c.bank, c.program)
+		if c.preset == nil {
+			return
+		}
+	}
+	for _, v := range s.voices {
+		if v.ch == ch && v.key == key && (v.on || v.sustained) {
+			v.release()
+		}
+	}
+	for _, pz := range c.preset.zones {
+		if !pz.match(key, vel) {
+			continue
+		}
+		for _, iz := range pz.inst.zones {
+			if !iz.match(key, vel) {
+				continue
+			}
+			g := iz.gens
+			for i := range g {
+				if presetGen(i) {
+					g[i] += pz.gens[i]
+				}
+			}
+			s.start(ch, key, vel, &g, iz.smp)
+		}
+	}
+}
+
+// start starts a voice with generators g.
+func (s *synth) start(ch, key, vel int, g *[numGens]int, smp *sfSample) {
+	if class := g[genExclusiveClass]; class != 0 {
+		for _, v := range s.voices {
+			if v.ch == ch && v.exclusive == class && !(v.key == key && v.on) {
+				// Cut the other voice quickly.
+				v.releaseMul = fall(-6000)
+				v.release()
+			}
+		}
+	}
+	pitchKey := key
+	if g[genKeynum] >= 0 {
+		pitchKey = g[genKeynum]
+	}
+	if g[genVelocity] > 0 {
+		vel = g[genVelocity]
+	}
+	v := &voice{
+		ch:        ch,
+		key:       key,
+		on:        true,
+		exclusive: g[genExclusiveClass],
+		data:      s.sf.data,
+		pos:       float64(smp.start + g[genStartOffset] + g[genStartCoarse]*32768),
+		end:       smp.end + g[genEndOffset] + g[genEndCoarse]*32768,
+		loopStart: smp.loopStart + g[genLoopStartOffset] + g[genLoopStartCoarse]*32768,
+		loopEnd:   smp.loopEnd + g[genLoopEndOffset] + g[genLoopEndCoarse]*32768,
+		mode:      g[genSampleModes] & 3,
+	}
+	if v.end > len(v.data) {
+		v.end = len(v.data)
+	}
+	if v.pos < 0 || int(v.pos) >= v.end {
+		return
+	}
+	if v.loopStart < int(v.pos) || v.loopEnd > v.end || v.loopEnd-v.loopStart < 2 {
+		v.mode = modeNoLoop
+	}
+
+	root := smp.key
+	if g[genOverridingRootKey] >= 0 {
+		root = g[genOverridingRootKey]
+	}
+	cents := (pitchKey-root)*g[genScaleTuning] + g[genCoarseTune]*100 + g[genFineTune] + smp.correction
+	v.step = float64(smp.rate) / rate * math.Pow(2, float64(cents)/1200)
+
+	atten := g[genAttenuation]
+	if atten < 0 {
+		atten = 0
+	}
+	v.amp = math.Pow(10, -float64(atten)/200) * float64(vel*vel) / (127 * 127)
+	v.pan = float64(g[genPan]) / 1000
+
+	v.counter = samples(g[genVolEnvDelay])
+	v.attackInc = 1 / float64(samples(g[genVolEnvAttack])+1)
+	v.hold = samples(g[genVolEnvHold] + g[genKeyToVolEnvHold]*(60-key))
+	v.decayMul = fall(g[genVolEnvDecay] + g[genKeyToVolEnvDecay]*(60-key))
+	sus := g[genVolEnvSustain]
+	if sus < 0 {
+		sus = 0
+	}
+	if sus > 1440 {
+		sus = 1440
+	}
+	v.sustainLvl = math.Pow(10, -float64(sus)/200)
+	v.releaseMul = fall(g[genVolEnvRelease])
+
+	v.vibDelay = samples(g[genVibLfoDelay])
+	v.vibInc = absCents(g[genVibLfoFreq]) / rate
+	v.vibDepth = float64(g[genVibLfoToPitch])
+
+	if fc := g[genFilterFc]; fc < 13500 {
+		f := absCents(fc)
+		if f < rate*0.45 {
+			q := math.Pow(10, float64(g[genFilterQ])/200)
+			if q < 0.7071 {
+				q = 0.7071
+			}
+			w := 2 * math.Pi * f / rate
+			alpha := math.Sin(w) / (2 * q)
+			a0 := 1 + alpha
+			v.filter = true
+			v.b1 = (1 - math.Cos(w)) / a0
+			v.b0, v.b2 = v.b1/2, v.b1/2
+			v.a1 = -2 * math.Cos(w) / a0
+			v.a2 = (1 - alpha) / a0
+		}
+	}
+
+	s.age++
+	v.age = s.age
+	if len(s.voices) >= maxVoices {
+		s.steal()
+	}
+	s.voices = append(s.voices, v)
+}
+
+// steal removes the voice best dropped: released voices first, then the
+// quietest and oldest.
+func (s *synth) steal() {
+	best := 0
+	score := func(v *voice) float64 {
+		sc := v.level * v.amp
+		if v.on || v.sustained {
+			sc += 1
+		}
+		return sc - float64(v.age)*1e-9
+	}
+	for i, v := range s.voices {
+		if score(v) < score(s.voices[best]) {
+			best = i
+		}
+	}
+	s.voices = append(s.voices[:best], s.voices[best+1:]...)
+}
+
+// render mixes all voices into out, which holds interleaved stereo frames.
+func (s *synth) render(out []float32) {
+	frames := len(out) / 2
+	vs := s.voices[:0]
+	for _, v := range s.voices {
+		c := &s.ch[v.ch]
+		cents := c.bend
+		if v.vibDelay > 0 {
+			v.vibDelay -= frames
+		} else {
+			depth := v.vibDepth + float64(c.modWheel)*50/127
+			if depth != 0 {
+				// A triangle wave.
+				t := v.vibPhase - math.Floor(v.vibPhase)
+				cents += depth * (1 - 4*math.Abs(t-0.5))
+			}
+			v.vibPhase += v.vibInc * float64(frames)
+		}
+		step := v.step
+		if cents != 0 {
+			step *= math.Pow(2, cents/1200)
+		}
+		vol := float64(c.volume*c.expression) / (127 * 127)
+		amp := v.amp * vol * vol * gain
+		p := v.pan + float64(c.pan-64)/128
+		if p < -0.5 {
+			p = -0.5
+		} else if p > 0.5 {
+			p = 0.5
+		}
+		left, right := amp*math.Sqrt(0.5-p), amp*math.Sqrt(0.5+p)
+		loop := v.mode == modeLoop || v.mode == modeRelease && (v.on || v.sustained)
+		for i := 0; i < frames && v.stage != envDone; i++ {
+			n := int(v.pos)
+			frac := v.pos - float64(n)
+			a := float64(v.data[n])
+			var b float64
+			switch {
+			case loop && n+1 >= v.loopEnd:
+				b = float64(v.data[v.loopStart])
+			case n+1 < v.end:
+				b = float64(v.data[n+1])
+			}
+			x := (a + (b-a)*frac) / 32768 * v.level
+			if v.filter {
+				y := v.b0*x + v.b1*v.x1 + v.b2*v.x2 - v.a1*v.y1 - v.a2*v.y2
+				v.x2, v.x1 = v.x1, x
+				v.y2, v.y1 = v.y1, y
+				x = y
+			}
+			out[i*2] += float32(x * left)
+			out[i*2+1] += float32(x * right)
+			v.envelope()
+			v.pos += step
+			if loop {
+				for v.pos >= float64(v.loopEnd) {
+					v.pos -= float64(v.loopEnd - v.loopStart)
+				}
+			} else if v.pos >= float64(v.end) {
+				v.stage = envDone
+			}
+		}
+		if v.stage != envDone {
+			vs = append(vs, v)
+		}
+	}
+	for i := len(vs); i < len(s.voices); i++ {
+		s.voices[i] = nil
+	}
+	s.voices = vs
+}
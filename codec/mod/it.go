@@ -0,0 +1,346 @@
+package mod
+
+import "strings"
+
+// itVolume converts an IT volume column byte.
+func itVolume(v int) (byte, byte) {
+	switch {
+	case v <= 64:
+		return vcVolume, byte(v)
+	case v <= 74:
+		return vcFineVolUp, byte(v - 65)
+	case v <= 84:
+		return vcFineVolDown, byte(v - 75)
+	case v <= 94:
+		return vcVolSlideUp, byte(v - 85)
+	case v <= 104:
+		return vcVolSlideDown, byte(v - 95)
+	case v <= 114:
+		return vcPortaDown, byte(v-105) * 4
+	case v <= 124:
+		return vcPortaUp, byte(v-115) * 4
+	case v >= 128 && v <= 192:
+		p := (v - 128) * 4
+		if p > 255 {
+			p = 255
+		}
+		return vcPanning, byte(p)
+	case v >= 193 && v <= 202:
+		return vcTonePorta, itTonePorta[v-193]
+	case v >= 203 && v <= 212:
+		return vcVibrato, byte(v - 203)
+	}
+	return vcNone, 0
+}
+
+// itTonePorta is the speed of each volume column tone portamento.
+var itTonePorta = [10]byte{0, 1, 4, 8, 16, 32, 64, 96, 128, 255}
+
+// itEnvelope reads an IT envelope at o. Values are offset so that volume
+// envelopes range 0-64 and the others -32-32.
+func itEnvelope(d data, o int, volume bool) envelope {
+	flags := d.u8(o)
+	e := envelope{
+		on:        flags&1 != 0,
+		loop:      flags&2 != 0,
+		sustain:   flags&4 != 0,
+		loopStart: d.u8(o + 2),
+		loopEnd:   d.u8(o + 3),
+		susStart:  d.u8(o + 4),
+		susEnd:    d.u8(o + 5),
+	}
+	if !volume && flags&0x80 != 0 {
+		// Filter envelopes are not supported.
+		e.on = false
+	}
+	n := d.u8(o + 1)
+	if n > 25 {
+		n = 25
+	}
+	for i := 0; i < n; i++ {
+		p := o + 6 + i*3
+		e.points = append(e.points, envPoint{d.u16(p + 1), int(int8(d.u8(p)))})
+	}
+	e.valid()
+	return e
+}
+
+func loadIT(d data) (*module, error) {
+	if string(d.slice(0, 4)) != "IMPM" {
+		return nil, errFormat
+	}
+	numOrders, numIns, numSamples, numPatterns := d.u16(0x20), d.u16(0x22), d.u16(0x24), d.u16(0x26)
+	cmwt := d.u16(0x2a)
+	flags := d.u16(0x2c)
+	m := &module{
+		format:    fmtIT,
+		middle:    60,
+		title:     d.str(4, 26),
+		linear:    flags&8 != 0,
+		oldFx:     flags&16 != 0,
+		compatGxx: flags&32 != 0,
+		globalVol: d.u8(0x30),
+		speed:     d.u8(0x32),
+		tempo:     d.u8(0x33),
+		mix:       float64(d.u8(0x31)) / 48,
+		channels:  64,
+	}
+	if m.globalVol > 128 {
+		m.globalVol = 128
+	}
+	if m.speed == 0 {
+		m.speed = 6
+	}
+	if m.tempo < 32 {
+		m.tempo = 125
+	}
+	if m.mix == 0 {
+		m.mix = 1
+	}
+	if d.u16(0x2e)&1 != 0 {
+		if n, o := d.u16(0x36), d.u32(0x38); n > 0 {
+			m.message = strings.TrimSpace(strings.Replace(d.str(o, n), "\r", "\n", -1))
+		}
+	}
+	for i := 0; i < 64; i++ {
+		p := d.u8(0x40 + i)
+		pan := 128
+		if p&0x7f <= 64 {
+			pan = p & 0x7f * 4
+		}
+		if flags&1 == 0 {
+			pan = 128
+		}
+		v := d.u8(0x80 + i)
+		if v > 64 {
+			v = 64
+		}
+		m.pan = append(m.pan, pan)
+		m.chanVol = append(m.chanVol, v)
+		m.muted = append(m.muted, p&0x80 != 0)
+	}
+	for i := 0; i < numOrders; i++ {
+		switch p := d.u8(0xc0 + i); p {
+		case 254:
+		case 255:
+			i = numOrders
+		default:
+			m.orders = append(m.orders, p)
+		}
+	}
+	o := 0xc0 + numOrders
+	insOff, smpOff, patOff := o, o+numIns*4, o+numIns*4+numSamples*4
+
+	if flags&4 != 0 {
+		for i := 0; i < numIns; i++ {
+			h := data(d.slice(d.u32(insOff+i*4), 0x226))
+			in := &instrument{
+				name:      h.str(0x20, 26),
+				globalVol: 128,
+				pan:       -1,
+			}
+			m.instruments = append(m.instruments, in)
+			for k := range in.keys {
+				in.keys[k] = keymap{byte(h.u8(0x40 + k*2)), h.u8(0x41 + k*2)}
+				if in.keys[k].note > 119 {
+					in.keys[k].note = byte(k)
+				}
+			}
+			if cmwt < 0x200 {
+				// Old instruments only have a volume envelope, which is
+				// not supported.
+				in.fadeout = h.u16(0x14) * 128
+				in.nna = h.u8(0x16)
+				continue
+			}
+			in.nna = h.u8(0x11)
+			in.fadeout = h.u16(0x14) * 64
+			in.globalVol = h.u8(0x18)
+			if p := h.u8(0x19); p&0x80 == 0 {
+				in.pan = p * 4
+			}
+			in.volEnv = itEnvelope(h, 0x130, true)
+			in.panEnv = itEnvelope(h, 0x182, false)
+			in.pitchEnv = itEnvelope(h, 0x1d4, false)
+		}
+	}
+
+	for i := 0; i < numSamples; i++ {
+		h := data(d.slice(d.u32(smpOff+i*4), 0x50))
+		s := &sample{
+			name:      h.str(0x14, 26),
+			globalVol: h.u8(0x11),
+			volume:    h.u8(0x13),
+			pan:       -1,
+			c5speed:   float64(h.u32(0x3c)),
+			loopStart: h.u32(0x34),
+			loopEnd:   h.u32(0x38),
+			susStart:  h.u32(0x40),
+			susEnd:    h.u32(0x44),
+			vibRate:   h.u8(0x4c),
+			vibDepth:  h.u8(0x4d),
+			vibType:   h.u8(0x4e) & 3,
+			vibSweep:  h.u8(0x4f),
+		}
+		m.samples = append(m.samples, s)
+		if s.globalVol > 64 {
+			s.globalVol = 64
+		}
+		if s.volume > 64 {
+			s.volume = 64
+		}
+		if p := h.u8(0x2f); p&0x80 != 0 {
+			s.pan = (p & 0x7f) * 4
+		}
+		f := h.u8(0x12)
+		if f&1 == 0 {
+			continue
+		}
+		if f&0x10 != 0 {
+			s.loop = loopForward
+			if f&0x40 != 0 {
+				s.loop = loopPingPong
+			}
+		}
+		if f&0x20 != 0 {
+			s.susLoop = loopForward
+			if f&0x80 != 0 {
+				s.susLoop = loopPingPong
+			}
+		}
+		length := h.u32(0x30)
+		if length > 1<<24 {
+			length = 1 << 24
+		}
+		s.data = itSampleData(d, h.u32(0x48), length, f, h.u8(0x2e))
+		s.fixLoops()
+	}
+
+	lastChannel := 0
+	for i := 0; i < numPatterns; i++ {
+		po := d.u32(patOff + i*4)
+		rows := d.u16(po + 2)
+		if po == 0 || rows == 0 || rows > 200 {
+			rows = 64
+		}
+		p := &pattern{rows: rows, cells: make([]cell, rows*64)}
+		m.patterns = append(m.patterns, p)
+		if po == 0 {
+			continue
+		}
+		b := data(d.slice(po+8, d.u16(po)))
+		var masks [64]int
+		var last [64]cell
+		j := 0
+		for row := 0; row < rows && j < len(b); {
+			v := b.u8(j)
+			j++
+			if v == 0 {
+				row++
+				continue
+			}
+			ch := (v - 1) & 63
+			if v&0x80 != 0 {
+				masks[ch] = b.u8(j)
+				j++
+			}
+			mask := masks[ch]
+			c, l := p.cell(row, ch, 64), &last[ch]
+			if mask&1 != 0 {
+				switch n := b.u8(j); {
+				case n < 120:
+					l.note = byte(n + 1)
+				case n == 255:
+					l.note = noteOff
+				case n == 254:
+					l.note = noteCut
+				default:
+					l.note = noteFade
+				}
+				j++
+			}
+			if mask&2 != 0 {
+				l.ins = byte(b.u8(j))
+				j++
+			}
+			if mask&4 != 0 {
+				l.volCmd, l.volArg = itVolume(b.u8(j))
+				j++
+			}
+			if mask&8 != 0 {
+				l.cmd, l.arg = s3mEffect(byte(b.u8(j)), byte(b.u8(j+1)), fmtIT)
+				j += 2
+			}
+			if mask&0x11 != 0 {
+				c.note = l.note
+			}
+			if mask&0x22 != 0 {
+				c.ins = l.ins
+			}
+			if mask&0x44 != 0 {
+				c.volCmd, c.volArg = l.volCmd, l.volArg
+			}
+			if mask&0x88 != 0 {
+				c.cmd, c.arg = l.cmd, l.arg
+			}
+			if mask != 0 && ch >= lastChannel {
+				lastChannel = ch + 1
+			}
+		}
+	}
+
+	// Drop unused channels to save mixing time.
+	if lastChannel == 0 {
+		lastChannel = 1
+	}
+	if lastChannel < 64 {
+		for _, p := range m.patterns {
+			cells := make([]cell, p.rows*lastChannel)
+			for r := 0; r < p.rows; r++ {
+				copy(cells[r*lastChannel:], p.cells[r*64:r*64+lastChannel])
+			}
+			p.cells = cells
+		}
+		m.channels = lastChannel
+		m.pan = m.pan[:lastChannel]
+		m.chanVol = m.chanVol[:lastChannel]
+		m.muted = m.muted[:lastChannel]
+	}
+	return m, nil
+}
+
+// itSampleData reads and decompresses IT sample data.
+func itSampleData(d data, o, length, flags, cvt int) []float32 {
+	sixteen := flags&2 != 0
+	stereo := flags&4 != 0
+	signed := cvt&1 != 0
+	delta := cvt&4 != 0
+	chans := 1
+	if stereo {
+		chans = 2
+	}
+	var out [2][]float32
+	for c := 0; c < chans; c++ {
+		if flags&8 != 0 {
+			var n int
+			out[c], n = itDecompress(d.slice(o, -1), length, sixteen, delta)
+			o += n
+			continue
+		}
+		if sixteen {
+			out[c] = pcm16(d.slice(o, length*2), signed, false)
+			o += length * 2
+		} else {
+			out[c] = pcm8(d.slice(o, length), signed, false)
+			o += length
+		}
+	}
+	if stereo {
+		for i := range out[0] {
+			if i < len(out[1]) {
+				out[0][i] = (out[0][i] + out[1][i]) / 2
+			}
+		}
+	}
+	return out[0]
+}
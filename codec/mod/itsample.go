@@ -0,0 +1,113 @@
+package mod
+
+// bitReader reads IT compressed sample bits, least significant first.
+type bitReader struct {
+	b    []byte
+	pos  int
+	bits uint
+}
+
+func (r *bitReader) read(n uint) int {
+	v := 0
+	for i := uint(0); i < n; i++ {
+		if r.pos >= len(r.b) {
+			return v
+		}
+		v |= int(r.b[r.pos]>>r.bits&1) << i
+		if r.bits++; r.bits == 8 {
+			r.bits = 0
+			r.pos++
+		}
+	}
+	return v
+}
+
+// itDecompress decodes length samples of IT 2.14 compressed data, or IT 2.15
+// if delta is set. It returns the samples and the number of bytes read.
+func itDecompress(b []byte, length int, sixteen, delta bool) ([]float32, int) {
+	out := make([]float32, 0, length)
+	blockLen, topWidth := 0x8000, uint(9)
+	if sixteen {
+		blockLen, topWidth = 0x4000, 17
+	}
+	o := 0
+	for len(out) < length && o+2 <= len(b) {
+		size := int(b[o]) | int(b[o+1])<<8
+		o += 2
+		end := o + size
+		if end > len(b) {
+			end = len(b)
+		}
+		r := &bitReader{b: b[o:end]}
+		o = end
+		n := length - len(out)
+		if n > blockLen {
+			n = blockLen
+		}
+		width := topWidth
+		var d1, d2 int
+		for i := 0; i < n && r.pos < len(r.b); {
+			if width == 0 || width > topWidth {
+				break
+			}
+			v := r.read(width)
+			switch {
+			case width < 7:
+				// Method 1: a single set top bit marks a width change.
+				if v == 1<<(width-1) {
+					if sixteen {
+						v = r.read(4) + 1
+					} else {
+						v = r.read(3) + 1
+					}
+					width = newWidth(width, v)
+					continue
+				}
+			case width < topWidth:
+				// Method 2: values in a band just below the top mark a
+				// width change.
+				border, band := (0xff>>(9-width))-4, 8
+				if sixteen {
+					border, band = (0xffff>>(17-width))-8, 16
+				}
+				if v > border && v <= border+band {
+					width = newWidth(width, v-border)
+					continue
+				}
+			case width == topWidth:
+				// Method 3: the top bit marks a width change.
+				if v&(1<<(topWidth-1)) != 0 {
+					width = uint(v+1) & 0xff
+					continue
+				}
+			}
+			// Sign extend.
+			if width < topWidth-1 {
+				shift := 32 - width
+				v = int(int32(uint32(v)<<shift) >> shift)
+			}
+			d1 += v
+			d2 += d1
+			s := d1
+			if delta {
+				s = d2
+			}
+			if sixteen {
+				out = append(out, float32(int16(s))/32768)
+			} else {
+				out = append(out, float32(int8(s))/128)
+			}
+			i++
+		}
+	}
+	return out, o
+}
+
+// newWidth returns the bit width selected by w, which skips the current
+// width.
+func newWidth(width uint, w int) uint {
+	if uint(w) < width {
+		return uint(w)
+	}
+	return uint(w) + 1
+}
@@ -0,0 +1,182 @@
+// Package mod plays ProTracker MOD, Scream Tracker 3 S3M, FastTracker 2 XM
+// and Impulse Tracker IT modules.
+package mod
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("MOD", strings.Repeat("?", 1080)+"M.K.", []string{"mod"}, ReadModule)
+	codec.RegisterCodec("S3M", strings.Repeat("?", 0x2c)+"SCRM", []string{"s3m"}, ReadModule)
+	codec.RegisterCodec("XM", "Extended Module: ", []string{"xm"}, ReadModule)
+	codec.RegisterCodec("IT", "IMPM", []string{"it"}, ReadModule)
+}
+
+const (
+	// rate is the output sample rate.
+	rate = 44100
+	// maxSize bounds the size of a module file.
+	maxSize = 64 << 20
+	// maxDuration bounds songs that never loop.
+	maxDuration = time.Hour
+)
+
+var errFormat = errors.New("mod: unknown format")
+
+// load parses a module of any supported format.
+func load(b []byte) (*module, error) {
+	var m *module
+	var err error
+	switch {
+	case bytes.HasPrefix(b, []byte("Extended Module: ")):
+		m, err = loadXM(b)
+	case bytes.HasPrefix(b, []byte("IMPM")):
+		m, err = loadIT(b)
+	case len(b) >= 0x30 && string(b[0x2c:0x30]) == "SCRM":
+		m, err = loadS3M(b)
+	default:
+		m, err = loadMOD(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.orders) == 0 {
+		return nil, errFormat
+	}
+	m.size = int64(len(b))
+	return m, nil
+}
+
+func read(rf codec.Reader) (*module, error) {
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	return load(b)
+}
+
+// ReadModule returns the song of a module file.
+func ReadModule(rf codec.Reader) ([]codec.Song, error) {
+	m, err := read(rf)
+	if err != nil {
+		return nil, err
+	}
+	return []codec.Song{&Module{Reader: rf, m: m}}, nil
+}
+
+// Module is a tracker module. It plays its order list until a row repeats,
+// which ends songs that loop.
+type Module struct {
+	Reader codec.Reader
+	m      *module
+	p      *player
+	// played and length are in sample frames.
+	played, length int
+}
+
+func (s *Module) module() (*module, error) {
+	if s.m != nil {
+		return s.m, nil
+	}
+	m, err := read(s.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.m = m
+	return m, nil
+}
+
+// duration estimates the length of the song by running its sequencer.
+func (s *Module) duration() (int, error) {
+	if s.length != 0 {
+		return s.length, nil
+	}
+	m, err := s.module()
+	if err != nil {
+		return 0, err
+	}
+	s.length = newPlayer(m).render(nil, int(maxDuration*rate/time.Second))
+	return s.length, nil
+}
+
+func (s *Module) Init() (sampleRate, channels int, err error) {
+	if s.p == nil {
+		m, err := s.module()
+		if err != nil {
+			return 0, 0, err
+		}
+		s.p = newPlayer(m)
+		s.played = 0
+	}
+	return rate, 2, nil
+}
+
+func (s *Module) Info() (info codec.SongInfo, err error) {
+	m, err := s.module()
+	if err != nil {
+		return
+	}
+	n, err := s.duration()
+	if err != nil {
+		return
+	}
+	return codec.SongInfo{
+		Time:       time.Duration(n) * time.Second / rate,
+		Title:      m.title,
+		Comment:    m.text(),
+		Codec:      formatNames[m.format],
+		SampleRate: rate,
+		Channels:   2,
+		Size:       m.size,
+	}, nil
+}
+
+func (s *Module) Play(n int) ([]float32, error) {
+	out := make([]float32, n/2*2)
+	frames := s.p.render(out, 0)
+	s.played += frames
+	return out[:frames*2], nil
+}
+
+// Seek seeks by running the sequencer from the start, or from the current
+// position when seeking forward, without mixing.
+func (s *Module) Seek(offset time.Duration) error {
+	return codec.SeekSteps(s, offset)
+}
+
+// SeekStep runs the sequencer a step toward offset.
+func (s *Module) SeekStep(offset time.Duration) (bool, error) {
+	if s.p == nil {
+		return false, errors.New("mod: seek before init")
+	}
+	target := int(offset * rate / time.Second)
+	if target < s.played {
+		s.p = newPlayer(s.m)
+		s.played = 0
+	}
+	n := target - s.played
+	if step := int(codec.StepLength * rate / time.Second); n > step {
+		n = step
+	}
+	played := s.p.render(nil, n)
+	s.played += played
+	// The song may end first.
+	return s.played >= target || played < n, nil
+}
+
+func (s *Module) Close() {
+	s.p = nil
+}
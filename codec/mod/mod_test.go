@@ -0,0 +1,125 @@
+package mod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// testFile returns a 4 channel MOD with one pattern. Channel 1 plays a
+// looped square wave sample at C-5 from row 0, sets its volume to 0 at row
+// 8, and row 15 breaks to the start of the song, which ends it.
+func testFile() []byte {
+	b := make([]byte, 1084+64*16)
+	copy(b, "Test Module")
+	copy(b[20:], "Square")
+	be := binary.BigEndian
+	be.PutUint16(b[20+22:], 16)
+	b[20+25] = 64
+	be.PutUint16(b[20+28:], 16)
+	b[950] = 1
+	copy(b[1080:], "M.K.")
+	cell := func(row, ch, period, ins, cmd, arg int) {
+		c := b[1084+row*16+ch*4:]
+		c[0] = byte(ins&0xf0 | period>>8)
+		c[1] = byte(period)
+		c[2] = byte(ins<<4 | cmd)
+		c[3] = byte(arg)
+	}
+	cell(0, 0, 428, 1, 0, 0)
+	cell(8, 0, 0, 0, 0xc, 0)
+	cell(15, 1, 0, 0, 0xd, 0)
+	for i := 0; i < 32; i++ {
+		s := byte(0x40)
+		if i >= 16 {
+			s = 0xc0
+		}
+		b = append(b, s)
+	}
+	return b
+}
+
+func open(t *testing.T) codec.Song {
+	decode := func(r codec.Reader) ([]codec.Song, error) {
+		songs, name, err := codec.Decode(r)
+		if err == nil && name != "MOD" {
+			err = fmt.Errorf("decoded as %s", name)
+		}
+		return songs, err
+	}
+	return codectest.Open(t, decode, codectest.Reader(testFile()), 0, rate, 2)
+}
+
+// rows returns the samples of rows start to end at speed 6 and tempo 125.
+func rows(b []float32, start, end int) []float32 {
+	const row = rate * 12 / 100 * 2
+	return b[start*row : end*row]
+}
+
+func TestInfo(t *testing.T) {
+	s := open(t)
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Test Module" || info.Codec != "MOD" {
+		t.Fatalf("got %+v", info)
+	}
+	// 16 rows of 6 ticks of 20ms.
+	if info.Time != 1920*time.Millisecond {
+		t.Fatalf("time %v", info.Time)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	b := codectest.PlayAll(t, open(t), 4096)
+	if len(b) != rate*192/100*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	tone := rows(b, 1, 7)
+	crossings := 0
+	for i := 2; i < len(tone); i += 2 {
+		if (tone[i-2] < 0) != (tone[i] < 0) {
+			crossings++
+		}
+	}
+	// The 32 sample wave plays at about 8363Hz.
+	want := float64(len(tone)/2) / rate * 8363 / 32 * 2
+	if math.Abs(float64(crossings)-want) > want*0.02 {
+		t.Fatalf("%d zero crossings, want %.0f", crossings, want)
+	}
+	// Channel 1 is panned left.
+	if l, r := codectest.RMS(tone, 0, 2), codectest.RMS(tone, 1, 2); l == 0 || r > l/2 {
+		t.Fatalf("rms %v, %v", l, r)
+	}
+	if l := codectest.RMS(rows(b, 9, 16), 0, 2); l != 0 {
+		t.Fatalf("rms %v after the volume is set to 0", l)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	s := open(t)
+	want := codectest.PlayAll(t, s, 4096)
+	for _, offset := range []time.Duration{time.Second / 2, 100 * time.Millisecond, 0, 1500 * time.Millisecond} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		w := want[int(offset*rate/time.Second)*2:]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got %d samples, want %d", offset, len(got), len(w))
+		}
+		// Seeking advances the voices without mixing, which rounds
+		// differently.
+		for i := range got {
+			if math.Abs(float64(got[i]-w[i])) > 1e-3 {
+				t.Fatalf("%v: sample %d is %v, want %v", offset, i, got[i], w[i])
+			}
+		}
+	}
+}
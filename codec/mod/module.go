@@ -0,0 +1,400 @@
+package mod
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Module formats. Effects that behave differently between trackers check
+// these.
+const (
+	fmtMOD = iota
+	fmtS3M
+	fmtXM
+	fmtIT
+)
+
+var formatNames = [...]string{
+	fmtMOD: "MOD",
+	fmtS3M: "S3M",
+	fmtXM:  "XM",
+	fmtIT:  "IT",
+}
+
+// Special note values. Regular notes are stored as note+1.
+const (
+	noteNone = 0
+	noteFade = 253
+	noteCut  = 254
+	noteOff  = 255
+)
+
+// Internal effects. Loaders translate each tracker's effect commands into
+// these.
+const (
+	fxNone = iota
+	fxArpeggio
+	fxPortaUp
+	fxPortaDown
+	fxTonePorta
+	fxVibrato
+	fxFineVibrato
+	fxTonePortaVol
+	fxVibratoVol
+	fxTremolo
+	fxTremor
+	fxPanning
+	fxOffset
+	fxVolSlide
+	fxJump
+	fxVolume
+	fxBreak
+	fxSpeed
+	fxTempo
+	fxSpeedTempo
+	fxGlobalVol
+	fxGlobalVolSlide
+	fxChannelVol
+	fxChannelVolSlide
+	fxPanSlide
+	fxPanbrello
+	fxRetrig
+	fxKeyOff
+	fxEnvPos
+	fxFinePortaUp
+	fxFinePortaDown
+	fxExtraFinePortaUp
+	fxExtraFinePortaDown
+	fxFineVolUp
+	fxFineVolDown
+	fxVibratoWave
+	fxTremoloWave
+	fxPanbrelloWave
+	fxFinetune
+	fxPatternLoop
+	fxPatternDelay
+	fxFinePatternDelay
+	fxNoteCut
+	fxNoteDelay
+	fxPan4
+	fxSurround
+	fxHighOffset
+	fxInstControl
+)
+
+// Volume column commands.
+const (
+	vcNone = iota
+	vcVolume
+	vcPanning
+	vcVolSlideUp
+	vcVolSlideDown
+	vcFineVolUp
+	vcFineVolDown
+	vcPortaUp
+	vcPortaDown
+	vcTonePorta
+	vcVibratoSpeed
+	vcVibrato
+	vcPanSlideLeft
+	vcPanSlideRight
+)
+
+// Loop types.
+const (
+	loopNone = iota
+	loopForward
+	loopPingPong
+)
+
+// New note actions.
+const (
+	nnaCut = iota
+	nnaContinue
+	nnaOff
+	nnaFade
+)
+
+type cell struct {
+	note, ins      byte
+	volCmd, volArg byte
+	cmd, arg       byte
+}
+
+type pattern struct {
+	rows  int
+	cells []cell
+}
+
+func (p *pattern) cell(row, ch, channels int) *cell {
+	return &p.cells[row*channels+ch]
+}
+
+type sample struct {
+	name string
+	// data is mono, normalized to [-1, 1].
+	data               []float32
+	loop               int
+	loopStart, loopEnd int
+	susLoop            int
+	susStart, susEnd   int
+	volume             int // 0-64
+	globalVol          int // 0-64
+	pan                int // 0-256, or -1 if unset
+	c5speed            float64
+	vibType, vibSweep  int
+	vibDepth, vibRate  int
+}
+
+type envPoint struct {
+	x, y int
+}
+
+type envelope struct {
+	on, loop, sustain  bool
+	points             []envPoint
+	loopStart, loopEnd int
+	susStart, susEnd   int
+}
+
+// value returns the envelope value at tick pos.
+func (e *envelope) value(pos int) float64 {
+	pts := e.points
+	if pos <= pts[0].x {
+		return float64(pts[0].y)
+	}
+	for i := 1; i < len(pts); i++ {
+		if pos < pts[i].x {
+			a, b := pts[i-1], pts[i]
+			return float64(a.y) + float64((b.y-a.y)*(pos-a.x))/float64(b.x-a.x)
+		}
+	}
+	return float64(pts[len(pts)-1].y)
+}
+
+// advance returns the tick after pos, following the sustain loop until
+// released and then the loop.
+func (e *envelope) advance(pos int, released bool) int {
+	pos++
+	if e.sustain && !released {
+		if pos > e.points[e.susEnd].x {
+			pos = e.points[e.susStart].x
+		}
+	} else if e.loop {
+		if pos > e.points[e.loopEnd].x {
+			pos = e.points[e.loopStart].x
+		}
+	}
+	return pos
+}
+
+// ended returns whether pos is past the last point.
+func (e *envelope) ended(pos int) bool {
+	return pos > e.points[len(e.points)-1].x
+}
+
+// valid reports whether the envelope can be used, and clamps its loop
+// points.
+func (e *envelope) valid() bool {
+	n := len(e.points)
+	if n == 0 {
+		e.on = false
+		return false
+	}
+	for i := 1; i < n; i++ {
+		if e.points[i].x <= e.points[i-1].x {
+			e.points = e.points[:i]
+			n = i
+			break
+		}
+	}
+	clamp := func(i *int) {
+		if *i >= n {
+			*i = n - 1
+		}
+		if *i < 0 {
+			*i = 0
+		}
+	}
+	clamp(&e.loopStart)
+	clamp(&e.loopEnd)
+	clamp(&e.susStart)
+	clamp(&e.susEnd)
+	if e.loopEnd < e.loopStart {
+		e.loop = false
+	}
+	if e.susEnd < e.susStart {
+		e.sustain = false
+	}
+	return e.on
+}
+
+type keymap struct {
+	note   byte
+	sample int // 1-based, 0 if none
+}
+
+type instrument struct {
+	name     string
+	keys     [120]keymap
+	volEnv   envelope
+	panEnv   envelope
+	pitchEnv envelope
+	// fadeout is subtracted from a volume of 65536 each tick after release.
+	fadeout   int
+	globalVol int // 0-128
+	pan       int // 0-256, or -1 if unset
+	nna       int
+}
+
+type module struct {
+	format   int
+	title    string
+	message  string
+	channels int
+	orders   []int
+	restart  int
+	patterns []*pattern
+	// instruments is nil if cells refer directly to samples.
+	instruments []*instrument
+	samples     []*sample
+	// middle is the note that plays samples at their C5 speed.
+	middle    int
+	speed     int
+	tempo     int
+	globalVol int // 0-128
+	// mix scales the output.
+	mix       float64
+	linear    bool
+	pan       []int // 0-256
+	chanVol   []int // 0-64
+	muted     []bool
+	oldFx     bool
+	compatGxx bool
+	// size is the file size.
+	size int64
+}
+
+// text returns the sample and instrument names and song message.
+func (m *module) text() string {
+	var names []string
+	for _, in := range m.instruments {
+		if in != nil {
+			names = append(names, in.name)
+		} else {
+			names = append(names, "")
+		}
+	}
+	if m.instruments == nil || m.format == fmtIT {
+		for _, s := range m.samples {
+			if s != nil {
+				names = append(names, s.name)
+			} else {
+				names = append(names, "")
+			}
+		}
+	}
+	for len(names) > 0 && strings.TrimSpace(names[len(names)-1]) == "" {
+		names = names[:len(names)-1]
+	}
+	t := strings.Join(names, "\n")
+	if m.message != "" {
+		if t != "" {
+			t += "\n\n"
+		}
+		t += m.message
+	}
+	return t
+}
+
+// data reads little-endian values, returning zero past its end so that
+// truncated files still load.
+type data []byte
+
+func (d data) u8(o int) int {
+	if o < 0 || o >= len(d) {
+		return 0
+	}
+	return int(d[o])
+}
+
+func (d data) u16(o int) int {
+	return d.u8(o) | d.u8(o+1)<<8
+}
+
+func (d data) u32(o int) int {
+	return d.u16(o) | d.u16(o+2)<<16
+}
+
+func (d data) be16(o int) int {
+	return d.u8(o)<<8 | d.u8(o+1)
+}
+
+func (d data) slice(o, n int) []byte {
+	if o < 0 || o > len(d) {
+		return nil
+	}
+	if n < 0 || o+n > len(d) {
+		n = len(d) - o
+	}
+	return d[o : o+n]
+}
+
+func (d data) str(o, n int) string {
+	b := d.slice(o, n)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
+
+// pcm8 converts 8-bit samples. If delta is set, each byte is the
+// difference from the previous sample.
+func pcm8(b []byte, signed, delta bool) []float32 {
+	out := make([]float32, len(b))
+	var acc byte
+	for i, v := range b {
+		if delta {
+			acc += v
+			v = acc
+		}
+		if !signed {
+			v ^= 0x80
+		}
+		out[i] = float32(int8(v)) / 128
+	}
+	return out
+}
+
+// pcm16 converts little-endian 16-bit samples.
+func pcm16(b []byte, signed, delta bool) []float32 {
+	out := make([]float32, len(b)/2)
+	var acc uint16
+	for i := range out {
+		v := uint16(b[i*2]) | uint16(b[i*2+1])<<8
+		if delta {
+			acc += v
+			v = acc
+		}
+		if !signed {
+			v ^= 0x8000
+		}
+		out[i] = float32(int16(v)) / 32768
+	}
+	return out
+}
+
+// fixLoops clamps the loops of s to its data.
+func (s *sample) fixLoops() {
+	n := len(s.data)
+	fix := func(typ *int, start, end *int) {
+		if *end > n {
+			*end = n
+		}
+		if *start < 0 || *start >= *end {
+			*typ = loopNone
+		}
+	}
+	fix(&s.loop, &s.loopStart, &s.loopEnd)
+	fix(&s.susLoop, &s.susStart, &s.susEnd)
+}
@@ -0,0 +1,1277 @@
+package mod
+
+import "math"
+
+const (
+	// amigaClock converts Amiga periods, in quarter steps, to frequencies.
+	amigaClock = 14317456
+	// ramp is the number of samples over which volume changes are
+	// smoothed to avoid clicks.
+	ramp = 64
+	// maxBackground bounds the voices left playing by new note actions.
+	maxBackground = 64
+)
+
+// Effect memory slots.
+const (
+	slotVolSlide = iota
+	slotPortaUp
+	slotPortaDown
+	slotTonePorta
+	slotFinePortaUp
+	slotFinePortaDown
+	slotExtraFinePortaUp
+	slotExtraFinePortaDown
+	slotFineVolUp
+	slotFineVolDown
+	slotOffset
+	slotRetrig
+	slotTremor
+	slotArpeggio
+	slotPanSlide
+	slotGlobalVolSlide
+	slotChannelVolSlide
+	slotTempo
+	slotPanbrello
+	numSlots
+)
+
+// Waveforms for vibrato, tremolo and panbrello.
+const (
+	waveSine = iota
+	waveRampDown
+	waveSquare
+	waveRandom
+	waveRampUp
+)
+
+var sineTable [64]int
+
+func init() {
+	for i := range sineTable {
+		sineTable[i] = int(math.Floor(255*math.Sin(2*math.Pi*float64(i)/64) + 0.5))
+	}
+}
+
+// channel is the pattern state of one channel.
+type channel struct {
+	index int
+	cell  cell
+	v     *voice
+
+	ins     *instrument
+	smp     *sample
+	note    int
+	c5speed float64
+	nna     int
+
+	// period is the current period and target the tone portamento
+	// target.
+	period, target float64
+	vol            int // 0-64
+	chanVol        int // 0-64
+	pan            int // 0-256
+
+	mem [numSlots]byte
+
+	// Per-tick modulation, reset each row.
+	arp        int
+	vibDelta   float64
+	tremDelta  int
+	panbDelta  int
+	tremorMute bool
+
+	vibSpeed, vibDepth, vibPos, vibWave     int
+	tremSpeed, tremDepth, tremPos, tremWave int
+	panbPos, panbWave                       int
+	tremorCount                             int
+	retrigCount                             int
+	highOffset                              int
+	loopRow, loopCount                      int
+	delay                                   int
+}
+
+// voice is a playing sample. Voices outlive their channel's note when new
+// note actions leave them playing in the background.
+type voice struct {
+	smp *sample
+	ins *instrument
+
+	pos float64
+	dir float64
+	// step is the sample increment per output sample.
+	step   float64
+	active bool
+	// stop is set when the voice should fade out over the next tick.
+	stop bool
+
+	released bool
+	fading   bool
+	fade     int
+
+	volEnvPos, panEnvPos, pitchEnvPos int
+	volEnvOff, panEnvOff, pitchEnvOff bool
+	vibPos, vibTicks                  int
+
+	// vol, pan and freq are set by the channel. The gains are the current
+	// and target output levels.
+	vol, pan, freq float64
+	gl, gr         float64
+	tl, tr         float64
+
+	channel int
+}
+
+type player struct {
+	m  *module
+	ch []channel
+	bg []*voice
+
+	order, row, tick int
+	speed, tempo     int
+	globalVol        int
+	rowTicks         int
+	patDelay         int
+	finePatDelay     int
+	jumpOrder        int
+	breakRow         int
+	loopTo           int
+	visited          [][]bool
+	done             bool
+
+	// left is the number of samples left in the current tick, and frac
+	// the remainder of tick lengths.
+	left, frac int
+	seed       uint32
+	gain       float64
+}
+
+func newPlayer(m *module) *player {
+	p := &player{
+		m:         m,
+		ch:        make([]channel, m.channels),
+		speed:     m.speed,
+		tempo:     m.tempo,
+		globalVol: m.globalVol,
+		jumpOrder: -1,
+		breakRow:  -1,
+		loopTo:    -1,
+		visited:   make([][]bool, len(m.orders)),
+		seed:      1,
+		gain:      m.mix / math.Sqrt(float64(m.channels)),
+	}
+	for i := range p.ch {
+		c := &p.ch[i]
+		c.index = i
+		c.pan = m.pan[i]
+		c.chanVol = m.chanVol[i]
+		c.vol = 64
+	}
+	for i := range p.visited {
+		p.visited[i] = make([]bool, p.pattern(i).rows)
+	}
+	return p
+}
+
+var emptyPattern = &pattern{rows: 64}
+
+func (p *player) pattern(order int) *pattern {
+	if n := p.m.orders[order]; n < len(p.m.patterns) {
+		return p.m.patterns[n]
+	}
+	return emptyPattern
+}
+
+func (p *player) random() int {
+	p.seed = p.seed*1103515245 + 12345
+	return int(p.seed >> 16 & 0x7fff)
+}
+
+func (p *player) wave(typ, pos int) int {
+	pos &= 63
+	switch typ {
+	case waveRampDown:
+		return 255 - pos*8
+	case waveSquare:
+		if pos < 32 {
+			return 255
+		}
+		return -255
+	case waveRandom:
+		return p.random()%511 - 255
+	case waveRampUp:
+		return pos*8 - 255
+	}
+	return sineTable[pos]
+}
+
+// notePeriod returns the period of note for a sample with the given C5
+// speed.
+func (p *player) notePeriod(note int, c5speed float64) float64 {
+	if p.m.linear {
+		return float64(7680 - note*64)
+	}
+	return amigaClock / (c5speed * math.Pow(2, float64(note-p.m.middle)/12))
+}
+
+func (p *player) frequency(period, c5speed float64) float64 {
+	if p.m.linear {
+		return c5speed * math.Pow(2, (float64(7680-p.m.middle*64)-period)/768)
+	}
+	if period < 1 {
+		period = 1
+	}
+	return amigaClock / period
+}
+
+func (p *player) clampPeriod(c *channel) {
+	lo, hi := 1.0, 65535.0
+	switch {
+	case p.m.linear:
+		lo, hi = 0, 11520
+	case p.m.format == fmtMOD:
+		lo, hi = 113*4, 856*4
+	}
+	if c.period < lo {
+		c.period = lo
+	}
+	if c.period > hi {
+		c.period = hi
+	}
+}
+
+// render fills out with stereo samples, returning the number of frames
+// written. Fewer frames than requested means the song ended. If out is nil,
+// frames frames are played without mixing.
+func (p *player) render(out []float32, frames int) int {
+	if out != nil {
+		frames = len(out) / 2
+	}
+	n := 0
+	for n < frames {
+		if p.left == 0 {
+			if p.done {
+				break
+			}
+			p.nextTick()
+			continue
+		}
+		k := frames - n
+		if k > p.left {
+			k = p.left
+		}
+		p.mix(out, n, k)
+		n += k
+		p.left -= k
+	}
+	return n
+}
+
+// nextTick runs the sequencer for one tick.
+func (p *player) nextTick() {
+	if p.tick == 0 {
+		if !p.startRow() {
+			p.done = true
+			return
+		}
+	} else {
+		for i := range p.ch {
+			p.tickChannel(&p.ch[i])
+		}
+	}
+	for i := range p.ch {
+		p.updateChannel(&p.ch[i])
+	}
+	for _, v := range p.bg {
+		p.updateVoice(v)
+	}
+	// A tick lasts 2.5/tempo seconds.
+	p.frac += rate * 5
+	p.left = p.frac / (2 * p.tempo)
+	p.frac %= 2 * p.tempo
+	if p.tick++; p.tick >= p.rowTicks {
+		p.tick = 0
+		p.nextRow()
+	}
+}
+
+func (p *player) nextRow() {
+	switch {
+	case p.loopTo >= 0:
+		for r := p.loopTo; r <= p.row; r++ {
+			p.visited[p.order][r] = false
+		}
+		p.row = p.loopTo
+	case p.jumpOrder >= 0 || p.breakRow >= 0:
+		if p.jumpOrder >= 0 {
+			p.order = p.jumpOrder
+		} else {
+			p.order++
+		}
+		p.row = 0
+		if p.breakRow >= 0 {
+			p.row = p.breakRow
+		}
+	default:
+		if p.row++; p.row >= p.pattern(p.order).rows {
+			p.order++
+			p.row = 0
+		}
+	}
+	p.loopTo, p.jumpOrder, p.breakRow = -1, -1, -1
+	if p.order >= len(p.m.orders) {
+		p.order = p.m.restart
+		if p.order >= len(p.m.orders) {
+			p.order = 0
+		}
+	}
+	if p.row >= p.pattern(p.order).rows {
+		p.row = 0
+	}
+}
+
+// startRow plays the current row. It returns false if the row has been
+// played before, which means the song has looped.
+func (p *player) startRow() bool {
+	if len(p.m.orders) == 0 || p.visited[p.order][p.row] {
+		return false
+	}
+	p.visited[p.order][p.row] = true
+	p.patDelay, p.finePatDelay = 0, 0
+	pat := p.pattern(p.order)
+	for i := range p.ch {
+		c := &p.ch[i]
+		c.cell = cell{}
+		if pat.cells != nil && !p.m.muted[i] {
+			c.cell = *pat.cell(p.row, i, p.m.channels)
+		}
+		c.arp, c.vibDelta, c.tremDelta, c.panbDelta = 0, 0, 0, 0
+		c.tremorMute = false
+		c.delay = 0
+		if c.cell.cmd == fxNoteDelay && c.cell.arg > 0 {
+			c.delay = int(c.cell.arg)
+			continue
+		}
+		p.playCell(c)
+	}
+	p.rowTicks = p.speed*(1+p.patDelay) + p.finePatDelay
+	if p.rowTicks < 1 {
+		p.rowTicks = 1
+	}
+	return true
+}
+
+// playCell triggers the channel's cell and runs its first tick effects.
+func (p *player) playCell(c *channel) {
+	cl := &c.cell
+	porta := cl.cmd == fxTonePorta || cl.cmd == fxTonePortaVol || cl.volCmd == vcTonePorta
+	note := int(cl.note)
+	if cl.ins != 0 {
+		n := c.note
+		if note > 0 && note <= 120 {
+			n = note - 1
+		}
+		p.setInstrument(c, int(cl.ins), n, note == noteNone)
+	}
+	switch note {
+	case noteNone:
+	case noteOff:
+		p.keyOff(c)
+	case noteCut:
+		if c.v != nil {
+			c.v.stop = true
+		}
+	case noteFade:
+		if c.v != nil {
+			c.v.fading = true
+		}
+	default:
+		p.noteOn(c, note-1, porta)
+	}
+	p.volumeColumn(c, true)
+	p.effect(c, true)
+}
+
+// lookup returns the sample and pitch played by note on the channel's
+// instrument.
+func (p *player) lookup(c *channel, note int) (*sample, int) {
+	if p.m.instruments == nil {
+		return c.smp, note
+	}
+	if c.ins == nil || note < 0 || note >= 120 {
+		return nil, note
+	}
+	k := c.ins.keys[note]
+	if k.sample == 0 || k.sample > len(p.m.samples) {
+		return nil, note
+	}
+	return p.m.samples[k.sample-1], int(k.note)
+}
+
+func (p *player) setInstrument(c *channel, n, note int, noNote bool) {
+	if p.m.instruments != nil {
+		if n > len(p.m.instruments) {
+			return
+		}
+		c.ins = p.m.instruments[n-1]
+	} else {
+		if n > len(p.m.samples) {
+			return
+		}
+		c.smp = p.m.samples[n-1]
+	}
+	s, _ := p.lookup(c, note)
+	if s == nil {
+		return
+	}
+	c.smp = s
+	c.vol = s.volume
+	if c.ins != nil && c.ins.pan >= 0 {
+		c.pan = c.ins.pan
+	}
+	if s.pan >= 0 {
+		c.pan = s.pan
+	}
+	if noNote && c.v != nil && c.v.ins == c.ins && p.m.format == fmtXM {
+		// FastTracker 2 restarts envelopes on an instrument alone.
+		c.v.resetEnvelopes()
+	}
+}
+
+func (p *player) noteOn(c *channel, note int, porta bool) {
+	s, pitch := p.lookup(c, note)
+	if s == nil || len(s.data) == 0 {
+		return
+	}
+	c.note = note
+	c5 := s.c5speed
+	if c.cell.cmd == fxFinetune {
+		ft := int(c.cell.arg) - 8
+		if p.m.format == fmtMOD {
+			ft = int(int8(c.cell.arg<<4) >> 4)
+		}
+		c5 = 8363 * math.Pow(2, float64(ft)/96)
+	}
+	period := p.notePeriod(pitch, c5)
+	if porta && c.v != nil && c.v.active && !c.v.stop {
+		c.target = period
+		return
+	}
+	if old := c.v; old != nil && old.active {
+		switch c.nna {
+		case nnaContinue:
+		case nnaOff:
+			p.release(old)
+		case nnaFade:
+			old.fading = true
+		default:
+			old.stop = true
+		}
+		p.background(old)
+	}
+	c.smp = s
+	c.c5speed = c5
+	c.period, c.target = period, period
+	c.nna = nnaCut
+	if c.ins != nil && p.m.format == fmtIT {
+		c.nna = c.ins.nna
+	}
+	v := &voice{
+		smp:     s,
+		ins:     c.ins,
+		dir:     1,
+		active:  true,
+		fade:    65536,
+		channel: c.index,
+	}
+	if c.cell.cmd == fxOffset {
+		arg := c.mem[slotOffset]
+		if c.cell.arg != 0 {
+			arg = c.cell.arg
+			c.mem[slotOffset] = arg
+		}
+		v.pos = float64(int(arg)*256 + c.highOffset*65536)
+		if int(v.pos) >= len(s.data) {
+			if p.m.format == fmtIT || p.m.format == fmtS3M {
+				v.pos = 0
+			} else {
+				v.active = false
+			}
+		}
+	}
+	c.v = v
+	if c.vibWave&4 == 0 {
+		c.vibPos = 0
+	}
+	if c.tremWave&4 == 0 {
+		c.tremPos = 0
+	}
+	c.retrigCount, c.tremorCount = 0, 0
+}
+
+// background moves a voice off its channel.
+func (p *player) background(v *voice) {
+	if len(p.bg) >= maxBackground {
+		p.bg[0].active = false
+		p.bg = p.bg[1:]
+	}
+	p.bg = append(p.bg, v)
+}
+
+func (p *player) release(v *voice) {
+	v.released = true
+	if v.ins == nil || !v.ins.volEnv.on || v.volEnvOff {
+		if p.m.format == fmtIT {
+			v.fading = true
+		}
+	} else if p.m.format == fmtXM || v.ins.volEnv.loop {
+		v.fading = true
+	}
+}
+
+func (p *player) keyOff(c *channel) {
+	v := c.v
+	if v == nil {
+		return
+	}
+	p.release(v)
+	if p.m.format == fmtXM && (v.ins == nil || !v.ins.volEnv.on) {
+		c.vol = 0
+	}
+}
+
+func (v *voice) resetEnvelopes() {
+	v.volEnvPos, v.panEnvPos, v.pitchEnvPos = 0, 0, 0
+	v.vibPos, v.vibTicks = 0, 0
+	v.released, v.fading, v.fade = false, false, 65536
+}
+
+// memory returns arg, or the last value used in slot if arg is 0.
+func (p *player) memory(c *channel, slot int, arg byte) byte {
+	switch p.m.format {
+	case fmtMOD:
+		if slot != slotTonePorta && slot != slotOffset {
+			return arg
+		}
+	case fmtS3M:
+		// Scream Tracker 3 shares most effect memory.
+		switch slot {
+		case slotVolSlide, slotPortaUp, slotPortaDown, slotTremor, slotArpeggio, slotRetrig:
+			slot = slotVolSlide
+		}
+	case fmtIT:
+		switch slot {
+		case slotPortaDown:
+			slot = slotPortaUp
+		case slotTonePorta:
+			if !p.m.compatGxx {
+				slot = slotPortaUp
+			}
+		}
+	}
+	if arg != 0 {
+		c.mem[slot] = arg
+	}
+	return c.mem[slot]
+}
+
+// slide applies a volume-like slide to v, clamped to [0, max]. Slides in
+// the ProTracker style have up in the high nibble and run on every tick but
+// the first; Scream Tracker slides also have fine variants.
+func (p *player) slide(v *int, arg byte, first bool, max, scale int, pt bool) {
+	x, y := int(arg>>4), int(arg&0xf)
+	d := 0
+	switch {
+	case pt:
+		if !first {
+			if x != 0 {
+				d = x
+			} else {
+				d = -y
+			}
+		}
+	case y == 0xf && x != 0:
+		if first {
+			d = x
+		}
+	case x == 0xf && y != 0:
+		if first {
+			d = -y
+		}
+	case y == 0:
+		if !first {
+			d = x
+		}
+	case x == 0:
+		if !first {
+			d = -y
+		}
+	}
+	*v += d * scale
+	if *v < 0 {
+		*v = 0
+	}
+	if *v > max {
+		*v = max
+	}
+}
+
+func (p *player) ptStyle() bool {
+	return p.m.format == fmtMOD || p.m.format == fmtXM
+}
+
+func (p *player) volSlide(c *channel, arg byte, first bool) {
+	p.slide(&c.vol, p.memory(c, slotVolSlide, arg), first, 64, 1, p.ptStyle())
+}
+
+// porta slides the period down, raising the pitch, if up is set.
+func (p *player) porta(c *channel, arg byte, first, up bool) {
+	slot := slotPortaDown
+	if up {
+		slot = slotPortaUp
+	}
+	arg = p.memory(c, slot, arg)
+	d := 0
+	switch {
+	case p.ptStyle():
+		if !first {
+			d = int(arg) * 4
+		}
+	case arg >= 0xf0:
+		if first {
+			d = int(arg&0xf) * 4
+		}
+	case arg >= 0xe0:
+		if first {
+			d = int(arg & 0xf)
+		}
+	default:
+		if !first {
+			d = int(arg) * 4
+		}
+	}
+	p.slidePeriod(c, d, up)
+}
+
+func (p *player) slidePeriod(c *channel, d int, up bool) {
+	if up {
+		d = -d
+	}
+	c.period += float64(d)
+	c.target = c.period
+	p.clampPeriod(c)
+}
+
+func (p *player) tonePorta(c *channel, arg byte, first bool) {
+	speed := float64(p.memory(c, slotTonePorta, arg)) * 4
+	if first {
+		return
+	}
+	if c.period < c.target {
+		c.period = math.Min(c.period+speed, c.target)
+	} else {
+		c.period = math.Max(c.period-speed, c.target)
+	}
+}
+
+func (p *player) vibrato(c *channel, arg byte, first bool, fine bool) {
+	if arg>>4 != 0 {
+		c.vibSpeed = int(arg >> 4)
+	}
+	if arg&0xf != 0 {
+		c.vibDepth = int(arg & 0xf)
+	}
+	if first {
+		return
+	}
+	d := float64(p.wave(c.vibWave&3, c.vibPos)*c.vibDepth) / 32
+	if fine {
+		d /= 4
+	}
+	c.vibDelta = d
+	c.vibPos += c.vibSpeed
+}
+
+func (p *player) volumeColumn(c *channel, first bool) {
+	arg := c.cell.volArg
+	switch c.cell.volCmd {
+	case vcVolume:
+		if first {
+			c.vol = int(arg)
+		}
+	case vcPanning:
+		if first {
+			c.pan = int(arg)
+		}
+	case vcVolSlideUp:
+		if !first {
+			p.slide(&c.vol, arg<<4, false, 64, 1, true)
+		}
+	case vcVolSlideDown:
+		if !first {
+			p.slide(&c.vol, arg, false, 64, 1, true)
+		}
+	case vcFineVolUp:
+		if first {
+			p.slide(&c.vol, arg<<4, false, 64, 1, true)
+		}
+	case vcFineVolDown:
+		if first {
+			p.slide(&c.vol, arg, false, 64, 1, true)
+		}
+	case vcPortaUp:
+		p.porta(c, arg, first, true)
+	case vcPortaDown:
+		p.porta(c, arg, first, false)
+	case vcTonePorta:
+		p.tonePorta(c, arg, first)
+	case vcVibratoSpeed:
+		if first && arg != 0 {
+			c.vibSpeed = int(arg)
+		}
+	case vcVibrato:
+		p.vibrato(c, arg, first, false)
+	case vcPanSlideLeft:
+		if !first {
+			p.slide(&c.pan, arg, false, 256, 1, true)
+		}
+	case vcPanSlideRight:
+		if !first {
+			p.slide(&c.pan, arg<<4, false, 256, 1, true)
+		}
+	}
+}
+
+// effect runs the channel's effect for the first tick of a row if first is
+// set, or otherwise for a later tick.
+func (p *player) effect(c *channel, first bool) {
+	arg := c.cell.arg
+	x, y := int(arg>>4), int(arg&0xf)
+	switch c.cell.cmd {
+	case fxArpeggio:
+		arg = p.memory(c, slotArpeggio, arg)
+		switch p.tick % 3 {
+		case 0:
+			c.arp = 0
+		case 1:
+			c.arp = int(arg >> 4)
+		case 2:
+			c.arp = int(arg & 0xf)
+		}
+	case fxPortaUp:
+		p.porta(c, arg, first, true)
+	case fxPortaDown:
+		p.porta(c, arg, first, false)
+	case fxFinePortaUp, fxFinePortaDown:
+		up := c.cell.cmd == fxFinePortaUp
+		slot := slotFinePortaDown
+		if up {
+			slot = slotFinePortaUp
+		}
+		if first {
+			p.slidePeriod(c, int(p.memory(c, slot, arg))*4, up)
+		}
+	case fxExtraFinePortaUp, fxExtraFinePortaDown:
+		up := c.cell.cmd == fxExtraFinePortaUp
+		slot := slotExtraFinePortaDown
+		if up {
+			slot = slotExtraFinePortaUp
+		}
+		if first {
+			p.slidePeriod(c, int(p.memory(c, slot, arg)), up)
+		}
+	case fxTonePorta:
+		p.tonePorta(c, arg, first)
+	case fxTonePortaVol:
+		p.tonePorta(c, 0, first)
+		p.volSlide(c, arg, first)
+	case fxVibrato:
+		p.vibrato(c, arg, first, false)
+	case fxFineVibrato:
+		p.vibrato(c, arg, first, true)
+	case fxVibratoVol:
+		p.vibrato(c, 0, first, false)
+		p.volSlide(c, arg, first)
+	case fxTremolo:
+		if x != 0 {
+			c.tremSpeed = x
+		}
+		if y != 0 {
+			c.tremDepth = y
+		}
+		if !first {
+			c.tremDelta = p.wave(c.tremWave&3, c.tremPos) * c.tremDepth / 64
+			c.tremPos += c.tremSpeed
+		}
+	case fxTremor:
+		arg = p.memory(c, slotTremor, arg)
+		on, off := int(arg>>4)+1, int(arg&0xf)+1
+		c.tremorMute = c.tremorCount%(on+off) >= on
+		c.tremorCount++
+	case fxPanning:
+		if first {
+			c.pan = int(arg)
+		}
+	case fxPan4:
+		if first {
+			c.pan = y * 17
+		}
+	case fxSurround:
+		if first {
+			c.pan = 128
+		}
+	case fxPanSlide:
+		arg = p.memory(c, slotPanSlide, arg)
+		if p.m.format == fmtXM {
+			p.slide(&c.pan, arg, first, 256, 1, true)
+		} else {
+			// Impulse Tracker slides left with the high nibble.
+			p.slide(&c.pan, arg>>4|arg<<4, first, 256, 4, false)
+		}
+	case fxPanbrello:
+		if x != 0 {
+			c.mem[slotPanbrello] = c.mem[slotPanbrello]&0xf | arg&0xf0
+		}
+		if y != 0 {
+			c.mem[slotPanbrello] = c.mem[slotPanbrello]&0xf0 | arg&0xf
+		}
+		m := c.mem[slotPanbrello]
+		c.panbDelta = p.wave(c.panbWave&3, c.panbPos) * int(m&0xf) / 64
+		c.panbPos += int(m >> 4)
+	case fxVolSlide:
+		p.volSlide(c, arg, first)
+	case fxFineVolUp:
+		if first {
+			c.vol += int(p.memory(c, slotFineVolUp, arg))
+			if c.vol > 64 {
+				c.vol = 64
+			}
+		}
+	case fxFineVolDown:
+		if first {
+			c.vol -= int(p.memory(c, slotFineVolDown, arg))
+			if c.vol < 0 {
+				c.vol = 0
+			}
+		}
+	case fxVolume:
+		if first {
+			c.vol = int(arg)
+		}
+	case fxChannelVol:
+		if first && arg <= 64 {
+			c.chanVol = int(arg)
+		}
+	case fxChannelVolSlide:
+		p.slide(&c.chanVol, p.memory(c, slotChannelVolSlide, arg), first, 64, 1, false)
+	case fxGlobalVol:
+		if first && arg <= 128 {
+			p.globalVol = int(arg)
+		}
+	case fxGlobalVolSlide:
+		arg = p.memory(c, slotGlobalVolSlide, arg)
+		scale := 2
+		if p.m.format == fmtIT {
+			scale = 1
+		}
+		p.slide(&p.globalVol, arg, first, 128, scale, p.m.format == fmtXM)
+	case fxJump:
+		if first {
+			p.jumpOrder = int(arg)
+			if p.jumpOrder >= len(p.m.orders) {
+				p.jumpOrder = 0
+			}
+		}
+	case fxBreak:
+		if first {
+			p.breakRow = int(arg)
+		}
+	case fxSpeed:
+		if first {
+			p.speed = int(arg)
+		}
+	case fxSpeedTempo:
+		if first {
+			if arg < 32 {
+				p.speed = int(arg)
+			} else {
+				p.tempo = int(arg)
+			}
+		}
+	case fxTempo:
+		arg = p.memory(c, slotTempo, arg)
+		switch {
+		case arg >= 0x20:
+			if first {
+				p.tempo = int(arg)
+			}
+		case first || p.m.format != fmtIT:
+		case x == 0:
+			if p.tempo -= y; p.tempo < 32 {
+				p.tempo = 32
+			}
+		case x == 1:
+			if p.tempo += y; p.tempo > 255 {
+				p.tempo = 255
+			}
+		}
+	case fxRetrig:
+		arg = p.memory(c, slotRetrig, arg)
+		x, y = int(arg>>4), int(arg&0xf)
+		if first || y == 0 {
+			break
+		}
+		if c.retrigCount++; c.retrigCount >= y {
+			c.retrigCount = 0
+			p.retrigger(c)
+			c.vol = retrigVolume(c.vol, x)
+		}
+	case fxNoteCut:
+		if p.tick == y {
+			if p.m.format == fmtIT {
+				if c.v != nil {
+					c.v.stop = true
+				}
+			} else {
+				c.vol = 0
+			}
+		}
+	case fxNoteDelay:
+		if c.delay > 0 && p.tick == c.delay {
+			c.delay = 0
+			p.playCell(c)
+		}
+	case fxKeyOff:
+		if p.tick == int(arg) {
+			p.keyOff(c)
+		}
+	case fxEnvPos:
+		if first && c.v != nil {
+			c.v.volEnvPos = int(arg)
+			c.v.panEnvPos = int(arg)
+		}
+	case fxVibratoWave:
+		c.vibWave = y
+	case fxTremoloWave:
+		c.tremWave = y
+	case fxPanbrelloWave:
+		c.panbWave = y
+	case fxHighOffset:
+		c.highOffset = y
+	case fxPatternLoop:
+		if !first {
+			break
+		}
+		if y == 0 {
+			c.loopRow = p.row
+		} else if c.loopCount == 0 {
+			c.loopCount = y
+			p.loopTo = c.loopRow
+		} else if c.loopCount--; c.loopCount > 0 {
+			p.loopTo = c.loopRow
+		}
+	case fxPatternDelay:
+		if first && p.patDelay == 0 {
+			p.patDelay = y
+		}
+	case fxFinePatternDelay:
+		if first {
+			p.finePatDelay += y
+		}
+	case fxInstControl:
+		if first {
+			p.instControl(c, y)
+		}
+	}
+}
+
+func (p *player) instControl(c *channel, y int) {
+	switch {
+	case y <= 2:
+		for _, v := range p.bg {
+			if v.channel != c.index {
+				continue
+			}
+			switch y {
+			case 0:
+				v.stop = true
+			case 1:
+				p.release(v)
+			case 2:
+				v.fading = true
+			}
+		}
+	case y <= 6:
+		c.nna = []int{nnaCut, nnaContinue, nnaOff, nnaFade}[y-3]
+	case c.v != nil:
+		switch y {
+		case 7, 8:
+			c.v.volEnvOff = y == 7
+		case 9, 10:
+			c.v.panEnvOff = y == 9
+		case 11, 12:
+			c.v.pitchEnvOff = y == 11
+		}
+	}
+}
+
+// retrigVolume applies a retrigger volume change.
+func retrigVolume(vol, x int) int {
+	switch x {
+	case 1, 2, 3, 4, 5:
+		vol -= 1 << uint(x-1)
+	case 6:
+		vol = vol * 2 / 3
+	case 7:
+		vol /= 2
+	case 9, 10, 11, 12, 13:
+		vol += 1 << uint(x-9)
+	case 14:
+		vol = vol * 3 / 2
+	case 15:
+		vol *= 2
+	}
+	if vol < 0 {
+		vol = 0
+	}
+	if vol > 64 {
+		vol = 64
+	}
+	return vol
+}
+
+func (p *player) retrigger(c *channel) {
+	if v := c.v; v != nil && len(v.smp.data) > 0 {
+		v.pos, v.dir, v.active, v.stop = 0, 1, true, false
+		v.resetEnvelopes()
+	}
+}
+
+// tickChannel runs a tick after the first of a row.
+func (p *player) tickChannel(c *channel) {
+	if c.delay > 0 {
+		if p.tick == c.delay {
+			c.delay = 0
+			p.playCell(c)
+		}
+		return
+	}
+	p.volumeColumn(c, false)
+	p.effect(c, false)
+}
+
+// updateChannel sets the channel's voice parameters for the next tick.
+func (p *player) updateChannel(c *channel) {
+	v := c.v
+	if v == nil {
+		return
+	}
+	if !v.active {
+		c.v = nil
+		return
+	}
+	vol := c.vol + c.tremDelta
+	if vol < 0 || c.tremorMute {
+		vol = 0
+	}
+	if vol > 64 {
+		vol = 64
+	}
+	g := float64(vol) / 64 * float64(v.smp.globalVol) / 64 * float64(c.chanVol) / 64
+	if v.ins != nil {
+		g *= float64(v.ins.globalVol) / 128
+	}
+	v.vol = g
+	v.pan = float64(c.pan + c.panbDelta)
+	v.freq = p.frequency(c.period+c.vibDelta, c.c5speed) * math.Pow(2, float64(c.arp)/12)
+	p.updateVoice(v)
+}
+
+// updateVoice runs the voice's envelopes and sets its gains for the next
+// tick.
+func (p *player) updateVoice(v *voice) {
+	if !v.active {
+		return
+	}
+	vol, pan, freq := v.vol, v.pan, v.freq
+	if in := v.ins; in != nil {
+		if e := &in.volEnv; e.on && !v.volEnvOff {
+			vol *= e.value(v.volEnvPos) / 64
+			v.volEnvPos = e.advance(v.volEnvPos, v.released)
+			if p.m.format == fmtIT && e.ended(v.volEnvPos) {
+				if e.points[len(e.points)-1].y == 0 {
+					v.stop = true
+				}
+				v.fading = true
+			}
+		}
+		if e := &in.panEnv; e.on && !v.panEnvOff {
+			d := 128 - math.Abs(pan-128)
+			pan += e.value(v.panEnvPos) * d / 32
+			v.panEnvPos = e.advance(v.panEnvPos, v.released)
+		}
+		if e := &in.pitchEnv; e.on && !v.pitchEnvOff {
+			freq *= math.Pow(2, e.value(v.pitchEnvPos)/24)
+			v.pitchEnvPos = e.advance(v.pitchEnvPos, v.released)
+		}
+		if v.fading {
+			if v.fade -= in.fadeout; v.fade <= 0 {
+				v.fade = 0
+				v.stop = true
+			}
+		}
+		vol *= float64(v.fade) / 65536
+	} else if v.fading {
+		v.stop = true
+	}
+	if s := v.smp; s.vibDepth != 0 && s.vibRate != 0 {
+		depth := float64(s.vibDepth)
+		if s.vibSweep != 0 && v.vibTicks < s.vibSweep {
+			depth *= float64(v.vibTicks) / float64(s.vibSweep)
+		}
+		v.vibTicks++
+		freq *= math.Pow(2, float64(p.wave(s.vibType, v.vibPos>>2))/255*depth/64/12)
+		v.vibPos += s.vibRate
+	}
+	if pan < 0 {
+		pan = 0
+	}
+	if pan > 256 {
+		pan = 256
+	}
+	if v.stop {
+		vol = 0
+	}
+	v.step = freq / rate
+	v.tl = vol * math.Sqrt(1-pan/256)
+	v.tr = vol * math.Sqrt(pan/256)
+}
+
+// mix mixes frames samples of all voices into out starting at frame off.
+func (p *player) mix(out []float32, off, frames int) {
+	g := p.gain * float64(p.globalVol) / 128
+	for i := range p.ch {
+		if v := p.ch[i].v; v != nil {
+			v.mix(out, off, frames, g)
+		}
+	}
+	bg := p.bg[:0]
+	for _, v := range p.bg {
+		v.mix(out, off, frames, g)
+		if v.active {
+			bg = append(bg, v)
+		}
+	}
+	for i := len(bg); i < len(p.bg); i++ {
+		p.bg[i] = nil
+	}
+	p.bg = bg
+}
+
+// loop returns the active loop.
+func (v *voice) loop() (typ, start, end int) {
+	s := v.smp
+	if s.susLoop != loopNone && !v.released {
+		return s.susLoop, s.susStart, s.susEnd
+	}
+	if s.loop != loopNone {
+		return s.loop, s.loopStart, s.loopEnd
+	}
+	return loopNone, 0, len(s.data)
+}
+
+// at returns sample i, following the active loop.
+func (v *voice) at(i, typ, start, end int) float64 {
+	d := v.smp.data
+	if i >= end {
+		switch typ {
+		case loopForward:
+			i = start + (i-end)%(end-start)
+		case loopPingPong:
+			i = end - 1 - (i-end)%(end-start)
+		default:
+			return 0
+		}
+	}
+	if i < 0 {
+		return 0
+	}
+	return float64(d[i])
+}
+
+// advance moves the voice forward by d samples.
+func (v *voice) advance(d float64, typ, start, end int) {
+	v.pos += d * v.dir
+	e, s := float64(end), float64(start)
+	switch typ {
+	case loopNone:
+		if v.pos >= e {
+			v.active = false
+		}
+	case loopForward:
+		if v.pos >= e {
+			v.pos = s + math.Mod(v.pos-e, e-s)
+		}
+	case loopPingPong:
+		if v.pos >= e || v.dir < 0 && v.pos < s {
+			// Fold the position into a loop twice as long.
+			n := 2 * (e - s)
+			x := math.Mod(v.pos-s, n)
+			if x < 0 {
+				x += n
+			}
+			if x < e-s {
+				v.pos, v.dir = s+x, 1
+			} else {
+				v.pos, v.dir = e-(x-(e-s)), -1
+			}
+		}
+	}
+}
+
+// mix adds frames samples to out at frame off, ramping the gains to their
+// targets. If out is nil, the voice only advances.
+func (v *voice) mix(out []float32, off, frames int, g float64) {
+	if !v.active {
+		return
+	}
+	typ, start, end := v.loop()
+	if out == nil {
+		v.advance(v.step*float64(frames), typ, start, end)
+		v.gl, v.gr = v.tl, v.tr
+	} else {
+		n := ramp
+		if n > frames {
+			n = frames
+		}
+		dl, dr := (v.tl-v.gl)/float64(n), (v.tr-v.gr)/float64(n)
+		d := v.smp.data
+		for i := 0; i < frames && v.active; i++ {
+			if i < n {
+				v.gl += dl
+				v.gr += dr
+			} else if i == n {
+				v.gl, v.gr = v.tl, v.tr
+			}
+			// Cubic Hermite interpolation.
+			j := int(v.pos)
+			f := v.pos - float64(j)
+			var s0, s1, s2, s3 float64
+			if j >= 1 && j+2 < end {
+				s0, s1, s2, s3 = float64(d[j-1]), float64(d[j]), float64(d[j+1]), float64(d[j+2])
+			} else {
+				s0, s1, s2, s3 = v.at(j-1, typ, start, end), v.at(j, typ, start, end), v.at(j+1, typ, start, end), v.at(j+2, typ, start, end)
+			}
+			c1 := (s2 - s0) / 2
+			c2 := s0 - 2.5*s1 + 2*s2 - s3/2
+			c3 := (s3-s0)/2 + 1.5*(s1-s2)
+			x := ((c3*f+c2)*f+c1)*f + s1
+			out[(off+i)*2] += float32(x * v.gl * g)
+			out[(off+i)*2+1] += float32(x * v.gr * g)
+			v.advance(v.step, typ, start, end)
+		}
+	}
+	if v.stop {
+		v.active = false
+	}
+}
@@ -0,0 +1,259 @@
+package mod
+
+import (
+	"math"
+	"strconv"
+)
+
+// modChannels returns the channel count for a MOD signature, or 0 if it is
+// not a known signature.
+func modChannels(sig string) int {
+	if len(sig) != 4 {
+		return 0
+	}
+	switch sig {
+	case "M.K.", "M!K!", "M&K!", "N.T.", "FLT4", "4CHN":
+		return 4
+	case "6CHN":
+		return 6
+	case "8CHN", "OKTA", "CD81", "FLT8":
+		return 8
+	}
+	if sig[:3] == "TDZ" && sig[3] >= '1' && sig[3] <= '9' {
+		return int(sig[3] - '0')
+	}
+	if sig[2:] == "CH" || sig[2:] == "CN" {
+		if n, err := strconv.Atoi(sig[:2]); err == nil && n > 0 && n <= 32 {
+			return n
+		}
+	}
+	return 0
+}
+
+// modNote converts an Amiga period to a note.
+func modNote(period int) byte {
+	if period == 0 {
+		return noteNone
+	}
+	n := 48 + int(math.Floor(12*math.Log2(428/float64(period))+0.5))
+	if n < 0 || n > 119 {
+		return noteNone
+	}
+	return byte(n + 1)
+}
+
+// modEffect converts a ProTracker or FastTracker 2 effect.
+func modEffect(cmd, arg byte, format int) (byte, byte) {
+	noMem := format == fmtMOD
+	switch cmd {
+	case 0x0:
+		if arg != 0 {
+			return fxArpeggio, arg
+		}
+	case 0x1:
+		if arg != 0 || !noMem {
+			return fxPortaUp, arg
+		}
+	case 0x2:
+		if arg != 0 || !noMem {
+			return fxPortaDown, arg
+		}
+	case 0x3:
+		return fxTonePorta, arg
+	case 0x4:
+		return fxVibrato, arg
+	case 0x5:
+		return fxTonePortaVol, arg
+	case 0x6:
+		return fxVibratoVol, arg
+	case 0x7:
+		return fxTremolo, arg
+	case 0x8:
+		return fxPanning, arg
+	case 0x9:
+		return fxOffset, arg
+	case 0xa:
+		if arg != 0 || !noMem {
+			return fxVolSlide, arg
+		}
+	case 0xb:
+		return fxJump, arg
+	case 0xc:
+		if arg > 64 {
+			arg = 64
+		}
+		return fxVolume, arg
+	case 0xd:
+		return fxBreak, arg>>4*10 + arg&0xf
+	case 0xe:
+		x := arg & 0xf
+		switch arg >> 4 {
+		case 0x1:
+			return fxFinePortaUp, x
+		case 0x2:
+			return fxFinePortaDown, x
+		case 0x4:
+			return fxVibratoWave, x
+		case 0x5:
+			return fxFinetune, x
+		case 0x6:
+			return fxPatternLoop, x
+		case 0x7:
+			return fxTremoloWave, x
+		case 0x8:
+			return fxPan4, x
+		case 0x9:
+			return fxRetrig, x
+		case 0xa:
+			return fxFineVolUp, x
+		case 0xb:
+			return fxFineVolDown, x
+		case 0xc:
+			return fxNoteCut, x
+		case 0xd:
+			return fxNoteDelay, x
+		case 0xe:
+			return fxPatternDelay, x
+		}
+	case 0xf:
+		if arg != 0 {
+			return fxSpeedTempo, arg
+		}
+	}
+	if format != fmtXM {
+		return fxNone, 0
+	}
+	switch cmd {
+	case 'G' - 'A' + 10:
+		if arg > 64 {
+			arg = 64
+		}
+		return fxGlobalVol, arg * 2
+	case 'H' - 'A' + 10:
+		return fxGlobalVolSlide, arg
+	case 'K' - 'A' + 10:
+		return fxKeyOff, arg
+	case 'L' - 'A' + 10:
+		return fxEnvPos, arg
+	case 'P' - 'A' + 10:
+		return fxPanSlide, arg
+	case 'R' - 'A' + 10:
+		return fxRetrig, arg
+	case 'T' - 'A' + 10:
+		return fxTremor, arg
+	case 'X' - 'A' + 10:
+		switch arg >> 4 {
+		case 1:
+			return fxExtraFinePortaUp, arg & 0xf
+		case 2:
+			return fxExtraFinePortaDown, arg & 0xf
+		}
+	}
+	return fxNone, 0
+}
+
+func loadMOD(d data) (*module, error) {
+	m := &module{
+		format:    fmtMOD,
+		middle:    48,
+		title:     d.str(0, 20),
+		speed:     6,
+		tempo:     125,
+		globalVol: 128,
+		mix:       1,
+	}
+	numSamples, orderOff, patOff := 31, 952, 1084
+	m.channels = modChannels(string(d.slice(1080, 4)))
+	if m.channels == 0 {
+		// Original Soundtracker modules have 15 samples and no signature.
+		if len(d) < 600 {
+			return nil, errFormat
+		}
+		m.channels = 4
+		numSamples, orderOff, patOff = 15, 472, 600
+	}
+	songLen := d.u8(orderOff - 2)
+	if songLen == 0 || songLen > 128 {
+		return nil, errFormat
+	}
+	m.restart = d.u8(orderOff - 1)
+	if m.restart >= songLen {
+		m.restart = 0
+	}
+	// ProTracker stores patterns up to the highest one in the whole order
+	// table, but other trackers only count the played orders.
+	numPatterns, played := 0, 0
+	for i := 0; i < 128; i++ {
+		o := d.u8(orderOff + i)
+		if o >= numPatterns {
+			numPatterns = o + 1
+		}
+		if i < songLen {
+			m.orders = append(m.orders, o)
+			if o >= played {
+				played = o + 1
+			}
+		}
+	}
+	rowSize := m.channels * 4
+	sampleSize := 0
+	for i := 0; i < numSamples; i++ {
+		sampleSize += d.be16(20+i*30+22) * 2
+	}
+	if patOff+numPatterns*64*rowSize+sampleSize > len(d) {
+		numPatterns = played
+	}
+
+	for i := 0; i < m.channels; i++ {
+		pan := 32
+		if i&3 == 1 || i&3 == 2 {
+			pan = 224
+		}
+		m.pan = append(m.pan, pan)
+		m.chanVol = append(m.chanVol, 64)
+		m.muted = append(m.muted, false)
+	}
+
+	for i := 0; i < numPatterns; i++ {
+		p := &pattern{rows: 64, cells: make([]cell, 64*m.channels)}
+		b := data(d.slice(patOff+i*64*rowSize, 64*rowSize))
+		for j := range p.cells {
+			o := j * 4
+			c := &p.cells[j]
+			c.ins = byte(b.u8(o)&0xf0 | b.u8(o+2)>>4)
+			c.note = modNote(b.u8(o)&0xf<<8 | b.u8(o+1))
+			c.cmd, c.arg = modEffect(byte(b.u8(o+2)&0xf), byte(b.u8(o+3)), fmtMOD)
+		}
+		m.patterns = append(m.patterns, p)
+	}
+
+	o := patOff + numPatterns*64*rowSize
+	for i := 0; i < numSamples; i++ {
+		h := data(d.slice(20+i*30, 30))
+		length := h.be16(22) * 2
+		s := &sample{
+			name:      h.str(0, 22),
+			volume:    h.u8(25),
+			globalVol: 64,
+			pan:       -1,
+			c5speed:   8363 * math.Pow(2, float64(int8(h.u8(24)<<4)>>4)/96),
+			loopStart: h.be16(26) * 2,
+		}
+		if s.volume > 64 {
+			s.volume = 64
+		}
+		if loopLen := h.be16(28) * 2; loopLen > 2 {
+			// Some old modules store the loop start in bytes.
+			if s.loopStart+loopLen > length && s.loopStart/2+loopLen <= length {
+				s.loopStart /= 2
+			}
+			s.loop = loopForward
+			s.loopEnd = s.loopStart + loopLen
+		}
+		s.data = pcm8(d.slice(o, length), true, false)
+		o += length
+		s.fixLoops()
+		m.samples = append(m.samples, s)
+	}
+	return m, nil
+}
@@ -0,0 +1,287 @@
+package mod
+
+// s3mEffect converts a Scream Tracker 3 or Impulse Tracker effect, where
+// cmd 1 is effect A.
+func s3mEffect(cmd, arg byte, format int) (byte, byte) {
+	switch cmd + 'A' - 1 {
+	case 'A':
+		if arg != 0 {
+			return fxSpeed, arg
+		}
+	case 'B':
+		return fxJump, arg
+	case 'C':
+		if format == fmtS3M {
+			arg = arg>>4*10 + arg&0xf
+		}
+		return fxBreak, arg
+	case 'D':
+		return fxVolSlide, arg
+	case 'E':
+		return fxPortaDown, arg
+	case 'F':
+		return fxPortaUp, arg
+	case 'G':
+		return fxTonePorta, arg
+	case 'H':
+		return fxVibrato, arg
+	case 'I':
+		return fxTremor, arg
+	case 'J':
+		return fxArpeggio, arg
+	case 'K':
+		return fxVibratoVol, arg
+	case 'L':
+		return fxTonePortaVol, arg
+	case 'M':
+		if format == fmtIT {
+			return fxChannelVol, arg
+		}
+	case 'N':
+		if format == fmtIT {
+			return fxChannelVolSlide, arg
+		}
+	case 'O':
+		return fxOffset, arg
+	case 'P':
+		if format == fmtIT {
+			return fxPanSlide, arg
+		}
+	case 'Q':
+		return fxRetrig, arg
+	case 'R':
+		return fxTremolo, arg
+	case 'S':
+		x := arg & 0xf
+		switch arg >> 4 {
+		case 0x2:
+			return fxFinetune, x
+		case 0x3:
+			return fxVibratoWave, x
+		case 0x4:
+			return fxTremoloWave, x
+		case 0x5:
+			return fxPanbrelloWave, x
+		case 0x6:
+			return fxFinePatternDelay, x
+		case 0x7:
+			if format == fmtIT {
+				return fxInstControl, x
+			}
+		case 0x8:
+			return fxPan4, x
+		case 0x9:
+			return fxSurround, x
+		case 0xa:
+			if format == fmtIT {
+				return fxHighOffset, x
+			}
+		case 0xb:
+			return fxPatternLoop, x
+		case 0xc:
+			return fxNoteCut, x
+		case 0xd:
+			return fxNoteDelay, x
+		case 0xe:
+			return fxPatternDelay, x
+		}
+	case 'T':
+		return fxTempo, arg
+	case 'U':
+		return fxFineVibrato, arg
+	case 'V':
+		if format == fmtS3M {
+			if arg > 64 {
+				arg = 64
+			}
+			arg *= 2
+		}
+		return fxGlobalVol, arg
+	case 'W':
+		return fxGlobalVolSlide, arg
+	case 'X':
+		if format == fmtS3M {
+			if arg == 0xa4 {
+				return fxSurround, 1
+			}
+			if arg >= 0x80 {
+				arg = 0xff
+			} else {
+				arg *= 2
+			}
+		}
+		return fxPanning, arg
+	case 'Y':
+		return fxPanbrello, arg
+	}
+	return fxNone, 0
+}
+
+func loadS3M(d data) (*module, error) {
+	if string(d.slice(0x2c, 4)) != "SCRM" {
+		return nil, errFormat
+	}
+	numOrders, numSamples, numPatterns := d.u16(0x20), d.u16(0x22), d.u16(0x24)
+	signed := d.u16(0x2a) == 1
+	m := &module{
+		format:    fmtS3M,
+		middle:    48,
+		title:     d.str(0, 28),
+		globalVol: d.u8(0x30) * 2,
+		speed:     d.u8(0x31),
+		tempo:     d.u8(0x32),
+	}
+	if m.globalVol > 128 {
+		m.globalVol = 128
+	}
+	if m.speed == 0 || m.speed == 255 {
+		m.speed = 6
+	}
+	if m.tempo < 32 {
+		m.tempo = 125
+	}
+	master := d.u8(0x33)
+	if mv := master & 0x7f; mv >= 16 {
+		m.mix = float64(mv) / 48
+	} else {
+		m.mix = 1
+	}
+
+	// Channels are numbered up to the last enabled one.
+	settings := d.slice(0x40, 32)
+	for i, s := range settings {
+		if s < 16 {
+			m.channels = i + 1
+		}
+	}
+	if m.channels == 0 {
+		return nil, errFormat
+	}
+	o := 0x60
+	for i := 0; i < numOrders; i++ {
+		switch p := d.u8(o + i); p {
+		case 254:
+		case 255:
+			i = numOrders
+		default:
+			m.orders = append(m.orders, p)
+		}
+	}
+	o += numOrders
+	panOff := o + numSamples*2 + numPatterns*2
+	usePan := d.u8(0x35) == 252
+	for i := 0; i < m.channels; i++ {
+		s := settings[i]
+		pan := 128
+		if master&0x80 != 0 {
+			pan = 0x3 * 17
+			if s&0x7f >= 8 {
+				pan = 0xc * 17
+			}
+		}
+		if p := d.u8(panOff + i); usePan && p&0x20 != 0 {
+			pan = (p & 0xf) * 17
+		}
+		m.pan = append(m.pan, pan)
+		m.chanVol = append(m.chanVol, 64)
+		m.muted = append(m.muted, s >= 16)
+	}
+
+	for i := 0; i < numSamples; i++ {
+		h := data(d.slice(d.u16(o+i*2)*16, 0x50))
+		s := &sample{
+			name:      h.str(0x30, 28),
+			volume:    h.u8(0x1c),
+			globalVol: 64,
+			pan:       -1,
+			c5speed:   float64(h.u32(0x20)),
+		}
+		m.samples = append(m.samples, s)
+		if h.u8(0) != 1 {
+			continue
+		}
+		if s.volume > 64 {
+			s.volume = 64
+		}
+		flags := h.u8(0x1f)
+		if flags&1 != 0 {
+			s.loop = loopForward
+			s.loopStart, s.loopEnd = h.u32(0x14), h.u32(0x18)
+		}
+		length := h.u32(0x10)
+		bytes := 1
+		if flags&4 != 0 {
+			bytes = 2
+		}
+		pos := (h.u8(0xd)<<16 | h.u16(0xe)) * 16
+		raw := d.slice(pos, length*bytes)
+		if bytes == 2 {
+			s.data = pcm16(raw, signed, false)
+		} else {
+			s.data = pcm8(raw, signed, false)
+		}
+		if flags&2 != 0 {
+			// Stereo samples store the right channel after the left.
+			right := d.slice(pos+length*bytes, length*bytes)
+			var r []float32
+			if bytes == 2 {
+				r = pcm16(right, signed, false)
+			} else {
+				r = pcm8(right, signed, false)
+			}
+			for j := range r {
+				if j < len(s.data) {
+					s.data[j] = (s.data[j] + r[j]) / 2
+				}
+			}
+		}
+		s.fixLoops()
+	}
+	o += numSamples * 2
+
+	for i := 0; i < numPatterns; i++ {
+		p := &pattern{rows: 64, cells: make([]cell, 64*m.channels)}
+		m.patterns = append(m.patterns, p)
+		po := d.u16(o+i*2) * 16
+		if po == 0 {
+			continue
+		}
+		b := data(d.slice(po+2, d.u16(po)))
+		j := 0
+		for row := 0; row < 64 && j < len(b); {
+			what := b.u8(j)
+			j++
+			if what == 0 {
+				row++
+				continue
+			}
+			c := &cell{}
+			if ch := what & 31; ch < m.channels {
+				c = p.cell(row, ch, m.channels)
+			}
+			if what&32 != 0 {
+				switch n := b.u8(j); {
+				case n == 254:
+					c.note = noteCut
+				case n < 254 && n>>4 < 10 && n&0xf < 12:
+					c.note = byte(n>>4*12 + n&0xf + 1)
+				}
+				c.ins = byte(b.u8(j + 1))
+				j += 2
+			}
+			if what&64 != 0 {
+				v := b.u8(j)
+				if v > 64 {
+					v = 64
+				}
+				c.volCmd, c.volArg = vcVolume, byte(v)
+				j++
+			}
+			if what&128 != 0 {
+				c.cmd, c.arg = s3mEffect(byte(b.u8(j)), byte(b.u8(j+1)), fmtS3M)
+				j += 2
+			}
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,234 @@
+package mod
+
+import (
+	"math"
+	"strings"
+)
+
+// xmVolume converts an XM volume column byte.
+func xmVolume(v byte) (byte, byte) {
+	x := v & 0xf
+	switch v >> 4 {
+	case 0x1, 0x2, 0x3, 0x4:
+		return vcVolume, v - 0x10
+	case 0x5:
+		if v == 0x50 {
+			return vcVolume, 64
+		}
+	case 0x6:
+		return vcVolSlideDown, x
+	case 0x7:
+		return vcVolSlideUp, x
+	case 0x8:
+		return vcFineVolDown, x
+	case 0x9:
+		return vcFineVolUp, x
+	case 0xa:
+		return vcVibratoSpeed, x
+	case 0xb:
+		return vcVibrato, x
+	case 0xc:
+		return vcPanning, x * 17
+	case 0xd:
+		return vcPanSlideLeft, x
+	case 0xe:
+		return vcPanSlideRight, x
+	case 0xf:
+		return vcTonePorta, x * 16
+	}
+	return vcNone, 0
+}
+
+// xmEnvelope reads an XM envelope whose points are at p.
+func xmEnvelope(d data, p, n, sus, loopStart, loopEnd, typ, offset int) envelope {
+	e := envelope{
+		on:        typ&1 != 0,
+		sustain:   typ&2 != 0,
+		loop:      typ&4 != 0,
+		susStart:  sus,
+		susEnd:    sus,
+		loopStart: loopStart,
+		loopEnd:   loopEnd,
+	}
+	if n > 12 {
+		n = 12
+	}
+	for i := 0; i < n; i++ {
+		e.points = append(e.points, envPoint{d.u16(p + i*4), d.u16(p+i*4+2) - offset})
+	}
+	e.valid()
+	return e
+}
+
+// xmWaves maps XM vibrato types to the player's waveforms.
+var xmWaves = [4]int{waveSine, waveSquare, waveRampDown, waveRampUp}
+
+func loadXM(d data) (*module, error) {
+	if string(d.slice(0, 17)) != "Extended Module: " {
+		return nil, errFormat
+	}
+	m := &module{
+		format:    fmtXM,
+		middle:    48,
+		title:     strings.TrimSpace(d.str(17, 20)),
+		restart:   d.u16(66),
+		channels:  d.u16(68),
+		linear:    d.u16(74)&1 != 0,
+		speed:     d.u16(76),
+		tempo:     d.u16(78),
+		globalVol: 128,
+		mix:       1,
+	}
+	if m.channels == 0 || m.channels > 64 {
+		return nil, errFormat
+	}
+	if m.speed == 0 {
+		m.speed = 6
+	}
+	if m.tempo < 32 {
+		m.tempo = 125
+	}
+	songLen := d.u16(64)
+	if songLen > 256 {
+		songLen = 256
+	}
+	numPatterns, numInstruments := d.u16(70), d.u16(72)
+	for i := 0; i < songLen; i++ {
+		m.orders = append(m.orders, d.u8(80+i))
+	}
+	if m.restart >= songLen {
+		m.restart = 0
+	}
+	for i := 0; i < m.channels; i++ {
+		m.pan = append(m.pan, 128)
+		m.chanVol = append(m.chanVol, 64)
+		m.muted = append(m.muted, false)
+	}
+
+	o := 60 + d.u32(60)
+	for i := 0; i < numPatterns; i++ {
+		rows, size := d.u16(o+5), d.u16(o+7)
+		if rows == 0 || rows > 256 {
+			rows = 64
+		}
+		p := &pattern{rows: rows, cells: make([]cell, rows*m.channels)}
+		m.patterns = append(m.patterns, p)
+		o += d.u32(o)
+		b := data(d.slice(o, size))
+		o += size
+		j := 0
+		for k := range p.cells {
+			if j >= len(b) {
+				break
+			}
+			c := &p.cells[k]
+			flags := 0x1f
+			if v := b.u8(j); v&0x80 != 0 {
+				flags = v
+				j++
+			}
+			var f [5]int
+			for bit := range f {
+				if flags&(1<<uint(bit)) != 0 {
+					f[bit] = b.u8(j)
+					j++
+				}
+			}
+			switch n := f[0]; {
+			case n == 97:
+				c.note = noteOff
+			case n > 0 && n < 97:
+				c.note = byte(n)
+			}
+			c.ins = byte(f[1])
+			c.volCmd, c.volArg = xmVolume(byte(f[2]))
+			c.cmd, c.arg = modEffect(byte(f[3]), byte(f[4]), fmtXM)
+		}
+	}
+
+	for i := 0; i < numInstruments; i++ {
+		h := data(d.slice(o, -1))
+		size := h.u32(0)
+		in := &instrument{
+			name:      h.str(4, 22),
+			globalVol: 128,
+			pan:       -1,
+		}
+		m.instruments = append(m.instruments, in)
+		n := h.u16(27)
+		if size < 29 {
+			size = 29
+		}
+		o += size
+		if n == 0 {
+			continue
+		}
+		in.volEnv = xmEnvelope(h, 129, h.u8(225), h.u8(227), h.u8(228), h.u8(229), h.u8(233), 0)
+		in.panEnv = xmEnvelope(h, 177, h.u8(226), h.u8(230), h.u8(231), h.u8(232), h.u8(234), 32)
+		in.fadeout = h.u16(239) * 2
+		vibType, vibSweep, vibDepth, vibRate := xmWaves[h.u8(235)&3], h.u8(236), h.u8(237), h.u8(238)
+
+		// Samples are numbered across all instruments.
+		base := len(m.samples)
+		for k := range in.keys {
+			in.keys[k].note = byte(k)
+			if k < 96 {
+				if s := h.u8(33 + k); s < n {
+					in.keys[k].sample = base + s + 1
+				}
+			}
+		}
+		hsize := h.u32(29)
+		type header struct {
+			s       *sample
+			length  int
+			sixteen bool
+		}
+		var hs []header
+		for j := 0; j < n; j++ {
+			sh := data(d.slice(o, 40))
+			o += hsize
+			s := &sample{
+				name:      sh.str(18, 22),
+				volume:    sh.u8(12),
+				globalVol: 64,
+				pan:       sh.u8(15),
+				loopStart: sh.u32(4),
+				loopEnd:   sh.u32(4) + sh.u32(8),
+				c5speed:   8363 * math.Pow(2, float64(int(int8(sh.u8(16)))*128+int(int8(sh.u8(13))))/(12*128)),
+				vibType:   vibType,
+				vibSweep:  vibSweep,
+				vibDepth:  vibDepth,
+				vibRate:   vibRate,
+			}
+			if s.volume > 64 {
+				s.volume = 64
+			}
+			typ := sh.u8(14)
+			switch typ & 3 {
+			case 1:
+				s.loop = loopForward
+			case 2, 3:
+				s.loop = loopPingPong
+			}
+			sixteen := typ&16 != 0
+			if sixteen {
+				s.loopStart /= 2
+				s.loopEnd /= 2
+			}
+			hs = append(hs, header{s, sh.u32(0), sixteen})
+		}
+		for _, h := range hs {
+			raw := d.slice(o, h.length)
+			o += h.length
+			if h.sixteen {
+				h.s.data = pcm16(raw, true, true)
+			} else {
+				h.s.data = pcm8(raw, true, true)
+			}
+			h.s.fixLoops()
+			m.samples = append(m.samples, h.s)
+		}
+	}
+	return m, nil
+}
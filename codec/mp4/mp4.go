@@ -0,0 +1,544 @@
+// Package mp4 reads audio tracks from MP4 (ISO base media) files.
+package mp4
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mjibson/mog/_third_party/github.com/mjibson/id3"
+	"github.com/mjibson/mog/codec"
+)
+
+// ErrFormat indicates the file is not a usable MP4 file.
+var ErrFormat = errors.New("mp4: bad format")
+
+// maxMoov bounds the size of the movie box read into memory.
+const maxMoov = 64 << 20
+
+// Track is an audio track.
+type Track struct {
+	// Format is the sample entry type, like "mp4a" or "alac".
+	Format     string
+	Channels   int
+	SampleSize int
+	SampleRate int
+	// Entry holds the boxes inside the sample entry, like "esds", keyed by
+	// type.
+	Entry map[string][]byte
+	// Timescale is the number of media time units per second.
+	Timescale uint32
+	// Duration is in media time units.
+	Duration uint64
+	// Skip is the number of media time units at the start that are not
+	// presented, like encoder delay. Length is the presented duration in
+	// media time units, or 0 if the edit list does not set one.
+	Skip, Length uint64
+
+	// sizes and offsets give the location of each sample.
+	sizes   []uint32
+	offsets []int64
+	// times holds the stts run length table.
+	times []timeRun
+}
+
+type timeRun struct {
+	count, delta uint32
+}
+
+// Samples returns the number of samples in t.
+func (t *Track) Samples() int {
+	return len(t.sizes)
+}
+
+// Sample returns the file offset and size of sample i.
+func (t *Track) Sample(i int) (offset int64, size uint32) {
+	return t.offsets[i], t.sizes[i]
+}
+
+// SampleAt returns the sample containing media time tm and the time at
+// which that sample starts.
+func (t *Track) SampleAt(tm uint64) (sample int, start uint64) {
+	for _, r := range t.times {
+		d := uint64(r.count) * uint64(r.delta)
+		if tm < start+d && r.delta > 0 {
+			n := (tm - start) / uint64(r.delta)
+			return sample + int(n), start + n*uint64(r.delta)
+		}
+		sample += int(r.count)
+		start += d
+	}
+	return sample, start
+}
+
+// SampleTime returns the media time at which sample i starts.
+func (t *Track) SampleTime(i int) uint64 {
+	var tm uint64
+	for _, r := range t.times {
+		if i < int(r.count) {
+			return tm + uint64(i)*uint64(r.delta)
+		}
+		i -= int(r.count)
+		tm += uint64(r.count) * uint64(r.delta)
+	}
+	return tm
+}
+
+// Data is an iTunes metadata value.
+type Data struct {
+	// Type is the well-known type, like 1 for UTF-8 or 13 for JPEG.
+	Type  uint32
+	Value []byte
+}
+
+// File is a parsed MP4 file.
+type File struct {
+	Tracks []*Track
+	// Tags holds iTunes metadata from the ilst box, keyed by item type,
+	// like "©nam".
+	Tags map[string]Data
+}
+
+// Read reads the movie box of the MP4 file in r, skipping media data.
+func Read(r io.Reader) (*File, error) {
+	var hdr [16]byte
+	first := true
+	for {
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = ErrFormat
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		hl := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hl = 16
+		}
+		if first && typ != "ftyp" {
+			return nil, ErrFormat
+		}
+		first = false
+		if size != 0 && size < hl {
+			return nil, ErrFormat
+		}
+		if typ == "moov" {
+			if size == 0 || size-hl > maxMoov {
+				return nil, ErrFormat
+			}
+			b := make([]byte, size-hl)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			return parseMoov(b)
+		}
+		if size == 0 {
+			return nil, ErrFormat
+		}
+		if err := skip(r, size-hl); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, 1)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return err
+}
+
+// box is a box within an in-memory buffer.
+type box struct {
+	typ  string
+	data []byte
+}
+
+// boxes splits b into its child boxes.
+func boxes(b []byte) ([]box, error) {
+	var bs []box
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		hl := uint64(8)
+		if size == 1 {
+			if len(b) < 16 {
+				return nil, ErrFormat
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			hl = 16
+		} else if size == 0 {
+			size = uint64(len(b))
+		}
+		if size < hl || size > uint64(len(b)) {
+			return nil, ErrFormat
+		}
+		bs = append(bs, box{typ, b[hl:size]})
+		b = b[size:]
+	}
+	return bs, nil
+}
+
+// child returns the data of the first child of b with type typ.
+func child(b []byte, typ string) []byte {
+	bs, _ := boxes(b)
+	for _, c := range bs {
+		if c.typ == typ {
+			return c.data
+		}
+	}
+	return nil
+}
+
+// path follows a path of child box types from b.
+func path(b []byte, types ...string) []byte {
+	for _, t := range types {
+		if b = child(b, t); b == nil {
+			return nil
+		}
+	}
+	return b
+}
+
+func parseMoov(b []byte) (*File, error) {
+	f := &File{
+		Tags: make(map[string]Data),
+	}
+	bs, err := boxes(b)
+	if err != nil {
+		return nil, err
+	}
+	var timescale uint32
+	if mvhd := child(b, "mvhd"); len(mvhd) >= 16 {
+		timescale = binary.BigEndian.Uint32(mvhd[12:])
+		if mvhd[0] == 1 && len(mvhd) >= 24 {
+			timescale = binary.BigEndian.Uint32(mvhd[20:])
+		}
+	}
+	for _, c := range bs {
+		switch c.typ {
+		case "trak":
+			t, err := parseTrak(c.data, timescale)
+			if err != nil {
+				return nil, err
+			}
+			if t != nil {
+				f.Tracks = append(f.Tracks, t)
+			}
+		case "udta":
+			if meta := child(c.data, "meta"); meta != nil {
+				parseMeta(f, meta)
+			}
+		}
+	}
+	return f, nil
+}
+
+// parseTrak parses a track box, returning nil for non-audio tracks.
+// Timescale is the movie timescale, used by the edit list.
+func parseTrak(b []byte, timescale uint32) (*Track, error) {
+	mdia := child(b, "mdia")
+	if hdlr := child(mdia, "hdlr"); len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return nil, nil
+	}
+	t := new(Track)
+	mdhd := child(mdia, "mdhd")
+	switch {
+	case len(mdhd) >= 32 && mdhd[0] == 1:
+		t.Timescale = binary.BigEndian.Uint32(mdhd[20:])
+		t.Duration = binary.BigEndian.Uint64(mdhd[24:])
+	case len(mdhd) >= 20:
+		t.Timescale = binary.BigEndian.Uint32(mdhd[12:])
+		t.Duration = uint64(binary.BigEndian.Uint32(mdhd[16:]))
+	default:
+		return nil, ErrFormat
+	}
+	stbl := path(mdia, "minf", "stbl")
+	if stbl == nil || t.Timescale == 0 {
+		return nil, ErrFormat
+	}
+	if err := t.parseStsd(child(stbl, "stsd")); err != nil {
+		return nil, err
+	}
+	if err := t.parseSampleTable(stbl); err != nil {
+		return nil, err
+	}
+	t.parseEdits(path(b, "edts", "elst"), timescale)
+	return t, nil
+}
+
+// parseEdits sets Skip and Length from the first non-empty edit.
+func (t *Track) parseEdits(elst []byte, timescale uint32) {
+	if len(elst) < 8 {
+		return
+	}
+	n := int(binary.BigEndian.Uint32(elst[4:]))
+	size := 12
+	if elst[0] == 1 {
+		size = 20
+	}
+	e := elst[8:]
+	for i := 0; i < n && len(e) >= size; i, e = i+1, e[size:] {
+		var dur uint64
+		var start int64
+		if size == 20 {
+			dur = binary.BigEndian.Uint64(e)
+			start = int64(binary.BigEndian.Uint64(e[8:]))
+		} else {
+			dur = uint64(binary.BigEndian.Uint32(e))
+			start = int64(int32(binary.BigEndian.Uint32(e[4:])))
+		}
+		if start < 0 {
+			// An empty edit.
+			continue
+		}
+		t.Skip = uint64(start)
+		if timescale > 0 {
+			t.Length = dur * uint64(t.Timescale) / uint64(timescale)
+		}
+		return
+	}
+}
+
+func (t *Track) parseStsd(b []byte) error {
+	if len(b) < 8+36 || binary.BigEndian.Uint32(b[4:]) < 1 {
+		return ErrFormat
+	}
+	es, err := boxes(b[8:])
+	if err != nil || len(es) == 0 {
+		return ErrFormat
+	}
+	e := es[0].data
+	t.Format = es[0].typ
+	if len(e) < 28 {
+		return ErrFormat
+	}
+	// Skip the reserved bytes and data reference index of the sample entry.
+	version := binary.BigEndian.Uint16(e[8:])
+	t.Channels = int(binary.BigEndian.Uint16(e[16:]))
+	t.SampleSize = int(binary.BigEndian.Uint16(e[18:]))
+	t.SampleRate = int(binary.BigEndian.Uint32(e[24:]) >> 16)
+	e = e[28:]
+	// QuickTime sound sample description versions add more fields.
+	switch version {
+	case 1:
+		if len(e) < 16 {
+			return ErrFormat
+		}
+		e = e[16:]
+	case 2:
+		if len(e) < 36 {
+			return ErrFormat
+		}
+		e = e[36:]
+	}
+	t.Entry = make(map[string][]byte)
+	cs, _ := boxes(e)
+	for _, c := range cs {
+		t.Entry[c.typ] = c.data
+		if c.typ == "wave" {
+			// QuickTime wraps the decoder configuration.
+			ws, _ := boxes(c.data)
+			for _, w := range ws {
+				t.Entry[w.typ] = w.data
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Track) parseSampleTable(stbl []byte) error {
+	stts := child(stbl, "stts")
+	if len(stts) < 8 {
+		return ErrFormat
+	}
+	n := int(binary.BigEndian.Uint32(stts[4:]))
+	if len(stts) < 8+n*8 {
+		return ErrFormat
+	}
+	t.times = make([]timeRun, n)
+	for i := range t.times {
+		t.times[i].count = binary.BigEndian.Uint32(stts[8+i*8:])
+		t.times[i].delta = binary.BigEndian.Uint32(stts[12+i*8:])
+	}
+
+	stsz := child(stbl, "stsz")
+	if len(stsz) < 12 {
+		return ErrFormat
+	}
+	fixed := binary.BigEndian.Uint32(stsz[4:])
+	count := int(binary.BigEndian.Uint32(stsz[8:]))
+	if fixed == 0 && len(stsz) < 12+count*4 {
+		return ErrFormat
+	}
+	t.sizes = make([]uint32, count)
+	for i := range t.sizes {
+		if fixed != 0 {
+			t.sizes[i] = fixed
+		} else {
+			t.sizes[i] = binary.BigEndian.Uint32(stsz[12+i*4:])
+		}
+	}
+
+	var chunks []int64
+	if stco := child(stbl, "stco"); stco != nil {
+		if len(stco) < 8 {
+			return ErrFormat
+		}
+		n := int(binary.BigEndian.Uint32(stco[4:]))
+		if len(stco) < 8+n*4 {
+			return ErrFormat
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco[8+i*4:])))
+		}
+	} else if co64 := child(stbl, "co64"); co64 != nil {
+		if len(co64) < 8 {
+			return ErrFormat
+		}
+		n := int(binary.BigEndian.Uint32(co64[4:]))
+		if len(co64) < 8+n*8 {
+			return ErrFormat
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64[8+i*8:])))
+		}
+	} else {
+		return ErrFormat
+	}
+
+	stsc := child(stbl, "stsc")
+	if len(stsc) < 8 {
+		return ErrFormat
+	}
+	n = int(binary.BigEndian.Uint32(stsc[4:]))
+	if len(stsc) < 8+n*12 {
+		return ErrFormat
+	}
+	t.offsets = make([]int64, 0, count)
+	for i := 0; i < n && len(t.offsets) < count; i++ {
+		e := stsc[8+i*12:]
+		first := int(binary.BigEndian.Uint32(e)) - 1
+		per := int(binary.BigEndian.Uint32(e[4:]))
+		last := len(chunks)
+		if i+1 < n {
+			last = int(binary.BigEndian.Uint32(stsc[8+(i+1)*12:])) - 1
+		}
+		if first < 0 || last > len(chunks) {
+			return ErrFormat
+		}
+		for c := first; c < last; c++ {
+			off := chunks[c]
+			for j := 0; j < per && len(t.offsets) < count; j++ {
+				t.offsets = append(t.offsets, off)
+				off += int64(t.sizes[len(t.offsets)-1])
+			}
+		}
+	}
+	if len(t.offsets) != count {
+		return ErrFormat
+	}
+	return nil
+}
+
+// parseMeta reads the iTunes metadata list from a meta box.
+func parseMeta(f *File, meta []byte) {
+	// The meta box is a full box in MP4 files but a plain box in
+	// QuickTime files.
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	items, _ := boxes(child(meta, "ilst"))
+	for _, it := range items {
+		data := child(it.data, "data")
+		if len(data) < 8 {
+			continue
+		}
+		typ := it.typ
+		if typ == "----" {
+			// Freeform items are named by their name box.
+			if name := child(it.data, "name"); len(name) >= 4 {
+				typ = "----:" + string(name[4:])
+			}
+		}
+		if _, ok := f.Tags[typ]; ok {
+			continue
+		}
+		f.Tags[typ] = Data{
+			Type:  binary.BigEndian.Uint32(data) & 0xffffff,
+			Value: data[8:],
+		}
+	}
+}
+
+// Fill sets the fields of si from the metadata.
+func (f *File) Fill(si *codec.SongInfo) {
+	if v, ok := f.Tags["\xa9nam"]; ok {
+		si.Title = string(v.Value)
+	}
+	if v, ok := f.Tags["\xa9ART"]; ok {
+		si.Artist = string(v.Value)
+	} else if v, ok := f.Tags["aART"]; ok {
+		si.Artist = string(v.Value)
+	}
+	if v, ok := f.Tags["\xa9alb"]; ok {
+		si.Album = string(v.Value)
+	}
+	if v, ok := f.Tags["aART"]; ok {
+		si.AlbumArtist = string(v.Value)
+	}
+	if v, ok := f.Tags["\xa9wrt"]; ok {
+		si.Composer = string(v.Value)
+	}
+	if v, ok := f.Tags["\xa9gen"]; ok {
+		si.Genre = string(v.Value)
+	} else if v, ok := f.Tags["gnre"]; ok && len(v.Value) >= 2 {
+		// Genres are also stored as ID3v1 genre numbers plus one.
+		si.Genre = id3.Genre(int(binary.BigEndian.Uint16(v.Value)) - 1)
+	}
+	if v, ok := f.Tags["\xa9day"]; ok {
+		si.Date = string(v.Value)
+	}
+	if v, ok := f.Tags["\xa9cmt"]; ok {
+		si.Comment = string(v.Value)
+	}
+	if v, ok := f.Tags["trkn"]; ok && len(v.Value) >= 4 {
+		si.Track = float64(binary.BigEndian.Uint16(v.Value[2:]))
+	}
+	if v, ok := f.Tags["disk"]; ok && len(v.Value) >= 6 {
+		si.Disc = int(binary.BigEndian.Uint16(v.Value[2:]))
+		si.DiscTotal = int(binary.BigEndian.Uint16(v.Value[4:]))
+	}
+	if v, ok := f.Tags["cpil"]; ok && len(v.Value) >= 1 {
+		si.Compilation = v.Value[0] != 0
+	}
+	for name, v := range f.Tags {
+		// iTunes stores ReplayGain as freeform items.
+		if strings.HasPrefix(name, "----:") {
+			si.SetReplayGain(name[len("----:"):], string(v.Value))
+		}
+	}
+	if v, ok := f.Tags["covr"]; ok {
+		mime := "image/jpeg"
+		switch {
+		case v.Type == 14:
+			mime = "image/png"
+		case v.Type == 27:
+			mime = "image/bmp"
+		case strings.HasPrefix(string(v.Value), "\x89PNG"):
+			mime = "image/png"
+		}
+		si.ImageURL = fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(v.Value))
+	}
+}
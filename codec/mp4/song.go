@@ -0,0 +1,297 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("MP4", "????ftyp", []string{"m4a", "m4b", "mp4"}, New)
+}
+
+// Decoder decodes the samples of a track.
+type Decoder interface {
+	// Decode decodes one sample into interleaved audio.
+	Decode(sample []byte) ([]float32, error)
+	// Reset clears the state carried between samples.
+	Reset()
+}
+
+// NewDecoder returns a decoder for t and the format of its output.
+type NewDecoder func(t *Track) (d Decoder, sampleRate, channels int, err error)
+
+type decoder struct {
+	preroll int
+	new     NewDecoder
+}
+
+var decoders = make(map[string]decoder)
+
+// RegisterDecoder registers a decoder for tracks with the sample entry type
+// format, like "mp4a". Preroll is the number of samples that must be
+// decoded before a seek target for the output to be correct.
+func RegisterDecoder(format string, preroll int, f NewDecoder) {
+	decoders[format] = decoder{preroll, f}
+}
+
+func New(rf codec.Reader) ([]codec.Song, error) {
+	m := MP4{
+		Reader: rf,
+	}
+	return []codec.Song{&m}, nil
+}
+
+type MP4 struct {
+	Reader codec.Reader
+	r      io.ReadCloser
+	// off is the byte offset of r.
+	off        int64
+	t          *Track
+	dec        decoder
+	d          Decoder
+	sampleRate int
+	channels   int
+	// sample is the next sample to decode.
+	sample int
+	// pos is the output sample number of the end of buf.
+	pos int64
+	// start and end are the presented range in output samples.
+	start, end int64
+	// skip is the output sample number before which output is dropped.
+	skip int64
+	// buf holds decoded, interleaved samples not yet played.
+	buf []float32
+	// size is the file size.
+	size int64
+}
+
+// open reads the MP4 file and returns its first decodable track.
+func (m *MP4) open() (*File, *Track, error) {
+	r, size, err := m.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	m.size = size
+	f, err := Read(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range f.Tracks {
+		if _, ok := decoders[t.Format]; ok && t.Samples() > 0 {
+			return f, t, nil
+		}
+	}
+	if len(f.Tracks) > 0 {
+		return nil, nil, fmt.Errorf("mp4: unsupported format %q", f.Tracks[0].Format)
+	}
+	return nil, nil, fmt.Errorf("mp4: no audio track")
+}
+
+// bounds returns the presented range of t in media time units.
+func bounds(f *File, t *Track) (start, end uint64) {
+	var total uint64
+	for _, r := range t.times {
+		total += uint64(r.count) * uint64(r.delta)
+	}
+	start, end = t.Skip, total
+	if t.Length > 0 {
+		end = start + t.Length
+	} else if v, ok := f.Tags["----:iTunSMPB"]; ok && t.Skip == 0 && t.SampleRate > 0 {
+		// iTunes stores the encoder delay and sample count as hex fields.
+		fs := strings.Fields(string(v.Value))
+		if len(fs) >= 4 {
+			delay, err1 := strconv.ParseUint(fs[1], 16, 64)
+			n, err2 := strconv.ParseUint(fs[3], 16, 64)
+			if err1 == nil && err2 == nil && n > 0 {
+				scale := uint64(t.Timescale) / uint64(t.SampleRate)
+				if scale == 0 {
+					scale = 1
+				}
+				start, end = delay*scale, (delay+n)*scale
+			}
+		}
+	}
+	if end > total {
+		end = total
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+func (m *MP4) Init() (sampleRate, channels int, err error) {
+	if m.d == nil {
+		f, t, err := m.open()
+		if err != nil {
+			return 0, 0, err
+		}
+		dec := decoders[t.Format]
+		d, sr, ch, err := dec.new(t)
+		if err != nil {
+			return 0, 0, err
+		}
+		m.t = t
+		m.dec = dec
+		m.d = d
+		m.sampleRate = sr
+		m.channels = ch
+		start, end := bounds(f, t)
+		m.start = m.toOutput(start)
+		m.end = m.toOutput(end)
+		if err := m.Seek(0); err != nil {
+			m.Close()
+			return 0, 0, err
+		}
+	}
+	return m.sampleRate, m.channels, nil
+}
+
+// toOutput converts media time units to output samples.
+func (m *MP4) toOutput(tm uint64) int64 {
+	return int64(tm * uint64(m.sampleRate) / uint64(m.t.Timescale))
+}
+
+func (m *MP4) Info() (info codec.SongInfo, err error) {
+	f, t, err := m.open()
+	if err != nil {
+		return
+	}
+	f.Fill(&info)
+	start, end := bounds(f, t)
+	info.Time = time.Duration(end-start) * time.Second / time.Duration(t.Timescale)
+	info.Codec = strings.ToUpper(t.Format)
+	switch t.Format {
+	case "mp4a":
+		info.Codec = "AAC"
+	case "alac":
+		info.BitDepth = t.SampleSize
+	}
+	info.SampleRate = t.SampleRate
+	info.Channels = t.Channels
+	info.Size = m.size
+	var size int64
+	for _, n := range t.sizes {
+		size += int64(n)
+	}
+	info.Bitrate = codec.Bitrate(size, info.Time)
+	return info, nil
+}
+
+// read returns the data of the next sample.
+func (m *MP4) read() ([]byte, error) {
+	off, size := m.t.Sample(m.sample)
+	if m.r == nil || off < m.off {
+		if m.r != nil {
+			m.r.Close()
+			m.r = nil
+		}
+		r, err := codec.OpenAt(m.Reader, off)
+		if err != nil {
+			return nil, err
+		}
+		m.r = r
+		m.off = off
+	} else if off > m.off {
+		if err := skip(m.r, off-m.off); err != nil {
+			return nil, err
+		}
+		m.off = off
+	}
+	b := make([]byte, size)
+	n, err := io.ReadFull(m.r, b)
+	m.off += int64(n)
+	return b, err
+}
+
+// fill decodes the next sample into buf.
+func (m *MP4) fill() error {
+	b, err := m.read()
+	if err != nil {
+		return err
+	}
+	m.sample++
+	ch := int64(m.channels)
+	out, err := m.d.Decode(b)
+	if err != nil {
+		// Replace undecodable samples with silence to keep the timing.
+		n := m.toOutput(m.t.SampleTime(m.sample)) - m.toOutput(m.t.SampleTime(m.sample-1))
+		out = make([]float32, n*ch)
+	}
+	begin := m.pos
+	m.pos += int64(len(out)) / ch
+	if begin >= m.end {
+		out = nil
+	} else if m.pos > m.end {
+		out = out[:(m.end-begin)*ch]
+	}
+	if begin < m.skip {
+		if drop := (m.skip - begin) * ch; drop < int64(len(out)) {
+			out = out[drop:]
+		} else {
+			out = nil
+		}
+	}
+	m.buf = append(m.buf, out...)
+	return nil
+}
+
+func (m *MP4) eof() bool {
+	return m.sample >= m.t.Samples() || m.pos >= m.end
+}
+
+func (m *MP4) Play(n int) ([]float32, error) {
+	for len(m.buf) < n && !m.eof() {
+		if err := m.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if n > len(m.buf) {
+		n = len(m.buf)
+	}
+	ret := make([]float32, n)
+	copy(ret, m.buf)
+	m.buf = m.buf[n:]
+	return ret, nil
+}
+
+// Seek finds the sample containing offset from the sample tables and
+// decodes forward from the preroll before it.
+func (m *MP4) Seek(offset time.Duration) error {
+	if m.d == nil {
+		return fmt.Errorf("mp4: seek before init")
+	}
+	target := m.start + int64(offset)*int64(m.sampleRate)/int64(time.Second)
+	if target > m.end {
+		target = m.end
+	}
+	tm := uint64(target) * uint64(m.t.Timescale) / uint64(m.sampleRate)
+	k, _ := m.t.SampleAt(tm)
+	if k -= m.dec.preroll; k < 0 {
+		k = 0
+	}
+	m.d.Reset()
+	m.sample = k
+	m.pos = m.toOutput(m.t.SampleTime(k))
+	m.buf = m.buf[:0]
+	m.skip = target
+	for m.pos < target && !m.eof() {
+		if err := m.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MP4) Close() {
+	if m.r != nil {
+		m.r.Close()
+	}
+	m.r, m.d, m.buf = nil, nil, nil
+}
@@ -0,0 +1,286 @@
+package mpa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	// bitrates is indexed by MPEG-2, layer and bitrate index.
+	bitrates = [2][4][16]int{
+		{
+			1: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			3: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+		{
+			1: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			3: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+	}
+	// sampleRates is indexed by the version field and sampling frequency.
+	sampleRates = [4][4]int{
+		0: {11025, 12000, 8000, 0},  // MPEG-2.5
+		2: {22050, 24000, 16000, 0}, // MPEG-2
+		3: {44100, 48000, 32000, 0}, // MPEG-1
+	}
+)
+
+// decoderDelay is the delay in samples of a layer III decoder, which LAME
+// counts as part of its encoder delay.
+const decoderDelay = 529
+
+// frameHeader is a parsed MPEG audio frame header.
+type frameHeader struct {
+	// lsf is set for MPEG-2 and MPEG-2.5 frames.
+	lsf        bool
+	layer      int
+	bitrate    int
+	sampleRate int
+	padding    int
+	channels   int
+}
+
+// parseHeader parses the four byte frame header h. It returns false if h is
+// not a valid header.
+func parseHeader(h uint32) (fh frameHeader, ok bool) {
+	var (
+		sync    = (h >> 21) & 0x7ff
+		version = (h >> 19) & 3
+		layer   = 4 - int((h>>17)&3)
+		br      = (h >> 12) & 15
+		sf      = (h >> 10) & 3
+	)
+	if sync != 0x7ff || version == 1 || layer == 4 || br == 0 || br == 15 || sf == 3 {
+		return fh, false
+	}
+	fh = frameHeader{
+		lsf:        version != 3,
+		layer:      layer,
+		sampleRate: sampleRates[version][sf],
+		padding:    int((h >> 9) & 1),
+		channels:   2,
+	}
+	lsf := 0
+	if fh.lsf {
+		lsf = 1
+	}
+	fh.bitrate = bitrates[lsf][layer][br] * 1000
+	if (h>>6)&3 == 3 {
+		fh.channels = 1
+	}
+	return fh, true
+}
+
+// size returns the frame length in bytes, including the header.
+func (fh frameHeader) size() int {
+	switch {
+	case fh.layer == 1:
+		return (12*fh.bitrate/fh.sampleRate + fh.padding) * 4
+	case fh.layer == 3 && fh.lsf:
+		return 72*fh.bitrate/fh.sampleRate + fh.padding
+	}
+	return 144*fh.bitrate/fh.sampleRate + fh.padding
+}
+
+// samples returns the number of samples per channel in the frame.
+func (fh frameHeader) samples() int {
+	switch {
+	case fh.layer == 1:
+		return 384
+	case fh.layer == 3 && fh.lsf:
+		return 576
+	}
+	return 1152
+}
+
+// skipID3 skips an ID3v2 tag at the start of r, if present, and returns the
+// number of bytes skipped.
+func skipID3(r *bufio.Reader) (int64, error) {
+	b, err := r.Peek(10)
+	if err != nil || string(b[:3]) != "ID3" {
+		return 0, nil
+	}
+	n := int64(b[6]&0x7f)<<21 | int64(b[7]&0x7f)<<14 | int64(b[8]&0x7f)<<7 | int64(b[9]&0x7f)
+	n += 10
+	if b[5]&0x10 != 0 {
+		// Footer present.
+		n += 10
+	}
+	if _, err := r.Discard(int(n)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// vbrHeader is a Xing, Info or VBRI header, which takes the place of the
+// first frame of a stream.
+type vbrHeader struct {
+	// offset is the position of the header frame in the stream.
+	offset int64
+	// frames is the number of audio frames and bytes their size, or 0 if
+	// unknown.
+	frames, bytes int64
+	// points maps audio frames to byte offsets from the header frame.
+	points []seekPoint
+	// delay and padding are the encoder delay and padding in samples of a
+	// LAME tag. gapless is set if they are known.
+	delay, padding int
+	gapless        bool
+}
+
+type seekPoint struct {
+	frame, offset int64
+}
+
+// parseVBR parses the Xing, Info or VBRI header of frame, with header fh. It
+// returns nil if frame has none.
+func parseVBR(fh frameHeader, frame []byte) *vbrHeader {
+	be := binary.BigEndian
+	side := 32
+	switch {
+	case fh.lsf && fh.channels == 1:
+		side = 9
+	case fh.lsf || fh.channels == 1:
+		side = 17
+	}
+	// The CRC is present when the protection bit is clear.
+	if frame[1]&1 == 0 {
+		side += 2
+	}
+	if len(frame) < 4+side {
+		return nil
+	}
+	if b := frame[4+side:]; len(b) >= 8 && (string(b[:4]) == "Xing" || string(b[:4]) == "Info") {
+		v := new(vbrHeader)
+		flags := be.Uint32(b[4:])
+		b = b[8:]
+		if flags&1 != 0 && len(b) >= 4 {
+			v.frames = int64(be.Uint32(b))
+			b = b[4:]
+		}
+		if flags&2 != 0 && len(b) >= 4 {
+			v.bytes = int64(be.Uint32(b))
+			b = b[4:]
+		}
+		if flags&4 != 0 && len(b) >= 100 {
+			// The table of contents maps each percent of the playing time to
+			// a 256th of the size.
+			if v.frames > 0 && v.bytes > 0 {
+				for i, t := range b[:100] {
+					v.points = append(v.points, seekPoint{
+						frame:  v.frames * int64(i) / 100,
+						offset: v.bytes * int64(t) / 256,
+					})
+				}
+			}
+			b = b[100:]
+		}
+		if flags&8 != 0 && len(b) >= 4 {
+			b = b[4:]
+		}
+		// LAME and FFmpeg follow with a tag holding the encoder delay and
+		// padding.
+		if len(b) >= 24 && fh.layer == 3 {
+			switch string(b[:4]) {
+			case "LAME", "Lavc", "Lavf":
+				v.delay = int(b[21])<<4 | int(b[22])>>4
+				v.padding = int(b[22]&0xf)<<8 | int(b[23])
+				v.gapless = true
+			}
+		}
+		return v
+	}
+	if b := frame[4:]; len(b) >= 32+26 && string(b[32:36]) == "VBRI" {
+		b = b[32:]
+		v := &vbrHeader{
+			bytes:  int64(be.Uint32(b[10:])),
+			frames: int64(be.Uint32(b[14:])),
+		}
+		var (
+			entries    = int(be.Uint16(b[18:]))
+			scale      = int64(be.Uint16(b[20:]))
+			entrySize  = int(be.Uint16(b[22:]))
+			perEntry   = int64(be.Uint16(b[24:]))
+			frame, off int64
+		)
+		b = b[26:]
+		for i := 0; i < entries && entrySize > 0 && entrySize <= 4 && len(b) >= entrySize; i++ {
+			v.points = append(v.points, seekPoint{frame: frame, offset: off})
+			var n int64
+			for _, c := range b[:entrySize] {
+				n = n<<8 | int64(c)
+			}
+			b = b[entrySize:]
+			frame += perEntry
+			off += n * scale
+		}
+		return v
+	}
+	return nil
+}
+
+// frameIndex holds the byte offset of each frame in a stream.
+type frameIndex struct {
+	offsets []int64
+	// samples is the number of samples per channel in each frame.
+	samples int
+	// vbr is the header of the stream, if it has one.
+	vbr *vbrHeader
+}
+
+// scanFrames reads r and records the byte offset of each frame without
+// decoding any audio. If header is set, it stops at the first frame, and
+// only samples and vbr are set.
+func scanFrames(r io.Reader, header bool) (*frameIndex, error) {
+	br := bufio.NewReader(r)
+	off, err := skipID3(br)
+	if err != nil {
+		return nil, err
+	}
+	var idx frameIndex
+	for {
+		b, err := br.Peek(4)
+		if err != nil {
+			break
+		}
+		fh, ok := parseHeader(binary.BigEndian.Uint32(b))
+		if !ok {
+			// Resynchronize one byte at a time.
+			br.Discard(1)
+			off++
+			continue
+		}
+		if idx.samples == 0 {
+			idx.samples = fh.samples()
+			// A leading Xing, Info or VBRI frame holds no audio.
+			b, err := br.Peek(fh.size())
+			if err == nil {
+				if idx.vbr = parseVBR(fh, b); idx.vbr != nil {
+					idx.vbr.offset = off
+				}
+			}
+			if header {
+				return &idx, nil
+			}
+			if idx.vbr != nil {
+				n, _ := br.Discard(len(b))
+				off += int64(n)
+				continue
+			}
+		}
+		idx.offsets = append(idx.offsets, off)
+		n, err := br.Discard(fh.size())
+		off += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	if len(idx.offsets) == 0 {
+		return nil, fmt.Errorf("mpa: no frames found")
+	}
+	return &idx, nil
+}
@@ -15,12 +15,16 @@ import (
 
 func init() {
 	exts := []string{"mp3"}
-	codec.RegisterCodec("MP3", "\xff\xfa", exts, NewSongs)
-	codec.RegisterCodec("MP3", "\xff\xfb", exts, NewSongs)
-	codec.RegisterCodec("MP3", "\xff\xfc", exts, NewSongs)
-	codec.RegisterCodec("MP3", "\xff\xfd", exts, NewSongs)
-	codec.RegisterCodec("MP3", "\xff\xfe", exts, NewSongs)
-	codec.RegisterCodec("MP3", "\xff\xff", exts, NewSongs)
+	// MPEG-1, MPEG-2 and MPEG-2.5 frames of each layer, with and without
+	// CRC, or an ID3v2 tag.
+	for _, magic := range []string{
+		"ID3",
+		"\xff\xfa", "\xff\xfb", "\xff\xfc", "\xff\xfd", "\xff\xfe", "\xff\xff",
+		"\xff\xf2", "\xff\xf3", "\xff\xf4", "\xff\xf5", "\xff\xf6", "\xff\xf7",
+		"\xff\xe2", "\xff\xe3", "\xff\xe4", "\xff\xe5", "\xff\xe6", "\xff\xe7",
+	} {
+		codec.RegisterCodec("MP3", magic, exts, NewSongs)
+	}
 }
 
 func NewSongs(rf codec.Reader) ([]codec.Song, error) {
@@ -32,11 +36,21 @@ func NewSongs(rf codec.Reader) ([]codec.Song, error) {
 }
 
 type Song struct {
-	Reader  codec.Reader
-	r       io.ReadCloser
-	decoder *mpa.Decoder
-	initbuf []byte
-	buff    [2][]float32
+	Reader   codec.Reader
+	r        io.ReadCloser
+	decoder  *mpa.Decoder
+	initbuf  []byte
+	size     int64
+	channels int
+	// buf holds the interleaved samples of the last decoded frame not yet
+	// played, and frame the samples of a frame per channel.
+	buf   []float32
+	frame [2][]float32
+	// pos is the position of buf in samples per channel, counted from the
+	// first audio frame.
+	pos   int64
+	vbr   *vbrHeader
+	index *frameIndex
 }
 
 func NewSong(rf codec.Reader) (*Song, error) {
@@ -47,12 +61,14 @@ func NewSong(rf codec.Reader) (*Song, error) {
 
 func (s *Song) Init() (sampleRate, channels int, err error) {
 	if s.decoder == nil {
-		r, _, err := s.Reader()
+		r, size, err := s.Reader()
 		if err != nil {
 			return 0, 0, err
 		}
-		buf := new(bytes.Buffer)
+		s.size = size
+		buf := new(codec.HeaderBuffer)
 		defer func() {
+			buf.Stop()
 			s.initbuf = buf.Bytes()
 		}()
 		s.decoder = &mpa.Decoder{Input: io.TeeReader(r, buf)}
@@ -64,73 +80,280 @@ func (s *Song) Init() (sampleRate, channels int, err error) {
 					continue
 				}
 				r.Close()
+				s.decoder = nil
 				return 0, 0, err
 			}
 			break
 		}
+		s.channels = s.decoder.NChannels()
+		s.pos = 0
+		s.buf = nil
+		// The decoder has read past the first frame, which holds no audio
+		// if it is a VBR header.
+		if s.vbr == nil {
+			if idx, err := scanFrames(bytes.NewReader(buf.Bytes()), true); err == nil {
+				s.vbr = idx.vbr
+			}
+		}
+		if s.vbr == nil {
+			s.readFrame()
+		}
 	}
-	return s.decoder.SamplingFrequency(), s.decoder.NChannels(), nil
+	return s.decoder.SamplingFrequency(), s.channels, nil
 }
 
-func (s *Song) Info() (info codec.SongInfo, err error) {
-	var r io.ReadCloser
-	if len(s.initbuf) != 0 {
-		r = ioutil.NopCloser(bytes.NewBuffer(s.initbuf))
+// bounds returns the first and end positions of the audio in samples per
+// channel, without the encoder delay and padding. end is -1 if unknown.
+func (s *Song) bounds() (start, end int64) {
+	v := s.vbr
+	if v == nil || !v.gapless || v.frames == 0 || s.decoder == nil {
+		return 0, -1
 	}
-	if r == nil {
-		r, _, err = s.Reader()
-		if err != nil {
-			return
+	total := v.frames * int64(s.decoder.NSamples())
+	start = int64(v.delay + decoderDelay)
+	end = total - int64(v.padding) + decoderDelay
+	if end > total {
+		end = total
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// duration returns the playing time of the song from its VBR header, or
+// by counting frames.
+func (s *Song) duration() (time.Duration, error) {
+	if s.decoder == nil {
+		if _, _, err := s.Init(); err != nil {
+			return 0, err
 		}
 	}
-	f := id3.Read(r)
+	rate := int64(s.decoder.SamplingFrequency())
+	if start, end := s.bounds(); end >= 0 {
+		return time.Duration(end-start) * time.Second / time.Duration(rate), nil
+	}
+	if s.vbr != nil && s.vbr.frames > 0 {
+		return time.Duration(s.vbr.frames*int64(s.decoder.NSamples())) * time.Second / time.Duration(rate), nil
+	}
+	if err := s.scan(); err != nil {
+		return 0, err
+	}
+	samples := int64(len(s.index.offsets) * s.index.samples)
+	return time.Duration(samples) * time.Second / time.Duration(rate), nil
+}
+
+// scan builds the frame index.
+func (s *Song) scan() error {
+	if s.index != nil {
+		return nil
+	}
+	r, _, err := s.Reader()
+	if err != nil {
+		return err
+	}
+	s.index, err = scanFrames(r, false)
 	r.Close()
-	if f == nil {
-		err = fmt.Errorf("could not read id3 data")
+	return err
+}
+
+func (s *Song) Info() (info codec.SongInfo, err error) {
+	dur, err := s.duration()
+	if err != nil {
 		return
 	}
-	track, _ := strconv.ParseFloat(f.Track, 64)
-	dur, _ := strconv.Atoi(f.Length)
-	si := codec.SongInfo{
-		Artist: f.Artist,
-		Title:  f.Name,
-		Album:  f.Album,
-		Track:  track,
-		Time:   time.Duration(dur) * time.Millisecond,
+	r := ioutil.NopCloser(bytes.NewBuffer(s.initbuf))
+	info = codec.SongInfo{
+		Time:       dur,
+		Codec:      "MP3",
+		Bitrate:    s.decoder.Bitrate(),
+		SampleRate: s.decoder.SamplingFrequency(),
+		Channels:   s.channels,
+		Size:       s.size,
+	}
+	if f := id3.Read(r); f != nil {
+		codec.FillID3(&info, f)
+		if dur == 0 {
+			ms, _ := strconv.Atoi(f.Length)
+			info.Time = time.Duration(ms) * time.Millisecond
+		}
 	}
-	if f.Image != nil {
-		si.ImageURL = f.Image.DataURL()
+	if s.vbr != nil {
+		// The bitrate of the header frame is meaningless.
+		info.Bitrate = codec.Bitrate(s.vbr.bytes, dur)
 	}
-	return si, nil
+	return info, nil
 }
 
-func (s *Song) Play(n int) (r []float32, err error) {
+func (s *Song) Play(n int) ([]float32, error) {
+	start, end := s.bounds()
+	ch := int64(s.channels)
+	r := make([]float32, 0, n)
 	for len(r) < n {
-		if len(s.buff[0]) == 0 {
-			err = s.decoder.DecodeFrame()
-			if err != nil {
+		if len(s.buf) == 0 {
+			if err := s.decoder.DecodeFrame(); err != nil {
 				switch err.(type) {
 				case mpa.MalformedStream:
 					continue
 				}
-				return
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					err = nil
+				}
+				return r, err
+			}
+			s.readFrame()
+		}
+		if skip := start - s.pos; skip > 0 {
+			// Drop the encoder delay.
+			if skip > int64(len(s.buf))/ch {
+				skip = int64(len(s.buf)) / ch
 			}
-			for i := 0; i < 2; i++ {
-				s.buff[i] = make([]float32, s.decoder.NSamples())
-				s.decoder.ReadSamples(i, s.buff[i])
+			s.buf = s.buf[skip*ch:]
+			s.pos += skip
+			continue
+		}
+		m := int64(len(s.buf))
+		if end >= 0 && m > (end-s.pos)*ch {
+			if m = (end - s.pos) * ch; m <= 0 {
+				// The rest is encoder padding.
+				return r, nil
 			}
 		}
-		for len(s.buff[0]) > 0 && len(r) < n {
-			r = append(r, s.buff[0][0], s.buff[1][0])
-			s.buff[0], s.buff[1] = s.buff[0][1:], s.buff[1][1:]
+		c := copy(r[len(r):n], s.buf[:m])
+		r = r[:len(r)+c]
+		s.buf = s.buf[c:]
+		s.pos += int64(c) / ch
+	}
+	return r, nil
+}
+
+// seekPriming is the number of frames decoded and discarded before the
+// target frame of a seek to fill the layer III bit reservoir.
+const seekPriming = 10
+
+// Seek seeks to offset using an index of frame offsets, built by scanning
+// the stream on the first call. Streams that can't seek use the table of
+// contents of their VBR header, if any, instead of reading the whole stream.
+func (s *Song) Seek(offset time.Duration) error {
+	if s.decoder == nil {
+		return fmt.Errorf("mpa: seek before init")
+	}
+	start, _ := s.bounds()
+	spf := int64(s.decoder.NSamples())
+	target := start + int64(offset)*int64(s.decoder.SamplingFrequency())/int64(time.Second)
+	frame := target / spf
+	var r io.ReadCloser
+	var err error
+	if s.index == nil && s.vbr != nil && len(s.vbr.points) > 0 && !s.seekable() {
+		frame, r, err = s.seekTOC(frame)
+	} else {
+		frame, r, err = s.seekIndex(frame)
+	}
+	if err != nil {
+		return err
+	}
+	s.r.Close()
+	s.r = r
+	s.decoder.Input = r
+	s.buf = nil
+	for i := frame; i <= target/spf; i++ {
+		if err := s.decoder.DecodeFrame(); err != nil {
+			if _, ok := err.(mpa.MalformedStream); ok {
+				continue
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// Seeking past the end leaves nothing to play.
+				s.pos = target
+				return nil
+			}
+			return err
+		}
+	}
+	if frame > target/spf {
+		target = frame * spf
+	}
+	s.readFrame()
+	s.pos = target / spf * spf
+	skip := target - s.pos
+	if skip > int64(len(s.buf))/int64(s.channels) {
+		skip = int64(len(s.buf)) / int64(s.channels)
+	}
+	s.buf = s.buf[skip*int64(s.channels):]
+	s.pos += skip
+	return nil
+}
+
+// seekable returns whether the stream can seek without reading up to the
+// seek position.
+func (s *Song) seekable() bool {
+	r, _, err := s.Reader()
+	if err != nil {
+		return false
+	}
+	_, ok := r.(io.Seeker)
+	r.Close()
+	return ok
+}
+
+// seekIndex opens the stream at the frame seekPriming frames before frame,
+// and returns that frame.
+func (s *Song) seekIndex(frame int64) (int64, io.ReadCloser, error) {
+	if err := s.scan(); err != nil {
+		return 0, nil, err
+	}
+	if n := int64(len(s.index.offsets)); frame >= n {
+		frame = n - 1
+	}
+	start := frame - seekPriming
+	if start < 0 {
+		start = 0
+	}
+	r, err := codec.OpenAt(s.Reader, s.index.offsets[start])
+	return start, r, err
+}
+
+// seekTOC opens the stream at the last VBR header seek point at least
+// seekPriming frames before frame, and returns the frame of the point. The
+// position is approximate.
+func (s *Song) seekTOC(frame int64) (int64, io.ReadCloser, error) {
+	p := s.vbr.points[0]
+	for _, q := range s.vbr.points {
+		if q.frame+seekPriming <= frame {
+			p = q
+		}
+	}
+	r, err := codec.OpenAt(s.Reader, s.vbr.offset+p.offset)
+	if p.frame == 0 {
+		// The first point is the VBR header frame.
+		return -1, r, err
+	}
+	return p.frame, r, err
+}
+
+// readFrame copies the samples of the last decoded frame into buf.
+func (s *Song) readFrame() {
+	n := s.decoder.NSamples()
+	for i := range s.frame {
+		if cap(s.frame[i]) < n {
+			s.frame[i] = make([]float32, n)
+		}
+		s.frame[i] = s.frame[i][:n]
+		s.decoder.ReadSamples(i, s.frame[i])
+	}
+	if cap(s.buf) < n*s.channels {
+		s.buf = make([]float32, n*s.channels)
+	}
+	s.buf = s.buf[:n*s.channels]
+	for i := 0; i < n; i++ {
+		for c := 0; c < s.channels; c++ {
+			s.buf[i*s.channels+c] = s.frame[c][i]
 		}
 	}
-	return
 }
 
 func (s *Song) Close() {
 	if s.r != nil {
 		s.r.Close()
 	}
-	s.decoder, s.buff[0], s.buff[1], s.r = nil, nil, nil, nil
+	s.decoder, s.buf, s.r = nil, nil, nil
 }
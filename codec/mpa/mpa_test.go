@@ -0,0 +1,187 @@
+package mpa
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// readRaw reads little endian 16-bit samples.
+func readRaw(t *testing.T, name string) []float32 {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := make([]float32, len(b)/2)
+	for i := range s {
+		s[i] = float32(int16(binary.LittleEndian.Uint16(b[i*2:]))) / (1 << 15)
+	}
+	return s
+}
+
+func equal(t *testing.T, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// TestLSF decodes the MPEG-2 layer III files in testdata, which have 40
+// frames of 576 samples, and compares them to the output of another
+// decoder.
+func TestLSF(t *testing.T) {
+	for _, rate := range []int{22050, 24000} {
+		name := filepath.Join("testdata", map[int]string{22050: "lsf22", 24000: "lsf24"}[rate])
+		b, err := ioutil.ReadFile(name + ".mp3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		songs, err := NewSongs(codectest.Reader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := songs[0]
+		info, err := s.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 40 * 576 * time.Second / time.Duration(rate); info.SampleRate != rate || info.Channels != 1 || info.Time != want {
+			t.Fatalf("%s: got %+v", name, info)
+		}
+		if sr, ch, err := s.Init(); err != nil || sr != rate || ch != 1 {
+			t.Fatalf("%s: init: %d %d %v", name, sr, ch, err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		want := readRaw(t, name+".raw")
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d samples, want %d", name, len(got), len(want))
+		}
+		// The reference is rounded to 16 bits, so allow the error of the
+		// limited accuracy test of ISO/IEC 11172-4.
+		var sum, signal, max float64
+		for i := range got {
+			d := float64(got[i] - want[i])
+			sum += d * d
+			signal += float64(want[i]) * float64(want[i])
+			max = math.Max(max, math.Abs(d))
+		}
+		rms := math.Sqrt(sum / float64(len(got)))
+		if signal := math.Sqrt(signal / float64(len(got))); signal < 0.01 {
+			t.Fatalf("%s: reference rms %v", name, signal)
+		}
+		if limit := math.Pow(2, -11) / math.Sqrt(12); rms > limit || max > math.Pow(2, -12) {
+			t.Fatalf("%s: error rms %v, max %v", name, rms, max)
+		}
+
+		for _, offset := range []time.Duration{500 * time.Millisecond, 100 * time.Millisecond, 0} {
+			if err := s.(codec.Seeker).Seek(offset); err != nil {
+				t.Fatal(err)
+			}
+			equal(t, codectest.PlayAll(t, s, 4096), got[int(offset)*rate/int(time.Second):])
+		}
+	}
+}
+
+// TestPadded checks that files with junk before the first frame are still
+// opened by their extension.
+func TestPadded(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "lsf22.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	songs, name, err := codec.ByExtension("padded.mp3", codectest.Reader(append(make([]byte, 16), b...)))
+	if err != nil || name != "MP3" {
+		t.Fatalf("got %q, %v", name, err)
+	}
+	s := songs[0]
+	if _, _, err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(codectest.PlayAll(t, s, 4096)), 40*576; got != want {
+		t.Fatalf("got %d samples, want %d", got, want)
+	}
+}
+
+// xingFile returns testdata/lsf22.mp3, whose frames are all 313 bytes, after
+// a Xing header frame with a table of contents.
+func xingFile(t *testing.T) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "lsf22.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 313
+	h := make([]byte, size)
+	copy(h, b[:4])
+	x := h[4+9:]
+	copy(x, "Xing")
+	binary.BigEndian.PutUint32(x[4:], 7)
+	binary.BigEndian.PutUint32(x[8:], 40)
+	binary.BigEndian.PutUint32(x[12:], uint32(size+len(b)))
+	// Point to the start of the frame of each percent, or just before it.
+	// As with LAME, the first point is the header frame.
+	for i := 1; i < 100; i++ {
+		frame := 1 + 40*i/100
+		x[16+i] = byte(frame * size * 256 / (size + len(b)))
+	}
+	return append(h, b...)
+}
+
+func TestXing(t *testing.T) {
+	b := xingFile(t)
+	fh, _ := parseHeader(binary.BigEndian.Uint32(b))
+	v := parseVBR(fh, b[:fh.size()])
+	if v == nil || v.frames != 40 || v.bytes != int64(len(b)) || len(v.points) != 100 || v.points[0] != (seekPoint{}) {
+		t.Fatalf("got %+v", v)
+	}
+	for i, p := range v.points[1:] {
+		frame := 40 * int64(i+1) / 100
+		if off := (frame + 1) * 313; p.frame != frame || p.offset > off || p.offset <= off-313 {
+			t.Fatalf("point %d is %+v, want frame %d in the frame before %d", i, p, frame, off)
+		}
+	}
+
+	songs, err := NewSongs(codectest.Reader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := songs[0]
+	if _, _, err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 40 * 576 * time.Second / 22050; info.Time != want {
+		t.Fatalf("time %v, want %v", info.Time, want)
+	}
+	// The header frame counts toward the size.
+	if want := len(b) * 8 * 22050 / (40 * 576); info.Bitrate != want {
+		t.Fatalf("bitrate %v, want %v", info.Bitrate, want)
+	}
+	got := codectest.PlayAll(t, s, 4096)
+	if len(got) != 40*576 {
+		t.Fatalf("got %d samples, want %d", len(got), 40*576)
+	}
+	// The stream can't seek, so the table of contents is used.
+	for _, offset := range []time.Duration{800 * time.Millisecond, 500 * time.Millisecond, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		if s.(*Song).index != nil {
+			t.Fatal("stream scanned to seek")
+		}
+		equal(t, codectest.PlayAll(t, s, 4096), got[int(offset)*22050/int(time.Second):])
+	}
+}
@@ -13,7 +13,7 @@ func init() {
 }
 
 func ReadNSFSongs(rf codec.Reader) ([]codec.Song, error) {
-	r, _, err := rf()
+	r, size, err := rf()
 	if err != nil {
 		return nil, err
 	}
@@ -28,6 +28,7 @@ func ReadNSFSongs(rf codec.Reader) ([]codec.Song, error) {
 			NSF:    n,
 			Index:  i + 1,
 			Reader: rf,
+			size:   size,
 		}
 	}
 	return songs, nil
@@ -38,6 +39,7 @@ type NSFSong struct {
 	Index   int
 	Playing bool
 	Reader  codec.Reader
+	size    int64
 }
 
 func (n *NSFSong) Init() (sampleRate, channels int, err error) {
@@ -69,7 +71,7 @@ func (n *NSFSong) Close() {
 func (n *NSFSong) Info() (si codec.SongInfo, err error) {
 	ns := n.NSF
 	if ns == nil {
-		r, _, err := n.Reader()
+		r, size, err := n.Reader()
 		if err != nil {
 			return si, err
 		}
@@ -78,18 +80,28 @@ func (n *NSFSong) Info() (si codec.SongInfo, err error) {
 		if err != nil {
 			return si, err
 		}
+		n.size = size
 	}
-	s := n.NSF.Songs[n.Index-1]
+	s := ns.Songs[n.Index-1]
 	title := s.Name
 	if title == "" {
-		title = fmt.Sprintf("%s:%02d", n.NSF.Game, n.Index)
+		title = fmt.Sprintf("%s:%02d", ns.Game, n.Index)
+	}
+	rate := ns.SampleRate
+	if rate == 0 {
+		rate = nsf.DefaultSampleRate
 	}
 	si = codec.SongInfo{
-		Time:   s.Duration,
-		Artist: n.NSF.Artist,
-		Album:  n.NSF.Game,
-		Track:  float64(n.Index),
-		Title:  title,
+		Time:       s.Duration,
+		Artist:     ns.Artist,
+		Album:      ns.Game,
+		Track:      float64(n.Index),
+		Title:      title,
+		Comment:    ns.Copyright,
+		Codec:      "NSF",
+		SampleRate: int(rate),
+		Channels:   1,
+		Size:       n.size,
 	}
 	return
 }
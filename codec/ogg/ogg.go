@@ -0,0 +1,325 @@
+// Package ogg reads packets from Ogg bitstreams.
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	ErrFormat = errors.New("ogg: bad page")
+	errCRC    = errors.New("ogg: crc mismatch")
+)
+
+const (
+	flagContinued = 1 << iota
+	flagBOS
+	flagEOS
+)
+
+const pageHeaderLen = 27
+
+var capture = []byte("OggS")
+
+// PageHeader is the header of an Ogg page.
+type PageHeader struct {
+	Flags    byte
+	Granule  int64
+	Serial   uint32
+	Sequence uint32
+	Segments []byte
+}
+
+// Continued reports whether the first packet on the page continues from the
+// previous page.
+func (h *PageHeader) Continued() bool { return h.Flags&flagContinued != 0 }
+
+// BOS reports whether the page is the first of a logical bitstream.
+func (h *PageHeader) BOS() bool { return h.Flags&flagBOS != 0 }
+
+// EOS reports whether the page is the last of a logical bitstream.
+func (h *PageHeader) EOS() bool { return h.Flags&flagEOS != 0 }
+
+// bodyLen returns the length of the page body.
+func (h *PageHeader) bodyLen() int {
+	n := 0
+	for _, s := range h.Segments {
+		n += int(s)
+	}
+	return n
+}
+
+// A Packet is one packet of a logical bitstream.
+type Packet struct {
+	Data []byte
+	// Granule is the granule position of the page on which the packet
+	// ended, or -1 if another packet ends after it on the same page.
+	Granule int64
+	// EOS is set on the final packet of the logical bitstream.
+	EOS bool
+}
+
+// Reader reads packets of the first logical bitstream found in an Ogg
+// stream. Pages of other multiplexed streams are skipped.
+type Reader struct {
+	r       io.Reader
+	serial  uint32
+	locked  bool
+	hdr     PageHeader
+	body    []byte
+	seg     int
+	partial []byte
+	// skip is set after a resync, when a continued packet can't be joined
+	// to its start.
+	skip bool
+	// Offset is the number of bytes consumed from the underlying reader.
+	Offset int64
+	buf    [pageHeaderLen + 255]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Serial returns the serial number of the stream being read. It is valid
+// after the first packet has been read.
+func (r *Reader) Serial() uint32 {
+	return r.serial
+}
+
+// Reset discards any partial packet and continues reading from nr, which
+// must be positioned at a page boundary. The serial number of the stream is
+// retained. Offset is set to off.
+func (r *Reader) Reset(nr io.Reader, off int64) {
+	r.r = nr
+	r.body = nil
+	r.hdr.Segments = nil
+	r.seg = 0
+	r.partial = nil
+	r.skip = true
+	r.Offset = off
+}
+
+// NextPacket returns the next packet.
+func (r *Reader) NextPacket() (*Packet, error) {
+	for {
+		for r.seg < len(r.hdr.Segments) {
+			n := int(r.hdr.Segments[r.seg])
+			r.seg++
+			r.partial = append(r.partial, r.body[:n]...)
+			r.body = r.body[n:]
+			if n == 255 {
+				continue
+			}
+			p := &Packet{
+				Data:    r.partial,
+				Granule: -1,
+			}
+			r.partial = nil
+			if r.skip {
+				r.skip = false
+				if r.hdr.Continued() && r.seg == r.firstEnd() {
+					continue
+				}
+			}
+			if r.lastEnd() == r.seg {
+				p.Granule = r.hdr.Granule
+				p.EOS = r.hdr.EOS()
+			}
+			return p, nil
+		}
+		if err := r.nextPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// firstEnd returns the segment index after which the first packet on the
+// current page ends.
+func (r *Reader) firstEnd() int {
+	for i, s := range r.hdr.Segments {
+		if s != 255 {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// lastEnd returns the segment index after which the last packet on the
+// current page ends.
+func (r *Reader) lastEnd() int {
+	for i := len(r.hdr.Segments) - 1; i >= 0; i-- {
+		if r.hdr.Segments[i] != 255 {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// nextPage reads the next page of the locked stream.
+func (r *Reader) nextPage() error {
+	for {
+		hdr, body, err := r.readPage()
+		if err == errCRC {
+			r.skip = true
+			r.partial = nil
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !r.locked {
+			r.serial = hdr.Serial
+			r.locked = true
+		}
+		if hdr.Serial != r.serial {
+			continue
+		}
+		if !hdr.Continued() {
+			r.partial = nil
+		}
+		r.hdr = hdr
+		r.body = body
+		r.seg = 0
+		return nil
+	}
+}
+
+// readPage reads one page, resynchronizing to the next capture pattern if
+// needed.
+func (r *Reader) readPage() (PageHeader, []byte, error) {
+	h, err := r.ReadPageHeader()
+	if err != nil {
+		return h, nil, err
+	}
+	body := make([]byte, h.bodyLen())
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	r.Offset += int64(len(body))
+	hb := r.buf[:pageHeaderLen+len(h.Segments)]
+	crc := binary.LittleEndian.Uint32(hb[22:])
+	hb[22], hb[23], hb[24], hb[25] = 0, 0, 0, 0
+	if crcUpdate(crcUpdate(0, hb), body) != crc {
+		return h, nil, errCRC
+	}
+	return h, body, nil
+}
+
+// ReadPageHeader reads the next page header without its body. It is
+// exported for callers that want to skip quickly through a stream with
+// SkipBody.
+func (r *Reader) ReadPageHeader() (PageHeader, error) {
+	var h PageHeader
+	b := r.buf[:pageHeaderLen]
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return h, err
+	}
+	r.Offset += pageHeaderLen
+	for !bytes.Equal(b[:4], capture) || b[4] != 0 {
+		// Resynchronize by shifting one byte at a time.
+		copy(b, b[1:])
+		if _, err := io.ReadFull(r.r, b[pageHeaderLen-1:]); err != nil {
+			return h, io.EOF
+		}
+		r.Offset++
+		r.skip = true
+	}
+	h.Flags = b[5]
+	h.Granule = int64(binary.LittleEndian.Uint64(b[6:]))
+	h.Serial = binary.LittleEndian.Uint32(b[14:])
+	h.Sequence = binary.LittleEndian.Uint32(b[18:])
+	n := int(b[26])
+	h.Segments = r.buf[pageHeaderLen : pageHeaderLen+n]
+	if _, err := io.ReadFull(r.r, h.Segments); err != nil {
+		return h, io.ErrUnexpectedEOF
+	}
+	r.Offset += int64(n)
+	return h, nil
+}
+
+// SkipBody discards the body of the page whose header was just read with
+// ReadPageHeader.
+func (r *Reader) SkipBody(h PageHeader) error {
+	n := int64(h.bodyLen())
+	if s, ok := r.r.(io.Seeker); ok {
+		if _, err := s.Seek(n, 1); err != nil {
+			return err
+		}
+	} else if _, err := io.CopyN(ioutil.Discard, r.r, n); err != nil {
+		return err
+	}
+	r.Offset += n
+	return nil
+}
+
+// LastGranule returns the granule position of the last page of the stream
+// with the given serial number. If r is an io.Seeker and size is known,
+// only the end of the stream is read.
+func LastGranule(r io.Reader, size int64, serial uint32) (int64, error) {
+	const tail = 1 << 16
+	s, ok := r.(io.Seeker)
+	if !ok || size <= tail {
+		or := NewReader(r)
+		g := int64(-1)
+		for {
+			h, _, err := or.readPage()
+			if err == errCRC {
+				continue
+			} else if err != nil {
+				break
+			}
+			if h.Serial == serial && h.Granule != -1 {
+				g = h.Granule
+			}
+		}
+		if g < 0 {
+			return 0, ErrFormat
+		}
+		return g, nil
+	}
+	if _, err := s.Seek(size-tail, 0); err != nil {
+		return 0, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	// Search backward for the last valid page of the stream.
+	for i := bytes.LastIndex(b, capture); i >= 0; i = bytes.LastIndex(b[:i], capture) {
+		or := NewReader(bytes.NewReader(b[i:]))
+		h, _, err := or.readPage()
+		if err != nil || h.Serial != serial || h.Granule == -1 {
+			continue
+		}
+		return h.Granule, nil
+	}
+	return 0, ErrFormat
+}
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return
+}()
+
+func crcUpdate(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
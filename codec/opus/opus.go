@@ -0,0 +1,312 @@
+package opus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/_third_party/github.com/pion/opus"
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/ogg"
+	"github.com/mjibson/mog/codec/vorbis"
+)
+
+func init() {
+	// The identification header packet follows the 28 byte first page
+	// header.
+	codec.RegisterCodec("Opus", "OggS"+strings.Repeat("?", 24)+"OpusHead", []string{"opus", "ogg", "oga"}, New)
+}
+
+const (
+	sampleRate = 48000
+	// maxPacket is the number of samples per channel in the longest
+	// (120ms) packet.
+	maxPacket = sampleRate * 120 / 1000
+	// preroll is the number of samples to decode before a seek target so
+	// the decoder state has converged.
+	preroll = sampleRate * 80 / 1000
+)
+
+var errHeader = errors.New("opus: bad header")
+
+func New(rf codec.Reader) ([]codec.Song, error) {
+	o := Opus{
+		Reader: rf,
+	}
+	return []codec.Song{&o}, nil
+}
+
+type Opus struct {
+	Reader codec.Reader
+	r      io.ReadCloser
+	or     *ogg.Reader
+	d      *opus.Decoder
+	h      *header
+	gain   float32
+	out    []float32
+	// dataStart is the byte offset of the first audio page.
+	dataStart int64
+	// buf holds decoded, interleaved samples not yet played.
+	buf []float32
+	// pos is the granule position of the end of buf. It is unknown after a
+	// seek until a page with a granule position is reached.
+	pos   int64
+	known bool
+	eos   bool
+}
+
+// header is the OpusHead identification header.
+type header struct {
+	channels int
+	preSkip  int64
+	// outputGain is in Q7.8 dB.
+	outputGain int16
+}
+
+func readHeader(b []byte) (*header, error) {
+	if len(b) < 19 || string(b[:8]) != "OpusHead" || b[8]>>4 != 0 {
+		return nil, errHeader
+	}
+	h := &header{
+		channels:   int(b[9]),
+		preSkip:    int64(binary.LittleEndian.Uint16(b[10:])),
+		outputGain: int16(binary.LittleEndian.Uint16(b[16:])),
+	}
+	switch family := b[18]; {
+	case family == 0 && (h.channels == 1 || h.channels == 2):
+	case len(b) >= 21+h.channels && h.channels <= 2 && b[19] == 1:
+		// A single stream with a trivial channel mapping decodes like
+		// family 0.
+		for i := 0; i < h.channels; i++ {
+			if int(b[21+i]) != i {
+				return nil, fmt.Errorf("opus: unsupported channel mapping")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("opus: unsupported channel mapping family %d", family)
+	}
+	return h, nil
+}
+
+// readHeaders reads the identification and comment headers.
+func readHeaders(or *ogg.Reader) (*header, *vorbis.Comment, error) {
+	p, err := or.NextPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := readHeader(p.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err = or.NextPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(p.Data) < 8 || string(p.Data[:8]) != "OpusTags" {
+		return nil, nil, errHeader
+	}
+	c, err := vorbis.ParseComment(p.Data[8:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, c, nil
+}
+
+func (o *Opus) Init() (sampleRate, channels int, err error) {
+	if o.d == nil {
+		r, _, err := o.Reader()
+		if err != nil {
+			return 0, 0, err
+		}
+		or := ogg.NewReader(r)
+		h, _, err := readHeaders(or)
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		d, err := opus.NewDecoderWithOutput(48000, h.channels)
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		o.r = r
+		o.or = or
+		o.d = &d
+		o.h = h
+		o.gain = float32(math.Pow(10, float64(h.outputGain)/(20*256)))
+		o.out = make([]float32, maxPacket*h.channels)
+		o.dataStart = or.Offset
+		o.buf = nil
+		o.pos = 0
+		o.known = true
+		o.eos = false
+		if err := o.skipTo(h.preSkip); err != nil {
+			o.Close()
+			return 0, 0, err
+		}
+	}
+	return 48000, o.h.channels, nil
+}
+
+func (o *Opus) Info() (info codec.SongInfo, err error) {
+	r, size, err := o.Reader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	or := ogg.NewReader(r)
+	h, c, err := readHeaders(or)
+	if err != nil {
+		return
+	}
+	c.Fill(&info)
+	info.Codec = "Opus"
+	info.SampleRate = sampleRate
+	info.Channels = h.channels
+	info.Size = size
+	// There is no nominal bitrate to estimate from, so only seekable
+	// readers get a duration.
+	if _, ok := r.(io.Seeker); ok {
+		if g, err := ogg.LastGranule(r, size, or.Serial()); err == nil && g > h.preSkip {
+			info.Time = time.Duration(g-h.preSkip) * time.Second / sampleRate
+			info.Bitrate = codec.Bitrate(size, info.Time)
+		}
+	}
+	return info, nil
+}
+
+// fill decodes the next audio packet into buf.
+func (o *Opus) fill() error {
+	p, err := o.or.NextPacket()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		o.eos = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if p.EOS {
+		o.eos = true
+	}
+	samples, err := o.d.DecodeToFloat32(p.Data, o.out)
+	if err != nil {
+		// Skip undecodable packets.
+		return nil
+	}
+	n := int64(samples)
+	if o.known && p.Granule >= 0 && p.EOS && o.pos+n > p.Granule {
+		// The last page's granule position trims the final packet.
+		n = p.Granule - o.pos
+		if n < 0 {
+			n = 0
+		}
+	}
+	for _, s := range o.out[:n*int64(o.h.channels)] {
+		o.buf = append(o.buf, s*o.gain)
+	}
+	o.pos += n
+	if !o.known && p.Granule >= 0 {
+		o.pos = p.Granule
+		o.known = true
+	}
+	return nil
+}
+
+// skipTo decodes until granule position g and discards the samples
+// before it.
+func (o *Opus) skipTo(g int64) error {
+	for !o.eos && (!o.known || o.pos <= g) {
+		if err := o.fill(); err != nil {
+			return err
+		}
+	}
+	ch := int64(o.h.channels)
+	begin := o.pos - int64(len(o.buf))/ch
+	if skip := (g - begin) * ch; skip > 0 {
+		if skip > int64(len(o.buf)) {
+			skip = int64(len(o.buf))
+		}
+		o.buf = o.buf[skip:]
+	}
+	return nil
+}
+
+func (o *Opus) Play(n int) ([]float32, error) {
+	for len(o.buf) < n && !o.eos {
+		if err := o.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if n > len(o.buf) {
+		n = len(o.buf)
+	}
+	ret := make([]float32, n)
+	copy(ret, o.buf)
+	o.buf = o.buf[n:]
+	return ret, nil
+}
+
+// Seek finds the last page that ends at least the preroll before offset
+// and decodes forward from there.
+func (o *Opus) Seek(offset time.Duration) error {
+	if o.d == nil {
+		return fmt.Errorf("opus: seek before init")
+	}
+	target := int64(offset)*sampleRate/int64(time.Second) + o.h.preSkip
+	start := o.dataStart
+	if margin := target - preroll; margin > 0 {
+		r, err := codec.OpenAt(o.Reader, o.dataStart)
+		if err != nil {
+			return err
+		}
+		or := ogg.NewReader(r)
+		or.Offset = o.dataStart
+		prev := start
+		for {
+			h, err := or.ReadPageHeader()
+			if err != nil {
+				break
+			}
+			if h.Serial == o.or.Serial() && h.Granule != -1 && h.Granule > margin {
+				if h.EOS() {
+					// The last page's granule position is trimmed, so
+					// it cannot place the samples before it.
+					start = prev
+				}
+				break
+			}
+			if err := or.SkipBody(h); err != nil {
+				break
+			}
+			if h.Serial == o.or.Serial() && h.Granule != -1 {
+				prev, start = start, or.Offset
+			}
+		}
+		r.Close()
+	}
+	r, err := codec.OpenAt(o.Reader, start)
+	if err != nil {
+		return err
+	}
+	o.r.Close()
+	o.r = r
+	o.or.Reset(r, start)
+	if err := o.d.Init(48000, o.h.channels); err != nil {
+		return err
+	}
+	o.buf = o.buf[:0]
+	o.eos = false
+	o.pos = 0
+	o.known = start == o.dataStart
+	return o.skipTo(target)
+}
+
+func (o *Opus) Close() {
+	if o.r != nil {
+		o.r.Close()
+	}
+	o.r, o.or, o.d, o.buf = nil, nil, nil, nil
+}
@@ -0,0 +1,72 @@
+package opus
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// testdata/celt.opus holds 1.5 seconds of mono CELT frames, 20ms each, in
+// pages of ten packets. It has a pre-skip of 312 samples and a TITLE tag.
+const (
+	testFile    = "testdata/celt.opus"
+	testSamples = 72000
+)
+
+func open(t *testing.T) codec.Song {
+	return codectest.Open(t, New, codectest.File(testFile), 0, 48000, 1)
+}
+
+func TestDecode(t *testing.T) {
+	s := open(t)
+	defer s.Close()
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Time != 1500*time.Millisecond || info.Title != "Tones" {
+		t.Fatalf("got time %v, title %q", info.Time, info.Title)
+	}
+	// The pre-skip is dropped from the start and the last page's granule
+	// position trims the end.
+	if n := len(codectest.PlayAll(t, s, 4096)); n != testSamples {
+		t.Fatalf("got %d samples, want %d", n, testSamples)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	s := open(t)
+	defer s.Close()
+	want := codectest.PlayAll(t, s, 4096)
+	for _, offset := range []time.Duration{
+		700 * time.Millisecond,
+		// Within the preroll of the start.
+		50 * time.Millisecond,
+		// Within the last page.
+		1450 * time.Millisecond,
+		0,
+		1500 * time.Millisecond,
+	} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		w := want[int(offset.Seconds()*48000):]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got %d samples, want %d", offset, len(got), len(w))
+		}
+		// The decoder state after the preroll is close to, but not exactly,
+		// that of decoding from the start.
+		var diff, sum float64
+		for i := range got {
+			diff += math.Pow(float64(got[i]-w[i]), 2)
+			sum += math.Pow(float64(w[i]), 2)
+		}
+		if diff > sum*1e-3 {
+			t.Fatalf("%v: samples differ by %.2g of their energy", offset, diff/sum)
+		}
+	}
+}
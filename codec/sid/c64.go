@@ -0,0 +1,341 @@
+package sid
+
+import "github.com/mjibson/mog/_third_party/github.com/mjibson/nsf/cpu6502"
+
+// Clock rates and video timings.
+const (
+	palClock       = 985248
+	palLines       = 312
+	palLineCycles  = 63
+	ntscClock      = 1022727
+	ntscLines      = 263
+	ntscLineCycles = 65
+)
+
+// kernal is a stand-in for the KERNAL ROM. It is filled with RTS and holds
+// just enough of the interrupt handlers for tunes that rely on them.
+var kernal [0x2000]byte
+
+func init() {
+	for i := range kernal {
+		kernal[i] = 0x60
+	}
+	code := func(addr uint16, b ...byte) {
+		copy(kernal[addr-0xe000:], b)
+	}
+	// IRQ entry: save registers and jump through $0314.
+	code(0xff48, 0x48, 0x8a, 0x48, 0x98, 0x48, 0x6c, 0x14, 0x03)
+	// Default IRQ handler: acknowledge CIA 1 and return.
+	code(0xea31, 0x4c, 0x7e, 0xea)
+	code(0xea7e, 0xad, 0x0d, 0xdc)
+	code(0xea81, 0x68, 0xa8, 0x68, 0xaa, 0x68, 0x40)
+	// NMI entry jumps through $0318 to an RTI.
+	code(0xfe43, 0x78, 0x6c, 0x18, 0x03)
+	code(0xfe47, 0x40)
+	code(0xfffa, 0x43, 0xfe, 0xe2, 0xfc, 0x48, 0xff)
+}
+
+// c64 emulates the parts of a Commodore 64 used by SID tunes: the CPU, RAM
+// and ROM banking, CIA 1 timer A, the VIC raster interrupt and the SIDs.
+type c64 struct {
+	ram  [0x10000]byte
+	cpu  *cpu6502.Cpu
+	sids []*chip
+	// addrs are the base addresses of sids.
+	addrs []uint16
+
+	clock      int
+	lines      int64
+	lineCycles int64
+	cycles     int64
+	// frac accumulates the output rate each cycle; a sample is due when it
+	// reaches clock.
+	frac int
+	out  []float32
+
+	// hw enables interrupts from the CIA and VIC. Otherwise the player calls
+	// the play routine itself every frame, or every timer A period if
+	// ciaTimed.
+	hw         bool
+	ciaTimed   bool
+	play       uint16
+	nextPlay   int64
+	playBank   byte
+	ciaLatch   uint16
+	ciaControl byte
+	ciaMask    byte
+	ciaFlags   byte
+	ciaNext    int64
+	vicMask    byte
+	vicFlags   byte
+	vicRaster  int64
+}
+
+func newC64(f *file, song int) *c64 {
+	m := &c64{
+		clock:      palClock,
+		lines:      palLines,
+		lineCycles: palLineCycles,
+		ciaLatch:   0x4025,
+		ciaControl: 1,
+		ciaMask:    1,
+		vicRaster:  0x137,
+	}
+	if f.ntsc() {
+		m.clock = ntscClock
+		m.lines = ntscLines
+		m.lineCycles = ntscLineCycles
+		m.ciaLatch = 0x4295
+	}
+	m.ciaNext = int64(m.ciaLatch) + 1
+	for i, a := range f.sids {
+		m.sids = append(m.sids, newChip(f.model(i)))
+		m.addrs = append(m.addrs, a)
+	}
+	copy(m.ram[f.load:], f.data)
+	m.ram[0] = 0x2f
+	m.ram[1] = 0x37
+	m.ram[0x0314], m.ram[0x0315] = 0x31, 0xea
+	m.ram[0x0316], m.ram[0x0317] = 0x66, 0xfe
+	m.ram[0x0318], m.ram[0x0319] = 0x47, 0xfe
+
+	m.cpu = cpu6502.New(m)
+	m.cpu.T = m
+	m.cpu.A = byte(song - 1)
+	m.hw = f.rsid || f.play == 0
+	m.play = f.play
+	if !f.rsid {
+		m.ram[1] = bank(f.init)
+		m.playBank = bank(f.play)
+		m.ciaTimed = f.cia(song)
+	}
+	m.call(f.init)
+	return m
+}
+
+// bank returns the $01 value a PSID uses to call addr.
+func bank(addr uint16) byte {
+	switch {
+	case addr < 0xa000:
+		return 0x37
+	case addr < 0xd000:
+		return 0x36
+	case addr < 0xe000:
+		return 0x34
+	}
+	return 0x35
+}
+
+// call calls addr as a subroutine that returns to address 0, where the CPU
+// idles.
+func (m *c64) call(addr uint16) {
+	m.push(0xff)
+	m.push(0xff)
+	m.cpu.PC = addr
+}
+
+func (m *c64) push(b byte) {
+	m.ram[0x100|uint16(m.cpu.S)] = b
+	m.cpu.S--
+}
+
+func (m *c64) io() bool {
+	return m.ram[1]&3 != 0 && m.ram[1]&4 != 0
+}
+
+// chip returns the SID mapped at a and its register.
+func (m *c64) chip(a uint16) (*chip, byte) {
+	for i := len(m.addrs) - 1; i > 0; i-- {
+		if a&^0x1f == m.addrs[i] {
+			return m.sids[i], byte(a & 0x1f)
+		}
+	}
+	if a >= 0xd400 && a < 0xd800 {
+		return m.sids[0], byte(a & 0x1f)
+	}
+	return nil, 0
+}
+
+func (m *c64) Read(a uint16) byte {
+	switch {
+	case a >= 0xd000 && a < 0xe000 && m.io():
+		if c, r := m.chip(a); c != nil {
+			return c.read(r)
+		}
+		switch {
+		case a < 0xd400:
+			return m.readVIC(byte(a & 0x3f))
+		case a >= 0xdc00 && a < 0xdd00:
+			return m.readCIA(byte(a & 0xf))
+		}
+	case a >= 0xe000 && m.ram[1]&2 != 0:
+		return kernal[a-0xe000]
+	}
+	return m.ram[a]
+}
+
+func (m *c64) Write(a uint16, b byte) {
+	if a >= 0xd000 && a < 0xe000 && m.io() {
+		if c, r := m.chip(a); c != nil {
+			c.write(r, b)
+			return
+		}
+		switch {
+		case a < 0xd400:
+			m.writeVIC(byte(a&0x3f), b)
+		case a >= 0xdc00 && a < 0xdd00:
+			m.writeCIA(byte(a&0xf), b)
+		}
+	}
+	m.ram[a] = b
+}
+
+func (m *c64) raster() int64 {
+	return m.cycles / m.lineCycles % m.lines
+}
+
+func (m *c64) readVIC(r byte) byte {
+	switch r {
+	case 0x11:
+		return m.ram[0xd011]&0x7f | byte(m.raster()>>1)&0x80
+	case 0x12:
+		return byte(m.raster())
+	case 0x19:
+		f := m.vicFlags | 0x70
+		if m.vicFlags&m.vicMask != 0 {
+			f |= 0x80
+		}
+		return f
+	case 0x1a:
+		return m.vicMask | 0xf0
+	}
+	return m.ram[0xd000|uint16(r)]
+}
+
+func (m *c64) writeVIC(r, b byte) {
+	switch r {
+	case 0x11:
+		m.vicRaster = m.vicRaster&0xff | int64(b&0x80)<<1
+	case 0x12:
+		m.vicRaster = m.vicRaster&0x100 | int64(b)
+	case 0x19:
+		m.vicFlags &^= b & 0xf
+	case 0x1a:
+		m.vicMask = b & 0xf
+	}
+}
+
+// timer returns the current value of timer A.
+func (m *c64) timer() uint16 {
+	if m.ciaControl&1 == 0 || m.ciaNext < m.cycles {
+		return m.ciaLatch
+	}
+	return uint16(m.ciaNext - m.cycles)
+}
+
+func (m *c64) readCIA(r byte) byte {
+	switch r {
+	case 0x4:
+		return byte(m.timer())
+	case 0x5:
+		return byte(m.timer() >> 8)
+	case 0xd:
+		f := m.ciaFlags
+		if f&m.ciaMask != 0 {
+			f |= 0x80
+		}
+		m.ciaFlags = 0
+		return f
+	case 0xe:
+		return m.ciaControl
+	}
+	return m.ram[0xdc00|uint16(r)]
+}
+
+func (m *c64) writeCIA(r, b byte) {
+	switch r {
+	case 0x4:
+		m.ciaLatch = m.ciaLatch&0xff00 | uint16(b)
+	case 0x5:
+		m.ciaLatch = m.ciaLatch&0xff | uint16(b)<<8
+		if m.ciaControl&1 == 0 {
+			m.ciaNext = m.cycles + int64(m.ciaLatch) + 1
+		}
+	case 0xd:
+		if b&0x80 != 0 {
+			m.ciaMask |= b & 0x1f
+		} else {
+			m.ciaMask &^= b & 0x1f
+		}
+	case 0xe:
+		if b&0x10 != 0 || m.ciaControl&1 == 0 {
+			m.ciaNext = m.cycles + int64(m.ciaLatch) + 1
+		}
+		m.ciaControl = b &^ 0x10
+	}
+}
+
+// Tick runs the SIDs and timers for one cycle.
+func (m *c64) Tick() {
+	m.cycles++
+	for _, c := range m.sids {
+		c.clock()
+	}
+	if m.ciaControl&1 != 0 && m.cycles >= m.ciaNext {
+		m.ciaFlags |= 1
+		m.ciaNext += int64(m.ciaLatch) + 1
+		if m.ciaControl&8 != 0 {
+			m.ciaControl &^= 1
+		}
+	}
+	if m.cycles%m.lineCycles == 0 && m.raster() == m.vicRaster {
+		m.vicFlags |= 1
+	}
+	m.frac += rate
+	if m.frac >= m.clock {
+		m.frac -= m.clock
+		var s float32
+		for _, c := range m.sids {
+			s += c.sample()
+		}
+		m.out = append(m.out, s/float32(len(m.sids)))
+	}
+}
+
+// step runs one instruction, or one idle cycle, and delivers interrupts.
+func (m *c64) step() {
+	if m.hw {
+		if (m.ciaFlags&m.ciaMask != 0 || m.vicFlags&m.vicMask != 0) && !m.cpu.I() {
+			m.cpu.Interrupt()
+			return
+		}
+		if m.cpu.PC == 0 {
+			// Idle with interrupts enabled, as after the KERNAL's CLI.
+			m.cpu.CLI()
+		}
+	} else if m.cycles >= m.nextPlay {
+		if m.ciaTimed {
+			m.nextPlay += int64(m.ciaLatch) + 1
+		} else {
+			m.nextPlay += m.lines * m.lineCycles
+		}
+		if m.cpu.PC == 0 {
+			m.ram[1] = m.playBank
+			m.call(m.play)
+		}
+	}
+	if m.cpu.PC == 0 {
+		m.Tick()
+		return
+	}
+	m.cpu.Step()
+}
+
+// run fills out with samples.
+func (m *c64) run(out []float32) {
+	for len(m.out) < len(out) {
+		m.step()
+	}
+	n := copy(out, m.out)
+	m.out = m.out[:copy(m.out, m.out[n:])]
+}
@@ -0,0 +1,342 @@
+package sid
+
+import "math"
+
+// Chip models.
+const (
+	MOS6581 = iota
+	MOS8580
+)
+
+// Control register bits.
+const (
+	ctrlGate = 1 << iota
+	ctrlSync
+	ctrlRing
+	ctrlTest
+	ctrlTriangle
+	ctrlSawtooth
+	ctrlPulse
+	ctrlNoise
+)
+
+// ratePeriods is the number of cycles between envelope steps for each
+// attack, decay or release setting.
+var ratePeriods = [16]uint16{
+	9, 32, 63, 95, 149, 220, 267, 313,
+	392, 977, 1954, 3126, 3907, 11720, 19532, 31251,
+}
+
+// Envelope states.
+const (
+	envAttack = iota
+	envDecaySustain
+	envRelease
+)
+
+// cutoff6581 approximates the filter cutoff frequency in Hz of a typical
+// 6581 at each multiple of 0x100 of the cutoff register.
+var cutoff6581 = [...]float64{220, 300, 480, 1000, 3200, 5200, 7100, 9100, 11000}
+
+type voice struct {
+	// acc is the 24-bit phase accumulator.
+	acc  uint32
+	freq uint32
+	// pw is the 12-bit pulse width.
+	pw      uint32
+	control byte
+	// noise is the 23-bit noise shift register.
+	noise uint32
+	// msbRising is set when the accumulator MSB went high this cycle.
+	msbRising bool
+
+	ad, sr      byte
+	env         byte
+	envState    int
+	rateCounter uint16
+	ratePeriod  uint16
+	expCounter  byte
+	expPeriod   byte
+	// holdZero stops the envelope once it decays to zero.
+	holdZero bool
+}
+
+func (v *voice) setControl(c byte) {
+	gate := c&ctrlGate != 0
+	if gate && v.control&ctrlGate == 0 {
+		v.envState = envAttack
+		v.holdZero = false
+	} else if !gate && v.control&ctrlGate != 0 {
+		v.envState = envRelease
+	}
+	if c&ctrlTest != 0 {
+		v.acc = 0
+		v.noise = 0x7ffff8
+	}
+	v.control = c
+	v.setRate()
+}
+
+func (v *voice) setRate() {
+	switch v.envState {
+	case envAttack:
+		v.ratePeriod = ratePeriods[v.ad>>4]
+	case envDecaySustain:
+		v.ratePeriod = ratePeriods[v.ad&0xf]
+	default:
+		v.ratePeriod = ratePeriods[v.sr&0xf]
+	}
+}
+
+func (v *voice) clock() {
+	prev := v.acc
+	if v.control&ctrlTest == 0 {
+		v.acc = (v.acc + v.freq) & 0xffffff
+	}
+	v.msbRising = prev&0x800000 == 0 && v.acc&0x800000 != 0
+	if prev&0x080000 == 0 && v.acc&0x080000 != 0 {
+		bit := (v.noise>>22 ^ v.noise>>17) & 1
+		v.noise = (v.noise<<1 | bit) & 0x7fffff
+	}
+
+	// The envelope counter steps when the rate counter reaches the rate
+	// period. A period below the counter makes it wrap at 15 bits.
+	v.rateCounter = (v.rateCounter + 1) & 0x7fff
+	if v.rateCounter != v.ratePeriod {
+		return
+	}
+	v.rateCounter = 0
+	if v.envState != envAttack {
+		// Decay and release are exponential.
+		if v.expCounter++; v.expCounter != v.expPeriod {
+			return
+		}
+	}
+	v.expCounter = 0
+	if v.holdZero {
+		return
+	}
+	switch v.envState {
+	case envAttack:
+		v.env++
+		if v.env == 0xff {
+			v.envState = envDecaySustain
+			v.setRate()
+		}
+	case envDecaySustain:
+		if v.env != v.sr>>4*0x11 {
+			v.env--
+		}
+	case envRelease:
+		v.env--
+	}
+	switch v.env {
+	case 0xff:
+		v.expPeriod = 1
+	case 0x5d:
+		v.expPeriod = 2
+	case 0x36:
+		v.expPeriod = 4
+	case 0x1a:
+		v.expPeriod = 8
+	case 0x0e:
+		v.expPeriod = 16
+	case 0x06:
+		v.expPeriod = 30
+	case 0x00:
+		v.expPeriod = 1
+		v.holdZero = true
+	}
+}
+
+// wave returns the 12-bit waveform output. Combined waveforms are
+// approximated by ANDing their components. src is the ring modulation
+// source.
+func (v *voice) wave(src *voice) uint32 {
+	if v.control&0xf0 == 0 {
+		return 0
+	}
+	w := uint32(0xfff)
+	if v.control&ctrlTriangle != 0 {
+		msb := v.acc & 0x800000
+		if v.control&ctrlRing != 0 {
+			msb ^= src.acc & 0x800000
+		}
+		tri := v.acc
+		if msb != 0 {
+			tri = ^tri
+		}
+		w &= tri >> 11 & 0xfff
+	}
+	if v.control&ctrlSawtooth != 0 {
+		w &= v.acc >> 12
+	}
+	if v.control&ctrlPulse != 0 && v.control&ctrlTest == 0 && v.acc>>12 < v.pw {
+		w = 0
+	}
+	if v.control&ctrlNoise != 0 {
+		r := v.noise
+		w &= r&0x100000>>9 | r&0x040000>>8 | r&0x004000>>5 | r&0x000800>>3 |
+			r&0x000200>>2 | r&0x000020<<1 | r&0x000004<<3 | r&0x000001<<4
+	}
+	return w
+}
+
+// chip emulates one SID.
+type chip struct {
+	model   int
+	voices  [3]voice
+	fc      int
+	resFilt byte
+	modeVol byte
+	// filtered and direct sum the voice outputs since the last sample over
+	// cycles cycles.
+	filtered, direct int64
+	cycles           int
+	// lp and bp are the filter state.
+	lp, bp float64
+	// hpIn and hpOut are the state of the output DC blocker.
+	hpIn, hpOut float64
+}
+
+func newChip(model int) *chip {
+	c := &chip{model: model}
+	for i := range c.voices {
+		v := &c.voices[i]
+		v.noise = 0x7ffff8
+		v.envState = envRelease
+		v.holdZero = true
+		v.expPeriod = 1
+		v.setRate()
+	}
+	return c
+}
+
+func (c *chip) write(reg, b byte) {
+	if reg < 21 {
+		v := &c.voices[reg/7]
+		switch reg % 7 {
+		case 0:
+			v.freq = v.freq&0xff00 | uint32(b)
+		case 1:
+			v.freq = v.freq&0xff | uint32(b)<<8
+		case 2:
+			v.pw = v.pw&0xf00 | uint32(b)
+		case 3:
+			v.pw = v.pw&0xff | uint32(b&0xf)<<8
+		case 4:
+			v.setControl(b)
+		case 5:
+			v.ad = b
+			v.setRate()
+		case 6:
+			v.sr = b
+			v.setRate()
+		}
+		return
+	}
+	switch reg {
+	case 21:
+		c.fc = c.fc&0x7f8 | int(b&7)
+	case 22:
+		c.fc = c.fc&7 | int(b)<<3
+	case 23:
+		c.resFilt = b
+	case 24:
+		c.modeVol = b
+	}
+}
+
+func (c *chip) read(reg byte) byte {
+	switch reg {
+	case 25, 26:
+		// Unconnected paddles.
+		return 0xff
+	case 27:
+		return byte(c.voices[2].wave(&c.voices[1]) >> 4)
+	case 28:
+		return c.voices[2].env
+	}
+	return 0
+}
+
+// clock runs the chip for one cycle.
+func (c *chip) clock() {
+	for i := range c.voices {
+		c.voices[i].clock()
+	}
+	// Each voice is synced and ring modulated by the previous one.
+	for i := range c.voices {
+		v := &c.voices[i]
+		if v.control&ctrlSync != 0 && c.voices[(i+2)%3].msbRising {
+			v.acc = 0
+		}
+	}
+	// The 6581 waveforms are offset from zero, and each voice adds a DC
+	// level that makes writes to the volume register audible.
+	zero, dc := 0x800, 0
+	if c.model == MOS6581 {
+		zero, dc = 0x380, 0x800*0xff
+	}
+	for i := range c.voices {
+		v := &c.voices[i]
+		out := (int(v.wave(&c.voices[(i+2)%3]))-zero)*int(v.env) + dc
+		switch {
+		case c.resFilt&(1<<uint(i)) != 0:
+			c.filtered += int64(out)
+		case i == 2 && c.modeVol&0x80 != 0:
+			// Voice 3 is disconnected.
+		default:
+			c.direct += int64(out)
+		}
+	}
+	c.cycles++
+}
+
+// cutoff returns the filter cutoff frequency in Hz.
+func (c *chip) cutoff() float64 {
+	if c.model == MOS8580 {
+		return 30 + float64(c.fc)*6.1
+	}
+	i, f := c.fc>>8, float64(c.fc&0xff)/256
+	if i+1 >= len(cutoff6581) {
+		return cutoff6581[len(cutoff6581)-1]
+	}
+	return cutoff6581[i] + (cutoff6581[i+1]-cutoff6581[i])*f
+}
+
+// sample returns the average output since the last call.
+func (c *chip) sample() float32 {
+	if c.cycles == 0 {
+		return 0
+	}
+	in := float64(c.filtered) / float64(c.cycles)
+	out := float64(c.direct) / float64(c.cycles)
+	c.filtered, c.direct, c.cycles = 0, 0, 0
+
+	// A state variable filter, run twice per sample for stability at high
+	// cutoffs.
+	w := 2 * math.Sin(math.Pi*c.cutoff()/(2*rate))
+	q := 1 / (0.707 + float64(c.resFilt>>4)/15)
+	var hp float64
+	for i := 0; i < 2; i++ {
+		c.lp += w * c.bp
+		hp = in - c.lp - q*c.bp
+		c.bp += w * hp
+	}
+	if c.modeVol&0x10 != 0 {
+		out += c.lp
+	}
+	if c.modeVol&0x20 != 0 {
+		out += c.bp
+	}
+	if c.modeVol&0x40 != 0 {
+		out += hp
+	}
+	out *= float64(c.modeVol&0xf) / 15 / (3 * 0x1000 * 0xff)
+
+	// Remove DC.
+	c.hpOut = out - c.hpIn + 0.998*c.hpOut
+	c.hpIn = out
+	return float32(c.hpOut)
+}
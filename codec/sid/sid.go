@@ -0,0 +1,323 @@
+// Package sid plays Commodore 64 PSID and RSID files by emulating the 6510
+// and the MOS 6581 or 8580 SID chip.
+package sid
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("PSID", "PSID", []string{"sid"}, ReadSIDSongs)
+	codec.RegisterCodec("RSID", "RSID", []string{"sid"}, ReadSIDSongs)
+}
+
+const (
+	// rate is the output sample rate.
+	rate = 44100
+
+	// maxSize bounds the file: the header plus 64K of C64 memory.
+	maxSize = 0x7c + 0x10002
+)
+
+// DefaultDuration is the play length of songs not in the Songlengths
+// database.
+var DefaultDuration = time.Minute * 3
+
+var errHeader = errors.New("sid: bad header")
+
+// file holds the parsed contents of a SID file.
+type file struct {
+	name, author, released string
+	rsid                   bool
+	load, init, play       uint16
+	songs, start           int
+	speed                  uint32
+	flags                  uint16
+	// data is the C64 data, without the load address.
+	data []byte
+	// sids are the base addresses of the SID chips.
+	sids []uint16
+	// sum and oldSum are the full and old style Songlengths MD5s.
+	sum, oldSum [md5.Size]byte
+	// size is the file size.
+	size int64
+}
+
+func headerString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func parse(b []byte) (*file, error) {
+	if len(b) < 0x76 {
+		return nil, errHeader
+	}
+	be := binary.BigEndian
+	version := be.Uint16(b[4:])
+	offset := int(be.Uint16(b[6:]))
+	if offset > len(b) || offset < 0x76 {
+		return nil, errHeader
+	}
+	f := &file{
+		rsid:     string(b[:4]) == "RSID",
+		load:     be.Uint16(b[8:]),
+		init:     be.Uint16(b[0xa:]),
+		play:     be.Uint16(b[0xc:]),
+		songs:    int(be.Uint16(b[0xe:])),
+		start:    int(be.Uint16(b[0x10:])),
+		speed:    be.Uint32(b[0x12:]),
+		name:     headerString(b[0x16:0x36]),
+		author:   headerString(b[0x36:0x56]),
+		released: headerString(b[0x56:0x76]),
+		data:     b[offset:],
+		sids:     []uint16{0xd400},
+	}
+	if version >= 2 && offset >= 0x7c {
+		f.flags = be.Uint16(b[0x76:])
+		// Extra SIDs are at $Dxx0 for even xx in $42-$7F and $E0-$FE.
+		for _, x := range b[0x7a:0x7c] {
+			if x&1 == 0 && (x >= 0x42 && x < 0x80 || x >= 0xe0) {
+				f.sids = append(f.sids, 0xd000|uint16(x)<<4)
+			}
+			if version < 3 {
+				break
+			}
+		}
+	}
+	if f.load == 0 {
+		if len(f.data) < 2 {
+			return nil, errHeader
+		}
+		f.load = binary.LittleEndian.Uint16(f.data)
+		f.data = f.data[2:]
+	}
+	if int(f.load)+len(f.data) > 0x10000 {
+		return nil, errHeader
+	}
+	if f.init == 0 {
+		f.init = f.load
+	}
+	if f.songs < 1 {
+		f.songs = 1
+	}
+	if f.start < 1 || f.start > f.songs {
+		f.start = 1
+	}
+	f.sum = md5.Sum(b)
+	f.oldSum = f.oldMD5()
+	return f, nil
+}
+
+// oldMD5 returns the checksum used by Songlengths databases before HVSC
+// 6.8. It covers the data, the addresses, the song count, the speed of
+// each song and the clock.
+func (f *file) oldMD5() [md5.Size]byte {
+	h := md5.New()
+	h.Write(f.data)
+	binary.Write(h, binary.LittleEndian, []uint16{f.init, f.play, uint16(f.songs)})
+	for i := 1; i <= f.songs; i++ {
+		var b byte
+		if f.cia(i) {
+			b = 60
+		}
+		h.Write([]byte{b})
+	}
+	if f.ntsc() {
+		h.Write([]byte{2})
+	}
+	var sum [md5.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// cia returns whether song is timed by CIA 1 instead of the vertical blank.
+func (f *file) cia(song int) bool {
+	if song > 32 {
+		song = 32
+	}
+	return f.speed>>uint(song-1)&1 != 0
+}
+
+func (f *file) ntsc() bool {
+	return f.flags>>2&3 == 2
+}
+
+// model returns the chip model of the ith SID. Extra SIDs default to the
+// model of the first.
+func (f *file) model(i int) int {
+	m := f.flags >> 4 & 3
+	if i > 0 {
+		if n := f.flags >> uint(4+2*i) & 3; n != 0 {
+			m = n
+		}
+	}
+	if m == 2 {
+		return MOS8580
+	}
+	return MOS6581
+}
+
+func read(rf codec.Reader) (*file, error) {
+	r, _, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	f.size = int64(len(b))
+	return f, nil
+}
+
+// ReadSIDSongs returns a song for each tune in a SID file.
+func ReadSIDSongs(rf codec.Reader) ([]codec.Song, error) {
+	f, err := read(rf)
+	if err != nil {
+		return nil, err
+	}
+	songs := make([]codec.Song, f.songs)
+	for i := range songs {
+		songs[i] = &SIDSong{
+			Index:  i + 1,
+			Reader: rf,
+			f:      f,
+		}
+	}
+	return songs, nil
+}
+
+// SIDSong is one tune of a SID file.
+type SIDSong struct {
+	Index  int
+	Reader codec.Reader
+	f      *file
+	m      *c64
+	// played and length are in samples.
+	played, length int
+}
+
+func (s *SIDSong) file() (*file, error) {
+	if s.f != nil {
+		return s.f, nil
+	}
+	f, err := read(s.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return f, nil
+}
+
+func (s *SIDSong) Init() (sampleRate, channels int, err error) {
+	if s.m == nil {
+		f, err := s.file()
+		if err != nil {
+			return 0, 0, err
+		}
+		s.m = newC64(f, s.Index)
+		s.played = 0
+		s.length = int(songLength(f, s.Index) * rate / time.Second)
+	}
+	return rate, 1, nil
+}
+
+func (s *SIDSong) Info() (info codec.SongInfo, err error) {
+	f, err := s.file()
+	if err != nil {
+		return
+	}
+	title := f.name
+	if f.songs > 1 {
+		title = fmt.Sprintf("%s:%02d", f.name, s.Index)
+	}
+	info = codec.SongInfo{
+		Time:       songLength(f, s.Index),
+		Artist:     f.author,
+		Title:      title,
+		Album:      f.name,
+		Track:      float64(s.Index),
+		Comment:    f.released,
+		Codec:      "SID",
+		SampleRate: rate,
+		Channels:   1,
+		Size:       f.size,
+	}
+	// The released field starts with the year, like "1987 Firebird".
+	if y := strings.Fields(f.released); len(y) > 0 && len(y[0]) == 4 {
+		if _, err := strconv.Atoi(y[0]); err == nil {
+			info.Date = y[0]
+		}
+	}
+	return info, nil
+}
+
+func (s *SIDSong) Play(n int) ([]float32, error) {
+	if left := s.length - s.played; n > left {
+		n = left
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	out := make([]float32, n)
+	s.m.run(out)
+	s.played += n
+	return out, nil
+}
+
+// Seek seeks by emulating from the start, or from the current position when
+// seeking forward.
+func (s *SIDSong) Seek(offset time.Duration) error {
+	return codec.SeekSteps(s, offset)
+}
+
+// SeekStep emulates a step toward offset.
+func (s *SIDSong) SeekStep(offset time.Duration) (bool, error) {
+	if s.m == nil {
+		return false, errors.New("sid: seek before init")
+	}
+	target := int(offset * rate / time.Second)
+	if target > s.length {
+		target = s.length
+	}
+	if target < s.played {
+		s.m = newC64(s.f, s.Index)
+		s.played = 0
+	}
+	end := target
+	if step := int(codec.StepLength * rate / time.Second); end > s.played+step {
+		end = s.played + step
+	}
+	buf := make([]float32, 4096)
+	for s.played < end {
+		n := end - s.played
+		if n > len(buf) {
+			n = len(buf)
+		}
+		s.m.run(buf[:n])
+		s.played += n
+	}
+	return s.played >= target, nil
+}
+
+func (s *SIDSong) Close() {
+	s.m = nil
+}
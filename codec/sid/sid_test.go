@@ -0,0 +1,166 @@
+package sid
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// freqs are the high bytes of the voice 1 frequency of each song.
+var freqs = []byte{0x1d, 0x3a}
+
+// testFile returns a PAL 8580 PSID with two songs. Init plays a triangle
+// wave on voice 1 at the song's frequency, and the play routine mutes the
+// SID after 25 calls.
+func testFile() []byte {
+	b := make([]byte, 0x7c)
+	copy(b, "PSID")
+	be := binary.BigEndian
+	be.PutUint16(b[4:], 2)
+	be.PutUint16(b[6:], 0x7c)
+	be.PutUint16(b[8:], 0x1000)
+	be.PutUint16(b[0xa:], 0x1000)
+	be.PutUint16(b[0xc:], 0x1020)
+	be.PutUint16(b[0xe:], uint16(len(freqs)))
+	be.PutUint16(b[0x10:], 1)
+	copy(b[0x16:], "Triangle")
+	copy(b[0x36:], "Composer")
+	copy(b[0x56:], "1987 Test")
+	be.PutUint16(b[0x76:], 0x24)
+
+	code := make([]byte, 0x40)
+	copy(code, []byte{
+		0xaa,             // tax
+		0xbd, 0x30, 0x10, // lda $1030,x
+		0x8d, 0x01, 0xd4, // sta $d401
+		0xa9, 0x00, // lda #0
+		0x8d, 0x00, 0xd4, // sta $d400
+		0x8d, 0x05, 0xd4, // sta $d405
+		0xa9, 0xf0, // lda #$f0
+		0x8d, 0x06, 0xd4, // sta $d406
+		0xa9, 0x0f, // lda #$0f
+		0x8d, 0x18, 0xd4, // sta $d418
+		0xa9, 0x11, // lda #$11
+		0x8d, 0x04, 0xd4, // sta $d404
+		0x60, // rts
+	})
+	copy(code[0x20:], []byte{
+		0xe6, 0xfb, // inc $fb
+		0xa5, 0xfb, // lda $fb
+		0xc9, 25, // cmp #25
+		0xd0, 0x05, // bne +5
+		0xa9, 0x00, // lda #0
+		0x8d, 0x18, 0xd4, // sta $d418
+		0x60, // rts
+	})
+	copy(code[0x30:], freqs)
+	return append(b, code...)
+}
+
+// open returns song number song of b.
+func open(t *testing.T, b []byte, song int) codec.Song {
+	return codectest.Open(t, ReadSIDSongs, codectest.Reader(b), song-1, rate, 1)
+}
+
+func TestInfo(t *testing.T) {
+	b := testFile()
+	songs, _ := ReadSIDSongs(codectest.Reader(b))
+	info, err := songs[1].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Triangle:02" || info.Album != "Triangle" || info.Artist != "Composer" || info.Date != "1987" {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Time != DefaultDuration {
+		t.Fatalf("time %v", info.Time)
+	}
+}
+
+func TestSonglengths(t *testing.T) {
+	b := testFile()
+	sum := md5.Sum(b)
+	f, err := ioutil.TempFile("", "songlengths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, "[Database]\n; /Test.sid\n"+hex.EncodeToString(sum[:])+"=0:01 0:02.5(G)\n")
+	f.Close()
+	if err := LoadSonglengths(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		songlengths.Lock()
+		songlengths.m = nil
+		songlengths.Unlock()
+	}()
+	for i, want := range []time.Duration{time.Second, 2500 * time.Millisecond} {
+		s := open(t, b, i+1)
+		info, _ := s.Info()
+		if info.Time != want {
+			t.Errorf("song %d: time %v, want %v", i+1, info.Time, want)
+		}
+		if n := len(codectest.PlayAll(t, s, 4096)); n != int(want*rate/time.Second) {
+			t.Errorf("song %d: got %d samples", i+1, n)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	b := testFile()
+	for i, hi := range freqs {
+		s := open(t, b, i+1)
+		out := make([]float32, rate)
+		p, _ := s.Play(len(out))
+		copy(out, p)
+		// Count zero crossings from 0.1s to 0.4s, before the play routine
+		// mutes the SID.
+		crossings := 0
+		for j := rate / 10; j < rate*4/10; j++ {
+			if (out[j-1] < 0) != (out[j] < 0) {
+				crossings++
+			}
+		}
+		freq := float64(hi) * 256 * palClock / (1 << 24)
+		if want := 2 * freq * 0.3; math.Abs(float64(crossings)-want) > want*0.02 {
+			t.Errorf("song %d: %d zero crossings, want %.0f", i+1, crossings, want)
+		}
+		// The triangle spans the 12-bit range at full volume.
+		if r := codectest.RMS(out[rate/10:rate*4/10], 0, 1); math.Abs(r-1/(6*math.Sqrt(3))) > 0.01 {
+			t.Errorf("song %d: rms %v", i+1, r)
+		}
+		if r := codectest.RMS(out[rate*6/10:], 0, 1); r > 1e-3 {
+			t.Errorf("song %d: rms %v after muting", i+1, r)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	b := testFile()
+	s := open(t, b, 1)
+	want := make([]float32, rate)
+	p, _ := s.Play(len(want))
+	copy(want, p)
+	for _, offset := range []time.Duration{700 * time.Millisecond, 100 * time.Millisecond, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		w := want[int(offset*rate/time.Second):]
+		got, _ := s.Play(len(w))
+		for i := range w {
+			if got[i] != w[i] {
+				t.Fatalf("%v: sample %d is %v, want %v", offset, i, got[i], w[i])
+			}
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package codec
 
-import "time"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Song interface {
 	// Info returns information about a song.
@@ -16,6 +20,38 @@ type Song interface {
 	Close()
 }
 
+// Seeker is implemented by songs that can seek natively, without the caller
+// needing to keep decoded samples around.
+type Seeker interface {
+	// Seek positions the song so that the next call to Play returns samples
+	// starting at offset, rounded down to the nearest sample frame. It is only
+	// valid after Init.
+	Seek(offset time.Duration) error
+}
+
+// StepSeeker is implemented by Seekers that seek by running the song
+// forward, which can take long, so that callers can spread a seek out.
+type StepSeeker interface {
+	Seeker
+	// SeekStep runs a seek to offset forward by about StepLength of audio,
+	// and returns whether it is done. It is called with the same offset
+	// until it is, and no other method is called meanwhile. Seek does
+	// the whole seek at once.
+	SeekStep(offset time.Duration) (done bool, err error)
+}
+
+// StepLength is the length of audio a StepSeeker runs through per step.
+const StepLength = time.Second
+
+// SeekSteps does a whole seek of s to offset with SeekStep.
+func SeekSteps(s StepSeeker, offset time.Duration) error {
+	for {
+		if done, err := s.SeekStep(offset); done || err != nil {
+			return err
+		}
+	}
+}
+
 type SongInfo struct {
 	Time     time.Duration
 	Artist   string
@@ -23,4 +59,116 @@ type SongInfo struct {
 	Album    string
 	Track    float64
 	ImageURL string `json:",omitempty"`
+	// Comment is free text, like tracker module instrument names.
+	Comment string `json:",omitempty"`
+
+	AlbumArtist string `json:",omitempty"`
+	Composer    string `json:",omitempty"`
+	Genre       string `json:",omitempty"`
+	// Date is the release date as tagged, usually a year or an ISO 8601
+	// date.
+	Date string `json:",omitempty"`
+	// Disc is the disc number and DiscTotal the number of discs, or 0.
+	Disc        int  `json:",omitempty"`
+	DiscTotal   int  `json:",omitempty"`
+	Compilation bool `json:",omitempty"`
+
+	// Codec is the name of the audio format, like "MP3" or "ALAC".
+	Codec string `json:",omitempty"`
+	// Bitrate is the average bitrate in bits per second.
+	Bitrate    int `json:",omitempty"`
+	SampleRate int `json:",omitempty"`
+	Channels   int `json:",omitempty"`
+	// BitDepth is the number of bits per sample of lossless formats.
+	BitDepth int `json:",omitempty"`
+	// Size is the file size in bytes, or 0 if unknown.
+	Size int64 `json:",omitempty"`
+
+	// TrackGain and AlbumGain are the tagged ReplayGain of the song and its
+	// album, or nil.
+	TrackGain *Gain `json:",omitempty"`
+	AlbumGain *Gain `json:",omitempty"`
+	// trackPeak and albumPeak hold peak tags read before their gain tag.
+	trackPeak, albumPeak float64
+}
+
+// Gain is a ReplayGain adjustment. It is only set from a gain tag, as a
+// peak alone gives no gain.
+type Gain struct {
+	// DB is the gain in dB that brings the audio to -18 LUFS.
+	DB float64
+	// Peak is the largest absolute sample value, or 0 if unknown.
+	Peak float64 `json:",omitempty"`
+}
+
+// SetReplayGain sets the track or album gain of si from the ReplayGain or
+// Opus R128 tag name, case insensitively. It returns false if name is not
+// such a tag or value is malformed.
+func (si *SongInfo) SetReplayGain(name, value string) bool {
+	name = strings.ToLower(name)
+	var g **Gain
+	var peak *float64
+	switch {
+	case strings.Contains(name, "track"):
+		g, peak = &si.TrackGain, &si.trackPeak
+	case strings.Contains(name, "album"):
+		g, peak = &si.AlbumGain, &si.albumPeak
+	default:
+		return false
+	}
+	value = strings.TrimSpace(value)
+	var db float64
+	switch name {
+	case "replaygain_track_gain", "replaygain_album_gain":
+		v := strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(value, "dB"), "db"))
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+		db = f
+	case "r128_track_gain", "r128_album_gain":
+		// A Q7.8 number relative to -23 LUFS.
+		n, err := strconv.ParseInt(value, 10, 16)
+		if err != nil {
+			return false
+		}
+		db = float64(n)/256 + 5
+	case "replaygain_track_peak", "replaygain_album_peak":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return false
+		}
+		if *g == nil {
+			*peak = f
+		} else {
+			(*g).Peak = f
+		}
+		return true
+	default:
+		return false
+	}
+	if *g == nil {
+		*g = &Gain{Peak: *peak}
+	}
+	(*g).DB = db
+	return true
+}
+
+// Bitrate returns the average bitrate in bits per second of size bytes that
+// play for d.
+func Bitrate(size int64, d time.Duration) int {
+	if size <= 0 || d <= 0 {
+		return 0
+	}
+	return int(float64(size) * 8 / d.Seconds())
+}
+
+// ParseNumber parses a number with an optional total, like "3" or "3/12".
+func ParseNumber(s string) (n, total int) {
+	sp := strings.SplitN(s, "/", 2)
+	n, _ = strconv.Atoi(strings.TrimSpace(sp[0]))
+	if len(sp) == 2 {
+		total, _ = strconv.Atoi(strings.TrimSpace(sp[1]))
+	}
+	return
 }
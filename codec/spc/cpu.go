@@ -0,0 +1,727 @@
+package spc
+
+// Processor status flags.
+const (
+	flagC = 1 << iota
+	flagZ
+	flagI
+	flagH
+	flagB
+	flagP
+	flagV
+	flagN
+)
+
+// cpuCycles is the number of cycles taken by each opcode. Taken branches
+// take two more.
+var cpuCycles = [256]uint8{
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 6, 5, 4, 5, 4, 6, 8,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 6, 5, 2, 2, 4, 6,
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 6, 5, 4, 5, 4, 5, 4,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 6, 5, 2, 2, 3, 8,
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 6, 4, 4, 5, 4, 6, 6,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 4, 5, 2, 2, 4, 3,
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 6, 4, 4, 5, 4, 5, 5,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 5, 5, 2, 2, 3, 6,
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 6, 5, 4, 5, 2, 4, 5,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 5, 5, 2, 2, 12, 5,
+	3, 8, 4, 5, 3, 4, 3, 6, 2, 6, 4, 4, 5, 2, 4, 4,
+	2, 8, 4, 5, 4, 5, 5, 6, 5, 5, 5, 5, 2, 2, 3, 4,
+	3, 8, 4, 5, 4, 5, 4, 7, 2, 5, 6, 4, 5, 2, 4, 9,
+	2, 8, 4, 5, 5, 6, 6, 7, 4, 5, 5, 5, 2, 2, 6, 3,
+	2, 8, 4, 5, 3, 4, 3, 6, 2, 4, 5, 3, 4, 3, 4, 3,
+	2, 8, 4, 5, 4, 5, 5, 6, 3, 4, 5, 4, 2, 2, 4, 3,
+}
+
+// iplROM is the boot ROM, mapped at $FFC0 when enabled in CONTROL.
+var iplROM = [64]byte{
+	0xcd, 0xef, 0xbd, 0xe8, 0x00, 0xc6, 0x1d, 0xd0,
+	0xfc, 0x8f, 0xaa, 0xf4, 0x8f, 0xbb, 0xf5, 0x78,
+	0xcc, 0xf4, 0xd0, 0xfb, 0x2f, 0x19, 0xeb, 0xf4,
+	0xd0, 0xfc, 0x7e, 0xf4, 0xd0, 0x0b, 0xe4, 0xf5,
+	0xcb, 0xf4, 0xd7, 0x00, 0xfc, 0xd0, 0xf3, 0xab,
+	0x01, 0x10, 0xef, 0x7e, 0xf4, 0x10, 0xeb, 0xba,
+	0xf6, 0xda, 0x00, 0xba, 0xf4, 0xc4, 0xf4, 0xdd,
+	0x5d, 0xd0, 0xdb, 0x1f, 0x00, 0x00, 0xc0, 0xff,
+}
+
+// The CPU runs at 1.024 MHz, producing one 32 kHz sample every 32 cycles.
+const cyclesPerSample = 32
+
+type timer struct {
+	// rate is the number of cycles per tick.
+	rate    int
+	div     int
+	enabled bool
+	stage   byte
+	target  byte
+	// counter is the 4-bit value read from the counter register.
+	counter byte
+}
+
+func (t *timer) run(cycles int) {
+	t.div += cycles
+	for t.div >= t.rate {
+		t.div -= t.rate
+		if !t.enabled {
+			continue
+		}
+		// A target of 0 counts 256 ticks.
+		t.stage++
+		if t.stage == t.target {
+			t.stage = 0
+			t.counter = (t.counter + 1) & 0xf
+		}
+	}
+}
+
+// emu emulates the SPC700, its timers and memory mapped registers, and the
+// attached DSP.
+type emu struct {
+	ram              [0x10000]byte
+	a, x, y, sp, psw byte
+	pc               uint16
+	// cycles is the number of cycles run since the last sample.
+	cycles int
+	halted bool
+	timers [3]timer
+	// control is the CONTROL register.
+	control byte
+	dspAddr byte
+	// ports hold the values last written by the main CPU.
+	ports [4]byte
+	dsp   dsp
+}
+
+func newEmu(f *file) *emu {
+	e := &emu{
+		ram: f.ram,
+		pc:  f.pc,
+		a:   f.a,
+		x:   f.x,
+		y:   f.y,
+		sp:  f.sp,
+		psw: f.psw,
+	}
+	e.timers[0].rate = 128
+	e.timers[1].rate = 128
+	e.timers[2].rate = 16
+	for i := range e.timers {
+		t := &e.timers[i]
+		t.enabled = e.ram[0xf1]&(1<<uint(i)) != 0
+		t.target = e.ram[0xfa+i]
+		t.counter = e.ram[0xfd+i] & 0xf
+	}
+	e.control = e.ram[0xf1]
+	e.dspAddr = e.ram[0xf2]
+	copy(e.ports[:], e.ram[0xf4:])
+	if e.control&0x80 != 0 {
+		// The RAM hidden under the IPL ROM is stored separately.
+		copy(e.ram[0xffc0:], f.extra[:])
+	}
+	e.dsp.load(&e.ram, f.dsp)
+	return e
+}
+
+// run fills out with interleaved stereo samples.
+func (e *emu) run(out []float32) {
+	for i := 0; i+1 < len(out); i += 2 {
+		for e.cycles < cyclesPerSample {
+			e.step()
+		}
+		e.cycles -= cyclesPerSample
+		l, r := e.dsp.sample()
+		out[i] = float32(l) / 32768
+		out[i+1] = float32(r) / 32768
+	}
+}
+
+func (e *emu) read(addr uint16) byte {
+	switch {
+	case addr >= 0xf0 && addr <= 0xff:
+		switch addr {
+		case 0xf2:
+			return e.dspAddr
+		case 0xf3:
+			return e.dsp.read(e.dspAddr & 0x7f)
+		case 0xf4, 0xf5, 0xf6, 0xf7:
+			return e.ports[addr-0xf4]
+		case 0xf8, 0xf9:
+			return e.ram[addr]
+		case 0xfd, 0xfe, 0xff:
+			t := &e.timers[addr-0xfd]
+			v := t.counter
+			t.counter = 0
+			return v
+		}
+		return 0
+	case addr >= 0xffc0 && e.control&0x80 != 0:
+		return iplROM[addr-0xffc0]
+	}
+	return e.ram[addr]
+}
+
+func (e *emu) write(addr uint16, v byte) {
+	e.ram[addr] = v
+	switch addr {
+	case 0xf1:
+		for i := range e.timers {
+			t := &e.timers[i]
+			on := v&(1<<uint(i)) != 0
+			if on && !t.enabled {
+				t.stage = 0
+				t.counter = 0
+			}
+			t.enabled = on
+		}
+		if v&0x10 != 0 {
+			e.ports[0], e.ports[1] = 0, 0
+		}
+		if v&0x20 != 0 {
+			e.ports[2], e.ports[3] = 0, 0
+		}
+		e.control = v
+	case 0xf2:
+		e.dspAddr = v
+	case 0xf3:
+		if e.dspAddr < 0x80 {
+			e.dsp.write(e.dspAddr, v)
+		}
+	case 0xfa, 0xfb, 0xfc:
+		e.timers[addr-0xfa].target = v
+	}
+}
+
+func (e *emu) fetch() byte {
+	v := e.read(e.pc)
+	e.pc++
+	return v
+}
+
+func (e *emu) fetch16() uint16 {
+	lo := e.fetch()
+	return uint16(lo) | uint16(e.fetch())<<8
+}
+
+// dp returns the address of a in the direct page.
+func (e *emu) dp(a byte) uint16 {
+	if e.psw&flagP != 0 {
+		return 0x100 | uint16(a)
+	}
+	return uint16(a)
+}
+
+// read16dp reads a word from the direct page. The high byte wraps within
+// the page.
+func (e *emu) read16dp(a byte) uint16 {
+	return uint16(e.read(e.dp(a))) | uint16(e.read(e.dp(a+1)))<<8
+}
+
+func (e *emu) push(v byte) {
+	e.write(0x100|uint16(e.sp), v)
+	e.sp--
+}
+
+func (e *emu) pop() byte {
+	e.sp++
+	return e.read(0x100 | uint16(e.sp))
+}
+
+func (e *emu) push16(v uint16) {
+	e.push(byte(v >> 8))
+	e.push(byte(v))
+}
+
+func (e *emu) pop16() uint16 {
+	lo := e.pop()
+	return uint16(lo) | uint16(e.pop())<<8
+}
+
+func (e *emu) setFlag(f byte, on bool) {
+	if on {
+		e.psw |= f
+	} else {
+		e.psw &^= f
+	}
+}
+
+// nz sets the N and Z flags from v and returns it.
+func (e *emu) nz(v byte) byte {
+	e.setFlag(flagN, v&0x80 != 0)
+	e.setFlag(flagZ, v == 0)
+	return v
+}
+
+func (e *emu) nz16(v uint16) {
+	e.setFlag(flagN, v&0x8000 != 0)
+	e.setFlag(flagZ, v == 0)
+}
+
+func (e *emu) adc(a, b byte) byte {
+	r := uint(a) + uint(b) + uint(e.psw&flagC)
+	e.setFlag(flagC, r > 0xff)
+	e.setFlag(flagH, (uint(a)^uint(b)^r)&0x10 != 0)
+	e.setFlag(flagV, ^(a^b)&(a^byte(r))&0x80 != 0)
+	return e.nz(byte(r))
+}
+
+func (e *emu) cmp(a, b byte) {
+	r := int(a) - int(b)
+	e.setFlag(flagC, r >= 0)
+	e.nz(byte(r))
+}
+
+// alu performs OR, AND, EOR, CMP, ADC or SBC, as selected by the high bits
+// of the opcode.
+func (e *emu) alu(op byte, a, b byte) byte {
+	switch op >> 5 {
+	case 0:
+		return e.nz(a | b)
+	case 1:
+		return e.nz(a & b)
+	case 2:
+		return e.nz(a ^ b)
+	case 3:
+		e.cmp(a, b)
+		return a
+	case 4:
+		return e.adc(a, b)
+	}
+	return e.adc(a, ^b)
+}
+
+// shift performs ASL, ROL, LSR, ROR, DEC or INC, as selected by the high
+// bits of the opcode.
+func (e *emu) shift(op byte, v byte) byte {
+	c := e.psw & flagC
+	switch op >> 5 {
+	case 0:
+		e.setFlag(flagC, v&0x80 != 0)
+		return e.nz(v << 1)
+	case 1:
+		e.setFlag(flagC, v&0x80 != 0)
+		return e.nz(v<<1 | c)
+	case 2:
+		e.setFlag(flagC, v&1 != 0)
+		return e.nz(v >> 1)
+	case 3:
+		e.setFlag(flagC, v&1 != 0)
+		return e.nz(v>>1 | c<<7)
+	case 4:
+		return e.nz(v - 1)
+	}
+	return e.nz(v + 1)
+}
+
+// operand returns the address of the operand for columns 4 through 7 of the
+// ALU and MOV rows.
+func (e *emu) operand(op byte) uint16 {
+	switch op & 0x1f {
+	case 0x04:
+		return e.dp(e.fetch())
+	case 0x05:
+		return e.fetch16()
+	case 0x06:
+		return e.dp(e.x)
+	case 0x07:
+		return e.read16dp(e.fetch() + e.x)
+	case 0x14:
+		return e.dp(e.fetch() + e.x)
+	case 0x15:
+		return e.fetch16() + uint16(e.x)
+	case 0x16:
+		return e.fetch16() + uint16(e.y)
+	}
+	return e.read16dp(e.fetch()) + uint16(e.y)
+}
+
+// memBit returns the address and bit of a 13-bit absolute bit operand.
+func (e *emu) memBit() (uint16, uint) {
+	v := e.fetch16()
+	return v & 0x1fff, uint(v >> 13)
+}
+
+// branch reads a relative offset and jumps if cond, returning the extra
+// cycles taken.
+func (e *emu) branch(cond bool) int {
+	r := int8(e.fetch())
+	if !cond {
+		return 0
+	}
+	e.pc += uint16(r)
+	return 2
+}
+
+// step executes one instruction.
+func (e *emu) step() {
+	if e.halted {
+		e.tick(cyclesPerSample)
+		return
+	}
+	op := e.fetch()
+	cycles := int(cpuCycles[op])
+	lo, hi := op&0xf, op>>4
+	switch {
+	case hi < 0xc && lo >= 4 && lo <= 9:
+		switch {
+		case lo <= 7:
+			e.a = e.alu(op, e.a, e.read(e.operand(op)))
+		case hi&1 == 0 && lo == 8:
+			e.a = e.alu(op, e.a, e.fetch())
+		default:
+			var src byte
+			var dst uint16
+			switch op & 0x1f {
+			case 0x09:
+				src = e.read(e.dp(e.fetch()))
+				dst = e.dp(e.fetch())
+			case 0x18:
+				src = e.fetch()
+				dst = e.dp(e.fetch())
+			case 0x19:
+				src = e.read(e.dp(e.y))
+				dst = e.dp(e.x)
+			}
+			r := e.alu(op, e.read(dst), src)
+			if op>>5 != 3 {
+				e.write(dst, r)
+			}
+		}
+	case hi < 0xc && (lo == 0xb || lo == 0xc):
+		switch {
+		case op&0x1f == 0x1c:
+			e.a = e.shift(op, e.a)
+		default:
+			var addr uint16
+			switch op & 0x1f {
+			case 0x0b:
+				addr = e.dp(e.fetch())
+			case 0x0c:
+				addr = e.fetch16()
+			case 0x1b:
+				addr = e.dp(e.fetch() + e.x)
+			}
+			e.write(addr, e.shift(op, e.read(addr)))
+		}
+	case hi >= 0xc && lo >= 4 && lo <= 7:
+		if hi >= 0xe {
+			e.a = e.nz(e.read(e.operand(op)))
+		} else {
+			e.write(e.operand(op), e.a)
+		}
+	case lo == 0 && hi&1 != 0:
+		var f byte
+		switch hi >> 2 {
+		case 0:
+			f = flagN
+		case 1:
+			f = flagV
+		case 2:
+			f = flagC
+		case 3:
+			f = flagZ
+		}
+		cycles += e.branch((e.psw&f != 0) == (hi&2 != 0))
+	case lo == 1:
+		e.push16(e.pc)
+		addr := 0xffde - 2*uint16(hi)
+		e.pc = uint16(e.read(addr)) | uint16(e.read(addr+1))<<8
+	case lo == 2:
+		addr := e.dp(e.fetch())
+		bit := byte(1) << (hi >> 1)
+		if hi&1 == 0 {
+			e.write(addr, e.read(addr)|bit)
+		} else {
+			e.write(addr, e.read(addr)&^bit)
+		}
+	case lo == 3:
+		v := e.read(e.dp(e.fetch()))
+		set := v&(1<<(hi>>1)) != 0
+		cycles += e.branch(set == (hi&1 == 0))
+	default:
+		cycles += e.exec(op)
+	}
+	e.tick(cycles)
+}
+
+// exec executes the opcodes not handled by the regular rows, returning any
+// extra cycles taken.
+func (e *emu) exec(op byte) int {
+	switch op {
+	case 0x00:
+	case 0x20:
+		e.psw &^= flagP
+	case 0x40:
+		e.psw |= flagP
+	case 0x60:
+		e.psw &^= flagC
+	case 0x80:
+		e.psw |= flagC
+	case 0xa0:
+		e.psw |= flagI
+	case 0xc0:
+		e.psw &^= flagI
+	case 0xe0:
+		e.psw &^= flagV | flagH
+	case 0xed:
+		e.psw ^= flagC
+	case 0x0a, 0x2a, 0x4a, 0x6a, 0x8a, 0xaa:
+		addr, bit := e.memBit()
+		b := e.read(addr)>>bit&1 != 0
+		c := e.psw&flagC != 0
+		switch op {
+		case 0x0a:
+			c = c || b
+		case 0x2a:
+			c = c || !b
+		case 0x4a:
+			c = c && b
+		case 0x6a:
+			c = c && !b
+		case 0x8a:
+			c = c != b
+		case 0xaa:
+			c = b
+		}
+		e.setFlag(flagC, c)
+	case 0xca:
+		addr, bit := e.memBit()
+		v := e.read(addr) &^ (1 << bit)
+		if e.psw&flagC != 0 {
+			v |= 1 << bit
+		}
+		e.write(addr, v)
+	case 0xea:
+		addr, bit := e.memBit()
+		e.write(addr, e.read(addr)^(1<<bit))
+	case 0x0d:
+		e.push(e.psw)
+	case 0x2d:
+		e.push(e.a)
+	case 0x4d:
+		e.push(e.x)
+	case 0x6d:
+		e.push(e.y)
+	case 0x8e:
+		e.psw = e.pop()
+	case 0xae:
+		e.a = e.pop()
+	case 0xce:
+		e.x = e.pop()
+	case 0xee:
+		e.y = e.pop()
+	case 0x0e, 0x4e:
+		addr := e.fetch16()
+		v := e.read(addr)
+		e.nz(e.a - v)
+		if op == 0x0e {
+			v |= e.a
+		} else {
+			v &^= e.a
+		}
+		e.write(addr, v)
+	case 0x0f:
+		e.push16(e.pc)
+		e.push(e.psw)
+		e.psw |= flagB
+		e.psw &^= flagI
+		e.pc = uint16(e.read(0xffde)) | uint16(e.read(0xffdf))<<8
+	case 0x1a, 0x3a:
+		d := e.fetch()
+		v := e.read16dp(d)
+		if op == 0x1a {
+			v--
+		} else {
+			v++
+		}
+		e.write(e.dp(d), byte(v))
+		e.write(e.dp(d+1), byte(v>>8))
+		e.nz16(v)
+	case 0x1d:
+		e.x = e.nz(e.x - 1)
+	case 0x3d:
+		e.x = e.nz(e.x + 1)
+	case 0xdc:
+		e.y = e.nz(e.y - 1)
+	case 0xfc:
+		e.y = e.nz(e.y + 1)
+	case 0x1e:
+		e.cmp(e.x, e.read(e.fetch16()))
+	case 0x3e:
+		e.cmp(e.x, e.read(e.dp(e.fetch())))
+	case 0xc8:
+		e.cmp(e.x, e.fetch())
+	case 0x5e:
+		e.cmp(e.y, e.read(e.fetch16()))
+	case 0x7e:
+		e.cmp(e.y, e.read(e.dp(e.fetch())))
+	case 0xad:
+		e.cmp(e.y, e.fetch())
+	case 0x1f:
+		addr := e.fetch16() + uint16(e.x)
+		e.pc = uint16(e.read(addr)) | uint16(e.read(addr+1))<<8
+	case 0x5f:
+		e.pc = e.fetch16()
+	case 0x2e:
+		v := e.read(e.dp(e.fetch()))
+		return e.branch(e.a != v)
+	case 0xde:
+		v := e.read(e.dp(e.fetch() + e.x))
+		return e.branch(e.a != v)
+	case 0x6e:
+		addr := e.dp(e.fetch())
+		v := e.read(addr) - 1
+		e.write(addr, v)
+		return e.branch(v != 0)
+	case 0xfe:
+		e.y--
+		return e.branch(e.y != 0)
+	case 0x2f:
+		return e.branch(true)
+	case 0x3f:
+		addr := e.fetch16()
+		e.push16(e.pc)
+		e.pc = addr
+	case 0x4f:
+		u := e.fetch()
+		e.push16(e.pc)
+		e.pc = 0xff00 | uint16(u)
+	case 0x6f:
+		e.pc = e.pop16()
+	case 0x7f:
+		e.psw = e.pop()
+		e.pc = e.pop16()
+	case 0x5a, 0x7a, 0x9a:
+		m := e.read16dp(e.fetch())
+		ya := uint16(e.y)<<8 | uint16(e.a)
+		var r uint16
+		switch op {
+		case 0x5a:
+			e.setFlag(flagC, ya >= m)
+			e.nz16(ya - m)
+			return 0
+		case 0x7a:
+			r = ya + m
+			e.setFlag(flagC, uint32(ya)+uint32(m) > 0xffff)
+			e.setFlag(flagH, (ya^m^r)&0x1000 != 0)
+			e.setFlag(flagV, ^(ya^m)&(ya^r)&0x8000 != 0)
+		case 0x9a:
+			r = ya - m
+			e.setFlag(flagC, ya >= m)
+			e.setFlag(flagH, (ya^m^r)&0x1000 == 0)
+			e.setFlag(flagV, (ya^m)&(ya^r)&0x8000 != 0)
+		}
+		e.a, e.y = byte(r), byte(r>>8)
+		e.nz16(r)
+	case 0xba:
+		v := e.read16dp(e.fetch())
+		e.a, e.y = byte(v), byte(v>>8)
+		e.nz16(v)
+	case 0xda:
+		d := e.fetch()
+		e.write(e.dp(d), e.a)
+		e.write(e.dp(d+1), e.y)
+	case 0x5d:
+		e.x = e.nz(e.a)
+	case 0x7d:
+		e.a = e.nz(e.x)
+	case 0x9d:
+		e.x = e.nz(e.sp)
+	case 0xbd:
+		e.sp = e.x
+	case 0xdd:
+		e.a = e.nz(e.y)
+	case 0xfd:
+		e.y = e.nz(e.a)
+	case 0x8d:
+		e.y = e.nz(e.fetch())
+	case 0xcd:
+		e.x = e.nz(e.fetch())
+	case 0xe8:
+		e.a = e.nz(e.fetch())
+	case 0x8f:
+		v := e.fetch()
+		e.write(e.dp(e.fetch()), v)
+	case 0xfa:
+		v := e.read(e.dp(e.fetch()))
+		e.write(e.dp(e.fetch()), v)
+	case 0xaf:
+		e.write(e.dp(e.x), e.a)
+		e.x++
+	case 0xbf:
+		e.a = e.nz(e.read(e.dp(e.x)))
+		e.x++
+	case 0xc9:
+		e.write(e.fetch16(), e.x)
+	case 0xcc:
+		e.write(e.fetch16(), e.y)
+	case 0xcb:
+		e.write(e.dp(e.fetch()), e.y)
+	case 0xd8:
+		e.write(e.dp(e.fetch()), e.x)
+	case 0xd9:
+		e.write(e.dp(e.fetch()+e.y), e.x)
+	case 0xdb:
+		e.write(e.dp(e.fetch()+e.x), e.y)
+	case 0xe9:
+		e.x = e.nz(e.read(e.fetch16()))
+	case 0xec:
+		e.y = e.nz(e.read(e.fetch16()))
+	case 0xeb:
+		e.y = e.nz(e.read(e.dp(e.fetch())))
+	case 0xf8:
+		e.x = e.nz(e.read(e.dp(e.fetch())))
+	case 0xf9:
+		e.x = e.nz(e.read(e.dp(e.fetch() + e.y)))
+	case 0xfb:
+		e.y = e.nz(e.read(e.dp(e.fetch() + e.x)))
+	case 0xcf:
+		v := uint16(e.y) * uint16(e.a)
+		e.a, e.y = byte(v), byte(v>>8)
+		e.nz(e.y)
+	case 0x9e:
+		ya, x := int(e.y)<<8|int(e.a), int(e.x)
+		e.setFlag(flagV, e.y >= e.x)
+		e.setFlag(flagH, e.y&0xf >= e.x&0xf)
+		if int(e.y) < x<<1 {
+			e.a, e.y = byte(ya/x), byte(ya%x)
+		} else {
+			// The quotient overflows; this matches the hardware's result.
+			e.a = byte(255 - (ya-x<<9)/(256-x))
+			e.y = byte(x + (ya-x<<9)%(256-x))
+		}
+		e.nz(e.a)
+	case 0x9f:
+		e.a = e.nz(e.a>>4 | e.a<<4)
+	case 0xdf:
+		if e.psw&flagC != 0 || e.a > 0x99 {
+			e.a += 0x60
+			e.psw |= flagC
+		}
+		if e.psw&flagH != 0 || e.a&0xf > 9 {
+			e.a += 6
+		}
+		e.nz(e.a)
+	case 0xbe:
+		if e.psw&flagC == 0 || e.a > 0x99 {
+			e.a -= 0x60
+			e.psw &^= flagC
+		}
+		if e.psw&flagH == 0 || e.a&0xf > 9 {
+			e.a -= 6
+		}
+		e.nz(e.a)
+	case 0xef, 0xff:
+		// SLEEP and STOP halt until reset.
+		e.halted = true
+	}
+	return 0
+}
+
+// tick advances the timers and the sample clock.
+func (e *emu) tick(cycles int) {
+	e.cycles += cycles
+	for i := range e.timers {
+		e.timers[i].run(cycles)
+	}
+}
@@ -0,0 +1,440 @@
+package spc
+
+import "math"
+
+// Voice register offsets.
+const (
+	vVolL = iota
+	vVolR
+	vPitchL
+	vPitchH
+	vSrcn
+	vADSR1
+	vADSR2
+	vGain
+	vEnvx
+	vOutx
+)
+
+// Global register addresses.
+const (
+	rMVolL = 0x0c
+	rMVolR = 0x1c
+	rEVolL = 0x2c
+	rEVolR = 0x3c
+	rKON   = 0x4c
+	rKOFF  = 0x5c
+	rFLG   = 0x6c
+	rENDX  = 0x7c
+	rEFB   = 0x0d
+	rPMON  = 0x2d
+	rNON   = 0x3d
+	rEON   = 0x4d
+	rDIR   = 0x5d
+	rESA   = 0x6d
+	rEDL   = 0x7d
+	rFIR   = 0x0f
+)
+
+// Envelope modes.
+const (
+	envRelease = iota
+	envAttack
+	envDecay
+	envSustain
+)
+
+const brrBlockSize = 9
+
+// counterRates is the number of samples between envelope and noise updates
+// at each rate. Rate 0 never updates.
+var counterRates = [32]int{
+	0, 2048, 1536, 1280, 1024, 768, 640, 512,
+	384, 320, 256, 192, 160, 128, 96, 80,
+	64, 48, 40, 32, 24, 20, 16, 12,
+	10, 8, 6, 5, 4, 3, 2, 1,
+}
+
+var counterOffsets = [32]int{
+	1, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	0, 0,
+}
+
+// gauss is the rising half of the interpolation kernel. The hardware uses a
+// fixed table; this approximates it with a Gaussian whose four taps sum to
+// about unity gain.
+var gauss [512]int
+
+func init() {
+	const sigma = 0.628
+	for i := range gauss {
+		t := (511.5 - float64(i)) / 256
+		gauss[i] = int(1300 * math.Exp(-t*t/(2*sigma*sigma)))
+	}
+}
+
+func clamp16(v int) int {
+	if v < -32768 {
+		return -32768
+	}
+	if v > 32767 {
+		return 32767
+	}
+	return v
+}
+
+type voice struct {
+	// buf holds the last 12 decoded samples twice so interpolation never
+	// needs to wrap.
+	buf       [24]int
+	bufPos    int
+	interpPos int
+	brrAddr   int
+	brrOffset int
+	konDelay  int
+	envMode   int
+	env       int
+	hiddenEnv int
+}
+
+// dsp emulates the S-DSP. It runs one sample at a time rather than
+// emulating each cycle.
+type dsp struct {
+	ram    *[0x10000]byte
+	regs   [128]byte
+	voices [8]voice
+	newKON byte
+	kon    byte
+	koff   byte
+	// everyOther toggles each sample; KON and KOFF are polled on every
+	// other sample.
+	everyOther bool
+	counter    int
+	noise      int
+	echoOffset int
+	echoLength int
+	// echoHist holds the last 8 echo samples twice.
+	echoHist    [16][2]int
+	echoHistPos int
+}
+
+func (d *dsp) load(ram *[0x10000]byte, regs [128]byte) {
+	d.ram = ram
+	d.regs = regs
+	for i := range d.voices {
+		d.voices[i] = voice{brrOffset: 1}
+	}
+	d.newKON = regs[rKON]
+	d.noise = 0x4000
+	d.everyOther = true
+	if regs[rFLG]&0x20 == 0 {
+		// Clear the echo buffer, which holds garbage in most files.
+		start := int(regs[rESA]) * 0x100
+		end := start + int(regs[rEDL]&0xf)*0x800
+		if end > len(ram) {
+			end = len(ram)
+		}
+		for i := start; i < end; i++ {
+			ram[i] = 0
+		}
+	}
+}
+
+func (d *dsp) read(addr byte) byte {
+	return d.regs[addr]
+}
+
+func (d *dsp) write(addr, v byte) {
+	d.regs[addr] = v
+	switch addr {
+	case rKON:
+		d.newKON = v
+	case rENDX:
+		d.regs[rENDX] = 0
+	}
+}
+
+func (d *dsp) read16(addr int) int {
+	return int(d.ram[addr&0xffff]) | int(d.ram[(addr+1)&0xffff])<<8
+}
+
+// tick reports whether an event at rate happens this sample.
+func (d *dsp) tick(rate int) bool {
+	if rate == 0 {
+		return false
+	}
+	return (d.counter+counterOffsets[rate])%counterRates[rate] == 0
+}
+
+// sample runs the DSP for one sample and returns its output.
+func (d *dsp) sample() (left, right int) {
+	if d.counter--; d.counter < 0 {
+		d.counter = 0x77ff
+	}
+	if d.tick(int(d.regs[rFLG] & 0x1f)) {
+		feedback := d.noise<<13 ^ d.noise<<14
+		d.noise = feedback&0x4000 ^ d.noise>>1
+	}
+	if d.everyOther = !d.everyOther; d.everyOther {
+		d.newKON &^= d.kon
+		d.kon = d.newKON
+		d.koff = d.regs[rKOFF]
+	}
+	var mainL, mainR, echoL, echoR, pmonInput int
+	dir := int(d.regs[rDIR]) * 0x100
+	for i := range d.voices {
+		v := &d.voices[i]
+		vr := d.regs[i<<4 : i<<4+0x10]
+		bit := byte(1) << uint(i)
+		header := d.ram[v.brrAddr]
+		pitch := int(vr[vPitchL]) | int(vr[vPitchH]&0x3f)<<8
+		if i > 0 && d.regs[rPMON]&bit != 0 {
+			pitch += (pmonInput >> 5 * pitch) >> 10
+		}
+		if v.konDelay > 0 {
+			if v.konDelay == 5 {
+				v.brrAddr = d.read16(dir + int(vr[vSrcn])*4)
+				v.brrOffset = 1
+				v.bufPos = 0
+				// The header is ignored on this sample.
+				header = 0
+			}
+			// The envelope does not run during KON.
+			v.env, v.hiddenEnv = 0, 0
+			// Decoding starts on the last three samples.
+			if v.konDelay--; v.konDelay&3 != 0 {
+				v.interpPos = 0x4000
+			} else {
+				v.interpPos = 0
+			}
+			pitch = 0
+		}
+
+		// Gaussian interpolation.
+		off := v.interpPos >> 4 & 0xff
+		in := v.buf[v.bufPos+v.interpPos>>12:]
+		out := gauss[255-off] * in[0] >> 11
+		out += gauss[511-off] * in[1] >> 11
+		out += gauss[256+off] * in[2] >> 11
+		out = int(int16(out))
+		out += gauss[off] * in[3] >> 11
+		out = clamp16(out) &^ 1
+		if d.regs[rNON]&bit != 0 {
+			out = int(int16(d.noise * 2))
+		}
+		out = out * v.env >> 11 &^ 1
+
+		// The end of a sample without a loop or a soft reset silence the
+		// voice immediately.
+		if d.regs[rFLG]&0x80 != 0 || header&3 == 1 {
+			v.envMode = envRelease
+			v.env = 0
+		}
+		if d.everyOther {
+			if d.koff&bit != 0 {
+				v.envMode = envRelease
+			}
+			if d.kon&bit != 0 {
+				v.konDelay = 5
+				v.envMode = envAttack
+				d.regs[rENDX] &^= bit
+			}
+		}
+		if v.konDelay == 0 {
+			d.envelope(v, vr)
+		}
+		pmonInput = out
+		vr[vOutx] = byte(out >> 8)
+		vr[vEnvx] = byte(v.env >> 4)
+
+		l := out * int(int8(vr[vVolL])) >> 7
+		r := out * int(int8(vr[vVolR])) >> 7
+		mainL = clamp16(mainL + l)
+		mainR = clamp16(mainR + r)
+		if d.regs[rEON]&bit != 0 {
+			echoL = clamp16(echoL + l)
+			echoR = clamp16(echoR + r)
+		}
+
+		if v.interpPos >= 0x4000 {
+			d.decodeBRR(v)
+			if v.brrOffset += 2; v.brrOffset >= brrBlockSize {
+				v.brrAddr = (v.brrAddr + brrBlockSize) & 0xffff
+				if header&1 != 0 {
+					v.brrAddr = d.read16(dir + int(vr[vSrcn])*4 + 2)
+					d.regs[rENDX] |= bit
+				}
+				v.brrOffset = 1
+			}
+		}
+		v.interpPos = v.interpPos&0x3fff + pitch
+		if v.interpPos > 0x7fff {
+			v.interpPos = 0x7fff
+		}
+	}
+
+	// Echo.
+	echoAddr := int(d.regs[rESA])*0x100 + d.echoOffset
+	if d.echoOffset == 0 {
+		d.echoLength = int(d.regs[rEDL]&0xf) * 0x800
+	}
+	if d.echoOffset += 4; d.echoOffset >= d.echoLength {
+		d.echoOffset = 0
+	}
+	if d.echoHistPos++; d.echoHistPos >= 8 {
+		d.echoHistPos = 0
+	}
+	hist := d.echoHist[d.echoHistPos:]
+	for c := 0; c < 2; c++ {
+		s := int(int16(d.read16(echoAddr+2*c))) >> 1
+		hist[0][c], hist[8][c] = s, s
+	}
+	var fir [2]int
+	for c := range fir {
+		// The first seven taps wrap; the last clamps.
+		sum := 0
+		for i := 0; i < 7; i++ {
+			sum += hist[i+1][c] * int(int8(d.regs[rFIR+i<<4])) >> 6
+		}
+		sum = int(int16(sum))
+		sum += hist[0][c] * int(int8(d.regs[rFIR+7<<4])) >> 6
+		fir[c] = clamp16(sum) &^ 1
+	}
+	if d.regs[rFLG]&0x20 == 0 {
+		efb := int(int8(d.regs[rEFB]))
+		for c, e := range [2]int{echoL, echoR} {
+			s := clamp16(e+fir[c]*efb>>7) &^ 1
+			addr := echoAddr + 2*c
+			d.ram[addr&0xffff] = byte(s)
+			d.ram[(addr+1)&0xffff] = byte(s >> 8)
+		}
+	}
+
+	if d.regs[rFLG]&0x40 != 0 {
+		return 0, 0
+	}
+	left = clamp16(mainL*int(int8(d.regs[rMVolL]))>>7 + fir[0]*int(int8(d.regs[rEVolL]))>>7)
+	right = clamp16(mainR*int(int8(d.regs[rMVolR]))>>7 + fir[1]*int(int8(d.regs[rEVolR]))>>7)
+	return left, right
+}
+
+// envelope runs the ADSR or GAIN envelope of v for one sample.
+func (d *dsp) envelope(v *voice, vr []byte) {
+	env := v.env
+	if v.envMode == envRelease {
+		if env -= 8; env < 0 {
+			env = 0
+		}
+		v.env = env
+		return
+	}
+	var rate int
+	data := int(vr[vADSR2])
+	if adsr1 := int(vr[vADSR1]); adsr1&0x80 != 0 {
+		if v.envMode >= envDecay {
+			env--
+			env -= env >> 8
+			rate = data & 0x1f
+			if v.envMode == envDecay {
+				rate = adsr1>>3&0xe + 0x10
+			}
+		} else {
+			rate = adsr1&0xf*2 + 1
+			if rate < 31 {
+				env += 0x20
+			} else {
+				env += 0x400
+			}
+		}
+	} else {
+		data = int(vr[vGain])
+		switch mode := data >> 5; {
+		case mode < 4:
+			// Direct.
+			env = data * 0x10
+			rate = 31
+		case mode == 4:
+			// Linear decrease.
+			rate = data & 0x1f
+			env -= 0x20
+		case mode == 5:
+			// Exponential decrease.
+			rate = data & 0x1f
+			env--
+			env -= env >> 8
+		default:
+			// Linear or bent increase.
+			rate = data & 0x1f
+			env += 0x20
+			if mode == 7 && uint(v.hiddenEnv) >= 0x600 {
+				env += 0x8 - 0x20
+			}
+		}
+	}
+	// Sustain level.
+	if env>>8 == data>>5 && v.envMode == envDecay {
+		v.envMode = envSustain
+	}
+	v.hiddenEnv = env
+	if uint(env) > 0x7ff {
+		if env < 0 {
+			env = 0
+		} else {
+			env = 0x7ff
+		}
+		if v.envMode == envAttack {
+			v.envMode = envDecay
+		}
+	}
+	if d.tick(rate) {
+		v.env = env
+	}
+}
+
+// decodeBRR decodes the next four samples of v's current BRR block.
+func (d *dsp) decodeBRR(v *voice) {
+	header := int(d.ram[v.brrAddr])
+	nybbles := int(d.ram[(v.brrAddr+v.brrOffset)&0xffff])<<8 | int(d.ram[(v.brrAddr+v.brrOffset+1)&0xffff])
+	filter := header & 0xc
+	shift := uint(header >> 4)
+	pos := v.bufPos
+	if v.bufPos += 4; v.bufPos >= 12 {
+		v.bufPos = 0
+	}
+	for end := pos + 4; pos < end; pos, nybbles = pos+1, nybbles<<4 {
+		s := int(int16(nybbles)) >> 12
+		s = s << shift >> 1
+		if shift >= 0xd {
+			// Invalid shifts give -2048 or 0.
+			s = s >> 25 << 11
+		}
+		p1 := v.buf[pos+11]
+		p2 := v.buf[pos+10] >> 1
+		switch {
+		case filter >= 8:
+			s += p1
+			s -= p2
+			if filter == 8 {
+				s += p2 >> 4
+				s += p1 * -3 >> 6
+			} else {
+				s += p1 * -13 >> 7
+				s += p2 * 3 >> 4
+			}
+		case filter != 0:
+			s += p1 >> 1
+			s += -p1 >> 5
+		}
+		s = int(int16(clamp16(s) * 2))
+		v.buf[pos], v.buf[pos+12] = s, s
+	}
+}
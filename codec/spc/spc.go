@@ -0,0 +1,344 @@
+// Package spc plays Super Nintendo SPC files by emulating the SPC700 and
+// S-DSP.
+package spc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("SPC", "SNES-SPC700 Sound File Data", []string{"spc"}, New)
+}
+
+const (
+	// rate is the output sample rate.
+	rate = 32000
+
+	// Offsets of the sections of an SPC file.
+	offRAM   = 0x100
+	offDSP   = 0x10100
+	offExtra = 0x101c0
+	offXID6  = 0x10200
+
+	// maxSize bounds the xid6 chunk.
+	maxSize = offXID6 + 0x10000
+)
+
+var (
+	// DefaultDuration is the play length of files without one.
+	DefaultDuration = time.Minute * 3
+	// DefaultFade is the fade length of files without one.
+	DefaultFade = time.Second * 10
+)
+
+var errShort = errors.New("spc: file too short")
+
+func New(rf codec.Reader) ([]codec.Song, error) {
+	s := SPC{
+		Reader: rf,
+	}
+	return []codec.Song{&s}, nil
+}
+
+// Tags holds the ID666 and xid6 tags of an SPC file.
+type Tags struct {
+	Title, Game, Artist, Dumper, Comments string
+	// Disc and Track are the disc and track numbers on the official
+	// soundtrack, and Year the copyright year, or 0.
+	Disc, Track, Year int
+	// Length is the time to play before fading out, and Fade the length of
+	// the fade. They are 0 if not tagged.
+	Length, Fade time.Duration
+}
+
+// file holds the parsed contents of an SPC file.
+type file struct {
+	ram              [0x10000]byte
+	dsp              [128]byte
+	extra            [64]byte
+	pc               uint16
+	a, x, y, psw, sp byte
+	tags             Tags
+	// size is the file size.
+	size int64
+}
+
+func parse(b []byte) (*file, error) {
+	if len(b) < offXID6 {
+		return nil, errShort
+	}
+	f := &file{
+		pc:  binary.LittleEndian.Uint16(b[0x25:]),
+		a:   b[0x27],
+		x:   b[0x28],
+		y:   b[0x29],
+		psw: b[0x2a],
+		sp:  b[0x2b],
+	}
+	copy(f.ram[:], b[offRAM:])
+	copy(f.dsp[:], b[offDSP:])
+	copy(f.extra[:], b[offExtra:])
+	if b[0x23] == 26 {
+		f.tags.parseID666(b)
+	}
+	f.tags.parseXID6(b[offXID6:])
+	return f, nil
+}
+
+func tagString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (t *Tags) parseID666(b []byte) {
+	t.Title = tagString(b[0x2e:0x4e])
+	t.Game = tagString(b[0x4e:0x6e])
+	t.Dumper = tagString(b[0x6e:0x7e])
+	t.Comments = tagString(b[0x7e:0x9e])
+	// The text and binary formats are told apart by the lengths: the text
+	// format stores them as ASCII digits.
+	text := true
+	for _, c := range b[0xa9:0xb1] {
+		if c != 0 && (c < '0' || c > '9') {
+			text = false
+		}
+	}
+	if text {
+		secs, _ := strconv.Atoi(tagString(b[0xa9:0xac]))
+		fade, _ := strconv.Atoi(tagString(b[0xac:0xb1]))
+		t.Length = time.Duration(secs) * time.Second
+		t.Fade = time.Duration(fade) * time.Millisecond
+		t.Artist = tagString(b[0xb1:0xd1])
+	} else {
+		secs := int(b[0xa9]) | int(b[0xaa])<<8 | int(b[0xab])<<16
+		t.Length = time.Duration(secs) * time.Second
+		t.Fade = time.Duration(binary.LittleEndian.Uint32(b[0xac:])) * time.Millisecond
+		t.Artist = tagString(b[0xb0:0xd0])
+	}
+}
+
+// xid6 lengths are in ticks of 1/64000 seconds.
+func ticks(n int64) time.Duration {
+	return time.Duration(n) * time.Second / 64000
+}
+
+// parseXID6 parses the extended tags, which override ID666.
+func (t *Tags) parseXID6(b []byte) {
+	if len(b) < 8 || string(b[:4]) != "xid6" {
+		return
+	}
+	if n := int(binary.LittleEndian.Uint32(b[4:])); n+8 < len(b) {
+		b = b[:n+8]
+	}
+	b = b[8:]
+	var intro, loop, end int64
+	loops := int64(1)
+	timed := false
+	for len(b) >= 4 {
+		id, typ := b[0], b[1]
+		size := int(binary.LittleEndian.Uint16(b[2:]))
+		b = b[4:]
+		var data []byte
+		if typ != 0 {
+			if size > len(b) {
+				break
+			}
+			data = b[:size]
+			// Items are padded to four bytes.
+			if size = (size + 3) &^ 3; size > len(b) {
+				size = len(b)
+			}
+			b = b[size:]
+		}
+		var n int64
+		if len(data) >= 4 {
+			n = int64(int32(binary.LittleEndian.Uint32(data)))
+		}
+		switch id {
+		case 0x01:
+			t.Title = tagString(data)
+		case 0x02:
+			t.Game = tagString(data)
+		case 0x03:
+			t.Artist = tagString(data)
+		case 0x04:
+			t.Dumper = tagString(data)
+		case 0x07:
+			t.Comments = tagString(data)
+		case 0x11:
+			t.Disc = size & 0xff
+		case 0x12:
+			// The low byte is an optional letter.
+			t.Track = size >> 8
+		case 0x14:
+			t.Year = size
+		case 0x30:
+			intro, timed = n, true
+		case 0x31:
+			loop, timed = n, true
+		case 0x32:
+			end, timed = n, true
+		case 0x33:
+			t.Fade = ticks(n)
+		case 0x35:
+			loops = int64(size)
+		}
+	}
+	if timed {
+		t.Length = ticks(intro + loop*loops + end)
+	}
+}
+
+// SPC is a Super Nintendo SPC file. It plays for the tagged length and then
+// fades out.
+type SPC struct {
+	Reader codec.Reader
+	f      *file
+	e      *emu
+	// played, length and fade are in sample frames.
+	played, length, fade int
+}
+
+func (s *SPC) read() (*file, error) {
+	r, _, err := s.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	f.size = int64(len(b))
+	return f, nil
+}
+
+// durations returns the play and fade lengths of t, with defaults.
+func durations(t Tags) (length, fade time.Duration) {
+	length, fade = t.Length, t.Fade
+	if length <= 0 {
+		length = DefaultDuration
+		if fade <= 0 {
+			fade = DefaultFade
+		}
+	}
+	return
+}
+
+func (s *SPC) Init() (sampleRate, channels int, err error) {
+	if s.e == nil {
+		f, err := s.read()
+		if err != nil {
+			return 0, 0, err
+		}
+		length, fade := durations(f.tags)
+		s.f = f
+		s.e = newEmu(f)
+		s.played = 0
+		s.length = int(length * rate / time.Second)
+		s.fade = int(fade * rate / time.Second)
+	}
+	return rate, 2, nil
+}
+
+func (s *SPC) Info() (info codec.SongInfo, err error) {
+	f := s.f
+	if f == nil {
+		if f, err = s.read(); err != nil {
+			return
+		}
+	}
+	length, fade := durations(f.tags)
+	info = codec.SongInfo{
+		Time:       length + fade,
+		Artist:     f.tags.Artist,
+		Title:      f.tags.Title,
+		Album:      f.tags.Game,
+		Track:      float64(f.tags.Track),
+		Disc:       f.tags.Disc,
+		Comment:    f.tags.Comments,
+		Codec:      "SPC",
+		SampleRate: rate,
+		Channels:   2,
+		Size:       f.size,
+	}
+	if f.tags.Year != 0 {
+		info.Date = strconv.Itoa(f.tags.Year)
+	}
+	return info, nil
+}
+
+func (s *SPC) Play(n int) ([]float32, error) {
+	frames := n / 2
+	if left := s.length + s.fade - s.played; frames > left {
+		frames = left
+	}
+	if frames <= 0 {
+		return nil, nil
+	}
+	out := make([]float32, frames*2)
+	s.e.run(out)
+	for i := 0; i < frames; i++ {
+		if pos := s.played + i; pos >= s.length {
+			g := 1 - float32(pos-s.length)/float32(s.fade)
+			out[i*2] *= g
+			out[i*2+1] *= g
+		}
+	}
+	s.played += frames
+	return out, nil
+}
+
+// Seek seeks by emulating from the start, or from the current position when
+// seeking forward.
+func (s *SPC) Seek(offset time.Duration) error {
+	return codec.SeekSteps(s, offset)
+}
+
+// SeekStep emulates a step toward offset.
+func (s *SPC) SeekStep(offset time.Duration) (bool, error) {
+	if s.e == nil {
+		return false, errors.New("spc: seek before init")
+	}
+	target := int(offset * rate / time.Second)
+	if max := s.length + s.fade; target > max {
+		target = max
+	}
+	if target < s.played {
+		s.e = newEmu(s.f)
+		s.played = 0
+	}
+	end := target
+	if step := int(codec.StepLength * rate / time.Second); end > s.played+step {
+		end = s.played + step
+	}
+	buf := make([]float32, 2*1024)
+	for s.played < end {
+		n := end - s.played
+		if n > 1024 {
+			n = 1024
+		}
+		s.e.run(buf[:2*n])
+		s.played += n
+	}
+	return s.played >= target, nil
+}
+
+func (s *SPC) Close() {
+	s.f, s.e = nil, nil
+}
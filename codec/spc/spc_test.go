@@ -0,0 +1,161 @@
+package spc
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// testFile returns an SPC file whose program keys on voice 0 if keyOn is
+// set. The voice loops a 16 sample square wave at the output rate, so it
+// plays at 2kHz, panned left.
+func testFile(keyOn bool) []byte {
+	b := make([]byte, offXID6)
+	copy(b, "SNES-SPC700 Sound File Data v0.30")
+	b[0x21], b[0x22], b[0x23] = 26, 26, 26
+	binary.LittleEndian.PutUint16(b[0x25:], 0x200)
+	b[0x2b] = 0xef
+	copy(b[0x2e:], "Square")
+	copy(b[0x4e:], "Test Game")
+	copy(b[0xa9:], "3")
+	copy(b[0xac:], "1000")
+	copy(b[0xb1:], "Composer")
+
+	ram := b[offRAM:]
+	prog := []byte{
+		0x8f, rKON, 0xf2, // mov $f2, #KON
+		0x8f, 0x01, 0xf3, // mov $f3, #1
+		0x2f, 0xfe, // bra -2
+	}
+	if !keyOn {
+		prog = prog[6:]
+	}
+	copy(ram[0x200:], prog)
+	// The sample directory entry holds the start and loop addresses.
+	copy(ram[0x300:], []byte{0x00, 0x04, 0x00, 0x04})
+	// A BRR block with a shift of 11, that loops and ends.
+	copy(ram[0x400:], []byte{0xb3, 0x77, 0x77, 0x77, 0x77, 0x88, 0x88, 0x88, 0x88})
+
+	dsp := b[offDSP:]
+	dsp[vVolL], dsp[vVolR] = 0x7f, 0x40
+	dsp[vPitchH] = 0x10
+	dsp[vGain] = 0x7f
+	dsp[rMVolL], dsp[rMVolR] = 0x7f, 0x7f
+	dsp[rDIR] = 0x03
+	dsp[rFLG] = 0x20
+	return b
+}
+
+func TestTags(t *testing.T) {
+	songs, _ := New(codectest.Reader(testFile(true)))
+	info, err := songs[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Title != "Square" || info.Album != "Test Game" || info.Artist != "Composer" {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Time != 4*time.Second {
+		t.Fatalf("time %v", info.Time)
+	}
+	// Extended tags override ID666.
+	b := testFile(true)
+	x := []byte("xid6\x00\x00\x00\x00")
+	x = append(x, 0x01, 1, 4, 0)
+	x = append(x, "Xid6"...)
+	x = append(x, 0x30, 4, 4, 0)
+	x = append(x, 0x00, 0xf4, 0x01, 0x00) // 2s in 1/64000s ticks
+	binary.LittleEndian.PutUint32(x[4:], uint32(len(x)-8))
+	f, err := parse(append(b, x...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.tags.Title != "Xid6" || f.tags.Length != 2*time.Second {
+		t.Fatalf("got %+v", f.tags)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	songs, _ := New(codectest.Reader(testFile(true)))
+	s := songs[0]
+	if sr, ch, err := s.Init(); err != nil || sr != rate || ch != 2 {
+		t.Fatalf("init: %d %d %v", sr, ch, err)
+	}
+	b := codectest.PlayAll(t, s, 4096)
+	if len(b) != 4*rate*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	// Count the zero crossings of the left channel over a second.
+	second := b[rate/10*2 : (rate/10+rate)*2]
+	crossings := 0
+	for i := 2; i < len(second); i += 2 {
+		if (second[i-2] < 0) != (second[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 3990 || crossings > 4010 {
+		t.Fatalf("%d zero crossings in a second, want 4000", crossings)
+	}
+	if r := codectest.RMS(second, 0, 2) / codectest.RMS(second, 1, 2); math.Abs(r-127.0/64) > 0.05 {
+		t.Fatalf("left to right ratio %v", r)
+	}
+	// The last second fades out.
+	if end, full := codectest.RMS(b[len(b)-rate/5:], 0, 2), codectest.RMS(second, 0, 2); end > full/10 {
+		t.Fatalf("end rms %v, full %v", end, full)
+	}
+
+	// Without the program keying on the voice, there is silence.
+	songs, _ = New(codectest.Reader(testFile(false)))
+	songs[0].Init()
+	if r := codectest.RMS(codectest.PlayAll(t, songs[0], 4096), 0, 2); r != 0 {
+		t.Fatalf("rms %v without key on", r)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	songs, _ := New(codectest.Reader(testFile(true)))
+	s := songs[0]
+	s.Init()
+	want := codectest.PlayAll(t, s, 4096)
+	for _, offset := range []time.Duration{2 * time.Second, time.Second / 3, 0} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		got := codectest.PlayAll(t, s, 4096)
+		w := want[int(offset*rate/time.Second)*2:]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got %d samples, want %d", offset, len(got), len(w))
+		}
+		for i := range got {
+			if got[i] != w[i] {
+				t.Fatalf("%v: sample %d is %v, want %v", offset, i, got[i], w[i])
+			}
+		}
+	}
+}
+
+func TestSeekStep(t *testing.T) {
+	songs, _ := New(codectest.Reader(testFile(true)))
+	s := songs[0]
+	s.Init()
+	want := codectest.PlayAll(t, s, 4096)
+	// Each step runs a second of the song at most.
+	sk := s.(codec.StepSeeker)
+	for i, w := range []bool{false, true} {
+		done, err := sk.SeekStep(2 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != w {
+			t.Fatalf("step %d: done %v, want %v", i, done, w)
+		}
+	}
+	got := codectest.PlayAll(t, s, 4096)
+	if w := want[2*rate*2:]; len(got) != len(w) || got[0] != w[0] {
+		t.Fatalf("got %d samples, want %d", len(got), len(w))
+	}
+}
@@ -0,0 +1,134 @@
+package vgm
+
+import "math"
+
+// psgVolumes maps attenuation to amplitude in 2dB steps.
+var psgVolumes [16]float64
+
+func init() {
+	for i := range psgVolumes[:15] {
+		psgVolumes[i] = math.Pow(10, -float64(i)/10)
+	}
+}
+
+// sn76489 emulates the SN76489 PSG and its Sega variants.
+type sn76489 struct {
+	// regs are the tone and volume registers in the order tone 0, volume
+	// 0, ..., noise, volume 3.
+	regs  [8]int
+	latch int
+
+	counters [4]int
+	high     [4]bool
+	lfsr     uint32
+	width    uint
+	feedback uint32
+	// stereo is the Game Gear stereo register.
+	stereo byte
+
+	// step is the number of ticks per output sample.
+	step, frac float64
+}
+
+func newSN76489(clock uint32, feedback uint16, width byte) *sn76489 {
+	p := &sn76489{
+		width:    uint(width),
+		feedback: uint32(feedback),
+		stereo:   0xff,
+		step:     float64(clock) / 16 / rate,
+	}
+	if p.feedback == 0 {
+		p.feedback = 9
+	}
+	if p.width == 0 {
+		p.width = 16
+	}
+	for i := 1; i < 8; i += 2 {
+		p.regs[i] = 0xf
+	}
+	p.lfsr = 1 << (p.width - 1)
+	return p
+}
+
+func (p *sn76489) write(v byte) {
+	if v&0x80 != 0 {
+		p.latch = int(v >> 4 & 7)
+		p.regs[p.latch] = p.regs[p.latch]&^0xf | int(v&0xf)
+	} else if p.latch&1 == 0 && p.latch < 6 {
+		p.regs[p.latch] = p.regs[p.latch]&0xf | int(v&0x3f)<<4
+	} else {
+		p.regs[p.latch] = int(v & 0xf)
+	}
+	if p.latch == 6 {
+		p.lfsr = 1 << (p.width - 1)
+	}
+}
+
+func parity(x uint32) uint32 {
+	x ^= x >> 16
+	x ^= x >> 8
+	x ^= x >> 4
+	x ^= x >> 2
+	x ^= x >> 1
+	return x & 1
+}
+
+func (p *sn76489) tick() {
+	for i := 0; i < 3; i++ {
+		// Periods of 0 and 1 hold the output high, for sample playback.
+		period := p.regs[i*2]
+		if period <= 1 {
+			p.high[i] = true
+			continue
+		}
+		if p.counters[i]--; p.counters[i] <= 0 {
+			p.counters[i] = period
+			p.high[i] = !p.high[i]
+		}
+	}
+	if p.counters[3]--; p.counters[3] <= 0 {
+		n := p.regs[6]
+		if n&3 == 3 {
+			p.counters[3] = p.regs[4]
+		} else {
+			p.counters[3] = 0x10 << uint(n&3)
+		}
+		p.high[3] = !p.high[3]
+		if p.high[3] {
+			fb := p.lfsr & 1
+			if n&4 != 0 {
+				fb = parity(p.lfsr & p.feedback)
+			}
+			p.lfsr = p.lfsr>>1 | fb<<(p.width-1)
+		}
+	}
+}
+
+// sample returns the stereo output averaged over one output sample.
+func (p *sn76489) sample() (l, r float64) {
+	n := 0
+	for p.frac += p.step; p.frac >= 1; p.frac-- {
+		p.tick()
+		for i := 0; i < 4; i++ {
+			v := psgVolumes[p.regs[i*2+1]]
+			on := p.high[i]
+			if i == 3 {
+				on = p.lfsr&1 != 0
+			}
+			if !on {
+				v = -v
+			}
+			if p.stereo&(0x10<<uint(i)) != 0 {
+				l += v
+			}
+			if p.stereo&(1<<uint(i)) != 0 {
+				r += v
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return l / float64(n), r / float64(n)
+}
@@ -0,0 +1,592 @@
+// Package vgm plays VGM and VGZ logs of Sega and arcade sound chips. The
+// SN76489 and YM2612 are emulated; writes to other chips are ignored.
+package vgm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mjibson/mog/codec"
+)
+
+func init() {
+	codec.RegisterCodec("VGM", "Vgm ", []string{"vgm", "vgz"}, New)
+}
+
+const (
+	// rate is the sample rate of VGM files.
+	rate = 44100
+
+	// maxSize bounds the uncompressed file.
+	maxSize = 64 << 20
+)
+
+var (
+	// Loops is the number of times a looped song plays its loop before
+	// fading out.
+	Loops = 2
+	// Fade is the fade length of looped songs.
+	Fade = time.Second * 8
+)
+
+var errHeader = errors.New("vgm: bad header")
+
+func New(rf codec.Reader) ([]codec.Song, error) {
+	return []codec.Song{&VGM{Reader: rf}}, nil
+}
+
+// Tags holds the GD3 tags of a VGM file. English strings are preferred
+// over Japanese ones.
+type Tags struct {
+	Title, Game, System, Author, Date, Ripper, Notes string
+}
+
+// file holds the parsed contents of a VGM file.
+type file struct {
+	b          []byte
+	version    uint32
+	snClock    uint32
+	snFeedback uint16
+	snWidth    byte
+	ymClock    uint32
+	// total and loop are the song and loop lengths in samples. data and
+	// loopStart are offsets into b.
+	total, loop     int
+	data, loopStart int
+	tags            Tags
+	// size is the file size, which may be compressed.
+	size int64
+}
+
+func parse(b []byte) (*file, error) {
+	if len(b) < 0x40 || string(b[:4]) != "Vgm " {
+		return nil, errHeader
+	}
+	le := binary.LittleEndian
+	u32 := func(off int) uint32 {
+		if off+4 > len(b) {
+			return 0
+		}
+		return le.Uint32(b[off:])
+	}
+	f := &file{
+		b:       b,
+		version: u32(0x08),
+		snClock: u32(0x0c) & 0x3fffffff,
+		total:   int(u32(0x18)),
+		loop:    int(u32(0x20)),
+		data:    0x40,
+	}
+	if f.version >= 0x110 {
+		f.snFeedback = le.Uint16(b[0x28:])
+		f.snWidth = b[0x2a]
+		f.ymClock = u32(0x2c) & 0x3fffffff
+	} else {
+		// Early files share the YM2413 clock with the YM2612.
+		f.ymClock = u32(0x10) & 0x3fffffff
+	}
+	if f.version >= 0x150 {
+		if off := u32(0x34); off != 0 {
+			f.data = 0x34 + int(off)
+		}
+	}
+	if off := u32(0x1c); off != 0 && f.loop > 0 {
+		f.loopStart = 0x1c + int(off)
+	}
+	if off := u32(0x14); off != 0 {
+		f.tags = parseGD3(b[min(0x14+int(off), len(b)):])
+	}
+	if f.data >= len(b) {
+		return nil, errHeader
+	}
+	return f, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func parseGD3(b []byte) Tags {
+	var t Tags
+	if len(b) < 12 || string(b[:4]) != "Gd3 " {
+		return t
+	}
+	n := int(binary.LittleEndian.Uint32(b[8:]))
+	b = b[12:]
+	if n < len(b) {
+		b = b[:n]
+	}
+	var s []string
+	for len(b) >= 2 && len(s) < 11 {
+		var u []uint16
+		for len(b) >= 2 {
+			c := binary.LittleEndian.Uint16(b)
+			b = b[2:]
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		s = append(s, string(utf16.Decode(u)))
+	}
+	for len(s) < 11 {
+		s = append(s, "")
+	}
+	pick := func(en, jp string) string {
+		if en != "" {
+			return en
+		}
+		return jp
+	}
+	t.Title = pick(s[0], s[1])
+	t.Game = pick(s[2], s[3])
+	t.System = pick(s[4], s[5])
+	t.Author = pick(s[6], s[7])
+	t.Date = s[8]
+	t.Ripper = s[9]
+	t.Notes = s[10]
+	return t
+}
+
+// stream is a DAC stream that writes PCM data to a chip register.
+type stream struct {
+	on   bool
+	ym   bool
+	port int
+	reg  byte
+	bank byte
+	step int
+	freq int
+	// start, pos and end are offsets into the data bank.
+	start, pos, end int
+	loop            bool
+	// frac accumulates freq each sample; a byte is due at each rate.
+	frac int
+}
+
+// player executes the commands of a VGM file.
+type player struct {
+	f    *file
+	pos  int
+	wait int
+	done bool
+	psg  *sn76489
+	ym   *ym2612
+	// banks holds the data blocks of each type, and blocks the offsets of
+	// the blocks within them.
+	banks   map[byte][]byte
+	blocks  map[byte][]int
+	pcmPos  int
+	streams map[byte]*stream
+}
+
+func newPlayer(f *file) *player {
+	p := &player{
+		f:       f,
+		pos:     f.data,
+		banks:   make(map[byte][]byte),
+		blocks:  make(map[byte][]int),
+		streams: make(map[byte]*stream),
+	}
+	if f.snClock != 0 {
+		p.psg = newSN76489(f.snClock, f.snFeedback, f.snWidth)
+	}
+	if f.ymClock != 0 {
+		p.ym = newYM2612(f.ymClock)
+	}
+	return p
+}
+
+// cmdLength returns the length of commands, including the opcode, that are
+// skipped.
+func cmdLength(op byte) int {
+	switch {
+	case op >= 0x30 && op <= 0x3f:
+		return 2
+	case op >= 0x40 && op <= 0x4e, op >= 0x51 && op <= 0x5f, op >= 0xa0 && op <= 0xbf:
+		return 3
+	case op >= 0xc0 && op <= 0xdf:
+		return 4
+	case op >= 0xe1:
+		return 5
+	}
+	return 1
+}
+
+// exec runs commands until a wait or the end.
+func (p *player) exec() {
+	b := p.f.b
+	le := binary.LittleEndian
+	for p.wait == 0 && !p.done {
+		if p.pos >= len(b) {
+			p.end()
+			continue
+		}
+		op := b[p.pos]
+		arg := func(i int) byte {
+			if p.pos+i < len(b) {
+				return b[p.pos+i]
+			}
+			return 0
+		}
+		n := cmdLength(op)
+		switch {
+		case op == 0x4f:
+			if p.psg != nil {
+				p.psg.stereo = arg(1)
+			}
+			n = 2
+		case op == 0x50:
+			if p.psg != nil {
+				p.psg.write(arg(1))
+			}
+			n = 2
+		case op == 0x52 || op == 0x53:
+			if p.ym != nil {
+				p.ym.write(int(op&1), arg(1), arg(2))
+			}
+		case op == 0x61:
+			p.wait = int(arg(1)) | int(arg(2))<<8
+			n = 3
+		case op == 0x62:
+			p.wait = 735
+		case op == 0x63:
+			p.wait = 882
+		case op == 0x66:
+			p.end()
+			continue
+		case op == 0x67:
+			if p.pos+7 > len(b) {
+				p.done = true
+				continue
+			}
+			typ := arg(2)
+			size := int(le.Uint32(b[p.pos+3:]) & 0x7fffffff)
+			start := p.pos + 7
+			end := min(start+size, len(b))
+			if typ < 0x40 {
+				p.blocks[typ] = append(p.blocks[typ], len(p.banks[typ]))
+				p.banks[typ] = append(p.banks[typ], b[start:end]...)
+			}
+			n = 7 + size
+		case op >= 0x70 && op <= 0x7f:
+			p.wait = int(op&0xf) + 1
+		case op >= 0x80 && op <= 0x8f:
+			if pcm := p.banks[0]; p.ym != nil && p.pcmPos < len(pcm) {
+				p.ym.write(0, 0x2a, pcm[p.pcmPos])
+			}
+			p.pcmPos++
+			p.wait = int(op & 0xf)
+		case op >= 0x90 && op <= 0x95:
+			n = p.streamCmd(op)
+		case op == 0xe0:
+			if p.pos+5 <= len(b) {
+				p.pcmPos = int(le.Uint32(b[p.pos+1:]))
+			}
+		}
+		p.pos += n
+	}
+}
+
+// streamCmd runs a DAC stream control command and returns its length.
+func (p *player) streamCmd(op byte) int {
+	b := p.f.b
+	if p.pos+11 > len(b) {
+		return len(b) - p.pos
+	}
+	le := binary.LittleEndian
+	id := b[p.pos+1]
+	s := p.streams[id]
+	if s == nil {
+		s = new(stream)
+		p.streams[id] = s
+	}
+	switch op {
+	case 0x90:
+		// Only YM2612 streams are played.
+		s.ym = b[p.pos+2]&0x7f == 0x02
+		s.port = int(b[p.pos+3])
+		s.reg = b[p.pos+4]
+		return 5
+	case 0x91:
+		s.bank = b[p.pos+2]
+		s.step = int(b[p.pos+3])
+		return 5
+	case 0x92:
+		s.freq = int(le.Uint32(b[p.pos+2:]))
+		return 6
+	case 0x93:
+		if start := le.Uint32(b[p.pos+2:]); start != 0xffffffff {
+			s.pos = int(start)
+		}
+		s.start = s.pos
+		mode := b[p.pos+6]
+		length := int(le.Uint32(b[p.pos+7:]))
+		switch mode & 3 {
+		case 0:
+			s.end = len(p.banks[s.bank])
+		case 1:
+			s.end = s.pos + length*max(s.step, 1)
+		case 2:
+			s.end = s.pos + length*s.freq/1000*max(s.step, 1)
+		case 3:
+			s.end = len(p.banks[s.bank])
+		}
+		s.loop = mode&0x80 != 0
+		s.on, s.frac = true, 0
+		return 11
+	case 0x94:
+		if id == 0xff {
+			for _, s := range p.streams {
+				s.on = false
+			}
+		} else {
+			s.on = false
+		}
+		return 2
+	default:
+		block := int(le.Uint16(b[p.pos+2:]))
+		blocks := p.blocks[s.bank]
+		if block < len(blocks) {
+			s.pos = blocks[block]
+			s.start = s.pos
+			s.end = len(p.banks[s.bank])
+			if block+1 < len(blocks) {
+				s.end = blocks[block+1]
+			}
+			s.loop = b[p.pos+4]&1 != 0
+			s.on, s.frac = true, 0
+		}
+		return 5
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// runStreams writes the stream bytes due in one sample.
+func (p *player) runStreams() {
+	for _, s := range p.streams {
+		if !s.on || !s.ym || p.ym == nil {
+			continue
+		}
+		data := p.banks[s.bank]
+		for s.frac += s.freq; s.frac >= rate; s.frac -= rate {
+			if s.pos >= s.end || s.pos >= len(data) {
+				if !s.loop {
+					s.on = false
+					break
+				}
+				s.pos = s.start
+			}
+			p.ym.write(s.port, s.reg, data[s.pos])
+			s.pos += max(s.step, 1)
+		}
+	}
+}
+
+func (p *player) end() {
+	if p.f.loopStart != 0 && p.f.loopStart < len(p.f.b) {
+		p.pos = p.f.loopStart
+		return
+	}
+	p.done = true
+}
+
+// render fills out with stereo samples. It returns the number of frames
+// rendered, fewer at the end of an unlooped song.
+func (p *player) render(out []float32) int {
+	frames := len(out) / 2
+	for i := 0; i < frames; i++ {
+		p.exec()
+		if p.done {
+			return i
+		}
+		p.wait--
+		p.runStreams()
+		var l, r float64
+		if p.ym != nil {
+			l, r = p.ym.sample()
+			l /= 4
+			r /= 4
+		}
+		if p.psg != nil {
+			pl, pr := p.psg.sample()
+			g := 0.25
+			if p.ym != nil {
+				g = 0.125
+			}
+			l += pl * g
+			r += pr * g
+		}
+		out[i*2] = clip(l)
+		out[i*2+1] = clip(r)
+	}
+	return frames
+}
+
+func clip(x float64) float32 {
+	switch {
+	case x > 1:
+		return 1
+	case x < -1:
+		return -1
+	}
+	return float32(x)
+}
+
+// VGM is a VGM or gzipped VGZ file. Looped songs play the loop Loops times
+// and then fade out over Fade.
+type VGM struct {
+	Reader codec.Reader
+	f      *file
+	p      *player
+	// played, length and fade are in samples.
+	played, length, fade int
+}
+
+func (v *VGM) read() (*file, error) {
+	r, _, err := v.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(b))
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		z, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if b, err = ioutil.ReadAll(io.LimitReader(z, maxSize)); err != nil {
+			return nil, err
+		}
+	}
+	f, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	f.size = size
+	return f, nil
+}
+
+// lengths returns the play and fade lengths in samples.
+func (f *file) lengths() (length, fade int) {
+	if f.loopStart == 0 {
+		return f.total, 0
+	}
+	loops := Loops
+	if loops < 1 {
+		loops = 1
+	}
+	return f.total + f.loop*(loops-1), int(Fade * rate / time.Second)
+}
+
+func (v *VGM) Init() (sampleRate, channels int, err error) {
+	if v.p == nil {
+		f, err := v.read()
+		if err != nil {
+			return 0, 0, err
+		}
+		v.f = f
+		v.p = newPlayer(f)
+		v.played = 0
+		v.length, v.fade = f.lengths()
+	}
+	return rate, 2, nil
+}
+
+func (v *VGM) Info() (info codec.SongInfo, err error) {
+	f := v.f
+	if f == nil {
+		if f, err = v.read(); err != nil {
+			return
+		}
+	}
+	length, fade := f.lengths()
+	return codec.SongInfo{
+		Time:       time.Duration(length+fade) * time.Second / rate,
+		Artist:     f.tags.Author,
+		Title:      f.tags.Title,
+		Album:      f.tags.Game,
+		Date:       f.tags.Date,
+		Comment:    f.tags.Notes,
+		Codec:      "VGM",
+		SampleRate: rate,
+		Channels:   2,
+		Size:       f.size,
+	}, nil
+}
+
+func (v *VGM) Play(n int) ([]float32, error) {
+	frames := n / 2
+	if left := v.length + v.fade - v.played; frames > left {
+		frames = left
+	}
+	if frames <= 0 {
+		return nil, nil
+	}
+	out := make([]float32, frames*2)
+	frames = v.p.render(out)
+	for i := 0; i < frames; i++ {
+		if pos := v.played + i; pos >= v.length {
+			g := 1 - float32(pos-v.length)/float32(v.fade)
+			out[i*2] *= g
+			out[i*2+1] *= g
+		}
+	}
+	v.played += frames
+	return out[:frames*2], nil
+}
+
+// Seek seeks by emulating from the start, or from the current position when
+// seeking forward.
+func (v *VGM) Seek(offset time.Duration) error {
+	return codec.SeekSteps(v, offset)
+}
+
+// SeekStep emulates a step toward offset.
+func (v *VGM) SeekStep(offset time.Duration) (bool, error) {
+	if v.p == nil {
+		return false, errors.New("vgm: seek before init")
+	}
+	target := int(offset * rate / time.Second)
+	if max := v.length + v.fade; target > max {
+		target = max
+	}
+	if target < v.played {
+		v.p = newPlayer(v.f)
+		v.played = 0
+	}
+	end := min(target, v.played+int(codec.StepLength*rate/time.Second))
+	buf := make([]float32, 2*1024)
+	for v.played < end {
+		n := min(end-v.played, 1024)
+		if v.p.render(buf[:2*n]) < n {
+			// The song ended first.
+			return true, nil
+		}
+		v.played += n
+	}
+	return v.played >= target, nil
+}
+
+func (v *VGM) Close() {
+	v.f, v.p = nil, nil
+}
@@ -0,0 +1,171 @@
+package vgm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// testFile returns a VGM file that plays a 440Hz square wave on the
+// SN76489 for a second, and is then silent for half a second. If loop is
+// set, the whole song loops.
+func testFile(loop bool) []byte {
+	b := make([]byte, 0x40)
+	le := binary.LittleEndian
+	copy(b, "Vgm ")
+	le.PutUint32(b[0x08:], 0x150)
+	le.PutUint32(b[0x0c:], 3579545)
+	le.PutUint32(b[0x18:], rate*3/2)
+	le.PutUint32(b[0x34:], 0x40-0x34)
+	b = append(b,
+		0x50, 0x8e, // tone 0 period 254
+		0x50, 0x0f,
+		0x50, 0x90, // volume 0 at full
+		0x61, 0x44, 0xac, // wait 44100
+		0x50, 0x9f, // volume 0 off
+		0x61, 0x22, 0x56, // wait 22050
+		0x66,
+	)
+	if loop {
+		le.PutUint32(b[0x1c:], 0x40-0x1c)
+		le.PutUint32(b[0x20:], rate*3/2)
+	}
+
+	le.PutUint32(b[0x14:], uint32(len(b)-0x14))
+	var gd3 []byte
+	for _, s := range []string{"Tone", "", "", "ゲーム", "Sega Master System", "", "Composer", "", "1995", "Ripper", "Notes"} {
+		for _, c := range utf16.Encode([]rune(s)) {
+			gd3 = append(gd3, byte(c), byte(c>>8))
+		}
+		gd3 = append(gd3, 0, 0)
+	}
+	h := make([]byte, 12)
+	copy(h, "Gd3 ")
+	le.PutUint32(h[4:], 0x100)
+	le.PutUint32(h[8:], uint32(len(gd3)))
+	b = append(append(b, h...), gd3...)
+	le.PutUint32(b[4:], uint32(len(b)-4))
+	return b
+}
+
+func open(t *testing.T, b []byte) codec.Song {
+	return codectest.Open(t, New, codectest.Reader(b), 0, rate, 2)
+}
+
+// second returns the samples from start to end seconds.
+func second(b []float32, start, end float64) []float32 {
+	return b[int(start*rate)*2 : int(end*rate)*2]
+}
+
+func equal(t *testing.T, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	songs, _ := New(codectest.Reader(testFile(false)))
+	info, err := songs[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The Japanese game name is used when there is no English one.
+	if info.Title != "Tone" || info.Album != "ゲーム" || info.Artist != "Composer" || info.Date != "1995" || info.Comment != "Notes" {
+		t.Fatalf("got %+v", info)
+	}
+	if info.Time != 1500*time.Millisecond {
+		t.Fatalf("time %v", info.Time)
+	}
+	songs, _ = New(codectest.Reader(testFile(true)))
+	info, _ = songs[0].Info()
+	if want := time.Duration(Loops)*1500*time.Millisecond + Fade; info.Time != want {
+		t.Fatalf("looped time %v, want %v", info.Time, want)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	b := codectest.PlayAll(t, open(t, testFile(false)), 4096)
+	if len(b) != rate*3/2*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	tone := second(b, 0.1, 0.9)
+	crossings := 0
+	for i := 2; i < len(tone); i += 2 {
+		if (tone[i-2] < 0) != (tone[i] < 0) {
+			crossings++
+		}
+	}
+	// The tone is 3579545/32/254 Hz.
+	if crossings < 700 || crossings > 710 {
+		t.Fatalf("%d zero crossings in 0.8s, want 705", crossings)
+	}
+	if r := codectest.RMS(tone, 0, 1); math.Abs(r-0.25) > 0.01 {
+		t.Fatalf("rms %v", r)
+	}
+	if r := codectest.RMS(second(b, 1.01, 1.5), 0, 1); r != 0 {
+		t.Fatalf("rms %v after muting", r)
+	}
+
+	// Compressed files play the same.
+	var z bytes.Buffer
+	w := gzip.NewWriter(&z)
+	w.Write(testFile(false))
+	w.Close()
+	equal(t, codectest.PlayAll(t, open(t, z.Bytes()), 4096), b)
+}
+
+func TestLoop(t *testing.T) {
+	defer func(loops int, fade time.Duration) {
+		Loops, Fade = loops, fade
+	}(Loops, Fade)
+	Loops, Fade = 2, time.Second
+	b := codectest.PlayAll(t, open(t, testFile(true)), 4096)
+	if len(b) != (rate*3+rate)*2 {
+		t.Fatalf("got %d samples", len(b))
+	}
+	// The loop plays the tone again. The oscillator keeps its phase, so the
+	// samples differ from the first time.
+	tone := codectest.RMS(second(b, 0.1, 0.9), 0, 1)
+	if r := codectest.RMS(second(b, 1.6, 2.4), 0, 1); math.Abs(r-tone) > 0.01 {
+		t.Fatalf("rms %v in the loop, %v before", r, tone)
+	}
+	if r := codectest.RMS(second(b, 2.51, 3), 0, 1); r != 0 {
+		t.Fatalf("rms %v after muting in the loop", r)
+	}
+	// The fade starts at the third play of the loop.
+	if r := codectest.RMS(second(b, 3.4, 3.6), 0, 1) / tone; math.Abs(r-0.5) > 0.05 {
+		t.Fatalf("rms ratio %v halfway through the fade", r)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	defer func(fade time.Duration) {
+		Fade = fade
+	}(Fade)
+	Fade = time.Second
+	s := open(t, testFile(true))
+	want := codectest.PlayAll(t, s, 4096)
+	for _, offset := range []time.Duration{2 * time.Second, 100 * time.Millisecond, 3500 * time.Millisecond, 0, 10 * time.Second} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		start := int(offset * rate / time.Second * 2)
+		if start > len(want) {
+			start = len(want)
+		}
+		equal(t, codectest.PlayAll(t, s, 4096), want[start:])
+	}
+}
@@ -0,0 +1,470 @@
+package vgm
+
+import "math"
+
+var (
+	// sinTab is one cycle of a sine wave.
+	sinTab [1024]float64
+	// attTab maps 10-bit attenuation, in steps of 3/32 dB, to amplitude.
+	attTab [1024]float64
+)
+
+func init() {
+	for i := range sinTab {
+		sinTab[i] = math.Sin(2 * math.Pi * (float64(i) + 0.5) / 1024)
+	}
+	for i := range attTab {
+		attTab[i] = math.Exp2(-float64(i) / 64)
+	}
+}
+
+// dtTab is the detune in phase increment units for each detune setting and
+// key code.
+var dtTab = [4][32]uint32{
+	{},
+	{0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2,
+		2, 3, 3, 3, 4, 4, 4, 5, 5, 6, 6, 7, 8, 8, 8, 8},
+	{1, 1, 1, 1, 2, 2, 2, 2, 2, 3, 3, 3, 4, 4, 4, 5,
+		5, 6, 6, 7, 8, 8, 9, 10, 11, 12, 13, 14, 16, 16, 16, 16},
+	{2, 2, 2, 2, 2, 3, 3, 3, 4, 4, 4, 5, 5, 6, 6, 7,
+		8, 8, 9, 10, 11, 12, 13, 14, 16, 17, 19, 20, 22, 22, 22, 22},
+}
+
+// keyNotes maps the top four bits of the frequency number to the low bits
+// of the key code.
+var keyNotes = [16]int{0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 3, 3, 3, 3, 3, 3}
+
+// egInc holds the envelope increments over eight cycles for each group of
+// rates.
+var egInc = [19][8]int{
+	{0, 1, 0, 1, 0, 1, 0, 1},
+	{0, 1, 0, 1, 1, 1, 0, 1},
+	{0, 1, 1, 1, 0, 1, 1, 1},
+	{0, 1, 1, 1, 1, 1, 1, 1},
+	{1, 1, 1, 1, 1, 1, 1, 1},
+	{1, 1, 1, 2, 1, 1, 1, 2},
+	{1, 2, 1, 2, 1, 2, 1, 2},
+	{1, 2, 2, 2, 1, 2, 2, 2},
+	{2, 2, 2, 2, 2, 2, 2, 2},
+	{2, 2, 2, 4, 2, 2, 2, 4},
+	{2, 4, 2, 4, 2, 4, 2, 4},
+	{2, 4, 4, 4, 2, 4, 4, 4},
+	{4, 4, 4, 4, 4, 4, 4, 4},
+	{4, 4, 4, 8, 4, 4, 4, 8},
+	{4, 8, 4, 8, 4, 8, 4, 8},
+	{4, 8, 8, 8, 4, 8, 8, 8},
+	{8, 8, 8, 8, 8, 8, 8, 8},
+	{16, 16, 16, 16, 16, 16, 16, 16},
+	{},
+}
+
+// lfoPeriods is the number of samples per LFO step.
+var lfoPeriods = [8]int{108, 77, 71, 67, 62, 44, 8, 5}
+
+// amsShifts and fmsCents are the LFO amplitude and frequency modulation
+// depths.
+var (
+	amsShifts = [4]uint{8, 3, 1, 0}
+	fmsCents  = [8]float64{0, 3.4, 6.7, 10, 14, 20, 40, 80}
+)
+
+// slots maps the operator bits of a register address to operators 1, 3, 2
+// and 4.
+var slots = [4]int{0, 2, 1, 3}
+
+// Envelope states.
+const (
+	egAttack = iota
+	egDecay
+	egSustain
+	egRelease
+)
+
+type operator struct {
+	dt, mul          uint32
+	tl, ks           int
+	ar, d1r, d2r, rr int
+	sl               int
+	am               bool
+	phase, inc       uint32
+	kc               int
+	env, state       int
+}
+
+func (o *operator) rate(r int) int {
+	if r == 0 {
+		return 0
+	}
+	r = 2*r + o.kc>>uint(3-o.ks)
+	if r > 63 {
+		r = 63
+	}
+	return r
+}
+
+// setFreq sets the phase increment from a frequency number and block.
+func (o *operator) setFreq(fnum, block int) {
+	o.kc = block<<2 | keyNotes[fnum>>7&15]
+	inc := uint32(fnum<<uint(block)) >> 1
+	dt := dtTab[o.dt&3][o.kc]
+	if o.dt&4 != 0 {
+		inc -= dt
+	} else {
+		inc += dt
+	}
+	inc &= 0x1ffff
+	if o.mul == 0 {
+		o.inc = inc >> 1
+	} else {
+		o.inc = inc * o.mul
+	}
+}
+
+func (o *operator) keyOn() {
+	if o.state != egRelease {
+		return
+	}
+	o.phase = 0
+	o.state = egAttack
+	if o.rate(o.ar) >= 62 {
+		o.env = 0
+		o.state = egDecay
+	}
+}
+
+// envelope steps the envelope at global envelope counter cnt.
+func (o *operator) envelope(cnt int) {
+	var r int
+	switch o.state {
+	case egAttack:
+		r = o.rate(o.ar)
+	case egDecay:
+		r = o.rate(o.d1r)
+	case egSustain:
+		r = o.rate(o.d2r)
+	default:
+		r = o.rate(o.rr*2 + 1)
+	}
+	shift := uint(0)
+	if r < 48 {
+		shift = uint(11 - r/4)
+	}
+	if cnt&(1<<shift-1) != 0 {
+		return
+	}
+	var row int
+	switch {
+	case r < 2:
+		row = 18
+	case r < 6:
+		row = 0
+	case r < 8:
+		row = 2
+	case r < 48:
+		row = r & 3
+	case r < 60:
+		row = r - 44
+	default:
+		row = 16
+	}
+	inc := egInc[row][cnt>>shift&7]
+	switch o.state {
+	case egAttack:
+		if r >= 62 {
+			o.env = 0
+		} else {
+			o.env += ^o.env * inc >> 4
+		}
+		if o.env <= 0 {
+			o.env = 0
+			o.state = egDecay
+		}
+	case egDecay:
+		if o.env += inc; o.env >= o.sl {
+			o.state = egSustain
+		}
+	default:
+		if o.env += inc; o.env > 1023 {
+			o.env = 1023
+		}
+	}
+}
+
+// output returns the operator output for a phase modulation in sine table
+// units.
+func (o *operator) output(mod int, am int) float64 {
+	att := o.env + o.tl<<3
+	if o.am {
+		att += am
+	}
+	if att >= 1023 {
+		return 0
+	}
+	return sinTab[(int(o.phase>>10)+mod)&1023] * attTab[att]
+}
+
+type fmChannel struct {
+	ops         [4]operator
+	fnum, block int
+	fb, alg     int
+	left, right bool
+	ams, fms    int
+	// out1 holds the last two outputs of operator 1, for feedback.
+	out1 [2]float64
+}
+
+// ym2612 emulates the YM2612 FM synthesizer. SSG-EG and the timers are
+// not emulated.
+type ym2612 struct {
+	ch [6]fmChannel
+	// ch3 is set in channel 3 special mode, where its first three
+	// operators take their frequencies from ch3Fnum and ch3Block.
+	ch3      bool
+	ch3Fnum  [3]int
+	ch3Block [3]int
+	// latch and ch3Latch hold the high frequency bytes until the low
+	// bytes are written.
+	latch, ch3Latch byte
+
+	lfoOn     bool
+	lfoPeriod int
+	lfoCnt    int
+	lfoStep   int
+	egDiv     int
+	egCnt     int
+	dac       float64
+	dacOn     bool
+
+	// step is the number of chip samples per output sample. prev and cur
+	// are the last two chip samples, and t the position between them.
+	step, t   float64
+	prev, cur [2]float64
+}
+
+func newYM2612(clock uint32) *ym2612 {
+	y := &ym2612{
+		step:      float64(clock) / 144 / rate,
+		lfoPeriod: lfoPeriods[0],
+	}
+	for i := range y.ch {
+		c := &y.ch[i]
+		c.left, c.right = true, true
+		for j := range c.ops {
+			c.ops[j].env = 1023
+			c.ops[j].state = egRelease
+		}
+	}
+	return y
+}
+
+func (y *ym2612) write(port int, r, v byte) {
+	if r < 0x30 {
+		if port != 0 {
+			return
+		}
+		switch r {
+		case 0x22:
+			y.lfoOn = v&8 != 0
+			y.lfoPeriod = lfoPeriods[v&7]
+			if !y.lfoOn {
+				y.lfoStep = 0
+			}
+		case 0x27:
+			y.ch3 = v&0xc0 != 0
+		case 0x28:
+			n := int(v & 3)
+			if n == 3 {
+				return
+			}
+			if v&4 != 0 {
+				n += 3
+			}
+			c := &y.ch[n]
+			for i := range c.ops {
+				if v&(0x10<<uint(i)) != 0 {
+					c.ops[i].keyOn()
+				} else {
+					c.ops[i].state = egRelease
+				}
+			}
+		case 0x2a:
+			y.dac = (float64(v) - 128) / 128
+		case 0x2b:
+			y.dacOn = v&0x80 != 0
+		}
+		return
+	}
+	n := int(r & 3)
+	if n == 3 {
+		return
+	}
+	c := &y.ch[port*3+n]
+	if r < 0xa0 {
+		o := &c.ops[slots[r>>2&3]]
+		switch r & 0xf0 {
+		case 0x30:
+			o.dt = uint32(v >> 4 & 7)
+			o.mul = uint32(v & 0xf)
+		case 0x40:
+			o.tl = int(v & 0x7f)
+		case 0x50:
+			o.ks = int(v >> 6)
+			o.ar = int(v & 0x1f)
+		case 0x60:
+			o.am = v&0x80 != 0
+			o.d1r = int(v & 0x1f)
+		case 0x70:
+			o.d2r = int(v & 0x1f)
+		case 0x80:
+			o.sl = int(v>>4) << 5
+			if v>>4 == 15 {
+				o.sl = 31 << 5
+			}
+			o.rr = int(v & 0xf)
+		}
+		return
+	}
+	switch r & 0xfc {
+	case 0xa0:
+		c.fnum = int(y.latch&7)<<8 | int(v)
+		c.block = int(y.latch >> 3 & 7)
+	case 0xa4:
+		y.latch = v
+	case 0xa8:
+		if port == 0 {
+			y.ch3Fnum[n] = int(y.ch3Latch&7)<<8 | int(v)
+			y.ch3Block[n] = int(y.ch3Latch >> 3 & 7)
+		}
+	case 0xac:
+		if port == 0 {
+			y.ch3Latch = v
+		}
+	case 0xb0:
+		c.fb = int(v >> 3 & 7)
+		c.alg = int(v & 7)
+	case 0xb4:
+		c.left = v&0x80 != 0
+		c.right = v&0x40 != 0
+		c.ams = int(v >> 4 & 3)
+		c.fms = int(v & 7)
+	}
+}
+
+// ch3Ops maps operators 1 to 3 of channel 3 to their special mode
+// frequency registers.
+var ch3Ops = [3]int{1, 2, 0}
+
+// clock computes one chip sample.
+func (y *ym2612) clock() (l, r float64) {
+	if y.lfoOn {
+		if y.lfoCnt++; y.lfoCnt >= y.lfoPeriod {
+			y.lfoCnt = 0
+			y.lfoStep = (y.lfoStep + 1) & 127
+		}
+	}
+	lfoAM := y.lfoStep * 2
+	if y.lfoStep >= 64 {
+		lfoAM = (127 - y.lfoStep) * 2
+	}
+	lfoPM := math.Sin(2 * math.Pi * float64(y.lfoStep) / 128)
+
+	if y.egDiv++; y.egDiv == 3 {
+		y.egDiv = 0
+		y.egCnt = (y.egCnt + 1) & 0xfff
+		if y.egCnt == 0 {
+			y.egCnt = 1
+		}
+		for i := range y.ch {
+			for j := range y.ch[i].ops {
+				y.ch[i].ops[j].envelope(y.egCnt)
+			}
+		}
+	}
+
+	for i := range y.ch {
+		c := &y.ch[i]
+		for j := range c.ops {
+			fnum, block := c.fnum, c.block
+			if i == 2 && y.ch3 && j < 3 {
+				fnum, block = y.ch3Fnum[ch3Ops[j]], y.ch3Block[ch3Ops[j]]
+			}
+			if c.fms != 0 && y.lfoOn {
+				f := float64(fnum) * math.Exp2(fmsCents[c.fms]*lfoPM/1200)
+				fnum = int(f)
+			}
+			c.ops[j].setFreq(fnum&0x7ff, block)
+		}
+		var out float64
+		if i == 5 && y.dacOn {
+			out = y.dac
+		} else {
+			out = c.output(lfoAM >> amsShifts[c.ams])
+		}
+		if c.left {
+			l += out
+		}
+		if c.right {
+			r += out
+		}
+		for j := range c.ops {
+			c.ops[j].phase = (c.ops[j].phase + c.ops[j].inc) & 0xfffff
+		}
+	}
+	return l, r
+}
+
+// output computes the channel output for the algorithm.
+func (c *fmChannel) output(am int) float64 {
+	ops := &c.ops
+	// Modulation of one full output is four sine cycles.
+	m := func(x float64) int {
+		return int(x * 4096)
+	}
+	var fb int
+	if c.fb != 0 {
+		fb = int((c.out1[0] + c.out1[1]) * float64(int(8)<<uint(c.fb)))
+	}
+	o1 := ops[0].output(fb, am)
+	c.out1[0], c.out1[1] = c.out1[1], o1
+	var out float64
+	switch c.alg {
+	case 0:
+		o2 := ops[1].output(m(o1), am)
+		o3 := ops[2].output(m(o2), am)
+		out = ops[3].output(m(o3), am)
+	case 1:
+		o2 := ops[1].output(0, am)
+		o3 := ops[2].output(m(o1+o2), am)
+		out = ops[3].output(m(o3), am)
+	case 2:
+		o2 := ops[1].output(0, am)
+		o3 := ops[2].output(m(o2), am)
+		out = ops[3].output(m(o1+o3), am)
+	case 3:
+		o2 := ops[1].output(m(o1), am)
+		o3 := ops[2].output(0, am)
+		out = ops[3].output(m(o2+o3), am)
+	case 4:
+		o2 := ops[1].output(m(o1), am)
+		o3 := ops[2].output(0, am)
+		out = o2 + ops[3].output(m(o3), am)
+	case 5:
+		out = ops[1].output(m(o1), am) + ops[2].output(m(o1), am) + ops[3].output(m(o1), am)
+	case 6:
+		out = ops[1].output(m(o1), am) + ops[2].output(0, am) + ops[3].output(0, am)
+	default:
+		out = o1 + ops[1].output(0, am) + ops[2].output(0, am) + ops[3].output(0, am)
+	}
+	return math.Max(-1, math.Min(1, out))
+}
+
+// sample returns the stereo output, interpolated from the chip rate.
+func (y *ym2612) sample() (l, r float64) {
+	for y.t += y.step; y.t >= 1; y.t-- {
+		y.prev = y.cur
+		y.cur[0], y.cur[1] = y.clock()
+	}
+	l = y.prev[0] + (y.cur[0]-y.prev[0])*y.t
+	r = y.prev[1] + (y.cur[1]-y.prev[1])*y.t
+	return
+}
@@ -0,0 +1,52 @@
+package vorbis
+
+// bitReader reads the LSb-first packed values of a Vorbis packet. Reads past
+// the end of the packet return zero and set eop.
+type bitReader struct {
+	b   []byte
+	pos uint
+	eop bool
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+func (r *bitReader) read(n uint) uint32 {
+	var v uint32
+	var got uint
+	for got < n {
+		i := r.pos >> 3
+		if i >= uint(len(r.b)) {
+			r.eop = true
+			return 0
+		}
+		off := r.pos & 7
+		take := 8 - off
+		if take > n-got {
+			take = n - got
+		}
+		v |= (uint32(r.b[i]) >> off) & (1<<take - 1) << got
+		got += take
+		r.pos += take
+	}
+	return v
+}
+
+func (r *bitReader) readInt(n uint) int {
+	return int(r.read(n))
+}
+
+func (r *bitReader) readBool() bool {
+	return r.read(1) == 1
+}
+
+// ilog returns the number of bits needed to store x.
+func ilog(x int) uint {
+	var n uint
+	for x > 0 {
+		n++
+		x >>= 1
+	}
+	return n
+}
@@ -0,0 +1,224 @@
+package vorbis
+
+import (
+	"errors"
+	"math"
+)
+
+var errCodebook = errors.New("vorbis: bad codebook")
+
+// codebook is a Huffman tree with optional vector quantization values.
+type codebook struct {
+	dimensions int
+	entries    int
+	// tree holds pairs of child indices. Non-negative values index another
+	// pair; negative values are ^entry.
+	tree []int32
+	// vectors holds dimensions values per entry if the book has a lookup
+	// table.
+	vectors []float32
+}
+
+func (c *codebook) read(r *bitReader) error {
+	if r.read(24) != 0x564342 {
+		return errCodebook
+	}
+	c.dimensions = r.readInt(16)
+	c.entries = r.readInt(24)
+	lengths := make([]uint8, c.entries)
+	if r.readBool() {
+		// Ordered.
+		length := r.readInt(5) + 1
+		for i := 0; i < c.entries; {
+			n := r.readInt(ilog(c.entries - i))
+			if i+n > c.entries {
+				return errCodebook
+			}
+			for j := 0; j < n; j++ {
+				lengths[i+j] = uint8(length)
+			}
+			i += n
+			length++
+		}
+	} else {
+		sparse := r.readBool()
+		for i := range lengths {
+			if !sparse || r.readBool() {
+				lengths[i] = uint8(r.readInt(5) + 1)
+			}
+		}
+	}
+	if err := c.buildTree(lengths); err != nil {
+		return err
+	}
+	switch lookup := r.readInt(4); lookup {
+	case 0:
+	case 1, 2:
+		min := float32Unpack(r.read(32))
+		delta := float32Unpack(r.read(32))
+		bits := uint(r.readInt(4) + 1)
+		sequence := r.readBool()
+		var n int
+		if lookup == 1 {
+			n = lookup1Values(c.entries, c.dimensions)
+		} else {
+			n = c.entries * c.dimensions
+		}
+		mults := make([]uint32, n)
+		for i := range mults {
+			mults[i] = r.read(bits)
+		}
+		c.vectors = make([]float32, c.entries*c.dimensions)
+		for e := 0; e < c.entries; e++ {
+			v := c.vectors[e*c.dimensions : (e+1)*c.dimensions]
+			var last float32
+			div := 1
+			for i := range v {
+				var off int
+				if lookup == 1 {
+					off = e / div % n
+					div *= n
+				} else {
+					off = e*c.dimensions + i
+				}
+				v[i] = float32(mults[off])*delta + min + last
+				if sequence {
+					last = v[i]
+				}
+			}
+		}
+	default:
+		return errCodebook
+	}
+	if r.eop {
+		return errCodebook
+	}
+	return nil
+}
+
+// buildTree assigns codewords to entries as described in section 3.2.1 of
+// the specification and builds the decoding tree.
+func (c *codebook) buildTree(lengths []uint8) error {
+	c.tree = []int32{0, 0}
+	used, single := 0, 0
+	for i, l := range lengths {
+		if l > 0 {
+			used++
+			single = i
+		}
+	}
+	if used == 0 {
+		return nil
+	}
+	if used == 1 {
+		// A single entry decodes from one bit of either value.
+		c.tree = []int32{^int32(single), ^int32(single)}
+		return nil
+	}
+	var marker [33]uint32
+	for i, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		entry := marker[l]
+		if l < 32 && entry>>l != 0 {
+			return errCodebook
+		}
+		c.insert(entry, uint(l), i)
+		for j := l; j > 0; j-- {
+			if marker[j]&1 != 0 {
+				if j == 1 {
+					marker[1]++
+				} else {
+					marker[j] = marker[j-1] << 1
+				}
+				break
+			}
+			marker[j]++
+		}
+		for j := l + 1; j < 33; j++ {
+			if marker[j]>>1 != entry {
+				break
+			}
+			entry = marker[j]
+			marker[j] = marker[j-1] << 1
+		}
+	}
+	return nil
+}
+
+// insert adds an entry with the given MSb-first codeword to the tree.
+func (c *codebook) insert(code uint32, length uint, entry int) {
+	node := 0
+	for i := length; i > 0; i-- {
+		bit := int((code >> (i - 1)) & 1)
+		idx := node*2 + bit
+		if i == 1 {
+			c.tree[idx] = ^int32(entry)
+			return
+		}
+		if c.tree[idx] <= 0 {
+			// Zero is the root, so it never appears as a child.
+			c.tree = append(c.tree, 0, 0)
+			c.tree[idx] = int32(len(c.tree)/2 - 1)
+		}
+		node = int(c.tree[idx])
+	}
+}
+
+// decode reads one entry number. It returns -1 at the end of the packet or
+// for an unused codeword.
+func (c *codebook) decode(r *bitReader) int {
+	node := int32(0)
+	for {
+		b := r.read(1)
+		if r.eop {
+			return -1
+		}
+		node = c.tree[int(node)*2+int(b)]
+		if node < 0 {
+			return int(^node)
+		}
+		if node == 0 {
+			return -1
+		}
+	}
+}
+
+// decodeVector reads one entry and returns its vector.
+func (c *codebook) decodeVector(r *bitReader) []float32 {
+	e := c.decode(r)
+	if e < 0 || c.vectors == nil {
+		return nil
+	}
+	return c.vectors[e*c.dimensions : (e+1)*c.dimensions]
+}
+
+func float32Unpack(x uint32) float32 {
+	mantissa := float64(x & 0x1fffff)
+	exp := int((x & 0x7fe00000) >> 21)
+	if x&0x80000000 != 0 {
+		mantissa = -mantissa
+	}
+	return float32(math.Ldexp(mantissa, exp-788))
+}
+
+// lookup1Values returns the largest integer r where r^dimensions <= entries.
+func lookup1Values(entries, dimensions int) int {
+	r := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	for pow(r+1, dimensions) <= entries {
+		r++
+	}
+	for r > 0 && pow(r, dimensions) > entries {
+		r--
+	}
+	return r
+}
+
+func pow(x, n int) int {
+	v := 1
+	for i := 0; i < n; i++ {
+		v *= x
+	}
+	return v
+}
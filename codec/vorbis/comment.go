@@ -0,0 +1,176 @@
+package vorbis
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mjibson/mog/codec"
+)
+
+var errComment = errors.New("vorbis: bad comment header")
+
+// Comment is a Vorbis comment header. The same structure is used by Opus
+// and FLAC.
+type Comment struct {
+	Vendor string
+	// Tags holds the comments as field name, value pairs. Field names are
+	// upper case.
+	Tags [][2]string
+}
+
+// ParseComment parses a comment header body that starts at the vendor
+// string length.
+func ParseComment(b []byte) (*Comment, error) {
+	str := func() (string, error) {
+		if len(b) < 4 {
+			return "", errComment
+		}
+		n := binary.LittleEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return "", errComment
+		}
+		s := string(b[:n])
+		b = b[n:]
+		return s, nil
+	}
+	var c Comment
+	var err error
+	if c.Vendor, err = str(); err != nil {
+		return nil, err
+	}
+	if len(b) < 4 {
+		return nil, errComment
+	}
+	n := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < n; i++ {
+		s, err := str()
+		if err != nil {
+			return nil, err
+		}
+		sp := strings.SplitN(s, "=", 2)
+		if len(sp) != 2 {
+			continue
+		}
+		c.Tags = append(c.Tags, [2]string{strings.ToUpper(sp[0]), sp[1]})
+	}
+	return &c, nil
+}
+
+// Get returns the first value of the named field.
+func (c *Comment) Get(name string) string {
+	for _, t := range c.Tags {
+		if t[0] == name {
+			return t[1]
+		}
+	}
+	return ""
+}
+
+// Fill sets the fields of si from the comments.
+func (c *Comment) Fill(si *codec.SongInfo) {
+	for _, tag := range c.Tags {
+		switch tag[0] {
+		case "TITLE":
+			si.Title = tag[1]
+		case "ARTIST":
+			si.Artist = tag[1]
+		case "ALBUM":
+			si.Album = tag[1]
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			si.AlbumArtist = tag[1]
+		case "COMPOSER":
+			si.Composer = tag[1]
+		case "GENRE":
+			si.Genre = tag[1]
+		case "DATE", "YEAR":
+			if si.Date == "" {
+				si.Date = tag[1]
+			}
+		case "COMMENT", "DESCRIPTION":
+			if si.Comment == "" {
+				si.Comment = tag[1]
+			}
+		case "TRACKNUMBER":
+			n, _ := codec.ParseNumber(tag[1])
+			si.Track = float64(n)
+		case "DISCNUMBER":
+			n, total := codec.ParseNumber(tag[1])
+			si.Disc = n
+			if total != 0 {
+				si.DiscTotal = total
+			}
+		case "DISCTOTAL", "TOTALDISCS":
+			si.DiscTotal, _ = strconv.Atoi(strings.TrimSpace(tag[1]))
+		case "COMPILATION":
+			si.Compilation = tag[1] == "1"
+		case "REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_TRACK_PEAK",
+			"REPLAYGAIN_ALBUM_GAIN", "REPLAYGAIN_ALBUM_PEAK",
+			"R128_TRACK_GAIN", "R128_ALBUM_GAIN":
+			si.SetReplayGain(tag[0], tag[1])
+		case "METADATA_BLOCK_PICTURE":
+			if u, err := pictureURL(tag[1]); err == nil && si.ImageURL == "" {
+				si.ImageURL = u
+			}
+		case "COVERART":
+			if si.ImageURL == "" {
+				mime := c.Get("COVERARTMIME")
+				if mime == "" {
+					mime = "image/jpeg"
+				}
+				si.ImageURL = fmt.Sprintf("data:%s;base64,%s", mime, tag[1])
+			}
+		}
+	}
+}
+
+// pictureURL converts a base64 encoded FLAC picture block into a data URL.
+func pictureURL(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	next := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, errComment
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return nil, errComment
+		}
+		v := b[:n]
+		b = b[n:]
+		return v, nil
+	}
+	// Picture type.
+	if len(b) < 4 {
+		return "", errComment
+	}
+	b = b[4:]
+	mime, err := next()
+	if err != nil {
+		return "", err
+	}
+	if _, err := next(); err != nil {
+		return "", err
+	}
+	// Width, height, depth and colors.
+	if len(b) < 16 {
+		return "", errComment
+	}
+	b = b[16:]
+	data, err := next()
+	if err != nil {
+		return "", err
+	}
+	if string(mime) == "-->" {
+		return string(data), nil
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(data)), nil
+}
@@ -0,0 +1,367 @@
+package vorbis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/mjibson/mog/codec/mdct"
+)
+
+var (
+	errHeader = errors.New("vorbis: bad header")
+	errPacket = errors.New("vorbis: bad audio packet")
+)
+
+type mapping struct {
+	// magnitude and angle hold the coupling steps.
+	magnitude, angle []int
+	// mux maps each channel to a submap.
+	mux      []int
+	floors   []int
+	residues []int
+}
+
+type mode struct {
+	blockflag bool
+	mapping   int
+}
+
+// decoder decodes Vorbis packets to interleaved samples.
+type decoder struct {
+	channels   int
+	sampleRate int
+	bitrate    int
+	blocksize  [2]int
+
+	books    []codebook
+	floors   []floor
+	residues []*residue
+	mappings []mapping
+	modes    []mode
+
+	mdct    [2]*mdct.MDCT
+	windows map[[3]int][]float32
+	// prev holds the windowed output of the previous block, or is nil if
+	// there was none.
+	prev [][]float32
+	// cur holds scratch vectors for the current block.
+	cur [][]float32
+}
+
+// readIdent reads the identification header.
+func (d *decoder) readIdent(b []byte) error {
+	r := newBitReader(b)
+	if err := readCommon(r, 1); err != nil {
+		return err
+	}
+	if r.read(32) != 0 {
+		return errHeader
+	}
+	d.channels = r.readInt(8)
+	d.sampleRate = int(r.read(32))
+	r.read(32)
+	d.bitrate = int(int32(r.read(32)))
+	r.read(32)
+	d.blocksize[0] = 1 << r.read(4)
+	d.blocksize[1] = 1 << r.read(4)
+	if !r.readBool() || r.eop || d.channels == 0 || d.sampleRate == 0 ||
+		d.blocksize[0] < 64 || d.blocksize[1] > 8192 || d.blocksize[0] > d.blocksize[1] {
+		return errHeader
+	}
+	return nil
+}
+
+// readCommon reads the packet type and "vorbis" signature of a header.
+func readCommon(r *bitReader, typ int) error {
+	if r.readInt(8) != typ {
+		return errHeader
+	}
+	for _, c := range []byte("vorbis") {
+		if byte(r.read(8)) != c {
+			return errHeader
+		}
+	}
+	return nil
+}
+
+// readSetup reads the setup header.
+func (d *decoder) readSetup(b []byte) error {
+	r := newBitReader(b)
+	if err := readCommon(r, 5); err != nil {
+		return err
+	}
+	d.books = make([]codebook, r.readInt(8)+1)
+	for i := range d.books {
+		if err := d.books[i].read(r); err != nil {
+			return err
+		}
+	}
+	for i := r.readInt(6) + 1; i > 0; i-- {
+		if r.read(16) != 0 {
+			return errHeader
+		}
+	}
+	d.floors = make([]floor, r.readInt(6)+1)
+	for i := range d.floors {
+		var err error
+		switch r.readInt(16) {
+		case 0:
+			d.floors[i], err = readFloor0(r, len(d.books))
+		case 1:
+			d.floors[i], err = readFloor1(r, len(d.books))
+		default:
+			err = errFloor
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.residues = make([]*residue, r.readInt(6)+1)
+	for i := range d.residues {
+		typ := r.readInt(16)
+		if typ > 2 {
+			return errResidue
+		}
+		var err error
+		if d.residues[i], err = readResidue(r, typ, len(d.books)); err != nil {
+			return err
+		}
+	}
+	d.mappings = make([]mapping, r.readInt(6)+1)
+	for i := range d.mappings {
+		if r.read(16) != 0 {
+			return errHeader
+		}
+		m := &d.mappings[i]
+		submaps := 1
+		if r.readBool() {
+			submaps = r.readInt(4) + 1
+		}
+		if r.readBool() {
+			steps := r.readInt(8) + 1
+			m.magnitude = make([]int, steps)
+			m.angle = make([]int, steps)
+			bits := ilog(d.channels - 1)
+			for j := 0; j < steps; j++ {
+				m.magnitude[j] = r.readInt(bits)
+				m.angle[j] = r.readInt(bits)
+				if m.magnitude[j] == m.angle[j] || m.magnitude[j] >= d.channels || m.angle[j] >= d.channels {
+					return errHeader
+				}
+			}
+		}
+		if r.read(2) != 0 {
+			return errHeader
+		}
+		m.mux = make([]int, d.channels)
+		if submaps > 1 {
+			for j := range m.mux {
+				m.mux[j] = r.readInt(4)
+				if m.mux[j] >= submaps {
+					return errHeader
+				}
+			}
+		}
+		m.floors = make([]int, submaps)
+		m.residues = make([]int, submaps)
+		for j := 0; j < submaps; j++ {
+			r.read(8)
+			m.floors[j] = r.readInt(8)
+			m.residues[j] = r.readInt(8)
+			if m.floors[j] >= len(d.floors) || m.residues[j] >= len(d.residues) {
+				return errHeader
+			}
+		}
+	}
+	d.modes = make([]mode, r.readInt(6)+1)
+	for i := range d.modes {
+		m := &d.modes[i]
+		m.blockflag = r.readBool()
+		if r.read(16) != 0 || r.read(16) != 0 {
+			return errHeader
+		}
+		m.mapping = r.readInt(8)
+		if m.mapping >= len(d.mappings) {
+			return errHeader
+		}
+	}
+	if !r.readBool() || r.eop {
+		return fmt.Errorf("vorbis: bad setup header framing")
+	}
+	d.mdct[0] = mdct.New(d.blocksize[0])
+	d.mdct[1] = mdct.New(d.blocksize[1])
+	d.windows = make(map[[3]int][]float32)
+	d.cur = make([][]float32, d.channels)
+	for i := range d.cur {
+		d.cur[i] = make([]float32, d.blocksize[1])
+	}
+	return nil
+}
+
+// reset forgets the previous block, as needed after a seek.
+func (d *decoder) reset() {
+	d.prev = nil
+}
+
+// decode decodes an audio packet and returns the per-channel samples it
+// completes. The returned slices are only valid until the next call.
+func (d *decoder) decode(b []byte) ([][]float32, error) {
+	r := newBitReader(b)
+	if r.readBool() {
+		return nil, errPacket
+	}
+	mn := r.readInt(ilog(len(d.modes) - 1))
+	if mn >= len(d.modes) {
+		return nil, errPacket
+	}
+	mode := d.modes[mn]
+	bf := 0
+	prevLong, nextLong := false, false
+	if mode.blockflag {
+		bf = 1
+		prevLong = r.readBool()
+		nextLong = r.readBool()
+	}
+	if r.eop {
+		return nil, errPacket
+	}
+	n := d.blocksize[bf]
+	half := n / 2
+	m := &d.mappings[mode.mapping]
+
+	floors := make([]interface{}, d.channels)
+	skip := make([]bool, d.channels)
+	for ch := range floors {
+		f := d.floors[m.floors[m.mux[ch]]]
+		floors[ch] = f.decode(r, d.books, half)
+		skip[ch] = floors[ch] == nil
+	}
+	// Coupled channels are decoded if either of them is.
+	for i := range m.magnitude {
+		if !skip[m.magnitude[i]] || !skip[m.angle[i]] {
+			skip[m.magnitude[i]] = false
+			skip[m.angle[i]] = false
+		}
+	}
+	vs := make([][]float32, d.channels)
+	for ch := range vs {
+		vs[ch] = d.cur[ch][:n]
+		for i := range vs[ch] {
+			vs[ch][i] = 0
+		}
+	}
+	for sm := range m.residues {
+		var svs [][]float32
+		var sskip []bool
+		for ch := range vs {
+			if m.mux[ch] == sm {
+				svs = append(svs, vs[ch][:half])
+				sskip = append(sskip, skip[ch])
+			}
+		}
+		d.residues[m.residues[sm]].decode(r, d.books, svs, sskip, half)
+	}
+	for i := len(m.magnitude) - 1; i >= 0; i-- {
+		mag := vs[m.magnitude[i]]
+		ang := vs[m.angle[i]]
+		for j := 0; j < half; j++ {
+			M, A := mag[j], ang[j]
+			switch {
+			case M > 0 && A > 0:
+				mag[j], ang[j] = M, M-A
+			case M > 0:
+				mag[j], ang[j] = M+A, M
+			case A > 0:
+				mag[j], ang[j] = M, M+A
+			default:
+				mag[j], ang[j] = M-A, M
+			}
+		}
+	}
+	win := d.window(bf, prevLong, nextLong)
+	out := make([]float32, n)
+	for ch, v := range vs {
+		if floors[ch] == nil {
+			for i := range v[:half] {
+				v[i] = 0
+			}
+		} else {
+			d.floors[m.floors[m.mux[ch]]].apply(floors[ch], v[:half])
+		}
+		d.mdct[bf].Inverse(v[:half], out)
+		for i := range out {
+			v[i] = out[i] * win[i]
+		}
+	}
+	prev := d.prev
+	d.prev = make([][]float32, d.channels)
+	for ch, v := range vs {
+		d.prev[ch] = append([]float32(nil), v...)
+	}
+	if prev == nil {
+		return make([][]float32, d.channels), nil
+	}
+	// Return from the center of the previous block to the center of this
+	// one, overlapping the previous right half with this left half.
+	pn := len(prev[0])
+	start := pn*3/4 - n/4
+	res := make([][]float32, d.channels)
+	for ch := range res {
+		o := make([]float32, pn/4+n/4)
+		for i := range o {
+			a := pn/2 + i
+			if a < pn {
+				o[i] = prev[ch][a]
+			}
+			if a >= start {
+				o[i] += vs[ch][a-start]
+			}
+		}
+		res[ch] = o
+	}
+	return res, nil
+}
+
+// window returns the window for a block of size blocksize[bf] with the given
+// neighbors.
+func (d *decoder) window(bf int, prevLong, nextLong bool) []float32 {
+	key := [3]int{bf, 0, 0}
+	if bf == 1 {
+		if prevLong {
+			key[1] = 1
+		}
+		if nextLong {
+			key[2] = 1
+		}
+	}
+	if w, ok := d.windows[key]; ok {
+		return w
+	}
+	n := d.blocksize[bf]
+	bs0 := d.blocksize[0]
+	w := make([]float32, n)
+	leftStart, leftEnd, leftN := 0, n/2, n/2
+	if bf == 1 && !prevLong {
+		leftStart, leftEnd, leftN = n/4-bs0/4, n/4+bs0/4, bs0/2
+	}
+	rightStart, rightEnd, rightN := n/2, n, n/2
+	if bf == 1 && !nextLong {
+		rightStart, rightEnd, rightN = n*3/4-bs0/4, n*3/4+bs0/4, bs0/2
+	}
+	slope := func(i, n int) float32 {
+		s := math.Sin((float64(i) + .5) / float64(n) * math.Pi / 2)
+		return float32(math.Sin(math.Pi / 2 * s * s))
+	}
+	for i := leftStart; i < leftEnd; i++ {
+		w[i] = slope(i-leftStart, leftN)
+	}
+	for i := leftEnd; i < rightStart; i++ {
+		w[i] = 1
+	}
+	for i := rightStart; i < rightEnd; i++ {
+		w[i] = slope(rightEnd-1-i, rightN)
+	}
+	d.windows[key] = w
+	return w
+}
@@ -0,0 +1,399 @@
+package vorbis
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+var errFloor = errors.New("vorbis: bad floor")
+
+// floor decodes a spectral envelope. decode returns nil if the channel is
+// unused in this packet; otherwise apply multiplies the data into a
+// residue vector of length n.
+type floor interface {
+	decode(r *bitReader, books []codebook, n int) interface{}
+	apply(data interface{}, out []float32)
+}
+
+type floor0 struct {
+	order          int
+	rate           int
+	barkMapSize    int
+	amplitudeBits  uint
+	amplitudeOffet int
+	books          []int
+	// maps caches the bark map for each block size.
+	maps map[int][]int
+}
+
+func readFloor0(r *bitReader, nbooks int) (*floor0, error) {
+	f := &floor0{
+		order:          r.readInt(8),
+		rate:           r.readInt(16),
+		barkMapSize:    r.readInt(16),
+		amplitudeBits:  uint(r.readInt(6)),
+		amplitudeOffet: r.readInt(8),
+		maps:           make(map[int][]int),
+	}
+	f.books = make([]int, r.readInt(4)+1)
+	for i := range f.books {
+		f.books[i] = r.readInt(8)
+		if f.books[i] >= nbooks {
+			return nil, errFloor
+		}
+	}
+	if f.order < 1 || f.rate < 1 || f.barkMapSize < 1 {
+		return nil, errFloor
+	}
+	return f, nil
+}
+
+type floor0Data struct {
+	amplitude int
+	coeffs    []float32
+}
+
+func (f *floor0) decode(r *bitReader, books []codebook, n int) interface{} {
+	amp := r.readInt(f.amplitudeBits)
+	if amp == 0 || r.eop {
+		return nil
+	}
+	bn := r.readInt(ilog(len(f.books)))
+	if bn >= len(f.books) {
+		return nil
+	}
+	book := &books[f.books[bn]]
+	coeffs := make([]float32, 0, f.order+book.dimensions)
+	var last float32
+	for len(coeffs) < f.order {
+		v := book.decodeVector(r)
+		if v == nil {
+			return nil
+		}
+		for _, c := range v {
+			coeffs = append(coeffs, c+last)
+		}
+		last = coeffs[len(coeffs)-1]
+	}
+	return &floor0Data{amp, coeffs[:f.order]}
+}
+
+func bark(x float64) float64 {
+	return 13.1*math.Atan(.00074*x) + 2.24*math.Atan(.0000000185*x*x) + .0001*x
+}
+
+func (f *floor0) barkMap(n int) []int {
+	if m, ok := f.maps[n]; ok {
+		return m
+	}
+	m := make([]int, n+1)
+	scale := float64(f.barkMapSize) / bark(.5*float64(f.rate))
+	for i := 0; i < n; i++ {
+		v := int(math.Floor(bark(float64(f.rate)*float64(i)/(2*float64(n))) * scale))
+		if v > f.barkMapSize-1 {
+			v = f.barkMapSize - 1
+		}
+		m[i] = v
+	}
+	m[n] = -1
+	f.maps[n] = m
+	return m
+}
+
+func (f *floor0) apply(data interface{}, out []float32) {
+	d := data.(*floor0Data)
+	n := len(out)
+	m := f.barkMap(n)
+	cos := make([]float64, len(d.coeffs))
+	for i, c := range d.coeffs {
+		cos[i] = math.Cos(float64(c))
+	}
+	for i := 0; i < n; {
+		w := math.Cos(math.Pi * float64(m[i]) / float64(f.barkMapSize))
+		var p, q float64
+		if f.order%2 == 1 {
+			p = 1 - w*w
+			q = .25
+			for j := 0; j < (f.order-3)/2+1; j++ {
+				p *= 4 * (cos[2*j+1] - w) * (cos[2*j+1] - w)
+			}
+			for j := 0; j < (f.order-1)/2+1; j++ {
+				q *= 4 * (cos[2*j] - w) * (cos[2*j] - w)
+			}
+		} else {
+			p = 1 - w
+			q = 1 + w
+			for j := 0; j < (f.order-2)/2+1; j++ {
+				p *= 4 * (cos[2*j+1] - w) * (cos[2*j+1] - w)
+				q *= 4 * (cos[2*j] - w) * (cos[2*j] - w)
+			}
+		}
+		v := float32(math.Exp(.11512925 * (float64(d.amplitude)*float64(f.amplitudeOffet)/(float64(int(1)<<f.amplitudeBits-1)*math.Sqrt(p+q)) - float64(f.amplitudeOffet))))
+		cond := m[i]
+		for {
+			out[i] *= v
+			i++
+			if m[i] != cond {
+				break
+			}
+		}
+	}
+}
+
+type floor1 struct {
+	partitionClass []int
+	classDims      []int
+	classSubs      []uint
+	classMaster    []int
+	subclassBooks  [][]int
+	multiplier     int
+	xs             []int
+	// order sorts xs.
+	order []int
+	// low and high are the neighbors of each point.
+	low, high []int
+}
+
+func readFloor1(r *bitReader, nbooks int) (*floor1, error) {
+	f := new(floor1)
+	f.partitionClass = make([]int, r.readInt(5))
+	maxClass := -1
+	for i := range f.partitionClass {
+		f.partitionClass[i] = r.readInt(4)
+		if f.partitionClass[i] > maxClass {
+			maxClass = f.partitionClass[i]
+		}
+	}
+	f.classDims = make([]int, maxClass+1)
+	f.classSubs = make([]uint, maxClass+1)
+	f.classMaster = make([]int, maxClass+1)
+	f.subclassBooks = make([][]int, maxClass+1)
+	for i := range f.classDims {
+		f.classDims[i] = r.readInt(3) + 1
+		f.classSubs[i] = uint(r.readInt(2))
+		if f.classSubs[i] != 0 {
+			f.classMaster[i] = r.readInt(8)
+			if f.classMaster[i] >= nbooks {
+				return nil, errFloor
+			}
+		}
+		f.subclassBooks[i] = make([]int, 1<<f.classSubs[i])
+		for j := range f.subclassBooks[i] {
+			f.subclassBooks[i][j] = r.readInt(8) - 1
+			if f.subclassBooks[i][j] >= nbooks {
+				return nil, errFloor
+			}
+		}
+	}
+	f.multiplier = r.readInt(2) + 1
+	rangeBits := uint(r.readInt(4))
+	f.xs = []int{0, 1 << rangeBits}
+	for _, c := range f.partitionClass {
+		for j := 0; j < f.classDims[c]; j++ {
+			f.xs = append(f.xs, r.readInt(rangeBits))
+		}
+	}
+	if len(f.xs) > 65 {
+		return nil, errFloor
+	}
+	f.order = make([]int, len(f.xs))
+	for i := range f.order {
+		f.order[i] = i
+	}
+	sort.Sort(byX{f.order, f.xs})
+	for i := 1; i < len(f.order); i++ {
+		if f.xs[f.order[i]] == f.xs[f.order[i-1]] {
+			return nil, errFloor
+		}
+	}
+	f.low = make([]int, len(f.xs))
+	f.high = make([]int, len(f.xs))
+	for i := 2; i < len(f.xs); i++ {
+		lo, hi := -1, -1
+		for j := 0; j < i; j++ {
+			if f.xs[j] < f.xs[i] && (lo < 0 || f.xs[j] > f.xs[lo]) {
+				lo = j
+			}
+			if f.xs[j] > f.xs[i] && (hi < 0 || f.xs[j] < f.xs[hi]) {
+				hi = j
+			}
+		}
+		f.low[i], f.high[i] = lo, hi
+	}
+	return f, nil
+}
+
+type byX struct {
+	order, xs []int
+}
+
+func (b byX) Len() int           { return len(b.order) }
+func (b byX) Less(i, j int) bool { return b.xs[b.order[i]] < b.xs[b.order[j]] }
+func (b byX) Swap(i, j int)      { b.order[i], b.order[j] = b.order[j], b.order[i] }
+
+var floor1Ranges = [...]int{256, 128, 86, 64}
+
+func (f *floor1) decode(r *bitReader, books []codebook, n int) interface{} {
+	if !r.readBool() {
+		return nil
+	}
+	rng := floor1Ranges[f.multiplier-1]
+	ys := make([]int, len(f.xs))
+	ys[0] = r.readInt(ilog(rng - 1))
+	ys[1] = r.readInt(ilog(rng - 1))
+	off := 2
+	for _, class := range f.partitionClass {
+		cdim := f.classDims[class]
+		cbits := f.classSubs[class]
+		csub := 1<<cbits - 1
+		cval := 0
+		if cbits > 0 {
+			cval = books[f.classMaster[class]].decode(r)
+			if cval < 0 {
+				return nil
+			}
+		}
+		for j := 0; j < cdim; j++ {
+			book := f.subclassBooks[class][cval&csub]
+			cval >>= cbits
+			if book >= 0 {
+				v := books[book].decode(r)
+				if v < 0 {
+					return nil
+				}
+				ys[off] = v
+			}
+			off++
+		}
+	}
+	if r.eop {
+		return nil
+	}
+	return f.synthesize(ys, rng)
+}
+
+// synthesize performs amplitude value synthesis and returns the final Y
+// values, or -1 for points that aren't used in curve synthesis.
+func (f *floor1) synthesize(ys []int, rng int) []int {
+	final := make([]int, len(ys))
+	step2 := make([]bool, len(ys))
+	step2[0], step2[1] = true, true
+	final[0], final[1] = ys[0], ys[1]
+	for i := 2; i < len(ys); i++ {
+		lo, hi := f.low[i], f.high[i]
+		pred := renderPoint(f.xs[lo], final[lo], f.xs[hi], final[hi], f.xs[i])
+		val := ys[i]
+		highroom := rng - pred
+		lowroom := pred
+		room := highroom
+		if lowroom < room {
+			room = lowroom
+		}
+		room *= 2
+		if val == 0 {
+			final[i] = pred
+			continue
+		}
+		step2[lo], step2[hi], step2[i] = true, true, true
+		if val >= room {
+			if highroom > lowroom {
+				final[i] = val - lowroom + pred
+			} else {
+				final[i] = pred - val + highroom - 1
+			}
+		} else if val%2 == 1 {
+			final[i] = pred - (val+1)/2
+		} else {
+			final[i] = pred + val/2
+		}
+	}
+	for i := range final {
+		if !step2[i] {
+			final[i] = -1
+		}
+	}
+	return final
+}
+
+func renderPoint(x0, y0, x1, y1, x int) int {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	off := ady * (x - x0) / adx
+	if dy < 0 {
+		return y0 - off
+	}
+	return y0 + off
+}
+
+func (f *floor1) apply(data interface{}, out []float32) {
+	ys := data.([]int)
+	n := len(out)
+	curve := make([]int, n)
+	lx, ly := 0, ys[f.order[0]]*f.multiplier
+	hx, hy := 0, 0
+	for _, i := range f.order[1:] {
+		if ys[i] < 0 {
+			continue
+		}
+		hx, hy = f.xs[i], ys[i]*f.multiplier
+		renderLine(lx, ly, hx, hy, curve)
+		lx, ly = hx, hy
+	}
+	if hx < n {
+		renderLine(hx, hy, n, hy, curve)
+	}
+	for i, y := range curve {
+		if y < 0 {
+			y = 0
+		} else if y > 255 {
+			y = 255
+		}
+		out[i] *= inverseDB[y]
+	}
+}
+
+// renderLine draws the line from (x0, y0) to (x1, y1) into v.
+func renderLine(x0, y0, x1, y1 int, v []int) {
+	dy := y1 - y0
+	adx := x1 - x0
+	ady := dy
+	if ady < 0 {
+		ady = -ady
+	}
+	base := dy / adx
+	sy := base + 1
+	if dy < 0 {
+		sy = base - 1
+	}
+	abase := base
+	if abase < 0 {
+		abase = -abase
+	}
+	ady -= abase * adx
+	x, y, e := x0, y0, 0
+	if x < len(v) {
+		v[x] = y
+	}
+	for x++; x < x1 && x < len(v); x++ {
+		e += ady
+		if e >= adx {
+			e -= adx
+			y += sy
+		} else {
+			y += base
+		}
+		v[x] = y
+	}
+}
+
+var inverseDB = func() (t [256]float32) {
+	for i := range t {
+		t[i] = float32(math.Exp(.11512925 * float64(i-255) * 140 / 256))
+	}
+	return
+}()
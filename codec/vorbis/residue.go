@@ -0,0 +1,166 @@
+package vorbis
+
+import "errors"
+
+var errResidue = errors.New("vorbis: bad residue")
+
+type residue struct {
+	typ             int
+	begin, end      int
+	partitionSize   int
+	classifications int
+	classbook       int
+	// books holds the book for each classification and pass, or -1.
+	books [][8]int
+}
+
+func readResidue(r *bitReader, typ, nbooks int) (*residue, error) {
+	res := &residue{
+		typ:             typ,
+		begin:           r.readInt(24),
+		end:             r.readInt(24),
+		partitionSize:   r.readInt(24) + 1,
+		classifications: r.readInt(6) + 1,
+		classbook:       r.readInt(8),
+	}
+	if res.classbook >= nbooks {
+		return nil, errResidue
+	}
+	cascade := make([]int, res.classifications)
+	for i := range cascade {
+		cascade[i] = r.readInt(3)
+		if r.readBool() {
+			cascade[i] |= r.readInt(5) << 3
+		}
+	}
+	res.books = make([][8]int, res.classifications)
+	for i := range res.books {
+		for j := range res.books[i] {
+			res.books[i][j] = -1
+			if cascade[i]&(1<<uint(j)) != 0 {
+				res.books[i][j] = r.readInt(8)
+				if res.books[i][j] >= nbooks {
+					return nil, errResidue
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// decode decodes residue vectors of length n into vs. Vectors whose
+// skip flag is set are left zeroed.
+func (res *residue) decode(r *bitReader, books []codebook, vs [][]float32, skip []bool, n int) {
+	if res.typ == 2 {
+		all := true
+		for _, s := range skip {
+			all = all && s
+		}
+		if all {
+			return
+		}
+		ch := len(vs)
+		v := make([]float32, n*ch)
+		res.decodeVectors(r, books, [][]float32{v}, []bool{false}, n*ch, 1)
+		for i := 0; i < n; i++ {
+			for j := range vs {
+				vs[j][i] = v[i*ch+j]
+			}
+		}
+		return
+	}
+	res.decodeVectors(r, books, vs, skip, n, res.typ)
+}
+
+func (res *residue) decodeVectors(r *bitReader, books []codebook, vs [][]float32, skip []bool, n, typ int) {
+	begin, end := res.begin, res.end
+	if begin > n {
+		begin = n
+	}
+	if end > n {
+		end = n
+	}
+	cb := &books[res.classbook]
+	perWord := cb.dimensions
+	psize := res.partitionSize
+	toRead := (end - begin) / psize
+	if toRead <= 0 || perWord < 1 {
+		return
+	}
+	classes := make([][]int, len(vs))
+	for i := range classes {
+		classes[i] = make([]int, toRead+perWord)
+	}
+	for pass := 0; pass < 8; pass++ {
+		for part := 0; part < toRead; {
+			if pass == 0 {
+				for j := range vs {
+					if skip[j] {
+						continue
+					}
+					temp := cb.decode(r)
+					if temp < 0 {
+						return
+					}
+					for i := perWord - 1; i >= 0; i-- {
+						classes[j][i+part] = temp % res.classifications
+						temp /= res.classifications
+					}
+				}
+			}
+			for i := 0; i < perWord && part < toRead; i++ {
+				for j, v := range vs {
+					if skip[j] {
+						continue
+					}
+					book := res.books[classes[j][part]][pass]
+					if book < 0 {
+						continue
+					}
+					b := &books[book]
+					if b.vectors == nil {
+						continue
+					}
+					off := begin + part*psize
+					if !res.decodePartition(r, b, v[off:off+psize], typ) {
+						return
+					}
+				}
+				part++
+			}
+		}
+	}
+}
+
+// decodePartition adds one partition of VQ values to v. It returns false at
+// the end of the packet.
+func (res *residue) decodePartition(r *bitReader, b *codebook, v []float32, typ int) bool {
+	dim := b.dimensions
+	if typ == 0 {
+		step := len(v) / dim
+		for j := 0; j < step; j++ {
+			e := b.decodeVector(r)
+			if e == nil {
+				return false
+			}
+			for i, x := range e {
+				v[j+i*step] += x
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(v); {
+		e := b.decodeVector(r)
+		if e == nil {
+			return false
+		}
+		for _, x := range e {
+			if i >= len(v) {
+				break
+			}
+			v[i] += x
+			i++
+		}
+	}
+	return true
+}
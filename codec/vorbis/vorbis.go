@@ -0,0 +1,273 @@
+package vorbis
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/ogg"
+)
+
+func init() {
+	// The identification header packet follows the 28 byte first page
+	// header.
+	codec.RegisterCodec("Vorbis", "OggS"+strings.Repeat("?", 24)+"\x01vorbis", []string{"ogg", "oga"}, New)
+}
+
+func New(rf codec.Reader) ([]codec.Song, error) {
+	v := Vorbis{
+		Reader: rf,
+	}
+	return []codec.Song{&v}, nil
+}
+
+type Vorbis struct {
+	Reader codec.Reader
+	r      io.ReadCloser
+	or     *ogg.Reader
+	d      *decoder
+	// dataStart is the byte offset of the first audio page.
+	dataStart int64
+	// buf holds decoded, interleaved samples not yet played.
+	buf []float32
+	// pos is the sample number of the end of buf. It is unknown after a
+	// seek until a page with a granule position is reached.
+	pos   int64
+	known bool
+	eos   bool
+}
+
+// channelOrder maps Vorbis channel order to WAV order for each channel
+// count.
+var channelOrder = [...][]int{
+	3: {0, 2, 1},
+	5: {0, 2, 1, 3, 4},
+	6: {0, 2, 1, 5, 3, 4},
+	7: {0, 2, 1, 6, 5, 3, 4},
+	8: {0, 2, 1, 7, 5, 6, 3, 4},
+}
+
+// readHeaders reads the identification and comment headers, and the setup
+// header if setup is set.
+func readHeaders(or *ogg.Reader, setup bool) (*decoder, *Comment, error) {
+	d := new(decoder)
+	p, err := or.NextPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.readIdent(p.Data); err != nil {
+		return nil, nil, err
+	}
+	p, err = or.NextPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	r := newBitReader(p.Data)
+	if err := readCommon(r, 3); err != nil {
+		return nil, nil, err
+	}
+	c, err := ParseComment(p.Data[7:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !setup {
+		return d, c, nil
+	}
+	p, err = or.NextPacket()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.readSetup(p.Data); err != nil {
+		return nil, nil, err
+	}
+	return d, c, nil
+}
+
+func (v *Vorbis) Init() (sampleRate, channels int, err error) {
+	if v.d == nil {
+		r, _, err := v.Reader()
+		if err != nil {
+			return 0, 0, err
+		}
+		or := ogg.NewReader(r)
+		d, _, err := readHeaders(or, true)
+		if err != nil {
+			r.Close()
+			return 0, 0, err
+		}
+		v.r = r
+		v.or = or
+		v.d = d
+		v.dataStart = or.Offset
+		v.buf = nil
+		v.pos = 0
+		v.known = true
+		v.eos = false
+	}
+	return v.d.sampleRate, v.d.channels, nil
+}
+
+func (v *Vorbis) Info() (info codec.SongInfo, err error) {
+	r, size, err := v.Reader()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	or := ogg.NewReader(r)
+	d, c, err := readHeaders(or, false)
+	if err != nil {
+		return
+	}
+	c.Fill(&info)
+	info.Codec = "Vorbis"
+	info.SampleRate = d.sampleRate
+	info.Channels = d.channels
+	info.Size = size
+	info.Bitrate = d.bitrate
+	if _, ok := r.(io.Seeker); ok {
+		if g, err := ogg.LastGranule(r, size, or.Serial()); err == nil {
+			info.Time = time.Duration(g) * time.Second / time.Duration(d.sampleRate)
+			info.Bitrate = codec.Bitrate(size, info.Time)
+		}
+	} else if size > 0 && d.bitrate > 0 {
+		// Reading the whole stream would be too slow, so estimate.
+		info.Time = time.Duration(size*8) * time.Second / time.Duration(d.bitrate)
+	}
+	return info, nil
+}
+
+// fill decodes the next audio packet into buf.
+func (v *Vorbis) fill() error {
+	p, err := v.or.NextPacket()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		v.eos = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if p.EOS {
+		v.eos = true
+	}
+	out, err := v.d.decode(p.Data)
+	if err != nil {
+		// Skip undecodable packets.
+		return nil
+	}
+	n := int64(len(out[0]))
+	if v.known && p.Granule >= 0 && p.EOS && v.pos+n > p.Granule {
+		// The last page's granule position trims the final block.
+		n = p.Granule - v.pos
+		if n < 0 {
+			n = 0
+		}
+	}
+	order := []int(nil)
+	if len(out) < len(channelOrder) {
+		order = channelOrder[len(out)]
+	}
+	for i := 0; i < int(n); i++ {
+		for ch := range out {
+			src := ch
+			if order != nil {
+				src = order[ch]
+			}
+			v.buf = append(v.buf, out[src][i])
+		}
+	}
+	v.pos += n
+	if !v.known && p.Granule >= 0 {
+		v.pos = p.Granule
+		v.known = true
+	}
+	return nil
+}
+
+func (v *Vorbis) Play(n int) ([]float32, error) {
+	for len(v.buf) < n && !v.eos {
+		if err := v.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if n > len(v.buf) {
+		n = len(v.buf)
+	}
+	ret := make([]float32, n)
+	copy(ret, v.buf)
+	v.buf = v.buf[n:]
+	return ret, nil
+}
+
+// Seek finds the last page that ends at least one long block before
+// offset and decodes forward from there.
+func (v *Vorbis) Seek(offset time.Duration) error {
+	if v.d == nil {
+		return fmt.Errorf("vorbis: seek before init")
+	}
+	target := int64(offset) * int64(v.d.sampleRate) / int64(time.Second)
+	start := v.dataStart
+	if margin := target - int64(v.d.blocksize[1]); margin > 0 {
+		r, err := codec.OpenAt(v.Reader, v.dataStart)
+		if err != nil {
+			return err
+		}
+		or := ogg.NewReader(r)
+		or.Offset = v.dataStart
+		prev := start
+		for {
+			h, err := or.ReadPageHeader()
+			if err != nil {
+				break
+			}
+			if h.Serial == v.or.Serial() && h.Granule != -1 && h.Granule > margin {
+				if h.EOS() {
+					// The last page's granule position is trimmed, so
+					// it cannot place the samples before it.
+					start = prev
+				}
+				break
+			}
+			if err := or.SkipBody(h); err != nil {
+				break
+			}
+			if h.Serial == v.or.Serial() && h.Granule != -1 {
+				prev, start = start, or.Offset
+			}
+		}
+		r.Close()
+	}
+	r, err := codec.OpenAt(v.Reader, start)
+	if err != nil {
+		return err
+	}
+	v.r.Close()
+	v.r = r
+	v.or.Reset(r, start)
+	v.d.reset()
+	v.buf = v.buf[:0]
+	v.eos = false
+	v.pos = 0
+	v.known = start == v.dataStart
+	for !v.eos && (!v.known || v.pos <= target) {
+		if err := v.fill(); err != nil {
+			return err
+		}
+	}
+	ch := int64(v.d.channels)
+	begin := v.pos - int64(len(v.buf))/ch
+	if skip := (target - begin) * ch; skip > 0 {
+		if skip > int64(len(v.buf)) {
+			skip = int64(len(v.buf))
+		}
+		v.buf = v.buf[skip:]
+	}
+	return nil
+}
+
+func (v *Vorbis) Close() {
+	if v.r != nil {
+		v.r.Close()
+	}
+	v.r, v.or, v.d, v.buf = nil, nil, nil, nil
+}
@@ -0,0 +1,90 @@
+package vorbis
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// reference returns the samples of testdata/test.ogg decoded by another
+// decoder.
+func reference(t *testing.T) []float32 {
+	f, err := os.Open("testdata/test.raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]float32, fi.Size()/4)
+	if err := binary.Read(f, binary.LittleEndian, b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func open(t *testing.T) codec.Song {
+	return codectest.Open(t, New, codectest.File("testdata/test.ogg"), 0, 44100, 1)
+}
+
+func compare(t *testing.T, s codec.Song, want []float32) {
+	t.Helper()
+	var got []float32
+	for {
+		b, err := s.Play(4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, b...)
+		if len(b) < 4096 {
+			break
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(float64(got[i]-want[i])) > 2e-5 {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	want := reference(t)
+	s := open(t)
+	defer s.Close()
+	info, err := s.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Time != time.Second {
+		t.Fatalf("time %v", info.Time)
+	}
+	compare(t, s, want)
+}
+
+func TestSeek(t *testing.T) {
+	want := reference(t)
+	s := open(t)
+	defer s.Close()
+	for _, offset := range []time.Duration{
+		500 * time.Millisecond,
+		100 * time.Millisecond,
+		0,
+		999 * time.Millisecond,
+		time.Second,
+	} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		compare(t, s, want[int(offset.Seconds()*44100):])
+	}
+}
@@ -0,0 +1,157 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// parseAIFF parses the chunks of an AIFF or AIFF-C file after its header.
+func parseAIFF(cr *chunkReader, aifc bool, tags bool) (*header, error) {
+	be := binary.BigEndian
+	h := &header{size: -1}
+	var gotFormat bool
+	var comments []string
+	for {
+		b, err := cr.read(8)
+		if err != nil {
+			if h.offset != 0 {
+				break
+			}
+			return nil, err
+		}
+		id, size := string(b[:4]), int64(be.Uint32(b[4:]))
+		pad := size & 1
+		switch id {
+		case "SSND":
+			if !gotFormat {
+				return nil, fmt.Errorf("aiff: SSND before COMM chunk")
+			}
+			if b, err = cr.read(8); err != nil {
+				return nil, err
+			}
+			// The samples start offset bytes after the chunk header.
+			offset := int64(be.Uint32(b))
+			if err := cr.skip(offset); err != nil {
+				return nil, err
+			}
+			h.offset = cr.off
+			if size -= 8 + offset; size < 0 {
+				return nil, fmt.Errorf("aiff: bad SSND chunk")
+			}
+			// COMM limits the samples to its number of frames.
+			if h.size < 0 || size < h.size {
+				h.size = size
+			}
+			if !tags {
+				h.info.Time = h.duration()
+				return h, nil
+			}
+			err = cr.skip(size + pad)
+		case "COMM", "NAME", "AUTH", "ANNO", "(c) ", "ID3 ", "id3 ":
+			if b, err = cr.read(size + pad); err != nil {
+				break
+			}
+			switch id {
+			case "COMM":
+				if err := h.parseComm(b, aifc); err != nil {
+					return nil, err
+				}
+				gotFormat = true
+			case "NAME":
+				h.info.Title = text(b[:size])
+			case "AUTH":
+				h.info.Artist = text(b[:size])
+			case "ANNO", "(c) ":
+				comments = append(comments, text(b[:size]))
+			case "ID3 ", "id3 ":
+				h.parseID3(b)
+			}
+		default:
+			err = cr.skip(size + pad)
+		}
+		if err != nil {
+			// Tags after the sample data are optional.
+			if h.offset != 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+	if h.offset == 0 {
+		return nil, errNoData
+	}
+	for _, c := range comments {
+		if h.info.Comment != "" {
+			h.info.Comment += "\n"
+		}
+		h.info.Comment += c
+	}
+	h.info.Time = h.duration()
+	return h, nil
+}
+
+// parseComm parses a COMM chunk.
+func (h *header) parseComm(b []byte, aifc bool) error {
+	if len(b) < 18 || aifc && len(b) < 22 {
+		return fmt.Errorf("aiff: short COMM chunk")
+	}
+	be := binary.BigEndian
+	h.channels = int(be.Uint16(b))
+	frames := int64(be.Uint32(b[2:]))
+	bits := int(be.Uint16(b[6:]))
+	h.rate = int(extended(b[8:18]) + 0.5)
+	h.width = (bits + 7) / 8
+	h.bits = bits
+	h.encoding = encInt
+	h.bigEndian = true
+	compression := "NONE"
+	if aifc {
+		compression = string(b[18:22])
+	}
+	switch compression {
+	case "NONE", "twos":
+	case "sowt":
+		h.bigEndian = false
+	case "raw ":
+		h.encoding = encUint8
+		h.width = 1
+	case "in24":
+		h.width = 3
+	case "in32":
+		h.width = 4
+	case "fl32", "FL32":
+		h.encoding = encFloat
+		h.width = 4
+	case "fl64", "FL64":
+		h.encoding = encFloat
+		h.width = 8
+	case "alaw", "ALAW":
+		h.encoding = encALaw
+		h.width = 1
+	case "ulaw", "ULAW":
+		h.encoding = encMuLaw
+		h.width = 1
+	default:
+		return fmt.Errorf("aiff: unsupported compression %q", compression)
+	}
+	if err := h.check(); err != nil {
+		return err
+	}
+	h.size = frames * int64(h.frame)
+	return nil
+}
+
+// extended converts an 80-bit IEEE 754 extended precision number.
+func extended(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b) & 0x7fff)
+	mant := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	v := math.Ldexp(float64(mant), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
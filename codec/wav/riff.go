@@ -0,0 +1,210 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mjibson/mog/_third_party/github.com/mjibson/id3"
+	"github.com/mjibson/mog/codec"
+)
+
+// WAVE format tags.
+const (
+	wavePCM        = 1
+	waveFloat      = 3
+	waveALaw       = 6
+	waveMuLaw      = 7
+	waveExtensible = 0xfffe
+)
+
+// parseWAV parses the chunks of a RIFF WAVE or RF64 file after its header.
+func parseWAV(cr *chunkReader, rf64 bool, tags bool) (*header, error) {
+	le := binary.LittleEndian
+	h := &header{size: -1}
+	var gotFormat bool
+	// size64 is the data size of RF64 files from their ds64 chunk.
+	size64 := int64(-1)
+	for {
+		b, err := cr.read(8)
+		if err != nil {
+			if h.offset != 0 {
+				break
+			}
+			return nil, err
+		}
+		id, size := string(b[:4]), int64(le.Uint32(b[4:]))
+		pad := size & 1
+		switch id {
+		case "data":
+			if !gotFormat {
+				return nil, fmt.Errorf("wav: data before fmt chunk")
+			}
+			h.offset = cr.off
+			if rf64 && size == 0xffffffff {
+				size = size64
+			}
+			// A size of 0 or the maximum is written by streaming encoders
+			// that never came back to fill it in.
+			if size > 0 && size != 0xffffffff {
+				h.size = size
+			}
+			if !tags || h.size < 0 {
+				h.info.Time = h.duration()
+				return h, nil
+			}
+			err = cr.skip(h.size + h.size&1)
+		case "ds64", "fmt ", "LIST", "id3 ", "ID3 ", "bext":
+			if b, err = cr.read(size + pad); err != nil {
+				break
+			}
+			switch id {
+			case "ds64":
+				if len(b) >= 16 {
+					size64 = int64(le.Uint64(b[8:]))
+				}
+			case "fmt ":
+				if err := h.parseFmt(b); err != nil {
+					return nil, err
+				}
+				gotFormat = true
+			case "LIST":
+				if len(b) >= 4 && string(b[:4]) == "INFO" {
+					h.parseInfo(b[4:])
+				}
+			case "id3 ", "ID3 ":
+				h.parseID3(b)
+			case "bext":
+				// The broadcast extension starts with a 256 byte
+				// description.
+				if len(b) >= 256 && h.info.Comment == "" {
+					h.info.Comment = text(b[:256])
+				}
+			}
+		default:
+			err = cr.skip(size + pad)
+		}
+		if err != nil {
+			// Tags after the sample data are optional.
+			if h.offset != 0 {
+				break
+			}
+			return nil, err
+		}
+	}
+	if h.offset == 0 {
+		return nil, errNoData
+	}
+	h.info.Time = h.duration()
+	return h, nil
+}
+
+// parseFmt parses a fmt chunk.
+func (h *header) parseFmt(b []byte) error {
+	if len(b) < 16 {
+		return fmt.Errorf("wav: short fmt chunk")
+	}
+	le := binary.LittleEndian
+	tag := le.Uint16(b)
+	h.channels = int(le.Uint16(b[2:]))
+	h.rate = int(le.Uint32(b[4:]))
+	h.frame = int(le.Uint16(b[12:]))
+	bits := int(le.Uint16(b[14:]))
+	h.width = (bits + 7) / 8
+	if tag == waveExtensible {
+		if len(b) < 26 {
+			return fmt.Errorf("wav: short extensible fmt chunk")
+		}
+		// The sub format GUID starts with the format tag.
+		tag = le.Uint16(b[24:])
+		// Samples may be padded beyond their valid bits.
+		if valid := int(le.Uint16(b[18:])); valid > 0 && valid < bits {
+			bits = valid
+		}
+	}
+	h.bits = bits
+	switch tag {
+	case wavePCM:
+		h.encoding = encInt
+		if h.width == 1 {
+			h.encoding = encUint8
+		}
+	case waveFloat:
+		h.encoding = encFloat
+	case waveALaw:
+		h.encoding = encALaw
+	case waveMuLaw:
+		h.encoding = encMuLaw
+	default:
+		return fmt.Errorf("wav: unsupported format tag %#x", tag)
+	}
+	return h.check()
+}
+
+// parseInfo parses the subchunks of a LIST INFO chunk.
+func (h *header) parseInfo(b []byte) {
+	for len(b) >= 8 {
+		id, size := string(b[:4]), int(binary.LittleEndian.Uint32(b[4:]))
+		b = b[8:]
+		if size > len(b) {
+			size = len(b)
+		}
+		v := text(b[:size])
+		switch id {
+		case "INAM":
+			h.info.Title = v
+		case "IART":
+			h.info.Artist = v
+		case "IPRD":
+			h.info.Album = v
+		case "ITRK", "IPRT", "TRCK":
+			h.info.Track = track(v)
+		case "ICMT":
+			h.info.Comment = v
+		case "ICRD":
+			h.info.Date = v
+		case "IGNR":
+			h.info.Genre = v
+		}
+		if size += size & 1; size > len(b) {
+			size = len(b)
+		}
+		b = b[size:]
+	}
+}
+
+// parseID3 reads an embedded ID3v2 tag. Its fields replace those of other
+// tags.
+func (h *header) parseID3(b []byte) {
+	if f := id3.Read(bytes.NewReader(b)); f != nil {
+		codec.FillID3(&h.info, f)
+	}
+}
+
+// text returns the string of a NUL terminated tag. Tags that are not UTF-8
+// are read as Latin-1.
+func text(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	if !utf8.Valid(b) {
+		rs := make([]rune, len(b))
+		for i, c := range b {
+			rs[i] = rune(c)
+		}
+		return strings.TrimSpace(string(rs))
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// track parses a track number like "3" or "3/12".
+func track(s string) float64 {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	t, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return t
+}
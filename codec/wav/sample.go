@@ -0,0 +1,122 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Sample encodings.
+const (
+	encInt = iota
+	encUint8
+	encFloat
+	encALaw
+	encMuLaw
+)
+
+// format describes how the samples of a file are stored.
+type format struct {
+	encoding  int
+	channels  int
+	rate      int
+	bigEndian bool
+	// width is the size of a sample in bytes, and frame the size of a
+	// sample frame, which may be padded.
+	width, frame int
+	// bits is the number of significant bits in a sample.
+	bits int
+}
+
+func (f *format) check() error {
+	if f.channels <= 0 || f.rate <= 0 {
+		return fmt.Errorf("wav: bad format: %d channels at %d Hz", f.channels, f.rate)
+	}
+	var ok bool
+	switch f.encoding {
+	case encInt:
+		ok = f.width >= 1 && f.width <= 4
+	case encUint8, encALaw, encMuLaw:
+		ok = f.width == 1
+	case encFloat:
+		ok = f.width == 4 || f.width == 8
+	}
+	if !ok {
+		return fmt.Errorf("wav: unsupported %d-bit samples", f.width*8)
+	}
+	if f.frame < f.channels*f.width {
+		f.frame = f.channels * f.width
+	}
+	return nil
+}
+
+// decode decodes the whole sample frames of b into out, which must be large
+// enough, and returns the number of samples decoded.
+func (f *format) decode(b []byte, out []float32) int {
+	n := 0
+	for ; len(b) >= f.frame; b = b[f.frame:] {
+		for c := 0; c < f.channels; c++ {
+			out[n] = f.sample(b[c*f.width:])
+			n++
+		}
+	}
+	return n
+}
+
+func (f *format) sample(b []byte) float32 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if f.bigEndian {
+		order = binary.BigEndian
+	}
+	switch f.encoding {
+	case encUint8:
+		return float32(int(b[0])-128) / 128
+	case encALaw:
+		return alaw[b[0]]
+	case encMuLaw:
+		return mulaw[b[0]]
+	case encFloat:
+		if f.width == 8 {
+			return float32(math.Float64frombits(order.Uint64(b)))
+		}
+		return math.Float32frombits(order.Uint32(b))
+	}
+	// Integers are sign extended from the top byte, so samples are left
+	// justified in 32 bits.
+	var v uint32
+	for i := 0; i < f.width; i++ {
+		j := i
+		if !f.bigEndian {
+			j = f.width - 1 - i
+		}
+		v = v<<8 | uint32(b[j])
+	}
+	v <<= uint(32 - 8*f.width)
+	return float32(int32(v)) / (1 << 31)
+}
+
+// alaw and mulaw map G.711 bytes to samples.
+var alaw, mulaw [256]float32
+
+func init() {
+	for i := range alaw {
+		a := byte(i) ^ 0x55
+		t := int(a&0x0f)<<4 + 8
+		if seg := uint(a&0x70) >> 4; seg > 0 {
+			t = (t + 0x100) << (seg - 1)
+		}
+		if a&0x80 == 0 {
+			t = -t
+		}
+		alaw[i] = float32(t) / 32768
+
+		u := ^byte(i)
+		t = (int(u&0x0f)<<3 + 0x84) << (uint(u&0x70) >> 4)
+		if u&0x80 != 0 {
+			t = 0x84 - t
+		} else {
+			t -= 0x84
+		}
+		mulaw[i] = float32(t) / 32768
+	}
+}
@@ -1,16 +1,22 @@
-package mpa
+// Package wav plays uncompressed WAV, RF64 and AIFF files.
+package wav
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
-	"github.com/mjibson/mog/_third_party/github.com/mjibson/go-dsp/wav"
 	"github.com/mjibson/mog/codec"
 )
 
 func init() {
 	codec.RegisterCodec("WAV", "RIFF????WAVE", []string{"wav"}, New)
+	codec.RegisterCodec("WAV", "RF64????WAVE", []string{"wav"}, New)
+	exts := []string{"aif", "aiff", "aifc"}
+	codec.RegisterCodec("AIFF", "FORM????AIFF", exts, New)
+	codec.RegisterCodec("AIFF", "FORM????AIFC", exts, New)
 }
 
 func New(rf codec.Reader) ([]codec.Song, error) {
@@ -20,64 +26,190 @@ func New(rf codec.Reader) ([]codec.Song, error) {
 	return []codec.Song{&w}, nil
 }
 
+// Wav is a WAV or AIFF file.
 type Wav struct {
-	Reader  codec.Reader
-	r       io.ReadCloser
-	initbuf []byte
-	w       *wav.Wav
+	Reader codec.Reader
+	r      io.ReadCloser
+	h      *header
+	buf    []byte
+	// left is the number of data bytes left to play, or -1 until EOF.
+	left int64
+}
+
+// header is the parsed header of a file.
+type header struct {
+	format
+	// offset is the position of the sample data, and size its length in
+	// bytes or -1 if unknown.
+	offset, size int64
+	info         codec.SongInfo
+}
+
+// maxChunk bounds the size of the chunks that are read into memory.
+const maxChunk = 16 << 20
+
+var errNoData = errors.New("wav: no sample data")
+
+// parse parses the header of the file read by r. Unless tags is set,
+// parsing stops at the sample data, leaving r positioned there.
+func parse(r io.Reader, tags bool) (*header, error) {
+	cr := &chunkReader{r: r}
+	b, err := cr.read(12)
+	if err != nil {
+		return nil, err
+	}
+	var h *header
+	switch {
+	case (string(b[:4]) == "RIFF" || string(b[:4]) == "RF64") && string(b[8:]) == "WAVE":
+		h, err = parseWAV(cr, string(b[:4]) == "RF64", tags)
+		if h != nil {
+			h.info.Codec = "WAV"
+		}
+	case string(b[:4]) == "FORM" && (string(b[8:]) == "AIFF" || string(b[8:]) == "AIFC"):
+		h, err = parseAIFF(cr, string(b[8:]) == "AIFC", tags)
+		if h != nil {
+			h.info.Codec = "AIFF"
+		}
+	default:
+		return nil, codec.ErrFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.info.SampleRate = h.rate
+	h.info.Channels = h.channels
+	h.info.Bitrate = h.rate * h.frame * 8
+	if h.encoding != encALaw && h.encoding != encMuLaw {
+		h.info.BitDepth = h.bits
+	}
+	return h, nil
+}
+
+// chunkReader reads the chunks of a file, tracking its position.
+type chunkReader struct {
+	r   io.Reader
+	off int64
+}
+
+func (c *chunkReader) read(n int64) ([]byte, error) {
+	if n > maxChunk {
+		return nil, fmt.Errorf("wav: chunk too large: %d bytes", n)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(c.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.off += n
+	return b, err
+}
+
+func (c *chunkReader) skip(n int64) error {
+	var err error
+	if s, ok := c.r.(io.Seeker); ok {
+		_, err = s.Seek(n, io.SeekCurrent)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, c.r, n)
+	}
+	c.off += n
+	return err
 }
 
 func (w *Wav) Init() (sampleRate, channels int, err error) {
-	if w.w == nil {
+	if w.r == nil {
 		r, _, err := w.Reader()
 		if err != nil {
 			return 0, 0, err
 		}
-		buf := new(bytes.Buffer)
-		defer func() {
-			w.initbuf = buf.Bytes()
-		}()
-		wr, err := wav.New(io.TeeReader(r, buf))
+		h, err := parse(r, false)
 		if err != nil {
 			r.Close()
 			return 0, 0, err
 		}
 		w.r = r
-		w.w = wr
+		w.h = h
+		w.left = h.size
 	}
-	return int(w.w.SampleRate), int(w.w.NumChannels), nil
+	return w.h.rate, w.h.channels, nil
 }
 
 func (w *Wav) Info() (info codec.SongInfo, err error) {
-	var r io.ReadCloser
-	if len(w.initbuf) != 0 {
-		r = ioutil.NopCloser(bytes.NewBuffer(w.initbuf))
-	}
-	if r == nil {
-		r, _, err = w.Reader()
-		if err != nil {
-			return
-		}
+	r, n, err := w.Reader()
+	if err != nil {
+		return
 	}
-	wv, err := wav.New(r)
+	h, err := parse(r, true)
 	r.Close()
 	if err != nil {
 		return
 	}
-	return codec.SongInfo{
-		Time: wv.Duration,
-	}, nil
+	if h.size < 0 && n > h.offset {
+		// Streamed files run to the end of the file.
+		h.size = n - h.offset
+		h.info.Time = h.duration()
+	}
+	h.info.Size = n
+	return h.info, nil
+}
+
+// duration returns the playing time of size bytes of samples.
+func (h *header) duration() time.Duration {
+	if h.size < 0 {
+		return 0
+	}
+	frames := h.size / int64(h.frame)
+	return time.Duration(frames) * time.Second / time.Duration(h.rate)
 }
 
 func (w *Wav) Play(n int) ([]float32, error) {
-	return w.w.ReadFloats(n)
+	frames := n / w.h.channels
+	size := int64(frames * w.h.frame)
+	if w.left >= 0 && size > w.left {
+		size = w.left - w.left%int64(w.h.frame)
+	}
+	if int64(cap(w.buf)) < size {
+		w.buf = make([]byte, size)
+	}
+	b := w.buf[:size]
+	m, err := io.ReadFull(w.r, b)
+	if w.left >= 0 {
+		w.left -= int64(m)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Truncated files play up to their last whole sample frame.
+		err = nil
+		w.left = 0
+	}
+	out := make([]float32, m/w.h.frame*w.h.channels)
+	w.h.decode(b[:m], out)
+	return out, err
+}
+
+// Seek seeks to offset by byte position in the sample data.
+func (w *Wav) Seek(offset time.Duration) error {
+	if w.r == nil {
+		return fmt.Errorf("wav: seek before init")
+	}
+	off := int64(offset) * int64(w.h.rate) / int64(time.Second) * int64(w.h.frame)
+	if w.h.size >= 0 && off > w.h.size {
+		off = w.h.size
+	}
+	r, err := codec.OpenAt(w.Reader, w.h.offset+off)
+	if err != nil {
+		return err
+	}
+	w.r.Close()
+	w.r = r
+	w.left = -1
+	if w.h.size >= 0 {
+		w.left = w.h.size - off
+	}
+	return nil
 }
 
 func (w *Wav) Close() {
 	if w.r != nil {
 		w.r.Close()
-	}
-	if w.w != nil {
-		w.w = nil
+		w.r = nil
 	}
 }
@@ -0,0 +1,189 @@
+package wav
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/codec/codectest"
+)
+
+// sampleValue returns the value of channel c of frame i in the test files,
+// which is distinct for each channel.
+func sampleValue(i, c int) int {
+	return (c-3)*100000 + i
+}
+
+// extensibleFile returns a WAVE_FORMAT_EXTENSIBLE file of 5.1 channel
+// 24-bit samples at 48kHz.
+func extensibleFile(frames int) []byte {
+	const channels = 6
+	le := binary.LittleEndian
+	f := make([]byte, 40)
+	le.PutUint16(f, waveExtensible)
+	le.PutUint16(f[2:], channels)
+	le.PutUint32(f[4:], 48000)
+	le.PutUint32(f[8:], 48000*channels*3)
+	le.PutUint16(f[12:], channels*3)
+	le.PutUint16(f[14:], 24)
+	le.PutUint16(f[16:], 22)
+	le.PutUint16(f[18:], 24)
+	le.PutUint32(f[20:], 0x3f)
+	// KSDATAFORMAT_SUBTYPE_PCM
+	copy(f[24:], "\x01\x00\x00\x00\x00\x00\x10\x00\x80\x00\x00\xaa\x00\x38\x9b\x71")
+	data := make([]byte, frames*channels*3)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			v := sampleValue(i, c)
+			b := data[(i*channels+c)*3:]
+			b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+		}
+	}
+	b := []byte("RIFF\x00\x00\x00\x00WAVE")
+	b = append(b, riffChunk("fmt ", f)...)
+	b = append(b, riffChunk("data", data)...)
+	le.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func riffChunk(id string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+1)
+	copy(b, id)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// aiffFile returns an AIFF file of stereo 16-bit samples at 44.1kHz, or an
+// AIFF-C file of little endian samples if sowt is set.
+func aiffFile(frames int, sowt bool) []byte {
+	be := binary.BigEndian
+	comm := make([]byte, 18)
+	be.PutUint16(comm, 2)
+	be.PutUint32(comm[2:], uint32(frames))
+	be.PutUint16(comm[6:], 16)
+	// 44100 as an 80-bit extended number.
+	be.PutUint16(comm[8:], 16383+15)
+	be.PutUint64(comm[10:], 44100<<(63-15))
+	form := "AIFF"
+	var order binary.ByteOrder = binary.BigEndian
+	if sowt {
+		form = "AIFC"
+		comm = append(comm, "sowt\x00\x00"...)
+		order = binary.LittleEndian
+	}
+	ssnd := make([]byte, 8+frames*4)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < 2; c++ {
+			order.PutUint16(ssnd[8+i*4+c*2:], uint16(int16(sampleValue(i, c)/10)))
+		}
+	}
+	b := []byte("FORM\x00\x00\x00\x00" + form)
+	b = append(b, aiffChunk("COMM", comm)...)
+	b = append(b, aiffChunk("NAME", []byte("Tone"))...)
+	b = append(b, aiffChunk("AUTH", []byte("Me"))...)
+	b = append(b, aiffChunk("SSND", ssnd)...)
+	be.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func aiffChunk(id string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+1)
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func open(t *testing.T, b []byte) codec.Song {
+	return codectest.Open(t, New, codectest.Reader(b), 0, 0, 0)
+}
+
+func equal(t *testing.T, got, want []float32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExtensible(t *testing.T) {
+	const frames = 10000
+	b := extensibleFile(frames)
+	songs, _ := New(codectest.Reader(b))
+	info, err := songs[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Channels != 6 || info.SampleRate != 48000 || info.BitDepth != 24 || info.Codec != "WAV" {
+		t.Fatalf("got %+v", info)
+	}
+	if want := time.Duration(frames) * time.Second / 48000; info.Time != want {
+		t.Fatalf("time %v, want %v", info.Time, want)
+	}
+
+	want := make([]float32, frames*6)
+	for i := range want {
+		want[i] = float32(sampleValue(i/6, i%6)) / (1 << 23)
+	}
+	s := open(t, b)
+	// Reads are rounded down to whole sample frames.
+	first, err := s.Play(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 4092 {
+		t.Fatalf("got %d samples", len(first))
+	}
+	equal(t, append(first, codectest.PlayAll(t, s, 4092)...), want)
+
+	for _, offset := range []time.Duration{100 * time.Millisecond, 0, time.Second} {
+		if err := s.(codec.Seeker).Seek(offset); err != nil {
+			t.Fatal(err)
+		}
+		start := int(offset*48000/time.Second) * 6
+		if start > len(want) {
+			start = len(want)
+		}
+		equal(t, codectest.PlayAll(t, s, 4092), want[start:])
+	}
+}
+
+func TestAIFF(t *testing.T) {
+	const frames = 1000
+	want := make([]float32, frames*2)
+	for i := range want {
+		want[i] = float32(int16(sampleValue(i/2, i%2)/10)) / (1 << 15)
+	}
+	for _, sowt := range []bool{false, true} {
+		b := aiffFile(frames, sowt)
+		songs, _ := New(codectest.Reader(b))
+		info, err := songs[0].Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Title != "Tone" || info.Artist != "Me" || info.Codec != "AIFF" || info.SampleRate != 44100 || info.BitDepth != 16 {
+			t.Fatalf("got %+v", info)
+		}
+		if info.Time != time.Duration(frames)*time.Second/44100 {
+			t.Fatalf("time %v", info.Time)
+		}
+		s := open(t, b)
+		equal(t, codectest.PlayAll(t, s, 256), want)
+		if err := s.(codec.Seeker).Seek(10 * time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		equal(t, codectest.PlayAll(t, s, 256), want[441*2:])
+	}
+}
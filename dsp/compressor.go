@@ -0,0 +1,96 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// Compressor reduces the dynamic range of audio, like for listening at
+// night. Zero fields other than Makeup take defaults suited to that.
+type Compressor struct {
+	// Threshold in dB above which the level is reduced, default -24.
+	Threshold float64
+	// Ratio of input to output level change above the threshold,
+	// default 4.
+	Ratio float64
+	// Attack and Release are the times in milliseconds to react to rising
+	// and falling levels, default 5 and 200.
+	Attack  float64
+	Release float64
+	// Makeup is the gain in dB applied after compression.
+	Makeup float64
+
+	channels         int
+	threshold, ratio float64
+	attack, release  float64
+	// reduction is the current gain reduction in dB.
+	reduction float64
+}
+
+// compressorKnee is the width in dB of the soft knee around the threshold.
+const compressorKnee = 6
+
+func (p *Compressor) validate() error {
+	if p.Threshold < -60 || p.Threshold > 0 {
+		return fmt.Errorf("dsp: bad compressor threshold: %v", p.Threshold)
+	}
+	if p.Ratio != 0 && (p.Ratio < 1 || p.Ratio > 20) {
+		return fmt.Errorf("dsp: bad compressor ratio: %v", p.Ratio)
+	}
+	if p.Attack < 0 || p.Attack > 1000 || p.Release < 0 || p.Release > 5000 {
+		return fmt.Errorf("dsp: bad compressor times: %v, %v", p.Attack, p.Release)
+	}
+	if p.Makeup < 0 || p.Makeup > 30 {
+		return fmt.Errorf("dsp: bad compressor makeup: %v", p.Makeup)
+	}
+	return nil
+}
+
+func (p *Compressor) Init(rate, channels int) {
+	def := func(v, d float64) float64 {
+		if v == 0 {
+			return d
+		}
+		return v
+	}
+	coef := func(ms float64) float64 {
+		return math.Exp(-1 / (ms / 1000 * float64(rate)))
+	}
+	p.channels = channels
+	p.threshold = def(p.Threshold, -24)
+	p.ratio = def(p.Ratio, 4)
+	p.attack = coef(def(p.Attack, 5))
+	p.release = coef(def(p.Release, 200))
+	p.reduction = 0
+}
+
+func (p *Compressor) Process(b []float32) {
+	slope := 1 - 1/p.ratio
+	for i := 0; i+p.channels <= len(b); i += p.channels {
+		var peak float64
+		for _, s := range b[i : i+p.channels] {
+			peak = math.Max(peak, math.Abs(float64(s)))
+		}
+		var target float64
+		if peak > 0 {
+			over := DB(peak) - p.threshold
+			switch {
+			case 2*over < -compressorKnee:
+			case 2*over <= compressorKnee:
+				k := over + compressorKnee/2
+				target = slope * k * k / (2 * compressorKnee)
+			default:
+				target = slope * over
+			}
+		}
+		c := p.release
+		if target > p.reduction {
+			c = p.attack
+		}
+		p.reduction = c*p.reduction + (1-c)*target
+		g := float32(Linear(p.Makeup - p.reduction))
+		for j := i; j < i+p.channels; j++ {
+			b[j] *= g
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// Crossfeed mixes a low passed part of each stereo channel into the other,
+// so that headphones sound more like speakers.
+type Crossfeed struct {
+	// Level is the level of the crossfed signal in dB, or 0 for -4.5.
+	Level float64
+	// Cutoff is the corner frequency of the low pass filter in Hz, or 0 for
+	// 700.
+	Cutoff float64
+
+	channels int
+	alpha    float64
+	gain     float64
+	lp       [2]float64
+}
+
+func (f *Crossfeed) validate() error {
+	if f.Level < -30 || f.Level > 0 {
+		return fmt.Errorf("dsp: bad crossfeed level: %v", f.Level)
+	}
+	if f.Cutoff != 0 && (f.Cutoff < 100 || f.Cutoff > 3000) {
+		return fmt.Errorf("dsp: bad crossfeed cutoff: %v", f.Cutoff)
+	}
+	return nil
+}
+
+func (f *Crossfeed) Init(rate, channels int) {
+	level, cutoff := f.Level, f.Cutoff
+	if level == 0 {
+		level = -4.5
+	}
+	if cutoff == 0 {
+		cutoff = 700
+	}
+	f.channels = channels
+	f.alpha = 1 - math.Exp(-2*math.Pi*cutoff/float64(rate))
+	f.gain = Linear(level)
+	f.lp = [2]float64{}
+}
+
+func (f *Crossfeed) Process(b []float32) {
+	if f.channels != 2 {
+		return
+	}
+	norm := 1 / (1 + f.gain)
+	for i := 0; i+1 < len(b); i += 2 {
+		l, r := float64(b[i]), float64(b[i+1])
+		f.lp[0] += f.alpha * (l - f.lp[0])
+		f.lp[1] += f.alpha * (r - f.lp[1])
+		b[i] = float32((l + f.gain*f.lp[1]) * norm)
+		b[i+1] = float32((r + f.gain*f.lp[0]) * norm)
+	}
+}
@@ -0,0 +1,46 @@
+// Package dsp processes interleaved float32 audio between the decoders and
+// the output.
+package dsp
+
+import "math"
+
+// DB converts a linear amplitude to decibels.
+func DB(v float64) float64 {
+	return 20 * math.Log10(v)
+}
+
+// Linear converts decibels to a linear amplitude.
+func Linear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// Gain multiplies the samples of b by g in place.
+func Gain(b []float32, g float64) {
+	if g == 1 {
+		return
+	}
+	f := float32(g)
+	for i := range b {
+		b[i] *= f
+	}
+}
+
+// Crossfade mixes b into a in place with equal power curves, fading a out
+// and b in over length frames of channels samples. pos is the frame of the
+// fade at which a and b start. Missing samples of b are silent.
+func Crossfade(a, b []float32, channels, pos, length int) {
+	for i := 0; i+channels <= len(a); i += channels {
+		p := 1.0
+		if pos < length {
+			p = float64(pos) / float64(length)
+		}
+		out, in := float32(math.Cos(p*math.Pi/2)), float32(math.Sin(p*math.Pi/2))
+		for c := i; c < i+channels; c++ {
+			a[c] *= out
+			if c < len(b) {
+				a[c] += in * b[c]
+			}
+		}
+		pos++
+	}
+}
@@ -0,0 +1,46 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCrossfade(t *testing.T) {
+	const length = 100
+	// Fading ones with silence gives the gain of each side.
+	ones := make([]float32, (length+1)*2)
+	for i := range ones {
+		ones[i] = 1
+	}
+	out := append([]float32(nil), ones...)
+	Crossfade(out, make([]float32, len(ones)), 2, 0, length)
+	in := make([]float32, len(ones))
+	Crossfade(in, ones, 2, 0, length)
+	for _, c := range []struct {
+		pos     int
+		out, in float64
+	}{
+		{0, 1, 0},
+		{length / 2, math.Sqrt2 / 2, math.Sqrt2 / 2},
+		{length, 0, 1},
+	} {
+		for ch := 0; ch < 2; ch++ {
+			i := c.pos*2 + ch
+			if math.Abs(float64(out[i])-c.out) > 1e-6 || math.Abs(float64(in[i])-c.in) > 1e-6 {
+				t.Errorf("frame %d: gains %v, %v, want %v, %v", c.pos, out[i], in[i], c.out, c.in)
+			}
+		}
+	}
+	// The power of the two is constant.
+	for i := range out {
+		if p := out[i]*out[i] + in[i]*in[i]; math.Abs(float64(p)-1) > 1e-6 {
+			t.Fatalf("sample %d: power %v", i, p)
+		}
+	}
+	// Frames past the fade are all b, and missing samples of b are silent.
+	a := []float32{1, 1, 1, 1}
+	Crossfade(a, []float32{0.5, 0.5}, 2, length+1, length)
+	if a[0] != 0.5 || a[1] != 0.5 || math.Abs(float64(a[2])) > 1e-6 || math.Abs(float64(a[3])) > 1e-6 {
+		t.Fatalf("got %v after the fade", a)
+	}
+}
@@ -0,0 +1,120 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+)
+
+// Band is a filter of an equalizer.
+type Band struct {
+	// Type is one of "peak", "lowshelf", "highshelf", "lowpass" or
+	// "highpass".
+	Type string
+	// Freq is the center or corner frequency in Hz.
+	Freq float64
+	// Gain in dB of peak and shelf filters.
+	Gain float64
+	// Q is the quality factor, or 0 for 1/√2.
+	Q float64
+}
+
+// filter returns the filter of b for sample rate rate, from the Audio EQ
+// Cookbook by Robert Bristow-Johnson.
+func (b Band) filter(rate int) biquad {
+	q := b.Q
+	if q == 0 {
+		q = math.Sqrt2 / 2
+	}
+	f := b.Freq
+	if max := float64(rate) * 0.49; f > max {
+		f = max
+	}
+	w := 2 * math.Pi * f / float64(rate)
+	cos := math.Cos(w)
+	alpha := math.Sin(w) / (2 * q)
+	a := math.Pow(10, b.Gain/40)
+	sq := 2 * math.Sqrt(a) * alpha
+	var b0, b1, b2, a0, a1, a2 float64
+	switch b.Type {
+	case "peak":
+		b0, b1, b2 = 1+alpha*a, -2*cos, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cos, 1-alpha/a
+	case "lowshelf":
+		b0 = a * ((a + 1) - (a-1)*cos + sq)
+		b1 = 2 * a * ((a - 1) - (a+1)*cos)
+		b2 = a * ((a + 1) - (a-1)*cos - sq)
+		a0 = (a + 1) + (a-1)*cos + sq
+		a1 = -2 * ((a - 1) + (a+1)*cos)
+		a2 = (a + 1) + (a-1)*cos - sq
+	case "highshelf":
+		b0 = a * ((a + 1) + (a-1)*cos + sq)
+		b1 = -2 * a * ((a - 1) + (a+1)*cos)
+		b2 = a * ((a + 1) + (a-1)*cos - sq)
+		a0 = (a + 1) - (a-1)*cos + sq
+		a1 = 2 * ((a - 1) - (a+1)*cos)
+		a2 = (a + 1) - (a-1)*cos - sq
+	case "lowpass":
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case "highpass":
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	}
+	return biquad{
+		b0: b0 / a0,
+		b1: b1 / a0,
+		b2: b2 / a0,
+		a1: a1 / a0,
+		a2: a2 / a0,
+	}
+}
+
+// EQ is a parametric equalizer.
+type EQ struct {
+	Bands []Band
+	// filters holds the filter of each band for each channel.
+	filters  [][]biquad
+	channels int
+}
+
+func (e *EQ) validate() error {
+	for _, b := range e.Bands {
+		switch b.Type {
+		case "peak", "lowshelf", "highshelf", "lowpass", "highpass":
+		default:
+			return fmt.Errorf("dsp: bad band type: %q", b.Type)
+		}
+		if b.Freq < 10 || b.Freq > 30000 {
+			return fmt.Errorf("dsp: bad band frequency: %v", b.Freq)
+		}
+		if b.Gain < -30 || b.Gain > 30 {
+			return fmt.Errorf("dsp: bad band gain: %v", b.Gain)
+		}
+		if b.Q < 0 || b.Q > 30 {
+			return fmt.Errorf("dsp: bad band Q: %v", b.Q)
+		}
+	}
+	return nil
+}
+
+func (e *EQ) Init(rate, channels int) {
+	e.channels = channels
+	e.filters = make([][]biquad, len(e.Bands))
+	for i, b := range e.Bands {
+		f := b.filter(rate)
+		e.filters[i] = make([]biquad, channels)
+		for c := range e.filters[i] {
+			e.filters[i][c] = f
+		}
+	}
+}
+
+func (e *EQ) Process(b []float32) {
+	for _, fs := range e.filters {
+		for i := 0; i+e.channels <= len(b); i += e.channels {
+			for c := range fs {
+				b[i+c] = float32(fs[c].next(float64(b[i+c])))
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// eqGain returns the gain in dB of e for a sine wave of freq Hz at 48kHz,
+// measured on each of two channels, of which only the first is fed.
+func eqGain(e *EQ, freq float64) (left, right float64) {
+	e.Init(48000, 2)
+	in := tone(48000, 48000, freq)
+	b := make([]float32, len(in)*2)
+	for i, v := range in {
+		b[i*2] = v
+	}
+	e.Process(b)
+	// Skip the first half second, as the filters settle.
+	var l, r []float32
+	for i := 24000; i < len(in); i++ {
+		l = append(l, b[i*2])
+		r = append(r, b[i*2+1])
+	}
+	return 20 * math.Log10(rms(l)/rms(in[24000:])), rms(r)
+}
+
+func TestEQ(t *testing.T) {
+	for _, c := range []struct {
+		band Band
+		freq float64
+		// want is the gain in dB, within tol.
+		want, tol float64
+	}{
+		{Band{Type: "peak", Freq: 1000, Gain: 6, Q: 1}, 1000, 6, 0.01},
+		{Band{Type: "peak", Freq: 1000, Gain: 6, Q: 1}, 50, 0, 0.05},
+		{Band{Type: "peak", Freq: 1000, Gain: -12, Q: 4}, 1000, -12, 0.01},
+		{Band{Type: "peak", Freq: 1000, Gain: -12, Q: 4}, 2000, 0, 0.5},
+		// Shelves have half their gain at their corner.
+		{Band{Type: "lowshelf", Freq: 200, Gain: 6}, 20, 6, 0.1},
+		{Band{Type: "lowshelf", Freq: 200, Gain: 6}, 200, 3, 0.01},
+		{Band{Type: "lowshelf", Freq: 200, Gain: 6}, 10000, 0, 0.05},
+		{Band{Type: "highshelf", Freq: 4000, Gain: -6}, 20000, -6, 0.1},
+		{Band{Type: "highshelf", Freq: 4000, Gain: -6}, 4000, -3, 0.01},
+		{Band{Type: "highshelf", Freq: 4000, Gain: -6}, 100, 0, 0.05},
+		// Butterworth filters are down 3dB at their corner, and fall 12dB
+		// per octave of the frequency warped by the bilinear transform,
+		// tan(πf/48000): at 8.81 and 8.01 times the corner here.
+		{Band{Type: "lowpass", Freq: 1000}, 1000, -3.01, 0.01},
+		{Band{Type: "lowpass", Freq: 1000}, 100, 0, 0.01},
+		{Band{Type: "lowpass", Freq: 1000}, 8000, -37.80, 0.01},
+		{Band{Type: "highpass", Freq: 1000}, 1000, -3.01, 0.01},
+		{Band{Type: "highpass", Freq: 1000}, 10000, 0, 0.01},
+		{Band{Type: "highpass", Freq: 1000}, 125, -36.15, 0.01},
+	} {
+		l, r := eqGain(&EQ{Bands: []Band{c.band}}, c.freq)
+		if math.Abs(l-c.want) > c.tol {
+			t.Errorf("%+v at %vHz: gain %.3fdB, want %vdB", c.band, c.freq, l, c.want)
+		}
+		if r != 0 {
+			t.Errorf("%+v: rms %v on the silent channel", c.band, r)
+		}
+	}
+
+	// The gains of bands add.
+	e := &EQ{Bands: []Band{
+		{Type: "peak", Freq: 1000, Gain: 6, Q: 1},
+		{Type: "lowshelf", Freq: 100, Gain: -6},
+	}}
+	if l, _ := eqGain(e, 1000); math.Abs(l-6) > 0.1 {
+		t.Errorf("gain %.3fdB at 1kHz, want 6dB", l)
+	}
+	if l, _ := eqGain(e, 20); math.Abs(l+6) > 0.1 {
+		t.Errorf("gain %.3fdB at 20Hz, want -6dB", l)
+	}
+
+	for _, b := range []Band{
+		{Type: "notch", Freq: 1000},
+		{Type: "peak", Freq: 5},
+		{Type: "peak", Freq: 1000, Gain: 40},
+		{Type: "peak", Freq: 1000, Q: -1},
+	} {
+		if err := (&EQ{Bands: []Band{b}}).validate(); err == nil {
+			t.Errorf("%+v: expected an error", b)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package dsp
+
+import (
+	"math"
+	"time"
+)
+
+// Limiter keeps the true peak of audio below a ceiling. It looks ahead a
+// few milliseconds so that gain reduction ramps in before a peak instead of
+// clipping it, and releases slowly after.
+type Limiter struct {
+	channels int
+	ceiling  float64
+	release  float64
+	peaks    []peakDetector
+	// delay holds the last frames written, and need the gain each of them
+	// requires, as rings starting at pos.
+	delay  []float32
+	need   []float64
+	pos    int
+	filled int
+	gain   float64
+}
+
+const (
+	limiterLookahead = 1500 * time.Microsecond
+	limiterRelease   = 50 * time.Millisecond
+)
+
+// NewLimiter returns a limiter for audio with the given sample rate and
+// number of channels that keeps its true peak at most ceiling dBTP.
+func NewLimiter(rate, channels int, ceiling float64) *Limiter {
+	n := int(int64(rate) * int64(limiterLookahead) / int64(time.Second))
+	if n < 2*peakTaps {
+		n = 2 * peakTaps
+	}
+	return &Limiter{
+		channels: channels,
+		ceiling:  Linear(ceiling),
+		release:  math.Exp(-1 / (limiterRelease.Seconds() * float64(rate))),
+		peaks:    make([]peakDetector, channels),
+		delay:    make([]float32, n*channels),
+		need:     make([]float64, n),
+		gain:     1,
+	}
+}
+
+// Process writes the interleaved samples of b and returns the limited
+// samples, delayed by the lookahead.
+func (l *Limiter) Process(b []float32) []float32 {
+	ch := l.channels
+	n := len(l.need)
+	out := make([]float32, 0, len(b))
+	for i := 0; i+ch <= len(b); i += ch {
+		var peak float64
+		for c := 0; c < ch; c++ {
+			if p := l.peaks[c].next(float64(b[i+c])); p > peak {
+				peak = p
+			}
+		}
+		if l.filled == n {
+			out = l.emit(out)
+		} else {
+			l.filled++
+		}
+		copy(l.delay[l.pos*ch:], b[i:i+ch])
+		l.need[l.pos] = 1
+		if peak > l.ceiling {
+			l.need[l.pos] = l.ceiling / peak
+		}
+		l.pos = (l.pos + 1) % n
+	}
+	return out
+}
+
+// emit appends the oldest frame at pos to out.
+func (l *Limiter) emit(out []float32) []float32 {
+	n := len(l.need)
+	target := 1.0
+	for k := 0; k < n; k++ {
+		need := l.need[(l.pos+k)%n]
+		if need == 1 {
+			continue
+		}
+		// Reduce linearly towards frames ahead. The peak detector lags by
+		// half its filter, so hold the full reduction over it.
+		f := float64(n-k) / float64(n-peakTaps)
+		if f > 1 {
+			f = 1
+		}
+		if g := 1 - (1-need)*f; g < target {
+			target = g
+		}
+	}
+	if target < l.gain {
+		l.gain = target
+	} else {
+		l.gain = target + (l.gain-target)*l.release
+	}
+	max := float32(l.ceiling)
+	for _, s := range l.delay[l.pos*l.channels : (l.pos+1)*l.channels] {
+		s *= float32(l.gain)
+		if s > max {
+			s = max
+		} else if s < -max {
+			s = -max
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Flush returns the samples still held in the lookahead and resets l.
+func (l *Limiter) Flush() []float32 {
+	out := l.Process(make([]float32, len(l.need)*l.channels))
+	l.Reset()
+	return out
+}
+
+// Reset drops the samples held in the lookahead and releases any gain
+// reduction.
+func (l *Limiter) Reset() {
+	for i := range l.peaks {
+		l.peaks[i] = peakDetector{}
+	}
+	l.pos, l.filled, l.gain = 0, 0, 1
+}
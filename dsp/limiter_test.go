@@ -0,0 +1,76 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// sine returns frames of a stereo sine wave of freq Hz at 48kHz.
+func sine(frames int, freq, amp float64) []float32 {
+	b := make([]float32, frames*2)
+	for i := 0; i < frames; i++ {
+		v := float32(amp * math.Sin(2*math.Pi*freq*float64(i)/48000))
+		b[i*2], b[i*2+1] = v, v
+	}
+	return b
+}
+
+func peak(b []float32) float64 {
+	var p float64
+	for _, v := range b {
+		p = math.Max(p, math.Abs(float64(v)))
+	}
+	return p
+}
+
+func TestLimiter(t *testing.T) {
+	const delay = 72 * 2
+	l := NewLimiter(48000, 2, -1)
+	ceiling := Linear(-1)
+
+	// Quiet audio is only delayed by the lookahead.
+	quiet := sine(4800, 1000, 0.5)
+	out := l.Process(quiet)
+	if len(out) != len(quiet)-delay {
+		t.Fatalf("got %d samples, want %d", len(out), len(quiet)-delay)
+	}
+	for i, v := range out {
+		if v != quiet[i] {
+			t.Fatalf("sample %d is %v, want %v", i, v, quiet[i])
+		}
+	}
+
+	// Loud audio is held at the ceiling, and ramped down before it starts.
+	loud := sine(4800, 1000, 2)
+	out = append(out, l.Process(loud)...)
+	out = append(out, l.Flush()...)
+	if len(out) != len(quiet)+len(loud) {
+		t.Fatalf("got %d samples, want %d", len(out), len(quiet)+len(loud))
+	}
+	if p := peak(out); p > ceiling {
+		t.Fatalf("peak %v above the ceiling %v", p, ceiling)
+	}
+	if p := peak(out[len(quiet)+4800:]); math.Abs(p-ceiling) > 0.01 {
+		t.Fatalf("peak %v of the loud part, want %v", p, ceiling)
+	}
+	// A 1kHz sine has 48 samples per cycle, so the last quiet cycle is
+	// already reduced.
+	if p := peak(out[len(quiet)-96 : len(quiet)]); p >= 0.5*0.99 {
+		t.Fatalf("peak %v before the loud part", p)
+	}
+
+	// Reset releases the gain reduction at once, where Process releases it
+	// over limiterRelease.
+	l.Process(loud)
+	l.Reset()
+	out = l.Process(quiet)
+	for i, v := range out {
+		if v != quiet[i] {
+			t.Fatalf("after reset, sample %d is %v, want %v", i, v, quiet[i])
+		}
+	}
+	l.Process(loud)
+	if out := l.Process(quiet); peak(out[len(out)-96:]) >= 0.5*0.99 {
+		t.Fatal("gain reduction released at once without reset")
+	}
+}
@@ -0,0 +1,162 @@
+package dsp
+
+import (
+	"math"
+)
+
+// ReferenceLoudness is the ReplayGain 2.0 reference level in LUFS. EBU R128
+// targets 5 LU below it.
+const ReferenceLoudness = -18
+
+// biquad is a second order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) next(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the pre-filter and RLB filter of ITU-R BS.1770 for
+// rate, derived for any rate as in libebur128.
+func kWeighting(rate int) (shelf, highpass biquad) {
+	f0 := 1681.974450955533
+	g := 3.999843853973347
+	q := 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / float64(rate))
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / float64(rate))
+	a0 = 1 + k/q + k*k
+	highpass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return
+}
+
+// Meter measures the integrated loudness and true peak of a song as
+// specified by EBU R128 and ITU-R BS.1770-4.
+type Meter struct {
+	channels int
+	weights  []float64
+	shelf    []biquad
+	highpass []biquad
+	peaks    []peakDetector
+	peak     float64
+	// sub holds the mean square of the last three complete 100ms blocks,
+	// and sum the weighted sum of squares of the current one.
+	sub    [3]float64
+	nsub   int
+	sum    float64
+	n, max int
+	// blocks holds the mean square of each 400ms gating block.
+	blocks []float64
+}
+
+// NewMeter returns a meter for audio with the given sample rate and number
+// of channels. Channels are in WAVE order; the surround channels of 5.1
+// audio are weighted up and its LFE channel ignored.
+func NewMeter(rate, channels int) *Meter {
+	m := &Meter{
+		channels: channels,
+		weights:  make([]float64, channels),
+		shelf:    make([]biquad, channels),
+		highpass: make([]biquad, channels),
+		peaks:    make([]peakDetector, channels),
+		max:      rate / 10,
+	}
+	for i := range m.weights {
+		m.weights[i] = 1
+		m.shelf[i], m.highpass[i] = kWeighting(rate)
+	}
+	if channels == 6 {
+		m.weights[3] = 0
+		m.weights[4] = 1.41
+		m.weights[5] = 1.41
+	}
+	return m
+}
+
+// Write measures the interleaved samples of b.
+func (m *Meter) Write(b []float32) {
+	for i := 0; i+m.channels <= len(b); i += m.channels {
+		for c := 0; c < m.channels; c++ {
+			x := float64(b[i+c])
+			if p := m.peaks[c].next(x); p > m.peak {
+				m.peak = p
+			}
+			y := m.highpass[c].next(m.shelf[c].next(x))
+			m.sum += m.weights[c] * y * y
+		}
+		if m.n++; m.n < m.max {
+			continue
+		}
+		// A gating block is four 100ms blocks, overlapping by 75%.
+		ms := m.sum / float64(m.n)
+		if m.nsub == len(m.sub) {
+			m.blocks = append(m.blocks, (m.sub[0]+m.sub[1]+m.sub[2]+ms)/4)
+			copy(m.sub[:], m.sub[1:])
+			m.nsub--
+		}
+		m.sub[m.nsub] = ms
+		m.nsub++
+		m.sum, m.n = 0, 0
+	}
+}
+
+// loudness converts a mean square to LUFS.
+func loudness(ms float64) float64 {
+	return -0.691 + 10*math.Log10(ms)
+}
+
+// Integrated returns the gated loudness in LUFS of the audio written so
+// far, or -Inf if it is silent or shorter than 400ms.
+func (m *Meter) Integrated() float64 {
+	gate := func(threshold float64) float64 {
+		var sum float64
+		var n int
+		for _, b := range m.blocks {
+			if loudness(b) > threshold {
+				sum += b
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	abs := gate(-70)
+	if abs == 0 {
+		return math.Inf(-1)
+	}
+	rel := gate(loudness(abs) - 10)
+	if rel == 0 {
+		return math.Inf(-1)
+	}
+	return loudness(rel)
+}
+
+// TruePeak returns the largest absolute value of the audio written so far,
+// including peaks between samples.
+func (m *Meter) TruePeak() float64 {
+	return m.peak
+}
@@ -0,0 +1,152 @@
+package dsp
+
+import "math"
+
+// speaker is a channel position.
+type speaker int
+
+const (
+	frontLeft speaker = iota
+	frontRight
+	frontCenter
+	lowFrequency
+	backLeft
+	backRight
+	backCenter
+	sideLeft
+	sideRight
+)
+
+// layouts holds the default channel layouts of WAVE files by number of
+// channels, which most formats share.
+var layouts = map[int][]speaker{
+	1: {frontCenter},
+	2: {frontLeft, frontRight},
+	3: {frontLeft, frontRight, frontCenter},
+	4: {frontLeft, frontRight, backLeft, backRight},
+	5: {frontLeft, frontRight, frontCenter, backLeft, backRight},
+	6: {frontLeft, frontRight, frontCenter, lowFrequency, backLeft, backRight},
+	7: {frontLeft, frontRight, frontCenter, lowFrequency, backCenter, sideLeft, sideRight},
+	8: {frontLeft, frontRight, frontCenter, lowFrequency, backLeft, backRight, sideLeft, sideRight},
+}
+
+type mixTarget struct {
+	to   speaker
+	gain float64
+}
+
+// mixFallbacks lists where to mix a speaker missing from the output
+// layout, in order of preference. The low frequency channel is dropped.
+var mixFallbacks = map[speaker][][]mixTarget{
+	frontLeft:   {{{frontCenter, math.Sqrt2 / 2}}},
+	frontRight:  {{{frontCenter, math.Sqrt2 / 2}}},
+	frontCenter: {{{frontLeft, math.Sqrt2 / 2}, {frontRight, math.Sqrt2 / 2}}},
+	backLeft:    {{{sideLeft, 1}}, {{frontLeft, math.Sqrt2 / 2}}},
+	backRight:   {{{sideRight, 1}}, {{frontRight, math.Sqrt2 / 2}}},
+	sideLeft:    {{{backLeft, 1}}, {{frontLeft, math.Sqrt2 / 2}}},
+	sideRight:   {{{backRight, 1}}, {{frontRight, math.Sqrt2 / 2}}},
+	backCenter:  {{{backLeft, math.Sqrt2 / 2}, {backRight, math.Sqrt2 / 2}}, {{sideLeft, math.Sqrt2 / 2}, {sideRight, math.Sqrt2 / 2}}},
+}
+
+// Mixer converts interleaved audio between channel layouts, downmixing with
+// the ITU-R BS.775 coefficients.
+type Mixer struct {
+	from, to int
+	// matrix holds the gain of each input channel in each output channel.
+	matrix [][]float32
+}
+
+// NewMixer returns a mixer from from channels to to channels. Channel
+// counts without a known layout map channels one to one.
+func NewMixer(from, to int) *Mixer {
+	m := &Mixer{from: from, to: to}
+	if from == to {
+		return m
+	}
+	matrix := make([][]float64, to)
+	for i := range matrix {
+		matrix[i] = make([]float64, from)
+	}
+	in, inOK := layouts[from]
+	out, outOK := layouts[to]
+	if !inOK || !outOK {
+		for i := 0; i < from && i < to; i++ {
+			matrix[i][i] = 1
+		}
+	} else {
+		index := make(map[speaker]int)
+		for i, s := range out {
+			index[s] = i
+		}
+		var mix func(c int, s speaker, gain float64, depth int)
+		mix = func(c int, s speaker, gain float64, depth int) {
+			if i, ok := index[s]; ok {
+				matrix[i][c] += gain
+				return
+			}
+			alts := mixFallbacks[s]
+			if len(alts) == 0 || depth > 2 {
+				return
+			}
+			// Prefer an alternative the layout has directly, and otherwise
+			// the last, most general one.
+			alt := alts[len(alts)-1]
+			for _, a := range alts {
+				if _, ok := index[a[0].to]; ok {
+					alt = a
+					break
+				}
+			}
+			for _, t := range alt {
+				mix(c, t.to, gain*t.gain, depth+1)
+			}
+		}
+		for c, s := range in {
+			mix(c, s, 1, 0)
+		}
+		// Scale down so that no output channel can clip.
+		var max float64
+		for _, row := range matrix {
+			var sum float64
+			for _, g := range row {
+				sum += g
+			}
+			max = math.Max(max, sum)
+		}
+		if max > 1 {
+			for _, row := range matrix {
+				for c := range row {
+					row[c] /= max
+				}
+			}
+		}
+	}
+	m.matrix = make([][]float32, to)
+	for i, row := range matrix {
+		m.matrix[i] = make([]float32, from)
+		for c, g := range row {
+			m.matrix[i][c] = float32(g)
+		}
+	}
+	return m
+}
+
+// Process returns the interleaved samples of b mixed to the output layout.
+func (m *Mixer) Process(b []float32) []float32 {
+	if m.from == m.to {
+		return b
+	}
+	frames := len(b) / m.from
+	out := make([]float32, frames*m.to)
+	for f := 0; f < frames; f++ {
+		in := b[f*m.from : (f+1)*m.from]
+		for i, row := range m.matrix {
+			var v float32
+			for c, g := range row {
+				v += g * in[c]
+			}
+			out[f*m.to+i] = v
+		}
+	}
+	return out
+}
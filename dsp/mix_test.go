@@ -0,0 +1,62 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMixer(t *testing.T) {
+	const h = math.Sqrt2 / 2
+	for _, c := range []struct {
+		from, to int
+		// matrix is the gain of each input channel in each output channel.
+		matrix [][]float64
+	}{
+		// 5.1 to stereo keeps the sides, mixes the center and back in at
+		// -3dB and drops the LFE, scaled down so that nothing clips.
+		{6, 2, [][]float64{
+			{1 / (1 + 2*h), 0, h / (1 + 2*h), 0, h / (1 + 2*h), 0},
+			{0, 1 / (1 + 2*h), h / (1 + 2*h), 0, 0, h / (1 + 2*h)},
+		}},
+		{2, 1, [][]float64{{0.5, 0.5}}},
+		{1, 2, [][]float64{{h}, {h}}},
+		// Without a known layout, channels map one to one.
+		{2, 10, [][]float64{{1, 0}, {0, 1}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}, {0, 0}}},
+	} {
+		m := NewMixer(c.from, c.to)
+		for in := 0; in < c.from; in++ {
+			// A frame with only this channel gives its column.
+			b := make([]float32, c.from)
+			b[in] = 1
+			out := m.Process(b)
+			if len(out) != c.to {
+				t.Fatalf("%d to %d: got %d samples, want %d", c.from, c.to, len(out), c.to)
+			}
+			for i, v := range out {
+				if math.Abs(float64(v)-c.matrix[i][in]) > 1e-6 {
+					t.Errorf("%d to %d: gain of %d in %d is %v, want %v", c.from, c.to, in, i, v, c.matrix[i][in])
+				}
+			}
+		}
+	}
+}
+
+func TestMixerClip(t *testing.T) {
+	// Full scale in every input channel does not clip any output channel.
+	for from := 1; from <= 8; from++ {
+		for to := 1; to <= 8; to++ {
+			m := NewMixer(from, to)
+			for _, v := range []float32{1, -1} {
+				b := make([]float32, from*2)
+				for i := range b {
+					b[i] = v
+				}
+				for i, o := range m.Process(b) {
+					if o > 1+1e-6 || o < -1-1e-6 {
+						t.Errorf("%d to %d: sample %d is %v", from, to, i, o)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package dsp
+
+import "math"
+
+const (
+	// resampleZeros is the number of zero crossings of the sinc on each
+	// side of its center.
+	resampleZeros = 16
+	// resampleResolution is the number of filter table entries per input
+	// sample.
+	resampleResolution = 512
+	// resampleBeta is the Kaiser window parameter, trading transition band
+	// width for about 90dB of stopband attenuation.
+	resampleBeta = 9
+	// resampleCutoff is the passband edge relative to the lower of the two
+	// Nyquist frequencies.
+	resampleCutoff = 0.95
+)
+
+// Resampler converts the sample rate of interleaved audio with a Kaiser
+// windowed sinc filter.
+type Resampler struct {
+	from, to int
+	channels int
+	// width is the half width of the filter in input samples, and table
+	// its right half sampled resampleResolution times per input sample.
+	width int
+	table []float64
+	scale float64
+	// buf holds the input frames not yet consumed. The next output frame
+	// is at input frame pos plus frac/to.
+	buf  []float32
+	pos  int
+	frac int
+}
+
+// NewResampler returns a resampler from rate from to rate to of audio with
+// the given number of channels.
+func NewResampler(from, to, channels int) *Resampler {
+	r := &Resampler{
+		from:     from,
+		to:       to,
+		channels: channels,
+	}
+	if from == to {
+		return r
+	}
+	cutoff := resampleCutoff
+	if to < from {
+		cutoff *= float64(to) / float64(from)
+	}
+	r.scale = cutoff
+	r.width = int(math.Ceil(resampleZeros / cutoff))
+	n := r.width * resampleResolution
+	r.table = make([]float64, n+2)
+	i0 := bessel0(resampleBeta)
+	for i := 0; i <= n; i++ {
+		x := float64(i) / resampleResolution
+		h := 1.0
+		if x != 0 {
+			h = math.Sin(math.Pi*cutoff*x) / (math.Pi * cutoff * x)
+		}
+		w := x / float64(r.width)
+		r.table[i] = h * bessel0(resampleBeta*math.Sqrt(1-w*w)) / i0
+	}
+	r.Reset()
+	return r
+}
+
+// bessel0 is the zeroth order modified Bessel function of the first kind.
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
+
+// Reset discards buffered audio, so that the next output starts at the next
+// input.
+func (r *Resampler) Reset() {
+	if r.from == r.to {
+		return
+	}
+	// Start with silence before the first frame so that the output is not
+	// delayed.
+	r.buf = make([]float32, r.width*r.channels, 4096)
+	r.pos = r.width
+	r.frac = 0
+}
+
+// tap returns the filter at distance x input samples from its center.
+func (r *Resampler) tap(x float64) float64 {
+	x = math.Abs(x) * resampleResolution
+	i := int(x)
+	if i >= len(r.table)-1 {
+		return 0
+	}
+	f := x - float64(i)
+	return r.table[i] + (r.table[i+1]-r.table[i])*f
+}
+
+// Process resamples the interleaved samples of b. Output frames that depend
+// on input not written yet are held until the next call.
+func (r *Resampler) Process(b []float32) []float32 {
+	if r.from == r.to {
+		return b
+	}
+	r.buf = append(r.buf, b...)
+	ch := r.channels
+	frames := len(r.buf) / ch
+	out := make([]float32, 0, (len(b)/ch*r.to/r.from+2)*ch)
+	acc := make([]float64, ch)
+	for r.pos+r.width < frames {
+		t := float64(r.frac) / float64(r.to)
+		for c := range acc {
+			acc[c] = 0
+		}
+		for k := r.pos - r.width + 1; k <= r.pos+r.width; k++ {
+			h := r.tap(float64(k-r.pos) - t)
+			for c := 0; c < ch; c++ {
+				acc[c] += h * float64(r.buf[k*ch+c])
+			}
+		}
+		for _, v := range acc {
+			out = append(out, float32(v*r.scale))
+		}
+		r.frac += r.from
+		r.pos += r.frac / r.to
+		r.frac %= r.to
+	}
+	// Keep the frames the next output still needs.
+	if drop := r.pos - r.width + 1; drop > 0 {
+		n := copy(r.buf, r.buf[drop*ch:])
+		r.buf = r.buf[:n]
+		r.pos -= drop
+	}
+	return out
+}
+
+// Flush returns the output still held for lack of input and resets r.
+func (r *Resampler) Flush() []float32 {
+	if r.from == r.to {
+		return nil
+	}
+	// Only output frames before the end of the input, counted from the
+	// position before processing.
+	end := int64(len(r.buf)/r.channels-r.pos)*int64(r.to) - int64(r.frac)
+	out := r.Process(make([]float32, (r.width+1)*r.channels))
+	n := (end + int64(r.from) - 1) / int64(r.from)
+	if max := int(n) * r.channels; len(out) > max && max >= 0 {
+		out = out[:max]
+	}
+	r.Reset()
+	return out
+}
@@ -0,0 +1,98 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// tone returns frames of a mono sine wave of freq Hz at rate.
+func tone(rate, frames int, freq float64) []float32 {
+	b := make([]float32, frames)
+	for i := range b {
+		b[i] = float32(0.5 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+	return b
+}
+
+func rms(b []float32) float64 {
+	var sum float64
+	for _, v := range b {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum / float64(len(b)))
+}
+
+// resample resamples b in chunks of n samples.
+func resample(r *Resampler, b []float32, n int) []float32 {
+	var out []float32
+	for len(b) > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		out = append(out, r.Process(b[:n])...)
+		b = b[n:]
+	}
+	return append(out, r.Flush()...)
+}
+
+func TestResampler(t *testing.T) {
+	const frames = 44100
+	for _, c := range []struct{ from, to int }{{44100, 48000}, {48000, 44100}, {22050, 96000}, {44100, 44100}} {
+		in := tone(c.from, frames, 1000)
+		r := NewResampler(c.from, c.to, 1)
+		out := resample(r, in, 4096)
+		if want := (frames*c.to + c.from - 1) / c.from; len(out) != want {
+			t.Fatalf("%d to %d: got %d samples, want %d", c.from, c.to, len(out), want)
+		}
+		// The output does not depend on how the input is split.
+		for i, v := range resample(r, in, 1000) {
+			if v != out[i] {
+				t.Fatalf("%d to %d: sample %d is %v, want %v", c.from, c.to, i, v, out[i])
+			}
+		}
+		// Away from the ends, the tone is resampled without delay to
+		// within -80dB.
+		want := tone(c.to, len(out), 1000)
+		var max float64
+		for i := c.to / 10; i < len(out)-c.to/10; i++ {
+			max = math.Max(max, math.Abs(float64(out[i]-want[i])))
+		}
+		if max > 1e-4 {
+			t.Errorf("%d to %d: error %v", c.from, c.to, max)
+		}
+	}
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	// Tones above the Nyquist frequency of the output are removed, and
+	// those well below kept.
+	for _, c := range []struct {
+		freq float64
+		gain float64
+	}{{15000, 0}, {5000, 1}} {
+		r := NewResampler(48000, 22050, 1)
+		out := resample(r, tone(48000, 48000, c.freq), 4096)
+		got := rms(out[2205:len(out)-2205]) / (0.5 / math.Sqrt2)
+		if math.Abs(got-c.gain) > 1e-4 {
+			t.Errorf("%vHz: gain %v, want %v", c.freq, got, c.gain)
+		}
+	}
+}
+
+func TestResamplerChannels(t *testing.T) {
+	left := tone(44100, 10000, 1000)
+	b := make([]float32, len(left)*2)
+	for i, v := range left {
+		b[i*2] = v
+	}
+	out := resample(NewResampler(44100, 48000, 2), b, 4096)
+	mono := resample(NewResampler(44100, 48000, 1), left, 4096)
+	if len(out) != len(mono)*2 {
+		t.Fatalf("got %d samples, want %d", len(out), len(mono)*2)
+	}
+	for i, v := range mono {
+		if out[i*2] != v || out[i*2+1] != 0 {
+			t.Fatalf("frame %d is %v, %v, want %v, 0", i, out[i*2], out[i*2+1], v)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package dsp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Stage is a step of sound shaping.
+type Stage interface {
+	// Init prepares the stage for audio with the given sample rate and
+	// number of channels, and resets its state.
+	Init(rate, channels int)
+	// Process processes the interleaved samples of b in place.
+	Process(b []float32)
+}
+
+// StageConfig is the configuration of a stage. Exactly one field is set.
+type StageConfig struct {
+	EQ         *EQ         `json:",omitempty"`
+	Preamp     *Preamp     `json:",omitempty"`
+	Balance    *Balance    `json:",omitempty"`
+	Crossfeed  *Crossfeed  `json:",omitempty"`
+	Compressor *Compressor `json:",omitempty"`
+}
+
+// validator is implemented by stages whose configuration can be invalid.
+type validator interface {
+	validate() error
+}
+
+// Stage returns a new stage with the configuration of c.
+func (c StageConfig) Stage() (Stage, error) {
+	var s Stage
+	n := 0
+	if c.EQ != nil {
+		e := *c.EQ
+		s = &e
+		n++
+	}
+	if c.Preamp != nil {
+		p := *c.Preamp
+		s = &p
+		n++
+	}
+	if c.Balance != nil {
+		b := *c.Balance
+		s = &b
+		n++
+	}
+	if c.Crossfeed != nil {
+		f := *c.Crossfeed
+		s = &f
+		n++
+	}
+	if c.Compressor != nil {
+		p := *c.Compressor
+		s = &p
+		n++
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("dsp: stage must have one type, has %d", n)
+	}
+	if v, ok := s.(validator); ok {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Config is the configuration of a chain, in processing order.
+type Config []StageConfig
+
+// ParseConfig parses a JSON chain configuration, like
+// [{"EQ":{"Bands":[{"Type":"peak","Freq":100,"Gain":3,"Q":1}]}},{"Balance":{"Balance":-0.1}}].
+func ParseConfig(s string) (Config, error) {
+	var c Config
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return nil, err
+	}
+	if _, err := NewChain(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Chain processes audio through a sequence of stages.
+type Chain struct {
+	stages         []Stage
+	rate, channels int
+}
+
+// NewChain returns a chain of the stages configured by c.
+func NewChain(c Config) (*Chain, error) {
+	ch := new(Chain)
+	for _, sc := range c {
+		s, err := sc.Stage()
+		if err != nil {
+			return nil, err
+		}
+		ch.stages = append(ch.stages, s)
+	}
+	return ch, nil
+}
+
+// Process processes the interleaved samples of b in place. The stages are
+// reset when the sample rate or number of channels changes.
+func (c *Chain) Process(b []float32, rate, channels int) {
+	if len(c.stages) == 0 {
+		return
+	}
+	if rate != c.rate || channels != c.channels {
+		for _, s := range c.stages {
+			s.Init(rate, channels)
+		}
+		c.rate, c.channels = rate, channels
+	}
+	for _, s := range c.stages {
+		s.Process(b)
+	}
+}
+
+// Preamp changes the level by a fixed gain.
+type Preamp struct {
+	// Gain in dB.
+	Gain float64
+}
+
+func (p *Preamp) validate() error {
+	if p.Gain < -60 || p.Gain > 30 {
+		return fmt.Errorf("dsp: bad preamp gain: %v", p.Gain)
+	}
+	return nil
+}
+
+func (p *Preamp) Init(rate, channels int) {}
+
+func (p *Preamp) Process(b []float32) {
+	Gain(b, Linear(p.Gain))
+}
+
+// Balance attenuates the left or right channel.
+type Balance struct {
+	// Balance is from -1, left only, to 1, right only.
+	Balance  float64
+	channels int
+}
+
+func (p *Balance) validate() error {
+	if p.Balance < -1 || p.Balance > 1 {
+		return fmt.Errorf("dsp: bad balance: %v", p.Balance)
+	}
+	return nil
+}
+
+func (p *Balance) Init(rate, channels int) {
+	p.channels = channels
+}
+
+func (p *Balance) Process(b []float32) {
+	if p.channels < 2 || p.Balance == 0 {
+		return
+	}
+	l, r := float32(1), float32(1)
+	if p.Balance > 0 {
+		l = float32(1 - p.Balance)
+	} else {
+		r = float32(1 + p.Balance)
+	}
+	for i := 0; i+1 < len(b); i += p.channels {
+		b[i] *= l
+		b[i+1] *= r
+	}
+}
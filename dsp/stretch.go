@@ -0,0 +1,259 @@
+package dsp
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Stretcher changes the tempo of audio without changing its pitch, by
+// waveform similarity overlap-add (WSOLA): overlapping windows of the input
+// are taken at a rate scaled by the speed, each shifted slightly to where
+// it best continues the previous one, and added at the original rate.
+type Stretcher struct {
+	channels int
+	speed    float64
+	// n is the window length, hop the output hop, tol the largest shift
+	// of a window, overlap the length compared to find the shift, and
+	// step that of the coarse search, all in frames.
+	n, hop, tol   int
+	overlap, step int
+	window        []float32
+	// ref and cand hold the mono sums of the frames compared by best.
+	ref, cand []float64
+	// in holds the input from frame start on.
+	in    []float32
+	start int64
+	// pos is the nominal position of the next window, prev that of the
+	// previous one, or -1.
+	pos  float64
+	prev int64
+	// acc holds the sum of the windows added, from the next output frame.
+	acc []float32
+	// skip is set until the first hop of output, which is of the silence
+	// before the input, is dropped.
+	skip bool
+	// inFrames and outFrames count the frames written and returned, to
+	// trim the output when flushing.
+	inFrames, outFrames int64
+}
+
+const (
+	stretchWindow  = 40 * time.Millisecond
+	stretchSeek    = 10 * time.Millisecond
+	stretchOverlap = 10 * time.Millisecond
+	stretchStep    = 250 * time.Microsecond
+)
+
+// stretchFrames returns the number of frames of d at rate, at least 1.
+func stretchFrames(rate int, d time.Duration) int {
+	n := int(int64(rate) * int64(d) / int64(time.Second))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// MinSpeed and MaxSpeed bound the speed of a Stretcher.
+const (
+	MinSpeed = 0.5
+	MaxSpeed = 3
+)
+
+// NewStretcher returns a stretcher that plays audio with the given sample
+// rate and number of channels speed times as fast.
+func NewStretcher(rate, channels int, speed float64) (*Stretcher, error) {
+	if math.IsNaN(speed) || speed < MinSpeed || speed > MaxSpeed {
+		return nil, fmt.Errorf("dsp: bad speed: %v", speed)
+	}
+	n := stretchFrames(rate, stretchWindow) &^ 1
+	if n < 2 {
+		n = 2
+	}
+	s := &Stretcher{
+		channels: channels,
+		speed:    speed,
+		n:        n,
+		hop:      n / 2,
+		tol:      stretchFrames(rate, stretchSeek),
+		overlap:  stretchFrames(rate, stretchOverlap),
+		step:     stretchFrames(rate, stretchStep),
+		window:   make([]float32, n),
+		acc:      make([]float32, n*channels),
+	}
+	if s.overlap > s.hop {
+		s.overlap = s.hop
+	}
+	for i := range s.window {
+		s.window[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n)))
+	}
+	s.Reset()
+	return s, nil
+}
+
+// Reset discards buffered audio.
+func (s *Stretcher) Reset() {
+	// Start half a window early on silence, and drop its output, so that
+	// the first frames are not faded in.
+	s.in = make([]float32, s.hop*s.channels, 4096)
+	s.start = -int64(s.hop)
+	s.pos = float64(s.start)
+	s.prev = -1
+	for i := range s.acc {
+		s.acc[i] = 0
+	}
+	s.skip = true
+	s.inFrames, s.outFrames = 0, 0
+}
+
+// Process stretches the interleaved samples of b. Some output is held until
+// more input is written or Flush is called.
+func (s *Stretcher) Process(b []float32) []float32 {
+	s.in = append(s.in, b...)
+	s.inFrames += int64(len(b) / s.channels)
+	return s.run(nil)
+}
+
+// Flush returns the held output and resets s.
+func (s *Stretcher) Flush() []float32 {
+	want := int64(math.Round(float64(s.inFrames) / s.speed))
+	s.in = append(s.in, make([]float32, (s.n+2*s.tol)*s.channels)...)
+	out := s.run(nil)
+	// The last window is still in acc.
+	for i := 0; i < 2 && s.outFrames < want; i++ {
+		out = s.emit(out)
+	}
+	if extra := s.outFrames - want; extra > 0 {
+		n := len(out) - int(extra)*s.channels
+		if n < 0 {
+			n = 0
+		}
+		out = out[:n]
+	}
+	s.Reset()
+	return out
+}
+
+// end returns the frame after the last one of in.
+func (s *Stretcher) end() int64 {
+	return s.start + int64(len(s.in)/s.channels)
+}
+
+// run adds windows while there is input for them, appending the output to
+// out.
+func (s *Stretcher) run(out []float32) []float32 {
+	for {
+		p := int64(math.Round(s.pos))
+		if p+int64(s.tol+s.n) > s.end() {
+			break
+		}
+		if s.prev >= 0 {
+			p = s.best(p)
+		}
+		s.add(p)
+		s.prev = p
+		s.pos += float64(s.hop) * s.speed
+		out = s.emit(out)
+		// Drop input no longer needed.
+		keep := int64(math.Round(s.pos)) - int64(s.tol)
+		if next := s.prev + int64(s.hop); next < keep {
+			keep = next
+		}
+		if drop := keep - s.start; drop > 0 {
+			n := copy(s.in, s.in[drop*int64(s.channels):])
+			s.in = s.in[:n]
+			s.start = keep
+		}
+	}
+	return out
+}
+
+// mono appends the mono sums of the n input frames from f to b.
+func (s *Stretcher) mono(b []float64, f int64, n int) []float64 {
+	i := int((f - s.start) * int64(s.channels))
+	for ; n > 0; n-- {
+		var v float64
+		for _, x := range s.in[i : i+s.channels] {
+			v += float64(x)
+		}
+		b = append(b, v)
+		i += s.channels
+	}
+	return b
+}
+
+// score returns how well the candidate frames from i continue the previous
+// window, comparing every step frames.
+func (s *Stretcher) score(i, step int) float64 {
+	var corr, energy float64
+	for k := 0; k < s.overlap; k += step {
+		c := s.cand[i+k]
+		corr += c * s.ref[k]
+		energy += c * c
+	}
+	return corr / math.Sqrt(energy+1e-9)
+}
+
+// best returns the position near p whose start best matches the
+// continuation of the previous window. It compares every step frames of
+// shifts step frames apart, then every frame of the shifts around the best
+// of those.
+func (s *Stretcher) best(p int64) int64 {
+	lo := p - int64(s.tol)
+	if lo < s.start {
+		lo = s.start
+	}
+	n := int(p + int64(s.tol) - lo)
+	s.ref = s.mono(s.ref[:0], s.prev+int64(s.hop), s.overlap)
+	s.cand = s.mono(s.cand[:0], lo, n+s.overlap)
+
+	coarse, bestScore := 0, math.Inf(-1)
+	for i := 0; i <= n; i += s.step {
+		if score := s.score(i, s.step); score > bestScore {
+			coarse, bestScore = i, score
+		}
+	}
+	from, to := coarse-s.step+1, coarse+s.step-1
+	if from < 0 {
+		from = 0
+	}
+	if to > n {
+		to = n
+	}
+	best := coarse
+	bestScore = math.Inf(-1)
+	for i := from; i <= to; i++ {
+		if score := s.score(i, 1); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return lo + int64(best)
+}
+
+// add adds the window of input at p to acc.
+func (s *Stretcher) add(p int64) {
+	i := int((p - s.start) * int64(s.channels))
+	for f, w := range s.window {
+		for c := 0; c < s.channels; c++ {
+			j := f*s.channels + c
+			s.acc[j] += w * s.in[i+j]
+		}
+	}
+}
+
+// emit appends the hop frames of acc that no later window adds to, and
+// shifts acc.
+func (s *Stretcher) emit(out []float32) []float32 {
+	n := s.hop * s.channels
+	if s.skip {
+		s.skip = false
+	} else {
+		out = append(out, s.acc[:n]...)
+		s.outFrames += int64(s.hop)
+	}
+	copy(s.acc, s.acc[n:])
+	for i := len(s.acc) - n; i < len(s.acc); i++ {
+		s.acc[i] = 0
+	}
+	return out
+}
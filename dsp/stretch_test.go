@@ -0,0 +1,91 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// stretch stretches b in chunks of n samples.
+func stretch(s *Stretcher, b []float32, n int) []float32 {
+	var out []float32
+	for len(b) > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		out = append(out, s.Process(b[:n])...)
+		b = b[n:]
+	}
+	return append(out, s.Flush()...)
+}
+
+func newStretcher(t *testing.T, rate, channels int, speed float64) *Stretcher {
+	t.Helper()
+	s, err := NewStretcher(rate, channels, speed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// crossings returns the number of times b changes sign.
+func crossings(b []float32) int {
+	n := 0
+	for i := 1; i < len(b); i++ {
+		if (b[i-1] < 0) != (b[i] < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStretcher(t *testing.T) {
+	const frames = 48000
+	in := tone(48000, frames, 440)
+	for _, speed := range []float64{0.5, 0.75, 1, 1.5, 2, 3} {
+		s := newStretcher(t, 48000, 1, speed)
+		out := stretch(s, in, 4096)
+		if want := int(math.Round(frames / speed)); len(out) != want {
+			t.Fatalf("%vx: got %d samples, want %d", speed, len(out), want)
+		}
+		// The output does not depend on how the input is split.
+		for i, v := range stretch(s, in, 1000) {
+			if v != out[i] {
+				t.Fatalf("%vx: sample %d is %v, want %v", speed, i, v, out[i])
+			}
+		}
+		// Away from the ends, the pitch and level are kept.
+		mid := out[len(out)/10 : len(out)-len(out)/10]
+		if f := float64(crossings(mid)) / 2 / float64(len(mid)) * 48000; math.Abs(f-440) > 440*0.01 {
+			t.Errorf("%vx: frequency %vHz, want 440Hz", speed, f)
+		}
+		if r := rms(mid) / (0.5 / math.Sqrt2); math.Abs(r-1) > 0.05 {
+			t.Errorf("%vx: gain %v, want 1", speed, r)
+		}
+	}
+}
+
+func TestStretcherChannels(t *testing.T) {
+	left := tone(44100, 10000, 1000)
+	b := make([]float32, len(left)*2)
+	for i, v := range left {
+		b[i*2] = v
+	}
+	out := stretch(newStretcher(t, 44100, 2, 1.5), b, 4096)
+	mono := stretch(newStretcher(t, 44100, 1, 1.5), left, 4096)
+	if len(out) != len(mono)*2 {
+		t.Fatalf("got %d samples, want %d", len(out), len(mono)*2)
+	}
+	for i, v := range mono {
+		if out[i*2] != v || out[i*2+1] != 0 {
+			t.Fatalf("frame %d is %v, %v, want %v, 0", i, out[i*2], out[i*2+1], v)
+		}
+	}
+}
+
+func TestStretcherSpeed(t *testing.T) {
+	for _, speed := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 0, -1, 0.49, 3.01} {
+		if _, err := NewStretcher(48000, 2, speed); err == nil {
+			t.Errorf("%v: expected an error", speed)
+		}
+	}
+}
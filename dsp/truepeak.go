@@ -0,0 +1,67 @@
+package dsp
+
+import "math"
+
+// oversample is the factor by which the true peak detector oversamples, as
+// in ITU-R BS.1770-4 annex 2.
+const oversample = 4
+
+// peakTaps is the length of each phase of the interpolation filter.
+const peakTaps = 12
+
+// peakFilter holds the phases of a windowed sinc interpolation filter.
+var peakFilter = func() (f [oversample][peakTaps]float64) {
+	n := oversample * peakTaps
+	c := float64(n-1) / 2
+	for i := 0; i < n; i++ {
+		x := (float64(i) - c) / oversample
+		h := 1.0
+		if x != 0 {
+			h = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		// Hann window.
+		h *= 0.5 - 0.5*math.Cos(2*math.Pi*float64(i+1)/float64(n+1))
+		f[i%oversample][i/oversample] = h
+	}
+	for p := range f {
+		var sum float64
+		for _, h := range f[p] {
+			sum += h
+		}
+		for k := range f[p] {
+			f[p][k] /= sum
+		}
+	}
+	return
+}()
+
+// peakDetector estimates the true peak of one channel by interpolating
+// between samples.
+type peakDetector struct {
+	// hist holds the latest samples twice, so that they can be read in
+	// order from pos.
+	hist [2 * peakTaps]float64
+	pos  int
+}
+
+// next adds sample x and returns the largest absolute value of the signal
+// around it.
+func (d *peakDetector) next(x float64) float64 {
+	d.pos--
+	if d.pos < 0 {
+		d.pos = peakTaps - 1
+	}
+	d.hist[d.pos] = x
+	d.hist[d.pos+peakTaps] = x
+	peak := math.Abs(x)
+	for p := range peakFilter {
+		var y float64
+		for k, h := range peakFilter[p] {
+			y += h * d.hist[d.pos+k]
+		}
+		if y = math.Abs(y); y > peak {
+			peak = y
+		}
+	}
+	return peak
+}
@@ -0,0 +1,58 @@
+package dsp
+
+import "math"
+
+// VolumeGain returns the linear gain of volume v, from 0 to 1, on a
+// perceptual curve that spans about 60dB.
+func VolumeGain(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 1
+	}
+	return v * v * v
+}
+
+// VolumeDB returns the volume at which VolumeGain is db decibels.
+func VolumeDB(db float64) float64 {
+	return math.Min(1, math.Cbrt(Linear(db)))
+}
+
+// fadeTime is the time constant in seconds of Fader.
+const fadeTime = 0.01
+
+// Fader applies a gain that moves smoothly to changes, so that they don't
+// click.
+type Fader struct {
+	gain, target float64
+}
+
+// NewFader returns a fader starting at gain g.
+func NewFader(g float64) *Fader {
+	return &Fader{gain: g, target: g}
+}
+
+// Set sets the gain to move to.
+func (f *Fader) Set(g float64) {
+	f.target = g
+}
+
+// Process applies the gain to the interleaved samples of b in place.
+func (f *Fader) Process(b []float32, rate, channels int) {
+	if f.gain == f.target {
+		Gain(b, f.gain)
+		return
+	}
+	a := 1 - math.Exp(-1/(fadeTime*float64(rate)))
+	for i := 0; i+channels <= len(b); i += channels {
+		f.gain += (f.target - f.gain) * a
+		if math.Abs(f.target-f.gain) < 1e-5 {
+			f.gain = f.target
+		}
+		g := float32(f.gain)
+		for c := i; c < i+channels; c++ {
+			b[c] *= g
+		}
+	}
+}
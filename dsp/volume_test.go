@@ -0,0 +1,71 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVolume(t *testing.T) {
+	for _, c := range []struct{ v, gain float64 }{
+		{-1, 0},
+		{0, 0},
+		{0.5, 0.125},
+		{1, 1},
+		{2, 1},
+	} {
+		if g := VolumeGain(c.v); g != c.gain {
+			t.Errorf("volume %v: gain %v, want %v", c.v, g, c.gain)
+		}
+	}
+	for v := 0.01; v <= 1; v += 0.01 {
+		if got := VolumeDB(DB(VolumeGain(v))); math.Abs(got-v) > 1e-12 {
+			t.Errorf("volume %v round trips to %v", v, got)
+		}
+	}
+	// The curve spans about 60dB.
+	if db := DB(VolumeGain(0.1)); db != -60 {
+		t.Errorf("volume 0.1 is %vdB, want -60dB", db)
+	}
+	if v := VolumeDB(6); v != 1 {
+		t.Errorf("6dB is volume %v, want 1", v)
+	}
+}
+
+func TestFader(t *testing.T) {
+	f := NewFader(0.5)
+	b := sine(4800, 1000, 1)
+	want := append([]float32(nil), b...)
+	Gain(want, 0.5)
+	f.Process(b, 48000, 2)
+	for i := range b {
+		if b[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, b[i], want[i])
+		}
+	}
+
+	// A change ramps over a few fadeTime, the same on each channel,
+	// without overshooting.
+	f.Set(0)
+	b = make([]float32, 48000/5*2)
+	for i := range b {
+		b[i] = 1
+	}
+	f.Process(b, 48000, 2)
+	prev := float32(0.5)
+	for i := 0; i < len(b); i += 2 {
+		if b[i] != b[i+1] {
+			t.Fatalf("frame %d is %v, %v", i/2, b[i], b[i+1])
+		}
+		if b[i] > prev || b[i] < 0 {
+			t.Fatalf("frame %d is %v after %v", i/2, b[i], prev)
+		}
+		prev = b[i]
+	}
+	// Half of the change is made in fadeTime·ln 2.
+	if i := int(math.Round(fadeTime*math.Ln2*48000)) * 2; math.Abs(float64(b[i])-0.25) > 0.01 {
+		t.Errorf("gain %v after %vs, want 0.25", b[i], fadeTime*math.Ln2)
+	}
+	if b[len(b)-1] != 0 || f.gain != 0 {
+		t.Errorf("gain %v after 200ms, want 0", f.gain)
+	}
+}
@@ -17,9 +17,19 @@ import (
 	"github.com/mjibson/mog/server"
 
 	// codecs
+	_ "github.com/mjibson/mog/codec/aac"
+	_ "github.com/mjibson/mog/codec/alac"
 	_ "github.com/mjibson/mog/codec/flac"
+	_ "github.com/mjibson/mog/codec/gbs"
+	"github.com/mjibson/mog/codec/midi"
+	_ "github.com/mjibson/mog/codec/mod"
 	_ "github.com/mjibson/mog/codec/mpa"
 	_ "github.com/mjibson/mog/codec/nsf"
+	_ "github.com/mjibson/mog/codec/opus"
+	"github.com/mjibson/mog/codec/sid"
+	_ "github.com/mjibson/mog/codec/spc"
+	"github.com/mjibson/mog/codec/vgm"
+	_ "github.com/mjibson/mog/codec/vorbis"
 	_ "github.com/mjibson/mog/codec/wav"
 
 	// protocols
@@ -39,6 +49,9 @@ var (
 	flagSoundcloud = flag.String("soundcloud", "ec28c2226a0838d01edc6ed0014e462e:a115e94029d698f541960c8dc8560978", "SoundCloud API credentials of the form ClientID:ClientSecret")
 	flagDev        = flag.Bool("dev", false, "enable dev mode")
 	stateFile      = flag.String("state", "", "specify non-default statefile location")
+	songlengths    = flag.String("songlengths", "", "HVSC Songlengths.md5 file with SID song lengths")
+	soundfont      = flag.String("soundfont", "", "SF2 SoundFont used to play MIDI files")
+	vgmLoops       = flag.Int("vgmloops", vgm.Loops, "number of times looped VGM songs play their loop")
 )
 
 func main() {
@@ -81,6 +94,17 @@ func main() {
 		}
 		soundcloud.Init(sp[0], sp[1], redir)
 	}
+	vgm.Loops = *vgmLoops
+	if *songlengths != "" {
+		if err := sid.LoadSonglengths(*songlengths); err != nil {
+			log.Println("songlengths:", err)
+		}
+	}
+	if *soundfont != "" {
+		if err := midi.LoadSoundFont(*soundfont); err != nil {
+			log.Println("soundfont:", err)
+		}
+	}
 	if *stateFile == "" {
 		switch {
 		case *flagDev:
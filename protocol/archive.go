@@ -0,0 +1,478 @@
+package protocol
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/mjibson/mog/codec"
+)
+
+// ArchiveSep separates the path of an archive from the path of an entry
+// inside it in song IDs.
+const ArchiveSep = "!/"
+
+// IsArchive returns whether name is a ZIP, tar or gzipped tar archive.
+func IsArchive(name string) bool {
+	return archiveKind(name) != ""
+}
+
+// TrimArchiveExt returns name without its archive extension.
+func TrimArchiveExt(name string) string {
+	if _, ext := archiveExt(name); ext != "" {
+		return name[:len(name)-len(ext)]
+	}
+	return name
+}
+
+var archiveExts = []struct{ ext, kind string }{
+	{".zip", "zip"},
+	{".tar", "tar"},
+	{".tar.gz", "tgz"},
+	{".tgz", "tgz"},
+}
+
+// archiveExt returns the kind and extension of archive name.
+func archiveExt(name string) (kind, ext string) {
+	n := strings.ToLower(name)
+	for _, a := range archiveExts {
+		if strings.HasSuffix(n, a.ext) {
+			return a.kind, a.ext
+		}
+	}
+	return "", ""
+}
+
+func archiveKind(name string) string {
+	kind, _ := archiveExt(name)
+	return kind
+}
+
+// SplitArchive splits path into an archive and an entry. entry is empty if
+// path is not inside an archive.
+func SplitArchive(path string) (archive, entry string) {
+	for i := 0; ; {
+		j := strings.Index(path[i:], ArchiveSep)
+		if j < 0 {
+			return path, ""
+		}
+		i += j
+		if IsArchive(path[:i]) {
+			return path[:i], path[i+len(ArchiveSep):]
+		}
+		i += len(ArchiveSep)
+	}
+}
+
+// maxArchives is the number of archive indexes an Archives keeps.
+const maxArchives = 4
+
+// Archives holds the indexes of the archives last listed by a protocol
+// instance, so that playing their entries does not read each archive again
+// to find them. Entries are streamed from the archive, so songs stay
+// playable after the index is dropped. The zero value is ready to use.
+//
+// Archives are identified by id, which is unique within the protocol, and
+// name is the archive's file name, whose extension gives its kind.
+type Archives struct {
+	mu sync.Mutex
+	// open holds the indexes, the most recently used last.
+	open []*archive
+}
+
+// Songs returns the songs of the entries of an archive, keyed by entry
+// path. Entries without a known extension are skipped.
+func (as *Archives) Songs(id, name string, rf codec.Reader) (map[string][]codec.Song, error) {
+	a, err := as.get(id, name, rf)
+	if err != nil {
+		return nil, err
+	}
+	songs := make(map[string][]codec.Song)
+	for _, e := range a.names {
+		ss, _, err := codec.ByExtension(e, a.reader(e))
+		if err != nil || len(ss) == 0 {
+			continue
+		}
+		songs[e] = ss
+	}
+	return songs, nil
+}
+
+// Entry returns the songs of entry in an archive.
+func (as *Archives) Entry(id, name string, rf codec.Reader, entry string) ([]codec.Song, error) {
+	a, err := as.get(id, name, rf)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := a.entries[entry]; !ok {
+		return nil, fmt.Errorf("missing %v", entry)
+	}
+	ss, _, err := codec.ByExtension(entry, a.reader(entry))
+	return ss, err
+}
+
+// Reset drops the indexes. Protocols reset when they refresh, since their
+// archives may have changed.
+func (as *Archives) Reset() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.open = nil
+}
+
+// get returns the archive id, indexing it if it is not indexed.
+func (as *Archives) get(id, name string, rf codec.Reader) (*archive, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	for i, a := range as.open {
+		if a.id == id {
+			copy(as.open[i:], as.open[i+1:])
+			as.open[len(as.open)-1] = a
+			return a, nil
+		}
+	}
+	a, err := openArchive(id, name, rf)
+	if err != nil {
+		return nil, err
+	}
+	if len(as.open) >= maxArchives {
+		as.open = as.open[1:]
+	}
+	as.open = append(as.open, a)
+	return a, nil
+}
+
+// archive is the index of an archive.
+type archive struct {
+	id, kind string
+	rf       codec.Reader
+	// at is set if rf can be read at random.
+	at    bool
+	names []string
+	// entries locates the contents of each entry.
+	entries map[string]section
+}
+
+// section locates the contents of an entry. off and size give its data in
+// the archive, or in the uncompressed tar of a gzipped tar, and length its
+// size once decompressed. Zips read at random only set length.
+type section struct {
+	off, size, length int64
+	// deflated is set if the data is compressed.
+	deflated bool
+}
+
+func openArchive(id, name string, rf codec.Reader) (*archive, error) {
+	a := &archive{
+		id:      id,
+		kind:    archiveKind(name),
+		rf:      rf,
+		entries: make(map[string]section),
+	}
+	r, size, err := rf()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if ra, ok := r.(io.ReaderAt); ok && size > 0 && a.kind != "tgz" {
+		a.at = true
+		err = a.indexAt(ra, size)
+	} else {
+		err = a.indexStream(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archive) add(name string, s section) {
+	a.names = append(a.names, name)
+	a.entries[name] = s
+}
+
+// indexAt lists the files in an archive read at random.
+func (a *archive) indexAt(ra io.ReaderAt, size int64) error {
+	if a.kind == "zip" {
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() {
+				a.add(f.Name, section{length: int64(f.UncompressedSize64)})
+			}
+		}
+		return nil
+	}
+	// The tar reader seeks past the contents of entries, and leaves sr at
+	// the start of each entry's contents.
+	sr := io.NewSectionReader(ra, 0, size)
+	tr := tar.NewReader(sr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg || h.Typeflag == tar.TypeRegA {
+			off, _ := sr.Seek(0, io.SeekCurrent)
+			a.add(h.Name, section{off, h.Size, h.Size, false})
+		}
+	}
+}
+
+// countReader reads from a bufio.Reader and counts the bytes read. It
+// implements io.ByteReader so that decompressors don't read ahead.
+type countReader struct {
+	br *bufio.Reader
+	n  int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.br.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.br.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// skip discards n bytes.
+func (c *countReader) skip(n int64) error {
+	_, err := io.CopyN(ioutil.Discard, c, n)
+	return err
+}
+
+// indexStream lists the files in an archive read in order.
+func (a *archive) indexStream(r io.Reader) error {
+	if a.kind == "zip" {
+		return a.indexZip(&countReader{br: bufio.NewReader(r)})
+	}
+	if a.kind == "tgz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	// The tar reader reads no further than the header of each entry.
+	c := &countReader{br: bufio.NewReader(r)}
+	tr := tar.NewReader(c)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg || h.Typeflag == tar.TypeRegA {
+			a.add(h.Name, section{c.n, h.Size, h.Size, false})
+		}
+	}
+}
+
+const (
+	zipLocalSig      = 0x04034b50
+	zipDescriptorSig = 0x08074b50
+	zip64ExtraID     = 0x0001
+)
+
+// indexZip lists the files in a zip from the local header before each,
+// since the central directory at its end can only be found by reading at
+// random.
+func (a *archive) indexZip(c *countReader) error {
+	le := binary.LittleEndian
+	for {
+		var h [30]byte
+		if _, err := io.ReadFull(c, h[:4]); err != nil {
+			return err
+		}
+		if le.Uint32(h[:]) != zipLocalSig {
+			// The central directory follows the last entry.
+			return nil
+		}
+		if _, err := io.ReadFull(c, h[4:]); err != nil {
+			return err
+		}
+		flags, method := le.Uint16(h[6:]), le.Uint16(h[8:])
+		s := section{size: int64(le.Uint32(h[18:])), length: int64(le.Uint32(h[22:]))}
+		b := make([]byte, int(le.Uint16(h[26:]))+int(le.Uint16(h[28:])))
+		if _, err := io.ReadFull(c, b); err != nil {
+			return err
+		}
+		name := string(b[:le.Uint16(h[26:])])
+		zip64 := false
+		for e := b[len(name):]; len(e) >= 4; {
+			id, n := le.Uint16(e), int(le.Uint16(e[2:]))
+			if len(e) < 4+n {
+				break
+			}
+			if id == zip64ExtraID {
+				zip64 = true
+				f := e[4 : 4+n]
+				if s.length == 0xffffffff && len(f) >= 8 {
+					s.length, f = int64(le.Uint64(f)), f[8:]
+				}
+				if s.size == 0xffffffff && len(f) >= 8 {
+					s.size = int64(le.Uint64(f))
+				}
+			}
+			e = e[4+n:]
+		}
+		s.off = c.n
+		switch method {
+		case zip.Store:
+		case zip.Deflate:
+			s.deflated = true
+		default:
+			return fmt.Errorf("%v: unsupported compression of %v", a.id, name)
+		}
+		dir := strings.HasSuffix(name, "/")
+		switch {
+		case flags&8 == 0:
+			if err := c.skip(s.size); err != nil {
+				return err
+			}
+		case s.deflated:
+			// The sizes follow the data, whose end is found by
+			// decompressing it.
+			n, err := io.Copy(ioutil.Discard, flate.NewReader(c))
+			if err != nil {
+				return err
+			}
+			s.size, s.length = c.n-s.off, n
+		case !dir:
+			return fmt.Errorf("%v: cannot find the end of %v", a.id, name)
+		}
+		if flags&8 != 0 {
+			// Skip the data descriptor: an optional signature, the CRC
+			// and the sizes.
+			n := int64(12)
+			if zip64 {
+				n = 20
+			}
+			if b, err := c.br.Peek(4); err == nil && le.Uint32(b) == zipDescriptorSig {
+				n += 4
+			}
+			if err := c.skip(n); err != nil {
+				return err
+			}
+		}
+		if !dir {
+			a.add(name, s)
+		}
+	}
+}
+
+type entryReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (e *entryReader) Close() error {
+	var err error
+	for _, c := range e.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// tarEntry reads an entry of a tar, which can seek.
+type tarEntry struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// reader returns a reader of entry.
+func (a *archive) reader(entry string) codec.Reader {
+	s := a.entries[entry]
+	if !a.at {
+		return func() (io.ReadCloser, int64, error) {
+			return a.stream(s)
+		}
+	}
+	return func() (io.ReadCloser, int64, error) {
+		r, size, err := a.rf()
+		if err != nil {
+			return nil, 0, err
+		}
+		ra, ok := r.(io.ReaderAt)
+		if !ok {
+			r.Close()
+			return nil, 0, fmt.Errorf("%v: cannot read at random", a.id)
+		}
+		if a.kind != "zip" {
+			return tarEntry{io.NewSectionReader(ra, s.off, s.size), r}, s.size, nil
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			r.Close()
+			return nil, 0, err
+		}
+		for _, f := range zr.File {
+			if f.Name != entry {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				r.Close()
+				return nil, 0, err
+			}
+			return &entryReader{rc, []io.Closer{rc, r}}, s.length, nil
+		}
+		r.Close()
+		return nil, 0, fmt.Errorf("missing %v", entry)
+	}
+}
+
+// stream opens the archive and reads it up to the data of s.
+func (a *archive) stream(s section) (io.ReadCloser, int64, error) {
+	var r io.Reader
+	var closers []io.Closer
+	if a.kind == "tgz" {
+		f, _, err := a.rf()
+		if err != nil {
+			return nil, 0, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		r, closers = gz, []io.Closer{gz, f}
+		if _, err := io.CopyN(ioutil.Discard, gz, s.off); err != nil {
+			gz.Close()
+			f.Close()
+			return nil, 0, err
+		}
+	} else {
+		f, err := codec.OpenAt(a.rf, s.off)
+		if err != nil {
+			return nil, 0, err
+		}
+		r, closers = f, []io.Closer{f}
+	}
+	r = io.LimitReader(r, s.size)
+	if s.deflated {
+		fr := flate.NewReader(r)
+		r, closers = fr, append([]io.Closer{fr}, closers...)
+	}
+	return &entryReader{r, closers}, s.length, nil
+}
@@ -0,0 +1,243 @@
+package protocol
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mjibson/mog/codec"
+	_ "github.com/mjibson/mog/codec/wav"
+)
+
+// wavFile returns a mono 16-bit WAV file of n samples of value v.
+func wavFile(n int, v int16) []byte {
+	b := make([]byte, 44+n*2)
+	le := binary.LittleEndian
+	copy(b, "RIFF")
+	le.PutUint32(b[4:], uint32(len(b)-8))
+	copy(b[8:], "WAVEfmt ")
+	le.PutUint32(b[16:], 16)
+	le.PutUint16(b[20:], 1)
+	le.PutUint16(b[22:], 1)
+	le.PutUint32(b[24:], 8000)
+	le.PutUint32(b[28:], 16000)
+	le.PutUint16(b[32:], 2)
+	le.PutUint16(b[34:], 16)
+	copy(b[36:], "data")
+	le.PutUint32(b[40:], uint32(n*2))
+	for i := 0; i < n; i++ {
+		le.PutUint16(b[44+i*2:], uint16(v))
+	}
+	return b
+}
+
+// testEntries are the files of the test archives. The WAV file at index i
+// holds 1000*(i+1) samples of value i+1.
+var testEntries = []string{"a.wav", "dir/b.wav", "notes.txt"}
+
+func testArchive(t *testing.T, kind string) []byte {
+	var buf bytes.Buffer
+	file := func(i int) []byte {
+		if i == len(testEntries)-1 {
+			return []byte("not audio")
+		}
+		return wavFile(1000*(i+1), int16(i+1))
+	}
+	switch kind {
+	case "zip":
+		w := zip.NewWriter(&buf)
+		for i, name := range testEntries {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(file(i))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "tar", "tgz":
+		var w io.Writer = &buf
+		var gz *gzip.Writer
+		if kind == "tgz" {
+			gz = gzip.NewWriter(&buf)
+			w = gz
+		}
+		tw := tar.NewWriter(w)
+		for i, name := range testEntries {
+			b := file(i)
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg})
+			tw.Write(b)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if gz != nil {
+			gz.Close()
+		}
+	}
+	return buf.Bytes()
+}
+
+// source counts the opens of an archive. Seekable sources can be read at
+// random.
+type source struct {
+	b        []byte
+	seekable bool
+	opens    int
+}
+
+type readAtCloser struct {
+	*bytes.Reader
+}
+
+func (readAtCloser) Close() error { return nil }
+
+func (s *source) reader() codec.Reader {
+	return func() (io.ReadCloser, int64, error) {
+		s.opens++
+		if s.seekable {
+			return readAtCloser{bytes.NewReader(s.b)}, int64(len(s.b)), nil
+		}
+		return ioutil.NopCloser(bytes.NewReader(s.b)), 0, nil
+	}
+}
+
+func checkEntry(t *testing.T, ss []codec.Song, i int) {
+	t.Helper()
+	if len(ss) != 1 {
+		t.Fatalf("got %d songs", len(ss))
+	}
+	if _, _, err := ss[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer ss[0].Close()
+	var n int
+	for {
+		b, err := ss[0].Play(4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range b {
+			if v != float32(i+1)/(1<<15) {
+				t.Fatalf("sample %v", v)
+			}
+		}
+		n += len(b)
+		if len(b) < 4096 {
+			break
+		}
+	}
+	if n != 1000*(i+1) {
+		t.Fatalf("got %d samples", n)
+	}
+}
+
+func TestArchives(t *testing.T) {
+	for _, kind := range []string{"zip", "tar", "tgz"} {
+		for _, seekable := range []bool{true, false} {
+			name := "test." + kind
+			t.Run(fmt.Sprintf("%s seekable=%v", kind, seekable), func(t *testing.T) {
+				var as Archives
+				defer as.Reset()
+				src := &source{b: testArchive(t, kind), seekable: seekable}
+				songs, err := as.Songs(name, name, src.reader())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(songs) != 2 {
+					t.Fatalf("got %d entries", len(songs))
+				}
+				for i, e := range testEntries[:2] {
+					checkEntry(t, songs[e], i)
+					ss, err := as.Entry(name, name, src.reader(), e)
+					if err != nil {
+						t.Fatal(err)
+					}
+					checkEntry(t, ss, i)
+				}
+				if _, err := as.Entry(name, name, src.reader(), "missing.wav"); err == nil {
+					t.Fatal("expected an error for a missing entry")
+				}
+				// Songs stay playable after the index is dropped.
+				ss, err := as.Entry(name, name, src.reader(), testEntries[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				as.Reset()
+				checkEntry(t, ss, 0)
+			})
+		}
+	}
+}
+
+func TestArchivesEvict(t *testing.T) {
+	var as Archives
+	b := testArchive(t, "tgz")
+	var srcs []*source
+	var first []codec.Song
+	for i := 0; i <= maxArchives; i++ {
+		src := &source{b: b}
+		srcs = append(srcs, src)
+		ss, err := as.Entry(fmt.Sprint(i), "test.tgz", src.reader(), "a.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = ss
+		}
+	}
+	if len(as.open) != maxArchives {
+		t.Fatalf("%d archives indexed", len(as.open))
+	}
+	// The first archive was dropped to index the last, but its songs
+	// still play, and it is indexed again.
+	checkEntry(t, first, 0)
+	opens := srcs[0].opens
+	if _, err := as.Entry("0", "test.tgz", srcs[0].reader(), "a.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if srcs[0].opens != opens+1 {
+		t.Fatalf("first archive opened %d times to index", srcs[0].opens-opens)
+	}
+}
+
+// TestZipStream checks the local headers of zips read in order: stored
+// entries, entries with data descriptors and directories.
+func TestZipStream(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	w.Create("dir/")
+	a := wavFile(1000, 1)
+	f, _ := w.CreateRaw(&zip.FileHeader{
+		Name:               "a.wav",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(a),
+		CompressedSize64:   uint64(len(a)),
+		UncompressedSize64: uint64(len(a)),
+	})
+	f.Write(a)
+	f, _ = w.Create("dir/b.wav")
+	f.Write(wavFile(2000, 2))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var as Archives
+	src := &source{b: buf.Bytes()}
+	songs, err := as.Songs("z", "test.zip", src.reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 2 || len(as.open[0].names) != 2 {
+		t.Fatalf("got %d songs of %v", len(songs), as.open[0].names)
+	}
+	checkEntry(t, songs["a.wav"], 0)
+	checkEntry(t, songs["dir/b.wav"], 1)
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"path"
 
 	"github.com/mjibson/mog/_third_party/github.com/google/google-api-go-client/drive/v2"
 	"github.com/mjibson/mog/_third_party/golang.org/x/oauth2"
@@ -41,6 +42,8 @@ type Drive struct {
 	Token *oauth2.Token
 	Files map[string]*drive.File
 	Songs protocol.SongList
+
+	archives protocol.Archives
 }
 
 func New(params []string, token *oauth2.Token) (protocol.Instance, error) {
@@ -76,11 +79,17 @@ func (d *Drive) GetSong(id string) (codec.Song, error) {
 	if err != nil {
 		return nil, err
 	}
-	f := d.Files[path]
+	archive, entry := protocol.SplitArchive(path)
+	f := d.Files[archive]
 	if f == nil {
 		return nil, fmt.Errorf("missing %v", path)
 	}
-	ss, _, err := codec.ByExtension(f.FileExtension, d.reader(path))
+	var ss []codec.Song
+	if entry != "" {
+		ss, err = d.archives.Entry(archive, f.Title, d.reader(archive), entry)
+	} else {
+		ss, _, err = codec.ByExtension(f.FileExtension, d.reader(path))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -118,6 +127,7 @@ func (d *Drive) Refresh() (protocol.SongList, error) {
 	if err != nil {
 		return nil, err
 	}
+	d.archives.Reset()
 	files := make(map[string]*drive.File)
 	songs := make(protocol.SongList)
 	var nextPage string
@@ -134,23 +144,23 @@ func (d *Drive) Refresh() (protocol.SongList, error) {
 		}
 		nextPage = fl.NextPageToken
 		for _, f := range fl.Items {
+			if protocol.IsArchive(f.Title) {
+				entries, err := d.archives.Songs(f.Id, f.Title, d.reader(f.Id))
+				if err != nil || len(entries) == 0 {
+					continue
+				}
+				files[f.Id] = f
+				for entry, ss := range entries {
+					addSongs(songs, f.Id+protocol.ArchiveSep+entry, path.Base(entry), protocol.TrimArchiveExt(f.Title), ss)
+				}
+				continue
+			}
 			ss, _, err = codec.ByExtension(f.FileExtension, d.reader(f.Id))
 			if err != nil || len(ss) == 0 {
 				continue
 			}
 			files[f.Id] = f
-			for i, v := range ss {
-				id := fmt.Sprintf("%v-%v", i, f.Id)
-				info, _ := v.Info()
-				if info.Title == "" {
-					title := f.Title
-					if len(ss) != 1 {
-						title += fmt.Sprintf(":%v", i)
-					}
-					info.Title = title
-				}
-				songs[id] = &info
-			}
+			addSongs(songs, f.Id, f.Title, "", ss)
 		}
 		if nextPage == "" {
 			break
@@ -160,3 +170,21 @@ func (d *Drive) Refresh() (protocol.SongList, error) {
 	d.Files = files
 	return songs, err
 }
+
+// addSongs adds the songs ss of the file with ID id to songs. title and album
+// are used for songs without them.
+func addSongs(songs protocol.SongList, id, title, album string, ss []codec.Song) {
+	for i, v := range ss {
+		info, _ := v.Info()
+		if info.Title == "" {
+			info.Title = title
+			if len(ss) != 1 {
+				info.Title += fmt.Sprintf(":%v", i)
+			}
+		}
+		if info.Album == "" {
+			info.Album = album
+		}
+		songs[fmt.Sprintf("%v-%v", i, id)] = &info
+	}
+}
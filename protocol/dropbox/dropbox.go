@@ -40,6 +40,8 @@ type Dropbox struct {
 	Token *oauth2.Token
 	Files map[string]*dropbox.ListContent
 	Songs protocol.SongList
+
+	archives protocol.Archives
 }
 
 func New(params []string, token *oauth2.Token) (protocol.Instance, error) {
@@ -75,11 +77,17 @@ func (d *Dropbox) GetSong(id string) (codec.Song, error) {
 	if err != nil {
 		return nil, err
 	}
-	f := d.Files[path]
+	archive, entry := protocol.SplitArchive(path)
+	f := d.Files[archive]
 	if f == nil {
 		return nil, fmt.Errorf("missing %v", path)
 	}
-	ss, _, err := codec.ByExtension(f.Path, d.reader(path, f.Bytes))
+	var ss []codec.Song
+	if entry != "" {
+		ss, err = d.archives.Entry(archive, f.Path, d.reader(archive, f.Bytes), entry)
+	} else {
+		ss, _, err = codec.ByExtension(f.Path, d.reader(path, f.Bytes))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -109,6 +117,7 @@ func (d *Dropbox) Refresh() (protocol.SongList, error) {
 	if err != nil {
 		return nil, err
 	}
+	d.archives.Reset()
 	files := make(map[string]*dropbox.ListContent)
 	songs := make(protocol.SongList)
 	var ss []codec.Song
@@ -128,29 +137,46 @@ func (d *Dropbox) Refresh() (protocol.SongList, error) {
 				dirs = append(dirs, f.Path)
 				continue
 			}
+			if protocol.IsArchive(f.Path) {
+				entries, err := d.archives.Songs(f.Path, f.Path, d.reader(f.Path, f.Bytes))
+				if err != nil || len(entries) == 0 {
+					continue
+				}
+				files[f.Path] = f
+				for entry, ss := range entries {
+					addSongs(songs, f.Path+protocol.ArchiveSep+entry, protocol.TrimArchiveExt(path.Base(f.Path)), ss)
+				}
+				continue
+			}
 			ss, _, err = codec.ByExtension(f.Path, d.reader(f.Path, f.Bytes))
 			if err != nil || len(ss) == 0 {
 				continue
 			}
 			files[f.Path] = f
-			for i, v := range ss {
-				id := fmt.Sprintf("%v-%v", i, f.Path)
-				info, _ := v.Info()
-				if info.Title == "" {
-					title := path.Base(f.Path)
-					if len(ss) != 1 {
-						title += fmt.Sprintf(":%v", i)
-					}
-					info.Title = title
-				}
-				if info.Album == "" {
-					info.Album = path.Base(dir)
-				}
-				songs[id] = &info
-			}
+			addSongs(songs, f.Path, path.Base(dir), ss)
 		}
 	}
 	d.Songs = songs
 	d.Files = files
 	return songs, err
 }
+
+// addSongs adds the songs ss of the file at p to songs. album is used for
+// songs without one.
+func addSongs(songs protocol.SongList, p, album string, ss []codec.Song) {
+	for i, v := range ss {
+		id := fmt.Sprintf("%v-%v", i, p)
+		info, _ := v.Info()
+		if info.Title == "" {
+			title := path.Base(p)
+			if len(ss) != 1 {
+				title += fmt.Sprintf(":%v", i)
+			}
+			info.Title = title
+		}
+		if info.Album == "" {
+			info.Album = album
+		}
+		songs[id] = &info
+	}
+}
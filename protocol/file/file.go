@@ -4,9 +4,11 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/mjibson/mog/_third_party/golang.org/x/oauth2"
 	"github.com/mjibson/mog/codec"
@@ -40,6 +42,8 @@ func New(params []string, token *oauth2.Token) (protocol.Instance, error) {
 type File struct {
 	Path  string
 	Songs protocol.SongList
+
+	archives protocol.Archives
 }
 
 func (f *File) Key() string {
@@ -64,10 +68,20 @@ func (f *File) GetSong(id string) (codec.Song, error) {
 	if err != nil {
 		return nil, err
 	}
-	songs, _, err := codec.ByExtension(path, fileReader(path))
+	var songs []codec.Song
+	if archive, entry := protocol.SplitArchive(path); entry != "" {
+		songs, err = f.archives.Entry(archive, archive, fileReader(archive), entry)
+	} else if isCue(path) {
+		songs, _, err = cueSongs(path)
+	} else {
+		songs, _, err = codec.ByExtension(path, fileReader(path))
+	}
 	if err != nil {
 		return nil, err
 	}
+	if len(songs) < num+1 {
+		return nil, fmt.Errorf("missing %v", id)
+	}
 	return songs[num], nil
 }
 
@@ -79,44 +93,138 @@ func (f *File) List() (protocol.SongList, error) {
 }
 
 func (f *File) Refresh() (protocol.SongList, error) {
+	f.archives.Reset()
 	songs := make(protocol.SongList)
+	var paths []string
 	err := filepath.Walk(f.Path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
+		if !info.IsDir() {
+			paths = append(paths, path)
 		}
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
+		return nil
+	})
+	// Files split by a CUE sheet are listed as its tracks only.
+	covered := make(map[string]bool)
+	for _, path := range paths {
+		if !isCue(path) {
+			continue
 		}
-		defer f.Close()
-		ss, _, err := codec.ByExtension(path, fileReader(path))
+		ss, files, err := cueSongs(path)
 		if err != nil || len(ss) == 0 {
-			return nil
-		}
-		for i, s := range ss {
-			id := fmt.Sprintf("%v-%v", i, path)
-			info, _ := s.Info()
-			if info.Title == "" {
-				title := filepath.Base(path)
-				if len(ss) != 1 {
-					title += fmt.Sprintf(":%v", i)
-				}
-				info.Title = title
+			continue
+		}
+		for _, name := range files {
+			covered[name] = true
+		}
+		addSongs(songs, path, filepath.Base(filepath.Dir(path)), ss)
+	}
+	for _, path := range paths {
+		if covered[path] {
+			continue
+		}
+		if protocol.IsArchive(path) {
+			entries, err := f.archives.Songs(path, path, fileReader(path))
+			if err != nil {
+				continue
 			}
-			if info.Album == "" {
-				info.Album = filepath.Base(filepath.Dir(path))
+			for entry, ss := range entries {
+				addSongs(songs, path+protocol.ArchiveSep+entry, protocol.TrimArchiveExt(filepath.Base(path)), ss)
 			}
-			songs[id] = &info
+			continue
 		}
-		return nil
-	})
+		ss, _, err := codec.ByExtension(path, fileReader(path))
+		if err != nil || len(ss) == 0 {
+			continue
+		}
+		addSongs(songs, path, filepath.Base(filepath.Dir(path)), ss)
+	}
 	f.Songs = songs
 	return songs, err
 }
 
+func isCue(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".cue")
+}
+
+// cueSongs returns the tracks of the CUE sheet at path and the audio files
+// they are in. Files that are split by their own embedded cue sheet are
+// skipped.
+func cueSongs(path string) ([]codec.Song, []string, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := codec.ParseCue(r)
+	r.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	var songs []codec.Song
+	var files []string
+	for i := range c.Files {
+		cf := &c.Files[i]
+		name := cueAudio(filepath.Join(filepath.Dir(path), cf.Name))
+		ss, _, err := codec.ByExtension(name, fileReader(name))
+		if err != nil || len(ss) != 1 {
+			continue
+		}
+		newSong := func() (codec.Song, error) {
+			ss, _, err := codec.ByExtension(name, fileReader(name))
+			if err != nil {
+				return nil, err
+			}
+			if len(ss) == 0 {
+				return nil, fmt.Errorf("missing %v", name)
+			}
+			return ss[0], nil
+		}
+		songs = append(songs, c.Spans(cf, newSong)...)
+		files = append(files, name)
+	}
+	return songs, files, nil
+}
+
+// cueAudio returns the audio file named by a cue sheet. Rips that were
+// converted after the sheet was made name a missing file with another
+// extension, so a file with the same base name is used.
+func cueAudio(name string) string {
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	dir, base := filepath.Split(name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		n := fi.Name()
+		if strings.TrimSuffix(n, filepath.Ext(n)) == base && !isCue(n) && codec.KnownExtension(n) {
+			return filepath.Join(dir, n)
+		}
+	}
+	return name
+}
+
+// addSongs adds the songs ss of the file at path to songs. album is used
+// for songs without one.
+func addSongs(songs protocol.SongList, path, album string, ss []codec.Song) {
+	for i, s := range ss {
+		id := fmt.Sprintf("%v-%v", i, path)
+		info, _ := s.Info()
+		if info.Title == "" {
+			title := filepath.Base(path)
+			if len(ss) != 1 {
+				title += fmt.Sprintf(":%v", i)
+			}
+			info.Title = title
+		}
+		if info.Album == "" {
+			info.Album = album
+		}
+		songs[id] = &info
+	}
+}
+
 func fileReader(path string) codec.Reader {
 	return func() (io.ReadCloser, int64, error) {
 		log.Println("open file", path)
@@ -86,12 +86,20 @@ func (g *GMusic) Refresh() (protocol.SongList, error) {
 	for _, t := range trackList {
 		tracks[t.ID] = t
 		duration, _ := strconv.Atoi(t.DurationMillis)
+		size, _ := strconv.ParseInt(t.EstimatedSize, 10, 64)
 		si := &codec.SongInfo{
-			Time:   time.Duration(duration) * time.Millisecond,
-			Artist: t.Artist,
-			Title:  t.Title,
-			Album:  t.Album,
-			Track:  t.TrackNumber,
+			Time:        time.Duration(duration) * time.Millisecond,
+			Artist:      t.Artist,
+			Title:       t.Title,
+			Album:       t.Album,
+			AlbumArtist: t.AlbumArtist,
+			Track:       t.TrackNumber,
+			Disc:        int(t.DiscNumber),
+			Codec:       "MP3",
+			Size:        size,
+		}
+		if t.Year != 0 {
+			si.Date = strconv.Itoa(int(t.Year))
 		}
 		if len(t.AlbumArtRef) != 0 {
 			si.ImageURL = t.AlbumArtRef[0].URL
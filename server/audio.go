@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"reflect"
 	"time"
 
 	"github.com/mjibson/mog/_third_party/golang.org/x/net/websocket"
 	"github.com/mjibson/mog/_third_party/golang.org/x/oauth2"
+	"github.com/mjibson/mog/dsp"
 	"github.com/mjibson/mog/output"
 	"github.com/mjibson/mog/protocol"
 )
@@ -18,12 +21,23 @@ import (
 func (srv *Server) audio() {
 	var o output.Output
 	var t chan interface{}
-	var dur time.Duration
 	srv.state = stateStop
 	var next, stop, tick, play, pause, prev func()
 	var timer <-chan time.Time
 	waiters := make(map[*websocket.Conn]chan struct{})
-	var seek *Seek
+	// cur is the playing track, and upcoming the next one if opened ahead
+	// of time for a gapless transition or crossfade.
+	var cur, upcoming *track
+	// fade is the crossfade into upcoming, if any.
+	var fade crossfade
+	// noUpcoming is set if the next song failed to open ahead of time.
+	var noUpcoming bool
+	chain, err := dsp.NewChain(srv.DSP)
+	if err != nil {
+		log.Println(err)
+		srv.DSP = nil
+		chain, _ = dsp.NewChain(nil)
+	}
 	broadcastData := func(wd *waitData) {
 		for ws := range waiters {
 			go func(ws *websocket.Conn) {
@@ -117,33 +131,92 @@ func (srv *Server) audio() {
 		stop()
 		play()
 	}
+	// nextIndex returns the queue index of the song to play after the
+	// current one.
+	nextIndex := func() int {
+		if srv.Random && len(srv.Queue) > 1 {
+			n := srv.PlaylistIndex
+			for n == srv.PlaylistIndex {
+				n = rand.Intn(len(srv.Queue))
+			}
+			return n
+		}
+		return srv.PlaylistIndex + 1
+	}
+	closeUpcoming := func() {
+		if upcoming != nil {
+			upcoming.close()
+			upcoming = nil
+		}
+		fade = crossfade{}
+		noUpcoming = false
+	}
 	stop = func() {
 		log.Println("stop")
 		srv.state = stateStop
 		t = nil
-		if srv.song != nil {
-			if srv.Random && len(srv.Queue) > 1 {
-				n := srv.PlaylistIndex
-				for n == srv.PlaylistIndex {
-					n = rand.Intn(len(srv.Queue))
-				}
-				srv.PlaylistIndex = n
-			} else {
-				srv.PlaylistIndex++
-			}
+		if cur != nil {
+			srv.PlaylistIndex = nextIndex()
+			cur.close()
+			cur = nil
 		}
+		closeUpcoming()
 		srv.song = nil
 		srv.elapsed = 0
+		srv.loop = nil
+	}
+	// openOutput opens the output that tr is converted to.
+	openOutput := func(tr *track) error {
+		out, err := output.Get(tr.outRate, tr.outChannels)
+		if err != nil {
+			return fmt.Errorf("mog: could not open audio (%v, %v): %v", tr.outRate, tr.outChannels, err)
+		}
+		if o != nil && o != out {
+			o.Stop()
+		}
+		o = out
+		return nil
+	}
+	fader := dsp.NewFader(srv.volumeGain())
+	// push plays b, converted for tr, through the DSP chain and at the
+	// volume.
+	push := func(b []float32, tr *track) {
+		chain.Process(b, tr.outRate, tr.outChannels)
+		fader.Process(b, tr.outRate, tr.outChannels)
+		o.Push(b)
+	}
+	setCurrent := func(tr *track) {
+		cur = tr
+		srv.PlaylistIndex = tr.index
+		srv.songID = tr.id
+		srv.song = tr.song
+		srv.info = tr.info
+		srv.elapsed = tr.seek.Pos()
+		srv.loop = tr.loop
+	}
+	// openUpcoming opens the song after the current one ahead of time.
+	openUpcoming := func() {
+		if upcoming != nil || noUpcoming || !srv.Gapless && srv.Crossfade == 0 {
+			return
+		}
+		i := nextIndex()
+		if i >= len(srv.Queue) {
+			if !srv.Repeat || len(srv.Queue) == 0 {
+				return
+			}
+			i = 0
+		}
+		tr, err := srv.openTrack(i)
+		if err != nil {
+			printErr(err)
+			noUpcoming = true
+			return
+		}
+		upcoming = tr
 	}
-	var inst protocol.Instance
-	var sid SongID
 	tick = func() {
 		const expected = 4096
-		if false && srv.elapsed > srv.info.Time {
-			log.Println("elapsed time completed", srv.elapsed, srv.info.Time)
-			stop()
-		}
-		if srv.song == nil {
+		if cur == nil {
 			if len(srv.Queue) == 0 {
 				log.Println("empty queue")
 				stop()
@@ -158,53 +231,57 @@ func (srv *Server) audio() {
 					return
 				}
 			}
-
-			srv.songID = srv.Queue[srv.PlaylistIndex]
-			sid = srv.songID
-			inst = srv.Protocols[sid.Protocol][sid.Key]
-			song, err := inst.GetSong(sid.ID)
-			if err != nil {
-				printErr(err)
-				next()
-				return
+			tr, err := srv.openTrack(srv.PlaylistIndex)
+			if err == nil {
+				if err = openOutput(tr); err != nil {
+					tr.close()
+				}
 			}
-			srv.song = song
-			sr, ch, err := srv.song.Init()
 			if err != nil {
-				srv.song.Close()
 				printErr(err)
-				next()
-				return
-			}
-			o, err = output.Get(sr, ch)
-			if err != nil {
-				printErr(fmt.Errorf("mog: could not open audio (%v, %v): %v", sr, ch, err))
-				next()
+				srv.PlaylistIndex = nextIndex()
+				play()
 				return
 			}
-			srv.info = *srv.songs[sid]
-			srv.elapsed = 0
-			dur = time.Second / (time.Duration(sr * ch))
-			seek = NewSeek(srv.info.Time > 0, dur, srv.song.Play)
-			log.Println("playing", srv.info.Title, sr, ch, dur, time.Duration(expected)*dur)
+			setCurrent(tr)
+			log.Println("playing", srv.info.Title, tr.rate, tr.channels, tr.outRate, tr.outChannels)
 			t = make(chan interface{})
 			close(t)
 			srv.state = statePlay
 			broadcast(waitStatus)
 		}
-		next, err := seek.Read(expected)
+		n := expected - expected%cur.outChannels
+		normalize := srv.GainMode != gainOff
+		err := cur.read(n, normalize)
 		if err == nil {
-			srv.elapsed = seek.Pos()
-			if len(next) > 0 {
-				o.Push(next)
+			srv.elapsed = cur.seek.Pos()
+			if cur.loop != srv.loop {
+				srv.loop = cur.loop
+				broadcast(waitStatus)
+			}
+			rem := cur.remaining()
+			if cur.eof || rem >= 0 && rem <= preload+srv.Crossfade {
+				openUpcoming()
+			}
+			out := cur.take(n)
+			if up := upcoming; up != nil && cur.sameFormat(up) {
+				var err error
+				if out, err = fade.join(cur, up, out, n, rem, srv.Crossfade, normalize); err != nil {
+					printErr(err)
+					closeUpcoming()
+				}
+			}
+			if len(out) > 0 {
+				push(out, cur)
 			}
 			select {
 			case <-timer:
 				// Check for updated song info.
-				if info, err := inst.Info(sid.ID); err != nil {
+				if info, err := cur.inst.Info(cur.id.ID); err != nil {
 					broadcastErr(err)
-				} else if srv.info != *info {
+				} else if !reflect.DeepEqual(srv.info, *info) {
 					srv.info = *info
+					cur.info = *info
 					broadcast(waitStatus)
 				}
 				timer = nil
@@ -214,14 +291,31 @@ func (srv *Server) audio() {
 				timer = time.After(time.Second)
 			}
 		}
-		if len(next) < expected || err != nil {
-			log.Println("end of song", len(next), expected, err)
+		if cur.done() || err != nil {
+			log.Println("end of song", err)
 			if err == io.ErrUnexpectedEOF {
 				log.Println("attempting to restart song")
 				n := srv.PlaylistIndex
 				stop()
 				srv.PlaylistIndex = n
 				play()
+			} else if tr := upcoming; tr != nil && err == nil {
+				upcoming = nil
+				closeUpcoming()
+				cur.close()
+				if !tr.sameFormat(cur) {
+					if err := openOutput(tr); err != nil {
+						printErr(err)
+						tr.close()
+						cur = nil
+						srv.PlaylistIndex = nextIndex()
+						play()
+						return
+					}
+				}
+				setCurrent(tr)
+				log.Println("playing", srv.info.Title, tr.rate, tr.channels, tr.outRate, tr.outChannels)
+				broadcast(waitStatus)
 			} else {
 				stop()
 				play()
@@ -273,6 +367,9 @@ func (srv *Server) audio() {
 			return
 		}
 		srv.Queue = n
+		if up := upcoming; up != nil && (up.index >= len(n) || n[up.index] != up.id) {
+			closeUpcoming()
+		}
 		if clear || len(n) == 0 {
 			stop()
 			srv.PlaylistIndex = 0
@@ -337,17 +434,175 @@ func (srv *Server) audio() {
 		c.done <- nil
 	}
 	doSeek := func(c cmdSeek) {
-		if seek == nil {
+		if cur == nil {
 			return
 		}
-		err := seek.Seek(time.Duration(c))
+		closeUpcoming()
+		err := cur.seek.Seek(time.Duration(c))
 		if err != nil {
 			broadcastErr(err)
 		}
+		cur.discard()
+		if l := cur.loop; l != nil && (time.Duration(c) < l.A || time.Duration(c) >= l.B) {
+			cur.loop = nil
+			srv.loop = nil
+		}
 	}
 	setMinDuration := func(c cmdMinDuration) {
 		srv.MinDuration = time.Duration(c)
 	}
+	measuring := false
+	unmeasurable := make(map[SongID]bool)
+	// measureNext starts measuring the loudness of the next song in the
+	// queue that needs it, one song at a time.
+	measureNext := func() {
+		if measuring || srv.GainMode == gainOff {
+			return
+		}
+		for i := range srv.Queue {
+			id := srv.Queue[(srv.PlaylistIndex+i)%len(srv.Queue)]
+			inst := srv.Protocols[id.Protocol][id.Key]
+			if inst == nil || unmeasurable[id] || !srv.needsMeasure(id) {
+				continue
+			}
+			measuring = true
+			go func() {
+				l, err := measure(inst, id)
+				srv.ch <- cmdLoudness{
+					id:       id,
+					loudness: l,
+					err:      err,
+				}
+			}()
+			return
+		}
+	}
+	setLoudness := func(c cmdLoudness) {
+		measuring = false
+		if c.err != nil {
+			log.Println("loudness:", c.id, c.err)
+			unmeasurable[c.id] = true
+		} else {
+			srv.Loudness[c.id] = *c.loudness
+		}
+		measureNext()
+	}
+	setGain := func(c cmdGain) {
+		srv.GainMode = c.mode
+		srv.Preamp = c.preamp
+		for _, tr := range []*track{cur, upcoming} {
+			if tr == nil {
+				continue
+			}
+			if c.mode == gainOff {
+				tr.buf = append(tr.buf, tr.convert(tr.limiter.Flush())...)
+			}
+			tr.gain = srv.songGain(tr.id)
+		}
+		measureNext()
+	}
+	setOutput := func(c cmdOutput) {
+		srv.OutputRate = c.rate
+		srv.OutputChannels = c.channels
+		closeUpcoming()
+		if cur == nil {
+			return
+		}
+		// Play what was converted to the old format first.
+		cur.flush()
+		if len(cur.buf) > 0 {
+			push(cur.buf, cur)
+			cur.buf = nil
+		}
+		cur.setOutput(c.rate, c.channels)
+		if err := openOutput(cur); err != nil {
+			broadcastErr(err)
+			stop()
+		}
+	}
+	setCrossfade := func(c cmdCrossfade) {
+		srv.Crossfade = time.Duration(c)
+		closeUpcoming()
+	}
+	setLoop := func(c cmdLoop) {
+		if cur == nil {
+			broadcastErr(fmt.Errorf("no song to loop"))
+			return
+		}
+		closeUpcoming()
+		if err := cur.setLoop(c.loop); err != nil {
+			broadcastErr(err)
+		}
+		srv.loop = cur.loop
+		srv.elapsed = cur.seek.Pos()
+	}
+	setSilence := func(c cmdSilence) {
+		srv.Silence = Silence(c)
+		closeUpcoming()
+		if cur != nil {
+			cur.setSilence(srv.Silence)
+		}
+	}
+	setSpeed := func(c cmdSpeed) {
+		id := srv.speedID()
+		if id.Protocol == "" {
+			broadcastErr(fmt.Errorf("no song to set the speed of"))
+			return
+		}
+		if c == 1 {
+			delete(srv.Speeds, speedKey(id))
+		} else {
+			srv.Speeds[speedKey(id)] = float64(c)
+		}
+		closeUpcoming()
+		if cur != nil {
+			cur.setSpeed(float64(c))
+		}
+	}
+	// updateVolume ramps to a changed volume, or sets it if not playing.
+	updateVolume := func() {
+		if srv.state == statePlay {
+			fader.Set(srv.volumeGain())
+		} else {
+			fader = dsp.NewFader(srv.volumeGain())
+		}
+	}
+	setVolume := func(c cmdVolume) {
+		if c.db {
+			srv.Volume = math.Min(0, c.v)
+		} else {
+			srv.setVolumePercent(c.v)
+		}
+		updateVolume()
+	}
+	stepVolume := func(c cmdVolumeStep) {
+		srv.setVolumePercent(srv.volumePercent() + float64(c))
+		updateVolume()
+	}
+	setDSP := func(c dsp.Config) {
+		n, err := dsp.NewChain(c)
+		if err != nil {
+			broadcastErr(err)
+			return
+		}
+		srv.DSP = c
+		chain = n
+	}
+	dspPreset := func(c cmdDSPPreset) {
+		switch c.op {
+		case "save":
+			srv.DSPPresets[c.name] = srv.DSP
+		case "load":
+			p, ok := srv.DSPPresets[c.name]
+			if !ok {
+				broadcastErr(fmt.Errorf("unknown preset: %v", c.name))
+				return
+			}
+			setDSP(p)
+		case "delete":
+			delete(srv.DSPPresets, c.name)
+		}
+	}
 	ch := make(chan interface{})
 	go func() {
 		for c := range srv.ch {
@@ -383,8 +638,16 @@ func (srv *Server) audio() {
 					prev()
 				case cmdRandom:
 					srv.Random = !srv.Random
+					closeUpcoming()
 				case cmdRepeat:
 					srv.Repeat = !srv.Repeat
+					closeUpcoming()
+				case cmdGapless:
+					srv.Gapless = !srv.Gapless
+					closeUpcoming()
+				case cmdMute:
+					srv.Muted = !srv.Muted
+					updateVolume()
 				default:
 					panic(c)
 				}
@@ -392,10 +655,12 @@ func (srv *Server) audio() {
 				playIdx(c)
 			case cmdRefresh:
 				refresh(c)
+				measureNext()
 			case cmdProtocolRemove:
 				protocolRemove(c)
 			case cmdQueueChange:
 				queueChange(c)
+				measureNext()
 			case cmdPlaylistChange:
 				playlistChange(c)
 			case cmdNewWS:
@@ -414,6 +679,29 @@ func (srv *Server) audio() {
 				doSeek(c)
 			case cmdMinDuration:
 				setMinDuration(c)
+			case cmdGain:
+				setGain(c)
+			case cmdLoudness:
+				setLoudness(c)
+			case cmdOutput:
+				setOutput(c)
+			case cmdCrossfade:
+				setCrossfade(c)
+			case cmdSpeed:
+				setSpeed(c)
+			case cmdSilence:
+				setSilence(c)
+			case cmdLoop:
+				save = false
+				setLoop(c)
+			case cmdVolume:
+				setVolume(c)
+			case cmdVolumeStep:
+				stepVolume(c)
+			case cmdDSP:
+				setDSP(dsp.Config(c))
+			case cmdDSPPreset:
+				dspPreset(c)
 			default:
 				panic(c)
 			}
@@ -436,6 +724,8 @@ const (
 	cmdRandom
 	cmdRepeat
 	cmdStop
+	cmdGapless
+	cmdMute
 )
 
 type cmdSeek time.Duration
@@ -468,3 +758,44 @@ type cmdAddOAuth struct {
 }
 
 type cmdMinDuration time.Duration
+
+type cmdCrossfade time.Duration
+
+type cmdSpeed float64
+
+type cmdSilence Silence
+
+// cmdLoop sets the A-B loop of the current song, or clears it if loop is
+// nil.
+type cmdLoop struct {
+	loop *Loop
+}
+
+type cmdGain struct {
+	mode   GainMode
+	preamp float64
+}
+
+type cmdLoudness struct {
+	id       SongID
+	loudness *Loudness
+	err      error
+}
+
+type cmdOutput struct {
+	rate, channels int
+}
+
+// cmdVolume sets the volume in percent, or in dB if db is set.
+type cmdVolume struct {
+	v  float64
+	db bool
+}
+
+type cmdVolumeStep float64
+
+type cmdDSP dsp.Config
+
+type cmdDSPPreset struct {
+	op, name string
+}
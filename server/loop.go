@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// loopFade is the length of the crossfade from B back to A.
+const loopFade = 5 * time.Millisecond
+
+// Loop is a region of the current song that is played repeatedly.
+type Loop struct {
+	A, B time.Duration
+	// Count is the number of passes left, including the current one, or 0
+	// to loop until cleared.
+	Count int
+	// a and b are the frames of A and B.
+	a, b int
+}
+
+// setLoop loops the region of l, or clears the loop if l is nil. Playback
+// moves to A if it is outside the region.
+func (t *track) setLoop(l *Loop) error {
+	if l == nil {
+		t.loop = nil
+		return nil
+	}
+	if l.A < 0 || l.B <= l.A || l.B > t.info.Time {
+		return fmt.Errorf("bad loop: %v-%v", l.A, l.B)
+	}
+	c := *l
+	c.a = int(math.Floor(c.A.Seconds()*float64(t.rate) + 0.5))
+	c.b = int(math.Floor(c.B.Seconds()*float64(t.rate) + 0.5))
+	if c.b-c.a <= t.loopFade() {
+		return fmt.Errorf("loop too short: %v-%v", l.A, l.B)
+	}
+	if f := t.seek.Frame(); f < c.a || f >= c.b {
+		if err := t.seek.SeekFrame(c.a, t.rate); err != nil {
+			return err
+		}
+		t.discard()
+	}
+	t.loop = &c
+	return nil
+}
+
+// loopFade returns the number of frames of loopFade.
+func (t *track) loopFade() int {
+	return int(loopFade.Seconds() * float64(t.rate))
+}
+
+// loopLeft returns the number of samples to read before the end of the
+// loop, or -1 if there is no loop ahead.
+func (t *track) loopLeft() int {
+	if t.loop == nil {
+		return -1
+	}
+	n := (t.loop.b - t.seek.Frame()) * t.channels
+	if n < 0 {
+		return -1
+	}
+	return n
+}
+
+// endPass is called when b, read up to B, ends a pass of the loop. It
+// continues playback at A, unless the pass was the last, and keeps the
+// audio after B in seam for joinPass, and returns the samples to play.
+func (t *track) endPass(b []float32) ([]float32, error) {
+	if t.loop.Count == 1 {
+		t.loop = nil
+		return b, nil
+	}
+	tail, err := t.seek.Read(t.loopFade() * t.channels)
+	if err != nil {
+		return nil, err
+	}
+	// Copy since Seek may keep tail.
+	t.seam = append([]float32(nil), tail...)
+	if err := t.seek.SeekFrame(t.loop.a, t.rate); err != nil {
+		return nil, err
+	}
+	if t.loop.Count > 0 {
+		// Replace the loop so that the change is noticed.
+		l := *t.loop
+		l.Count--
+		t.loop = &l
+	}
+	return b, nil
+}
+
+// joinPass is called once the seek to A of endPass is done. It crossfades
+// the audio after B into that after A, and returns the samples to play.
+func (t *track) joinPass() ([]float32, error) {
+	out := t.seam
+	t.seam = nil
+	head, err := t.seek.Read(len(out))
+	if err != nil {
+		return nil, err
+	}
+	// Fade linearly rather than with equal power, since the audio at A and
+	// B is usually alike.
+	frames := len(out) / t.channels
+	for i := range out {
+		if i >= len(head) {
+			break
+		}
+		g := float32(i/t.channels) / float32(frames)
+		out[i] = (1-g)*out[i] + g*head[i]
+	}
+	return out, nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/dsp"
+)
+
+// newTestTrack returns a track of song played at its own format, without
+// gain.
+func newTestTrack(song codec.Song) *track {
+	sr, ch, _ := song.Init()
+	info, _ := song.Info()
+	t := &track{
+		song:     song,
+		info:     info,
+		rate:     sr,
+		channels: ch,
+		gain:     1,
+		speed:    1,
+		limiter:  dsp.NewLimiter(sr, ch, limiterCeiling),
+	}
+	t.seek = NewSeek(true, time.Second/time.Duration(sr*ch), ch, song)
+	t.setOutput(0, 0)
+	return t
+}
+
+// readTrack returns the next n samples of t, or fewer if it ends.
+func readTrack(t *testing.T, tr *track, n int) []float32 {
+	t.Helper()
+	var b []float32
+	for len(b) < n && !tr.done() {
+		if err := tr.read(n-len(b), false); err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, tr.take(n-len(b))...)
+	}
+	return b
+}
+
+// rampFrames appends the samples of a rampSong from frame from to to.
+func rampFrames(b []float32, from, to int) []float32 {
+	for f := from; f < to; f++ {
+		b = append(b, float32(f*2), float32(f*2+1))
+	}
+	return b
+}
+
+// rampSeam appends the crossfade of a rampSong from frame b back to a.
+func rampSeam(out []float32, a, b int) []float32 {
+	const n = 5
+	for i := 0; i < n*2; i++ {
+		g := float32(i/2) / n
+		out = append(out, (1-g)*float32(b*2+i)+g*float32(a*2+i))
+	}
+	return out
+}
+
+func TestLoop(t *testing.T) {
+	tr := newTestTrack(&rampSong{n: 4000})
+	// Frames 1000 to 1500, played three times.
+	if err := tr.setLoop(&Loop{A: time.Second, B: time.Second * 3 / 2, Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if d := tr.remaining(); d != -1 {
+		t.Fatalf("remaining %v while looping", d)
+	}
+	want := rampFrames(nil, 1000, 1500)
+	want = rampSeam(want, 1000, 1500)
+	want = rampFrames(want, 1005, 1500)
+	want = rampSeam(want, 1000, 1500)
+	want = rampFrames(want, 1005, 2000)
+	got := readTrack(t, tr, 1<<20)
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+	if tr.loop != nil {
+		t.Fatal("loop kept after its last pass")
+	}
+}
+
+func TestLoopStepped(t *testing.T) {
+	// Without the buffer, passes seek back to A a step per read, and still
+	// join at the same samples.
+	defer func(n int) { maxSeekBuffer = n }(maxSeekBuffer)
+	maxSeekBuffer = 100
+	song := &steppingRamp{rampSong: rampSong{n: 4000}}
+	tr := newTestTrack(song)
+	if err := tr.setLoop(&Loop{A: time.Second, B: time.Second * 3 / 2, Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := rampFrames(nil, 1000, 1500)
+	want = rampSeam(want, 1000, 1500)
+	want = rampFrames(want, 1005, 2000)
+	got := readTrack(t, tr, 1<<20)
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+	if song.steps == 0 {
+		t.Fatal("pass not sought by steps")
+	}
+}
+
+func TestLoopForever(t *testing.T) {
+	tr := newTestTrack(&rampSong{n: 4000})
+	if err := tr.setLoop(&Loop{A: time.Second, B: time.Second * 3 / 2}); err != nil {
+		t.Fatal(err)
+	}
+	want := rampFrames(nil, 1000, 1500)
+	for i := 0; i < 10; i++ {
+		want = rampSeam(want, 1000, 1500)
+		want = rampFrames(want, 1005, 1500)
+	}
+	got := readTrack(t, tr, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+	if tr.loop == nil || tr.loop.Count != 0 {
+		t.Fatalf("loop %+v, want one without a count", tr.loop)
+	}
+
+	// Clearing the loop plays on to the end.
+	tr.setLoop(nil)
+	got = readTrack(t, tr, 1<<20)
+	if n := len(got); n == 0 || got[n-1] != 3999 {
+		t.Fatal("song not played to its end after clearing the loop")
+	}
+}
+
+func TestLoopBad(t *testing.T) {
+	tr := newTestTrack(&rampSong{n: 4000})
+	for _, l := range []Loop{
+		{A: -time.Second, B: time.Second},
+		{A: time.Second, B: time.Second},
+		{A: time.Second, B: 3 * time.Second},
+		// Loops must be longer than their crossfade.
+		{A: time.Second, B: time.Second + loopFade},
+	} {
+		if err := tr.setLoop(&l); err == nil {
+			t.Errorf("%v-%v: expected an error", l.A, l.B)
+		}
+	}
+	if tr.loop != nil {
+		t.Fatal("bad loop set")
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mjibson/mog/dsp"
+	"github.com/mjibson/mog/protocol"
+)
+
+// GainMode selects how songs are normalized to the same loudness.
+type GainMode string
+
+const (
+	gainOff   GainMode = "off"
+	gainTrack GainMode = "track"
+	gainAlbum GainMode = "album"
+)
+
+func parseGainMode(s string) (GainMode, error) {
+	switch m := GainMode(s); m {
+	case gainOff, gainTrack, gainAlbum:
+		return m, nil
+	}
+	return "", fmt.Errorf("bad gain mode: %v", s)
+}
+
+// Loudness is the measured loudness of a song.
+type Loudness struct {
+	// Integrated is the EBU R128 integrated loudness in LUFS, or -Inf if
+	// the song is silent.
+	Integrated float64
+	// Peak is the true peak as a linear amplitude.
+	Peak float64
+	// Time is the length of the measured audio.
+	Time time.Duration
+}
+
+const (
+	// maxMeasure limits the audio measured of a song.
+	maxMeasure = time.Minute * 20
+	// limiterCeiling is the true peak in dBTP that gain may not push audio
+	// above.
+	limiterCeiling = -1
+	// maxPreamp bounds the preamp in dB.
+	maxPreamp = 15
+)
+
+// measure decodes song id of inst and returns its loudness.
+func measure(inst protocol.Instance, id SongID) (*Loudness, error) {
+	song, err := inst.GetSong(id.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer song.Close()
+	sr, ch, err := song.Init()
+	if err != nil {
+		return nil, err
+	}
+	expected := 4096 - 4096%ch
+	m := dsp.NewMeter(sr, ch)
+	max := int64(maxMeasure/time.Second) * int64(sr*ch)
+	var n int64
+	for n < max {
+		b, err := song.Play(expected)
+		if err != nil {
+			return nil, err
+		}
+		m.Write(b)
+		n += int64(len(b))
+		if len(b) < expected {
+			break
+		}
+	}
+	return &Loudness{
+		Integrated: m.Integrated(),
+		Peak:       m.TruePeak(),
+		Time:       time.Duration(n/int64(ch)) * time.Second / time.Duration(sr),
+	}, nil
+}
+
+// needsMeasure returns whether id has no ReplayGain tags and has not been
+// measured. Songs of unknown length, like streams, are never measured.
+func (srv *Server) needsMeasure(id SongID) bool {
+	info := srv.songs[id]
+	if info == nil || info.Time == 0 || info.TrackGain != nil {
+		return false
+	}
+	_, ok := srv.Loudness[id]
+	return !ok
+}
+
+// albumLoudness returns the loudness in LUFS of the measured songs of the
+// album of id, weighted by length, and their largest true peak.
+func (srv *Server) albumLoudness(id SongID) (lufs, peak float64, ok bool) {
+	info := srv.songs[id]
+	if info == nil || info.Album == "" {
+		return 0, 0, false
+	}
+	var sum, total float64
+	for sid, l := range srv.Loudness {
+		if sid.Protocol != id.Protocol || sid.Key != id.Key {
+			continue
+		}
+		if si := srv.songs[sid]; si == nil || si.Album != info.Album {
+			continue
+		}
+		t := l.Time.Seconds()
+		total += t
+		peak = math.Max(peak, l.Peak)
+		if !math.IsInf(l.Integrated, -1) {
+			sum += t * math.Pow(10, l.Integrated/10)
+		}
+	}
+	if sum == 0 {
+		return 0, 0, false
+	}
+	return 10 * math.Log10(sum/total), peak, true
+}
+
+// songGain returns the linear gain to play id with under the current mode.
+// Songs without tags use their measured loudness, or play unchanged until
+// measured.
+func (srv *Server) songGain(id SongID) float64 {
+	if srv.GainMode == gainOff {
+		return 1
+	}
+	info := srv.songs[id]
+	if info == nil {
+		return 1
+	}
+	db := srv.Preamp
+	if srv.GainMode == gainAlbum {
+		if g := info.AlbumGain; g != nil {
+			return peakGain(db+g.DB, g.Peak)
+		}
+		if lufs, peak, ok := srv.albumLoudness(id); ok && info.TrackGain == nil {
+			return peakGain(db+dsp.ReferenceLoudness-lufs, peak)
+		}
+	}
+	if g := info.TrackGain; g != nil {
+		return peakGain(db+g.DB, g.Peak)
+	}
+	if l, ok := srv.Loudness[id]; ok && !math.IsInf(l.Integrated, -1) {
+		return peakGain(db+dsp.ReferenceLoudness-l.Integrated, l.Peak)
+	}
+	return dsp.Linear(db)
+}
+
+// peakGain returns the linear gain of db, lowered so that audio with the
+// given peak stays under the limiter ceiling, which the limiter would
+// otherwise have to hold down throughout. A peak of 0 is unknown.
+func peakGain(db, peak float64) float64 {
+	g := dsp.Linear(db)
+	if peak > 0 {
+		g = math.Min(g, dsp.Linear(limiterCeiling)/peak)
+	}
+	return g
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/dsp"
+)
+
+func TestSongGain(t *testing.T) {
+	id := func(s string) SongID { return SongID{"file", "k", s} }
+	srv := &Server{
+		GainMode: gainTrack,
+		Preamp:   2,
+		songs: map[SongID]*codec.SongInfo{
+			id("tagged"): {
+				Album:     "A",
+				TrackGain: &codec.Gain{DB: -4},
+				AlbumGain: &codec.Gain{DB: -6, Peak: 0.5},
+			},
+			id("peak"):     {Album: "A", TrackGain: &codec.Gain{DB: 10, Peak: 0.8}},
+			id("measured"): {Album: "B"},
+			id("loud"):     {Album: "B"},
+			id("new"):      {Album: "B"},
+		},
+		Loudness: map[SongID]Loudness{
+			id("measured"): {Integrated: -20, Peak: 0.1, Time: time.Minute},
+			id("loud"):     {Integrated: -10, Peak: 1.5, Time: time.Minute},
+		},
+	}
+	ceiling := dsp.Linear(limiterCeiling)
+	for _, c := range []struct {
+		mode GainMode
+		id   string
+		want float64
+	}{
+		{gainTrack, "tagged", dsp.Linear(-2)},
+		// Tagged peaks keep the gain below the limiter ceiling.
+		{gainTrack, "peak", ceiling / 0.8},
+		{gainAlbum, "tagged", dsp.Linear(-4)},
+		{gainAlbum, "peak", ceiling / 0.8},
+		{gainTrack, "measured", dsp.Linear(2 + 2)},
+		{gainTrack, "loud", dsp.Linear(2 - 8)},
+		// Album loudness takes the largest peak of the album, which holds
+		// the gain of 2-18+12.6 dB down.
+		{gainAlbum, "measured", ceiling / 1.5},
+		{gainAlbum, "new", ceiling / 1.5},
+		{gainTrack, "new", dsp.Linear(2)},
+		{gainOff, "tagged", 1},
+	} {
+		srv.GainMode = c.mode
+		if got := srv.songGain(id(c.id)); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s %s: gain %v, want %v", c.mode, c.id, got, c.want)
+		}
+	}
+}
@@ -3,35 +3,88 @@ package server
 import (
 	"errors"
 	"time"
+
+	"github.com/mjibson/mog/codec"
 )
 
+// maxSeekBuffer is the maximum number of decoded samples kept to support
+// seeking in songs that can't seek natively, or only by running forward. About three minutes of 44.1kHz
+// stereo audio.
+var maxSeekBuffer = 1 << 24
+
 type Seek struct {
-	b   []float32
+	song codec.Song
+	// seeker is set if song can seek natively, and stepper instead if it
+	// seeks by running forward.
+	seeker  codec.Seeker
+	stepper codec.StepSeeker
+	// stepping is set while stepper seeks to offset.
+	stepping bool
+	offset   time.Duration
+	// b holds all decoded samples while buffering. It is dropped when it
+	// grows past maxSeekBuffer.
+	b []float32
+	// end is set once b holds the end of the song, after which it is not
+	// played further.
+	end bool
 	pos int
-	sr  time.Duration
-	f   func(int) ([]float32, error)
+	// target is the position a seek decodes forward to, if past pos.
+	target   int
+	canSeek  bool
+	sr       time.Duration
+	channels int
 }
 
-func NewSeek(canSeek bool, sr time.Duration, f func(int) ([]float32, error)) *Seek {
+func NewSeek(canSeek bool, sr time.Duration, channels int, song codec.Song) *Seek {
 	s := Seek{
-		f:  f,
-		sr: sr,
+		song:     song,
+		sr:       sr,
+		channels: channels,
+		canSeek:  canSeek,
 	}
-	if canSeek {
-		s.b = make([]float32, 4096)
+	switch sk := song.(type) {
+	case codec.StepSeeker:
+		// Songs that seek by running forward are buffered too, so that
+		// seeking back, as loops do, is immediate while the buffer lasts.
+		if canSeek {
+			s.stepper = sk
+			s.b = make([]float32, 0, 4096)
+		}
+	case codec.Seeker:
+		if canSeek {
+			s.seeker = sk
+		}
+	default:
+		if canSeek {
+			s.b = make([]float32, 0, 4096)
+		}
 	}
 	return &s
 }
 
+// Read reads up to n samples, rounded down to whole sample frames.
 func (s *Seek) Read(n int) (b []float32, err error) {
+	n -= n % s.channels
+	for s.Seeking() {
+		if err := s.Skip(); err != nil {
+			return nil, err
+		}
+	}
+	return s.read(n)
+}
+
+func (s *Seek) read(n int) (b []float32, err error) {
 	if s.b == nil {
-		b, err = s.f(n)
+		b, err = s.song.Play(n)
 		s.pos += len(b)
 		return
 	}
-	for len(s.b)-s.pos < n {
-		b, err = s.f(n)
+	for !s.end && len(s.b)-s.pos < n {
+		b, err = s.song.Play(n)
 		s.b = append(s.b, b...)
+		if len(b) < n {
+			s.end = true
+		}
 		if err != nil || len(b) == 0 {
 			break
 		}
@@ -42,6 +95,11 @@ func (s *Seek) Read(n int) (b []float32, err error) {
 	}
 	b = s.b[s.pos:tot]
 	s.pos = tot
+	if len(s.b) > maxSeekBuffer {
+		// Copy out b since the buffer it refers to is about to be dropped.
+		b = append([]float32(nil), b...)
+		s.b = nil
+	}
 	return
 }
 
@@ -50,22 +108,109 @@ var errSeekable = errors.New("cannot seek this file")
 // Seek sets the offset for the next Read to offset, relative to the origin
 // of the file.
 func (s *Seek) Seek(offset time.Duration) error {
-	if s.b == nil {
+	if !s.canSeek {
 		return errSeekable
 	}
 	pos := int(offset / s.sr)
-	if pos < len(s.b) {
+	pos -= pos % s.channels
+	return s.seek(pos, offset)
+}
+
+// SeekFrame sets the offset for the next Read to frame of a song with
+// sample rate rate. Unlike Seek, it is exact.
+func (s *Seek) SeekFrame(frame, rate int) error {
+	if !s.canSeek {
+		return errSeekable
+	}
+	// Round up since native seekers round down.
+	offset := (time.Duration(frame)*time.Second + time.Duration(rate) - 1) / time.Duration(rate)
+	return s.seek(frame*s.channels, offset)
+}
+
+// seek sets the offset for the next Read to sample pos, at time offset.
+func (s *Seek) seek(pos int, offset time.Duration) error {
+	if s.seeker != nil {
+		if err := s.seeker.Seek(offset); err != nil {
+			return err
+		}
 		s.pos = pos
+		s.target = pos
 		return nil
 	}
-	_, err := s.Read(pos - len(s.b))
+	s.target = pos
+	s.stepping = false
+	if s.b != nil {
+		if pos < len(s.b) {
+			s.pos = pos
+			return nil
+		}
+		s.pos = len(s.b)
+	} else if s.stepper != nil {
+		// Seeking is left to Skip, a step at a time.
+		s.stepping = true
+		s.offset = offset
+		return nil
+	} else if pos < s.pos {
+		// The buffer was dropped, so restart the song and decode forward.
+		s.song.Close()
+		if _, _, err := s.song.Init(); err != nil {
+			return err
+		}
+		s.pos = 0
+	}
+	// Decoding forward is left to Skip and Read.
+	return nil
+}
+
+// Seeking returns whether the song must be decoded forward to the offset of
+// the last seek.
+func (s *Seek) Seeking() bool {
+	return s.stepping || s.target > s.pos
+}
+
+// Skip decodes a step toward the offset of the last seek, so that long
+// seeks can be done a step at a time.
+func (s *Seek) Skip() error {
+	if s.stepping {
+		done, err := s.stepper.SeekStep(s.offset)
+		if err != nil {
+			return err
+		}
+		if done {
+			s.stepping = false
+			s.pos = s.target
+		}
+		return nil
+	}
+	n := s.target - s.pos
+	if max := 4096 * 16; n > max {
+		n = max - max%s.channels
+	}
+	b, err := s.read(n)
 	if err != nil {
 		return err
 	}
-	s.pos = pos
+	if len(b) < n {
+		// The song ended first.
+		s.target = s.pos
+	}
 	return nil
 }
 
+// Pos returns the offset of the next Read.
 func (s *Seek) Pos() time.Duration {
-	return s.sr * time.Duration(s.pos)
+	return s.sr * time.Duration(s.position())
+}
+
+// Frame returns the frame of the next Read.
+func (s *Seek) Frame() int {
+	return s.position() / s.channels
+}
+
+// position returns the sample of the next Read.
+func (s *Seek) position() int {
+	if s.Seeking() {
+		return s.target
+	}
+	return s.pos
 }
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+// rampSong is a stereo song whose samples count up from 0, so that the
+// position of any sample is known.
+type rampSong struct {
+	n, pos int
+	inits  int
+}
+
+func (r *rampSong) Init() (int, int, error) {
+	r.pos = 0
+	r.inits++
+	return 1000, 2, nil
+}
+
+func (r *rampSong) Play(n int) ([]float32, error) {
+	if n > r.n-r.pos {
+		n = r.n - r.pos
+	}
+	b := make([]float32, n)
+	for i := range b {
+		b[i] = float32(r.pos + i)
+	}
+	r.pos += n
+	return b, nil
+}
+
+func (r *rampSong) Close() {}
+
+func (r *rampSong) Info() (codec.SongInfo, error) {
+	return codec.SongInfo{Time: time.Duration(r.n/2) * time.Second / 1000}, nil
+}
+
+// seekingRamp is a rampSong that can seek natively.
+type seekingRamp struct {
+	rampSong
+}
+
+func (r *seekingRamp) Seek(offset time.Duration) error {
+	r.pos = int(offset*1000/time.Second) * 2
+	return nil
+}
+
+// steppingRamp is a rampSong that seeks by running forward a second per
+// step.
+type steppingRamp struct {
+	rampSong
+	steps int
+}
+
+func (r *steppingRamp) Seek(offset time.Duration) error {
+	return codec.SeekSteps(r, offset)
+}
+
+func (r *steppingRamp) SeekStep(offset time.Duration) (bool, error) {
+	r.steps++
+	target := int(offset*1000/time.Second) * 2
+	if target < r.pos {
+		r.pos = 0
+	}
+	r.pos += 2000
+	if r.pos >= target {
+		r.pos = target
+		return true, nil
+	}
+	return false, nil
+}
+
+func newRampSeek(song codec.Song) *Seek {
+	song.Init()
+	return NewSeek(true, time.Second/2000, 2, song)
+}
+
+func checkRamp(t *testing.T, b []float32, from, n int) {
+	t.Helper()
+	if len(b) != n {
+		t.Fatalf("got %d samples, want %d", len(b), n)
+	}
+	for i, v := range b {
+		if int(v) != from+i {
+			t.Fatalf("sample %d is %v, want %d", i, v, from+i)
+		}
+	}
+}
+
+func TestSeekBuffered(t *testing.T) {
+	s := newRampSeek(&rampSong{n: 20000})
+	b, _ := s.Read(1000)
+	checkRamp(t, b, 0, 1000)
+	if err := s.Seek(time.Second * 3); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = s.Read(10)
+	checkRamp(t, b, 6000, 10)
+	if err := s.Seek(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if s.Seeking() {
+		t.Fatal("seek within the buffer should not decode")
+	}
+	b, _ = s.Read(10)
+	checkRamp(t, b, 2000, 10)
+}
+
+func TestSeekDropped(t *testing.T) {
+	defer func(n int) { maxSeekBuffer = n }(maxSeekBuffer)
+	maxSeekBuffer = 1000
+	song := &rampSong{n: 1 << 20}
+	s := newRampSeek(song)
+	for i := 0; i < 10; i++ {
+		s.Read(4096)
+	}
+	if s.b != nil {
+		t.Fatal("buffer not dropped")
+	}
+	// Seeking is decoded forward a step at a time.
+	if err := s.Seek(time.Second * 100); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Pos(); got != time.Second*100 {
+		t.Fatalf("position %v while seeking", got)
+	}
+	steps := 0
+	for s.Seeking() {
+		if err := s.Skip(); err != nil {
+			t.Fatal(err)
+		}
+		steps++
+	}
+	if steps < 2 {
+		t.Fatalf("seek done in %d steps", steps)
+	}
+	b, _ := s.Read(10)
+	checkRamp(t, b, 200000, 10)
+	// Seeking backward restarts the song, and Read finishes the seek
+	// itself.
+	if err := s.Seek(time.Second * 10); err != nil {
+		t.Fatal(err)
+	}
+	if song.inits != 2 {
+		t.Fatalf("song initialized %d times, want 2", song.inits)
+	}
+	if !s.Seeking() {
+		t.Fatal("backward seek decoded at once")
+	}
+	b, _ = s.Read(10)
+	checkRamp(t, b, 20000, 10)
+}
+
+func TestSeekFrame(t *testing.T) {
+	song := &seekingRamp{rampSong{n: 1 << 20}}
+	s := newRampSeek(song)
+	for _, frame := range []int{0, 1, 333, 44099} {
+		if err := s.SeekFrame(frame, 1000); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Frame(); got != frame {
+			t.Fatalf("frame %d, want %d", got, frame)
+		}
+		b, _ := s.Read(4)
+		checkRamp(t, b, frame*2, 4)
+	}
+}
+
+func TestSeekEnd(t *testing.T) {
+	song := &rampSong{n: 100}
+	s := newRampSeek(song)
+	b, _ := s.Read(4096)
+	checkRamp(t, b, 0, 100)
+	s.Seek(0)
+	s.Read(4096)
+	if song.pos != 100 || song.inits != 1 {
+		t.Fatal("song played past its end")
+	}
+}
+
+func TestSeekWholeFrames(t *testing.T) {
+	defer func(n int) { maxSeekBuffer = n }(maxSeekBuffer)
+	maxSeekBuffer = 1000
+	for _, song := range []codec.Song{&rampSong{n: 1 << 20}, &seekingRamp{rampSong{n: 1 << 20}}} {
+		s := newRampSeek(song)
+		b, _ := s.Read(4095)
+		checkRamp(t, b, 0, 4094)
+		s.Seek(time.Second)
+		b, _ = s.Read(3)
+		checkRamp(t, b, 2000, 2)
+	}
+}
+
+func TestSeekStepped(t *testing.T) {
+	defer func(n int) { maxSeekBuffer = n }(maxSeekBuffer)
+	maxSeekBuffer = 10000
+	song := &steppingRamp{rampSong: rampSong{n: 1 << 20}}
+	s := newRampSeek(song)
+	s.Read(4000)
+	// Seeking back is done in the buffer.
+	if err := s.Seek(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if s.Seeking() || song.steps != 0 {
+		t.Fatal("seek within the buffer should not step")
+	}
+	b, _ := s.Read(10)
+	checkRamp(t, b, 2000, 10)
+	for s.b != nil {
+		s.Read(4096)
+	}
+	// Once the buffer is dropped, seeks step a second at a time.
+	if err := s.Seek(time.Second * 100); err != nil {
+		t.Fatal(err)
+	}
+	if song.steps != 0 {
+		t.Fatal("seek stepped before Skip")
+	}
+	if got := s.Pos(); got != time.Second*100 {
+		t.Fatalf("position %v while seeking", got)
+	}
+	for s.Seeking() {
+		if err := s.Skip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if song.steps < 90 {
+		t.Fatalf("seek done in %d steps", song.steps)
+	}
+	b, _ = s.Read(10)
+	checkRamp(t, b, 200000, 10)
+	// Read finishes a seek itself, backward too.
+	if err := s.Seek(time.Second * 3); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = s.Read(10)
+	checkRamp(t, b, 6000, 10)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/mjibson/mog/_third_party/github.com/boltdb/bolt"
 	"github.com/mjibson/mog/_third_party/github.com/pkg/browser"
 	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/dsp"
 	"github.com/mjibson/mog/protocol"
 )
 
@@ -120,6 +121,33 @@ type Server struct {
 	Random      bool
 	Protocols   map[string]map[string]protocol.Instance
 	MinDuration time.Duration
+	// GainMode selects ReplayGain normalization, and Preamp is added to the
+	// gain in dB.
+	GainMode GainMode
+	Preamp   float64
+	// Loudness holds the measured loudness of songs without ReplayGain
+	// tags.
+	Loudness map[SongID]Loudness
+	// OutputRate and OutputChannels configure the audio output, to which
+	// songs are resampled and mixed. Zero plays songs as they are.
+	OutputRate     int
+	OutputChannels int
+	// Gapless plays songs back to back without a gap, and Crossfade fades
+	// between songs over its duration, except between tracks of an album.
+	Gapless   bool
+	Crossfade time.Duration
+	// DSP is the sound shaping chain, and DSPPresets holds named chains.
+	DSP        dsp.Config
+	DSPPresets map[string]dsp.Config
+	// Volume is the playback level in dB, at most 0. Muted silences
+	// playback without changing it.
+	Volume float64
+	Muted  bool
+	// Speeds holds the playback speed of each protocol instance, keyed by
+	// speedKey, if not 1.
+	Speeds map[string]float64
+	// Silence configures the skipping of silence in songs.
+	Silence Silence
 
 	// Current song data.
 	PlaylistIndex int
@@ -127,6 +155,7 @@ type Server struct {
 	song          codec.Song
 	info          codec.SongInfo
 	elapsed       time.Duration
+	loop          *Loop
 
 	ch          chan interface{}
 	state       State
@@ -135,6 +164,46 @@ type Server struct {
 	savePending bool
 }
 
+// volumeGain returns the linear gain of the volume.
+func (srv *Server) volumeGain() float64 {
+	if srv.Muted {
+		return 0
+	}
+	return dsp.Linear(srv.Volume)
+}
+
+// speedKey returns the key in Speeds of the instance of id.
+func speedKey(id SongID) string {
+	return id.Protocol + "/" + id.Key
+}
+
+// speed returns the playback speed of the instance of id.
+func (srv *Server) speed(id SongID) float64 {
+	if s, ok := srv.Speeds[speedKey(id)]; ok {
+		return s
+	}
+	return 1
+}
+
+// speedID returns the song whose speed is reported and set: the current
+// one, or the one to play next if stopped.
+func (srv *Server) speedID() SongID {
+	if srv.song == nil && srv.PlaylistIndex >= 0 && srv.PlaylistIndex < len(srv.Queue) {
+		return srv.Queue[srv.PlaylistIndex]
+	}
+	return srv.songID
+}
+
+// volumePercent returns the volume from 0 to 100.
+func (srv *Server) volumePercent() float64 {
+	return dsp.VolumeDB(srv.Volume) * 100
+}
+
+// setVolumePercent sets the volume from p, from 0 to 100.
+func (srv *Server) setVolumePercent(p float64) {
+	srv.Volume = math.Min(0, dsp.DB(dsp.VolumeGain(p/100)))
+}
+
 type PlaylistInfo []listItem
 
 func (srv *Server) playlistInfo(p Playlist) PlaylistInfo {
@@ -157,6 +226,10 @@ func New(stateFile string) (*Server, error) {
 		Protocols:   make(map[string]map[string]protocol.Instance),
 		Playlists:   make(map[string]Playlist),
 		MinDuration: time.Second * 30,
+		GainMode:    gainOff,
+		Loudness:    make(map[SongID]Loudness),
+		DSPPresets:  make(map[string]dsp.Config),
+		Speeds:      make(map[string]float64),
 	}
 	for name := range protocol.Get() {
 		srv.Protocols[name] = make(map[string]protocol.Instance)
@@ -353,4 +426,23 @@ type Status struct {
 	Time   time.Duration
 	Random bool
 	Repeat bool
+	// Loudness normalization mode and preamp in dB.
+	GainMode GainMode
+	Preamp   float64
+	// Output sample rate and channels, or 0 to follow the song.
+	OutputRate     int
+	OutputChannels int
+	Gapless        bool
+	Crossfade      time.Duration
+	// DSP chain and the names of the saved presets.
+	DSP        dsp.Config
+	DSPPresets []string
+	// Volume from 0 to 100 on a perceptual curve.
+	Volume float64
+	Muted  bool
+	// Playback speed of the current song's protocol instance.
+	Speed float64
+	// A-B loop of the current song, if any.
+	Loop    *Loop
+	Silence Silence
 }
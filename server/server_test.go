@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"math"
 
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"testing"
 	"time"
 
@@ -17,6 +19,9 @@ import (
 )
 
 func TestServer(t *testing.T) {
+	if _, err := os.Stat("../m"); err != nil {
+		t.Skip("no music in ../m")
+	}
 	srv, err := New("")
 	if err != nil {
 		t.Fatal(err)
@@ -68,7 +73,9 @@ func TestServer(t *testing.T) {
 		},
 	}
 	resp = fetch("/api/playlist/change", v)
-	var pc PlaylistChange
+	var pc struct {
+		Errors []string
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&pc); err != nil {
 		t.Fatal(err)
 	}
@@ -90,3 +97,51 @@ func TestServer(t *testing.T) {
 	resp = fetch("/api/cmd/prev", nil)
 	time.Sleep(time.Second)
 }
+
+func TestSpeed(t *testing.T) {
+	srv := &Server{Speeds: map[string]float64{
+		speedKey(SongID{"file", "podcasts", "1"}): 1.5,
+	}}
+	// Speeds carry over to other songs of the same protocol instance.
+	for _, c := range []struct {
+		id   SongID
+		want float64
+	}{
+		{SongID{"file", "podcasts", "1"}, 1.5},
+		{SongID{"file", "podcasts", "2"}, 1.5},
+		{SongID{"file", "music", "1"}, 1},
+		{SongID{"drive", "podcasts", "1"}, 1},
+	} {
+		if got := srv.speed(c.id); got != c.want {
+			t.Errorf("%v: speed %v, want %v", c.id, got, c.want)
+		}
+	}
+}
+
+func TestVolume(t *testing.T) {
+	srv := &Server{}
+	for _, p := range []float64{0, 10, 50, 100} {
+		srv.setVolumePercent(p)
+		if got := srv.volumePercent(); math.Abs(got-p) > 1e-9 {
+			t.Errorf("volume %v%% round trips to %v%%", p, got)
+		}
+	}
+	srv.setVolumePercent(150)
+	if srv.Volume != 0 {
+		t.Errorf("volume %vdB above 100%%", srv.Volume)
+	}
+	srv.setVolumePercent(50)
+	want := srv.volumeGain()
+	if math.Abs(want-0.125) > 1e-9 {
+		t.Errorf("gain %v at 50%%, want 0.125", want)
+	}
+	// Muting silences playback and keeps the volume.
+	srv.Muted = true
+	if g := srv.volumeGain(); g != 0 {
+		t.Errorf("gain %v while muted", g)
+	}
+	srv.Muted = false
+	if g := srv.volumeGain(); g != want {
+		t.Errorf("gain %v after unmuting, want %v", g, want)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mjibson/mog/dsp"
+)
+
+const (
+	// defaultSilenceThreshold is the level in dBFS below which audio is
+	// silent if not configured.
+	defaultSilenceThreshold = -60
+	// maxSkip is the most silence skipped at once before a song is ended,
+	// so that songs of unknown length that fall silent still stop.
+	maxSkip = 10 * time.Minute
+)
+
+// Silence configures the skipping of silence in songs.
+type Silence struct {
+	// Threshold is the level in dBFS below which audio is silent, or 0 for
+	// -60.
+	Threshold float64
+	// Duration is how long silence plays before the song is ended or, with
+	// Gaps, the rest of the silence is skipped. Zero disables detection.
+	Duration time.Duration
+	// Gaps collapses silence within songs to Duration instead of ending
+	// them.
+	Gaps bool
+}
+
+// trimmer drops leading silence of a song, and silence past the configured
+// duration.
+type trimmer struct {
+	threshold float32
+	gaps      bool
+	channels  int
+	// min and max are the frames of silence to play and to skip at most.
+	min, max int
+	// started is set once sound has been heard. run counts the frames of
+	// the current silence, and skipped those of it dropped.
+	started      bool
+	run, skipped int
+}
+
+// setSilence configures the silence detection of t. Leading silence is
+// skipped only if t has not been played yet.
+func (t *track) setSilence(s Silence) {
+	t.trim = nil
+	if s.Duration <= 0 {
+		return
+	}
+	th := s.Threshold
+	if th == 0 {
+		th = defaultSilenceThreshold
+	}
+	t.trim = &trimmer{
+		threshold: float32(dsp.Linear(th)),
+		gaps:      s.Gaps,
+		channels:  t.channels,
+		min:       int(s.Duration.Seconds() * float64(t.rate)),
+		max:       int(maxSkip.Seconds() * float64(t.rate)),
+		started:   t.seek.Frame() > 0,
+	}
+}
+
+// process returns the samples of b to play. end is set if the song should
+// end after them.
+func (m *trimmer) process(b []float32) (out []float32, end bool) {
+	out = b[:0:0]
+	for i := 0; i+m.channels <= len(b); i += m.channels {
+		f := b[i : i+m.channels]
+		silent := true
+		for _, v := range f {
+			if v > m.threshold || v < -m.threshold {
+				silent = false
+				break
+			}
+		}
+		switch {
+		case !silent:
+			m.started = true
+			m.run, m.skipped = 0, 0
+		case m.started && m.run < m.min:
+			m.run++
+		case m.skipped >= m.max || m.started && !m.gaps:
+			return out, true
+		default:
+			m.skipped++
+			continue
+		}
+		out = append(out, f...)
+	}
+	return out, false
+}
+
+// reset restarts the count of silence, after a seek.
+func (m *trimmer) reset() {
+	m.run, m.skipped = 0, 0
+}
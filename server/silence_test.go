@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+)
+
+// sliceSong is a mono song at 1kHz of the samples of b.
+type sliceSong struct {
+	b   []float32
+	pos int
+	// time is the length reported, if the song reports it.
+	time bool
+}
+
+func (s *sliceSong) Init() (int, int, error) {
+	s.pos = 0
+	return 1000, 1, nil
+}
+
+func (s *sliceSong) Play(n int) ([]float32, error) {
+	if n > len(s.b)-s.pos {
+		n = len(s.b) - s.pos
+	}
+	b := s.b[s.pos : s.pos+n]
+	s.pos += n
+	return b, nil
+}
+
+func (s *sliceSong) Close() {}
+
+func (s *sliceSong) Info() (codec.SongInfo, error) {
+	var info codec.SongInfo
+	if s.time {
+		info.Time = time.Duration(len(s.b)) * time.Millisecond
+	}
+	return info, nil
+}
+
+// segments returns the samples of a mono song at 1kHz with alternating
+// seconds of silence and sound, starting with silence.
+func segments(secs ...int) []float32 {
+	var b []float32
+	for i, n := range secs {
+		var v float32
+		if i%2 == 1 {
+			v = 0.5
+		}
+		for j := 0; j < n*1000; j++ {
+			b = append(b, v)
+		}
+	}
+	return b
+}
+
+func TestSilence(t *testing.T) {
+	song := segments(2, 1, 10, 1, 30)
+	for _, c := range []struct {
+		silence Silence
+		time    bool
+		want    []float32
+	}{
+		// Leading silence is skipped, and trailing silence ends the song.
+		{Silence{Duration: 5 * time.Second}, true, segments(0, 1, 5)},
+		// Songs of unknown length stop too.
+		{Silence{Duration: 5 * time.Second}, false, segments(0, 1, 5)},
+		{Silence{Duration: 2 * time.Second}, true, segments(0, 1, 2)},
+		{Silence{Duration: 5 * time.Second, Gaps: true}, true, segments(0, 1, 5, 1, 5)},
+		// Sound is what is above the threshold.
+		{Silence{Duration: 5 * time.Second, Threshold: -3}, true, nil},
+		// Zero disables detection.
+		{Silence{}, true, song},
+	} {
+		tr := newTestTrack(&sliceSong{b: song, time: c.time})
+		tr.setSilence(c.silence)
+		got := readTrack(t, tr, 1<<20)
+		if len(got) != len(c.want) {
+			t.Fatalf("%+v: got %d samples, want %d", c.silence, len(got), len(c.want))
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%+v: sample %d is %v, want %v", c.silence, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestSilenceSeek(t *testing.T) {
+	// Silence is counted again after a seek, and leading silence is not
+	// skipped once the song has been played.
+	tr := newTestTrack(&sliceSong{b: segments(2, 1, 10), time: true})
+	tr.setSilence(Silence{Duration: 5 * time.Second})
+	readTrack(t, tr, 1000)
+	if err := tr.seek.Seek(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	tr.discard()
+	if got := readTrack(t, tr, 1<<20); len(got) != 5000 {
+		t.Fatalf("got %d samples after seeking, want 5000", len(got))
+	}
+}
+
+func TestSilenceSteps(t *testing.T) {
+	// Long silence is skipped over several reads, so that one does not
+	// decode for long.
+	tr := newTestTrack(&sliceSong{b: segments(600, 1)})
+	tr.setSilence(Silence{Duration: 5 * time.Second})
+	reads := 0
+	for !tr.done() && len(tr.buf) == 0 {
+		if err := tr.read(4096, false); err != nil {
+			t.Fatal(err)
+		}
+		reads++
+	}
+	if reads < 2 {
+		t.Fatalf("silence skipped in %d reads", reads)
+	}
+	if got := readTrack(t, tr, 1<<20); len(got) != 1000 {
+		t.Fatalf("got %d samples, want 1000", len(got))
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/mjibson/mog/codec"
+	"github.com/mjibson/mog/dsp"
+	"github.com/mjibson/mog/protocol"
+)
+
+// preload is how long before the end of a song the next one is opened.
+const preload = 10 * time.Second
+
+// track is an open song and the processing that converts it to the output
+// format.
+type track struct {
+	id    SongID
+	index int
+	inst  protocol.Instance
+	song  codec.Song
+	info  codec.SongInfo
+	seek  *Seek
+	// rate and channels are those of the song, and outRate and outChannels
+	// those it is converted to.
+	rate, channels       int
+	outRate, outChannels int
+
+	gain      float64
+	limiter   *dsp.Limiter
+	mixer     *dsp.Mixer
+	resampler *dsp.Resampler
+	// speed is the playback speed, and stretch changes the tempo to it if
+	// not 1.
+	speed   float64
+	stretch *dsp.Stretcher
+	// buf holds converted samples not yet pushed. eof is set once the
+	// song has been read to its end and buf holds the rest of it.
+	buf []float32
+	eof bool
+	// loop is the A-B loop, if any, and seam the audio after B to
+	// crossfade into that after A once seeking there is done.
+	loop *Loop
+	seam []float32
+	// trim skips silence, if enabled.
+	trim *trimmer
+}
+
+// openTrack opens the song at index in the queue.
+func (srv *Server) openTrack(index int) (*track, error) {
+	id := srv.Queue[index]
+	inst, err := srv.GetInstance(id.Protocol, id.Key)
+	if err != nil {
+		return nil, err
+	}
+	song, err := inst.GetSong(id.ID)
+	if err != nil {
+		return nil, err
+	}
+	sr, ch, err := song.Init()
+	if err != nil {
+		song.Close()
+		return nil, err
+	}
+	t := &track{
+		id:       id,
+		index:    index,
+		inst:     inst,
+		song:     song,
+		rate:     sr,
+		channels: ch,
+		gain:     srv.songGain(id),
+		speed:    srv.speed(id),
+		limiter:  dsp.NewLimiter(sr, ch, limiterCeiling),
+	}
+	if info := srv.songs[id]; info != nil {
+		t.info = *info
+	}
+	dur := time.Second / (time.Duration(sr * ch))
+	t.seek = NewSeek(t.info.Time > 0, dur, ch, song)
+	t.setSilence(srv.Silence)
+	t.setOutput(srv.OutputRate, srv.OutputChannels)
+	return t, nil
+}
+
+// setOutput converts the rest of t to rate and channels, or to its own if
+// zero.
+func (t *track) setOutput(rate, channels int) {
+	if rate <= 0 {
+		rate = t.rate
+	}
+	if channels <= 0 {
+		channels = t.channels
+	}
+	t.outRate, t.outChannels = rate, channels
+	t.mixer = dsp.NewMixer(t.channels, channels)
+	t.resampler = dsp.NewResampler(t.rate, rate, channels)
+	t.setSpeed(t.speed)
+}
+
+// setSpeed changes the speed of the rest of t.
+func (t *track) setSpeed(speed float64) {
+	if t.stretch != nil {
+		t.buf = append(t.buf, t.stretch.Flush()...)
+	}
+	t.speed, t.stretch = 1, nil
+	if speed == 1 {
+		return
+	}
+	s, err := dsp.NewStretcher(t.outRate, t.outChannels, speed)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	t.speed, t.stretch = speed, s
+}
+
+// convert converts samples of the song to the output format and speed.
+func (t *track) convert(b []float32) []float32 {
+	b = t.resampler.Process(t.mixer.Process(b))
+	if t.stretch != nil {
+		b = t.stretch.Process(b)
+	}
+	return b
+}
+
+// flush appends the audio held back by the processing to buf.
+func (t *track) flush() {
+	t.buf = append(t.buf, t.convert(t.limiter.Flush())...)
+	b := t.resampler.Flush()
+	if t.stretch != nil {
+		b = append(t.stretch.Process(b), t.stretch.Flush()...)
+	}
+	t.buf = append(t.buf, b...)
+}
+
+// discard drops the converted audio not yet played, after a seek.
+func (t *track) discard() {
+	t.buf = nil
+	t.eof = false
+	t.seam = nil
+	t.limiter.Reset()
+	if t.stretch != nil {
+		t.stretch.Reset()
+	}
+	if t.trim != nil {
+		t.trim.reset()
+	}
+}
+
+// read reads from the song until buf holds at least n samples or the song
+// ends. Gain is applied if normalize is set.
+func (t *track) read(n int, normalize bool) error {
+	// dropped counts the reads whose samples were all skipped as silence.
+	dropped := 0
+	for len(t.buf) < n && !t.eof {
+		if t.seek.Seeking() {
+			// Decode toward the seek offset a step per call, so that
+			// commands are handled meanwhile.
+			return t.seek.Skip()
+		}
+		next, eof, err := t.next()
+		if err != nil {
+			return err
+		}
+		if t.trim != nil {
+			var end bool
+			in := len(next)
+			next, end = t.trim.process(next)
+			eof = eof || end
+			if in > 0 && len(next) == 0 && !eof {
+				// Skip silence a step per call too, as seeks do.
+				if dropped++; dropped >= 16 {
+					return nil
+				}
+			}
+		}
+		out := next
+		if normalize {
+			// Copy since Seek may keep next.
+			out = append([]float32(nil), next...)
+			dsp.Gain(out, t.gain)
+			out = t.limiter.Process(out)
+		}
+		t.buf = append(t.buf, t.convert(out)...)
+		if eof {
+			t.flush()
+			t.eof = true
+		}
+	}
+	return nil
+}
+
+// next reads the next samples of the song, and returns whether it ended.
+func (t *track) next() (next []float32, eof bool, err error) {
+	if t.seam != nil {
+		next, err = t.joinPass()
+		return next, false, err
+	}
+	const expected = 4096
+	// Songs are read in whole sample frames.
+	want := expected - expected%t.channels
+	left := t.loopLeft()
+	if left > 0 && left < want {
+		want = left
+	}
+	if next, err = t.seek.Read(want); err != nil {
+		return nil, false, err
+	}
+	eof = len(next) < want
+	if !eof && left >= 0 && t.loopLeft() == 0 {
+		next, err = t.endPass(next)
+	}
+	return next, eof, err
+}
+
+// take removes and returns up to n samples from buf.
+func (t *track) take(n int) []float32 {
+	if n > len(t.buf) {
+		n = len(t.buf)
+	}
+	b := t.buf[:n:n]
+	t.buf = t.buf[n:]
+	return b
+}
+
+// done returns whether all of t has been taken.
+func (t *track) done() bool {
+	return t.eof && len(t.buf) == 0
+}
+
+// remaining returns the time left to play at its speed, or -1 if unknown
+// or looping.
+func (t *track) remaining() time.Duration {
+	if t.info.Time <= 0 || t.loopLeft() >= 0 {
+		return -1
+	}
+	d := t.info.Time - t.seek.Pos()
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(float64(d) / t.speed)
+}
+
+// sameFormat returns whether t and u are converted to the same output
+// format, so that their samples can be mixed or spliced.
+func (t *track) sameFormat(u *track) bool {
+	return t.outRate == u.outRate && t.outChannels == u.outChannels
+}
+
+// sameAlbum returns whether t and u are from the same album.
+func (t *track) sameAlbum(u *track) bool {
+	return t.info.Album != "" && t.info.Album == u.info.Album &&
+		t.id.Protocol == u.id.Protocol && t.id.Key == u.id.Key
+}
+
+// crossfade is the position and length in output frames of a crossfade
+// between tracks, both 0 until it starts.
+type crossfade struct {
+	pos, length int
+}
+
+// join mixes up, the next track, into out, the samples just taken from t
+// with rem left to play of the n wanted. Unless both are from the same
+// album, up is faded in over the last d of t, and once t is done the start
+// of up is spliced onto its end.
+func (f *crossfade) join(t, up *track, out []float32, n int, rem, d time.Duration, normalize bool) ([]float32, error) {
+	if f.length == 0 && d > 0 && rem >= 0 && rem <= d && !t.sameAlbum(up) {
+		log.Println("crossfade", rem)
+		f.pos = 0
+		// The fade covers the audio taken and converted too.
+		f.length = int(rem.Seconds()*float64(t.outRate)) + (len(out)+len(t.buf))/t.outChannels
+	}
+	if f.length > 0 {
+		if err := up.read(len(out), normalize); err != nil {
+			return out, err
+		}
+		dsp.Crossfade(out, up.take(len(out)), t.outChannels, f.pos, f.length)
+		f.pos += len(out) / t.outChannels
+	}
+	if len(out) < n && t.done() {
+		if err := up.read(n-len(out), normalize); err != nil {
+			return out, err
+		}
+		out = append(out, up.take(n-len(out))...)
+	}
+	return out, nil
+}
+
+func (t *track) close() {
+	t.song.Close()
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// playJoined plays cur into up as the audio loop does, with a crossfade of
+// d, and returns the output and the crossfade.
+func playJoined(t *testing.T, cur, up *track, d time.Duration) ([]float32, crossfade) {
+	t.Helper()
+	const n = 1000
+	var f crossfade
+	var got []float32
+	for !cur.done() {
+		if err := cur.read(n, false); err != nil {
+			t.Fatal(err)
+		}
+		rem := cur.remaining()
+		out, err := f.join(cur, up, cur.take(n), n, rem, d, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, out...)
+	}
+	return append(got, readTrack(t, up, 1<<20)...), f
+}
+
+// countSong returns a song of 100s of samples counting up from start.
+func countSong(start int) *sliceSong {
+	s := &sliceSong{b: make([]float32, 100000), time: true}
+	for i := range s.b {
+		s.b[i] = float32(start + i)
+	}
+	return s
+}
+
+func TestJoinGapless(t *testing.T) {
+	// Without a crossfade the next song follows on the very next sample.
+	got, f := playJoined(t, newTestTrack(countSong(0)), newTestTrack(countSong(100000)), 0)
+	if f.length != 0 {
+		t.Fatal("crossfaded without a crossfade set")
+	}
+	if len(got) != 200000 {
+		t.Fatalf("got %d samples, want 200000", len(got))
+	}
+	for i, v := range got {
+		if int(v) != i {
+			t.Fatalf("sample %d is %v", i, v)
+		}
+	}
+}
+
+func TestJoinCrossfade(t *testing.T) {
+	got, f := playJoined(t, newTestTrack(countSong(0)), newTestTrack(countSong(0)), 10*time.Second)
+	// The fade covers the end of the first song, and starts within a read
+	// of the set crossfade.
+	if f.length < 10000 || f.length > 10000+4096 || f.pos != f.length {
+		t.Fatalf("crossfade %+v", f)
+	}
+	if len(got) != 200000-f.length {
+		t.Fatalf("got %d samples, want %d", len(got), 200000-f.length)
+	}
+	start := 100000 - f.length
+	if got[start-1] != float32(start-1) || got[len(got)-1] != 99999 {
+		t.Fatal("audio outside the crossfade changed")
+	}
+}
+
+func TestJoinSameAlbum(t *testing.T) {
+	// Songs of one album are joined without a crossfade.
+	cur, up := newTestTrack(countSong(0)), newTestTrack(countSong(100000))
+	cur.info.Album, up.info.Album = "Album", "Album"
+	got, f := playJoined(t, cur, up, 10*time.Second)
+	if f.length != 0 || len(got) != 200000 {
+		t.Fatalf("crossfade %+v, %d samples", f, len(got))
+	}
+	for i, v := range got {
+		if int(v) != i {
+			t.Fatalf("sample %d is %v", i, v)
+		}
+	}
+}
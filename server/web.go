@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/mjibson/mog/_third_party/github.com/julienschmidt/httprouter"
 	"github.com/mjibson/mog/_third_party/golang.org/x/net/websocket"
+	"github.com/mjibson/mog/dsp"
 	"github.com/mjibson/mog/protocol"
 )
 
@@ -139,6 +141,116 @@ func (srv *Server) Cmd(form url.Values, ps httprouter.Params) (interface{}, erro
 		srv.ch <- cmdRandom
 	case "repeat":
 		srv.ch <- cmdRepeat
+	case "gapless":
+		srv.ch <- cmdGapless
+	case "crossfade":
+		d, err := time.ParseDuration(form.Get("d"))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 || d > time.Second*30 {
+			return nil, fmt.Errorf("bad crossfade: %v", d)
+		}
+		srv.ch <- cmdCrossfade(d)
+	case "speed":
+		v, err := strconv.ParseFloat(form.Get("v"), 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) || v < dsp.MinSpeed || v > dsp.MaxSpeed {
+			return nil, fmt.Errorf("bad speed: %v", v)
+		}
+		srv.ch <- cmdSpeed(v)
+	case "silence":
+		var s Silence
+		var err error
+		if s.Duration, err = time.ParseDuration(form.Get("d")); err != nil {
+			return nil, err
+		}
+		if s.Duration < 0 {
+			return nil, fmt.Errorf("bad silence duration: %v", s.Duration)
+		}
+		if th := form.Get("threshold"); th != "" {
+			if s.Threshold, err = strconv.ParseFloat(th, 64); err != nil {
+				return nil, err
+			}
+			if s.Threshold < -120 || s.Threshold >= 0 {
+				return nil, fmt.Errorf("bad silence threshold: %v", s.Threshold)
+			}
+		}
+		if g := form.Get("gaps"); g != "" {
+			if s.Gaps, err = strconv.ParseBool(g); err != nil {
+				return nil, err
+			}
+		}
+		srv.ch <- cmdSilence(s)
+	case "loop_set":
+		var l Loop
+		var err error
+		if l.A, err = time.ParseDuration(form.Get("a")); err != nil {
+			return nil, err
+		}
+		if l.B, err = time.ParseDuration(form.Get("b")); err != nil {
+			return nil, err
+		}
+		if c := form.Get("count"); c != "" {
+			if l.Count, err = strconv.Atoi(c); err != nil {
+				return nil, err
+			}
+			if l.Count < 0 {
+				return nil, fmt.Errorf("bad loop count: %v", l.Count)
+			}
+		}
+		srv.ch <- cmdLoop{&l}
+	case "loop_clear":
+		srv.ch <- cmdLoop{}
+	case "volume":
+		var c cmdVolume
+		if db := form.Get("db"); db != "" {
+			v, err := parseFinite(db)
+			if err != nil {
+				return nil, err
+			}
+			c.db = true
+			c.v = v
+		} else {
+			v, err := parseFinite(form.Get("v"))
+			if err != nil {
+				return nil, err
+			}
+			c.v = v
+		}
+		srv.ch <- c
+	case "volume_up", "volume_down":
+		step := 5.0
+		if s := form.Get("step"); s != "" {
+			v, err := parseFinite(s)
+			if err != nil {
+				return nil, err
+			}
+			step = v
+		}
+		if cmd == "volume_down" {
+			step = -step
+		}
+		srv.ch <- cmdVolumeStep(step)
+	case "mute":
+		srv.ch <- cmdMute
+	case "dsp":
+		c, err := dsp.ParseConfig(form.Get("chain"))
+		if err != nil {
+			return nil, err
+		}
+		srv.ch <- cmdDSP(c)
+	case "dsp_save", "dsp_load", "dsp_delete":
+		name := form.Get("name")
+		if name == "" {
+			return nil, fmt.Errorf("missing preset name")
+		}
+		srv.ch <- cmdDSPPreset{
+			op:   strings.TrimPrefix(cmd, "dsp_"),
+			name: name,
+		}
 	case "seek":
 		d, err := time.ParseDuration(form.Get("pos"))
 		if err != nil {
@@ -151,12 +263,63 @@ func (srv *Server) Cmd(form url.Values, ps httprouter.Params) (interface{}, erro
 			return nil, err
 		}
 		srv.ch <- cmdMinDuration(d)
+	case "replaygain":
+		mode, err := parseGainMode(form.Get("mode"))
+		if err != nil {
+			return nil, err
+		}
+		var preamp float64
+		if p := form.Get("preamp"); p != "" {
+			preamp, err = strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(preamp) {
+				return nil, fmt.Errorf("bad preamp: %v", p)
+			}
+			preamp = math.Max(-maxPreamp, math.Min(preamp, maxPreamp))
+		}
+		srv.ch <- cmdGain{
+			mode:   mode,
+			preamp: preamp,
+		}
+	case "output":
+		rate, err := strconv.Atoi(form.Get("rate"))
+		if err != nil {
+			return nil, err
+		}
+		channels, err := strconv.Atoi(form.Get("channels"))
+		if err != nil {
+			return nil, err
+		}
+		if rate != 0 && (rate < 8000 || rate > 384000) {
+			return nil, fmt.Errorf("bad sample rate: %v", rate)
+		}
+		if channels < 0 || channels > 8 {
+			return nil, fmt.Errorf("bad channels: %v", channels)
+		}
+		srv.ch <- cmdOutput{
+			rate:     rate,
+			channels: channels,
+		}
 	default:
 		return nil, fmt.Errorf("unknown command: %v", cmd)
 	}
 	return nil, nil
 }
 
+// parseFinite parses s as a number that is neither infinite nor NaN.
+func parseFinite(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("bad number: %v", s)
+	}
+	return v, nil
+}
+
 func (srv *Server) QueueChange(form url.Values, ps httprouter.Params) (interface{}, error) {
 	srv.ch <- cmdQueueChange(form)
 	return nil, nil
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mjibson/mog/_third_party/github.com/julienschmidt/httprouter"
+)
+
+// TestCmdBad checks that commands with bad values are refused before they
+// reach the audio loop, which the nil channel of the server would block.
+func TestCmdBad(t *testing.T) {
+	srv := &Server{}
+	for _, c := range []struct {
+		cmd  string
+		form url.Values
+	}{
+		{"speed", url.Values{"v": {"NaN"}}},
+		{"speed", url.Values{"v": {"+Inf"}}},
+		{"speed", url.Values{"v": {"0.25"}}},
+		{"speed", url.Values{"v": {"4"}}},
+		{"volume", url.Values{"v": {"NaN"}}},
+		{"volume", url.Values{"db": {"NaN"}}},
+		{"volume", url.Values{"db": {"-Inf"}}},
+		{"volume_up", url.Values{"step": {"NaN"}}},
+		{"volume_down", url.Values{"step": {"Inf"}}},
+		{"silence", url.Values{"d": {"-1s"}}},
+	} {
+		ps := httprouter.Params{{Key: "cmd", Value: c.cmd}}
+		if _, err := srv.Cmd(c.form, ps); err == nil {
+			t.Errorf("%s %v: expected an error", c.cmd, c.form)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/mjibson/mog/_third_party/golang.org/x/net/websocket"
 	"github.com/mjibson/mog/protocol"
@@ -41,6 +42,11 @@ func (srv *Server) makeWaitData(wt waitType) (*waitData, error) {
 			protos,
 		}
 	case waitStatus:
+		var presets []string
+		for name := range srv.DSPPresets {
+			presets = append(presets, name)
+		}
+		sort.Strings(presets)
 		data = &Status{
 			State:    srv.state,
 			Song:     srv.songID,
@@ -49,6 +55,20 @@ func (srv *Server) makeWaitData(wt waitType) (*waitData, error) {
 			Time:     srv.info.Time,
 			Random:   srv.Random,
 			Repeat:   srv.Repeat,
+			GainMode: srv.GainMode,
+			Preamp:   srv.Preamp,
+
+			OutputRate:     srv.OutputRate,
+			OutputChannels: srv.OutputChannels,
+			Gapless:        srv.Gapless,
+			Crossfade:      srv.Crossfade,
+			DSP:            srv.DSP,
+			DSPPresets:     presets,
+			Volume:         srv.volumePercent(),
+			Muted:          srv.Muted,
+			Speed:          srv.speed(srv.speedID()),
+			Loop:           srv.loop,
+			Silence:        srv.Silence,
 		}
 	case waitTracks:
 		songs := make([]listItem, len(srv.songs))